@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/server"
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean <input.d2>",
+	Short: "Remove layout metadata (.d2meta) for a D2 diagram",
+	Long: `Remove the .d2meta file that stores manual positions, edge vertices,
+routing modes, and label positions for a D2 diagram.
+
+With --reset, the file is kept but cleared to empty metadata for the
+current source instead of being deleted.
+
+Examples:
+  # Delete diagram.d2meta
+  diagtool clean diagram.d2
+
+  # Clear diagram.d2meta instead of deleting it
+  diagtool clean diagram.d2 --reset
+
+  # Clean the metadata of every .d2 file in a directory
+  diagtool clean diagrams/ --all`,
+	Args: cobra.ExactArgs(1),
+	RunE: runClean,
+}
+
+var (
+	cleanAll   bool
+	cleanReset bool
+)
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanAll, "all", false, "Clean every .d2 file in the given directory")
+	cleanCmd.Flags().BoolVar(&cleanReset, "reset", false, "Clear the metadata instead of deleting the file")
+}
+
+func runClean(cmd *cobra.Command, args []string) error {
+	files := []string{args[0]}
+	if cleanAll {
+		entries, err := os.ReadDir(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read directory: %w", err)
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".d2") {
+				files = append(files, filepath.Join(args[0], entry.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	out := cmd.OutOrStdout()
+	cleaned := 0
+	for _, file := range files {
+		action, err := cleanMetadata(file)
+		if err != nil {
+			return err
+		}
+		if action == "" {
+			fmt.Fprintf(out, "No metadata for %s\n", file)
+			continue
+		}
+		fmt.Fprintf(out, "%s %s\n", action, server.MetadataPath(file))
+		cleaned++
+	}
+
+	if cleanAll {
+		fmt.Fprintf(out, "Cleaned %d of %d files\n", cleaned, len(files))
+	}
+	return nil
+}
+
+// cleanMetadata deletes or, with --reset, clears the .d2meta file of a D2
+// file. It returns what was done ("Removed" or "Reset"), or "" if the file
+// has no metadata.
+func cleanMetadata(d2Path string) (string, error) {
+	metaPath := server.MetadataPath(d2Path)
+	if _, err := os.Stat(metaPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to access metadata file: %w", err)
+	}
+
+	if !cleanReset {
+		if err := os.Remove(metaPath); err != nil {
+			return "", fmt.Errorf("failed to remove metadata file: %w", err)
+		}
+		return "Removed", nil
+	}
+
+	content, err := os.ReadFile(d2Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read input file: %w", err)
+	}
+	meta := server.NewMetadata()
+	meta.SourceHash = ir.HashSource(string(content))
+	if err := server.SaveMetadata(d2Path, meta); err != nil {
+		return "", fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return "Reset", nil
+}
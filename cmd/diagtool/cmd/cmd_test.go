@@ -1,13 +1,32 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
+	"github.com/mark/dsl-diagram-tool/pkg/render"
+	"github.com/mark/dsl-diagram-tool/pkg/server"
 )
 
 // Helper to create a fresh root command for testing
@@ -17,23 +36,84 @@ func newTestRootCmd() *cobra.Command {
 	outputFormat = "svg"
 	themeID = 0
 	darkMode = false
+	darkThemeID = 0
 	sketchMode = false
 	padding = 100
 	noCenter = false
 	verbose = false
+	allowSelfLoops = false
+	syntaxOnly = false
+	strictLint = false
 	watchMode = false
 	pixelDensity = 3
+	embedSource = false
+	routingMode = ""
+	c4Mode = false
+	cssFile = ""
+	pageSize = ""
+	landscape = false
+	statsFormat = "table"
+	exportFormat = "csv"
+	convertOutput = ""
+	convertFrom = ""
+	convertTo = ""
+	statsFrom = nil
+	splitContainers = false
+	gzipOutput = false
+	logLevel = "info"
+	board = ""
+	rasterizer = ""
+	fitBox = ""
+	watermark = ""
+	showGrid = false
+	formats = nil
+	noLayout = false
+	diffImage = ""
+	servePort = 8080
+	serveOpen = false
+	cleanAll = false
+	cleanReset = false
+	quiet = false
+	jsonOutput = false
+	mergeEdges = false
+	palette = ""
+	inputFormat = ""
+	validateFormat = ""
+	highlight = nil
+	focus = ""
+	focusDepth = 1
+	groupByTag = false
+	collapse = nil
+	minify = false
+	fontRegular = ""
+	fontBold = ""
+	fontItalic = ""
+	embedFonts = false
+	inlineIcons = false
+	renderTimeout = 30 * time.Second
+	validateTimeout = 30 * time.Second
 
 	// Create fresh commands
 	testRoot := &cobra.Command{
-		Use:           "diagtool",
-		Short:         "DSL Diagram Tool - Render D2 diagrams to various formats",
-		SilenceUsage:  true,
-		SilenceErrors: true,
+		Use:               "diagtool",
+		Short:             "DSL Diagram Tool - Render D2 diagrams to various formats",
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+		PersistentPreRunE: setupLogging,
 	}
+	testRoot.PersistentFlags().StringVar(&logLevel, "log-level", "info", "")
 
 	testRoot.AddCommand(renderCmd)
 	testRoot.AddCommand(validateCmd)
+	testRoot.AddCommand(statsCmd)
+	testRoot.AddCommand(cleanCmd)
+	testRoot.AddCommand(examplesCmd)
+	testRoot.AddCommand(themesCmd)
+	testRoot.AddCommand(schemaCmd)
+	testRoot.AddCommand(diffCmd)
+	testRoot.AddCommand(exportCmd)
+	testRoot.AddCommand(convertCmd)
+	testRoot.AddCommand(serveCmd)
 	testRoot.AddCommand(versionCmd)
 
 	return testRoot
@@ -123,6 +203,216 @@ func TestRenderCommand_SVGOutput(t *testing.T) {
 	}
 }
 
+func TestRenderCommand_Markdown(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "diagram.markdown")
+
+	os.WriteFile(inputFile, []byte("server -> database: connects"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-f", "md", "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render command failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		t.Fatalf("Output file was not created: %v", err)
+	}
+
+	match := regexp.MustCompile(`!\[diagram\]\(data:image/svg\+xml;base64,([A-Za-z0-9+/=]+)\)`).FindSubmatch(content)
+	if match == nil {
+		t.Fatalf("Output should contain a Markdown image with an SVG data URI, got: %.200s", content)
+	}
+	svg, err := base64.StdEncoding.DecodeString(string(match[1]))
+	if err != nil {
+		t.Fatalf("Image data is not valid base64: %v", err)
+	}
+	if !strings.Contains(string(svg), "<svg") || !strings.Contains(string(svg), "connects") {
+		t.Error("Embedded image should be the rendered SVG")
+	}
+}
+
+func TestRenderCommand_MarkdownTitle(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "diagram.json")
+	outputFilePath := filepath.Join(tmpDir, "diagram.md")
+
+	diagram := &ir.Diagram{
+		ID:       "overview",
+		Metadata: map[string]string{"title": "System Overview"},
+		Nodes:    []*ir.Node{{ID: "a", Label: "A"}},
+	}
+	data, _ := json.Marshal(diagram)
+	os.WriteFile(inputFile, data, 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render command failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.HasPrefix(string(content), "# System Overview\n\n![System Overview](data:image/svg+xml;base64,") {
+		t.Errorf("Output should start with the title and a titled image, got: %.200s", content)
+	}
+}
+
+func TestRenderCommand_Gzip(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("client: Web Client\nserver: API Server\nclient -> server: request\n"), 0644)
+
+	plainFile := filepath.Join(tmpDir, "plain.svg")
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", plainFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "test.svgz")})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render to .svgz failed: %v", err)
+	}
+
+	plain, _ := os.ReadFile(plainFile)
+	compressed, err := os.ReadFile(filepath.Join(tmpDir, "test.svgz"))
+	if err != nil {
+		t.Fatalf("Expected test.svgz to be written: %v", err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Errorf("Expected .svgz (%d bytes) to be smaller than SVG (%d bytes)", len(compressed), len(plain))
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Output is not gzip: %v", err)
+	}
+	var svg bytes.Buffer
+	if _, err := svg.ReadFrom(gz); err != nil {
+		t.Fatalf("Failed to decompress output: %v", err)
+	}
+	for _, label := range []string{"<svg", "Web Client", "API Server", "request"} {
+		if !strings.Contains(svg.String(), label) {
+			t.Errorf("Expected decompressed SVG to contain %q", label)
+		}
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-f", "png", "--gzip"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("Expected --gzip with PNG output to fail")
+	}
+}
+
+func TestRenderCommand_Board(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "scenarios.d2")
+	source := `base: Base Service
+layers: {
+  scenario1: {
+    cache: Redis Cache
+    base -> cache
+  }
+  scenario2: {
+    queue: Message Queue
+  }
+}
+`
+	os.WriteFile(inputFile, []byte(source), 0644)
+
+	renderBoard := func(args ...string) string {
+		t.Helper()
+		outPath := filepath.Join(tmpDir, "out.svg")
+		cmd := newTestRootCmd()
+		cmd.SetArgs(append([]string{"render", inputFile, "-o", outPath}, args...))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("render %v failed: %v", args, err)
+		}
+		content, _ := os.ReadFile(outPath)
+		return string(content)
+	}
+
+	svg := renderBoard("--board", "scenario1")
+	if !strings.Contains(svg, "Redis Cache") || strings.Contains(svg, "Message Queue") {
+		t.Error("Expected only scenario1's nodes in the output")
+	}
+
+	// Transforms apply to the selected board
+	svg = renderBoard("--board", "scenario2", "--highlight", "queue")
+	if !strings.Contains(svg, "Message Queue") || strings.Contains(svg, "Redis Cache") {
+		t.Error("Expected only scenario2's nodes in the output")
+	}
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "--board", "scenario3"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "scenario3") {
+		t.Errorf("Expected unknown board error, got %v", err)
+	}
+}
+
+func TestRenderCommand_Split(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "system.d2")
+	outputFilePath := filepath.Join(tmpDir, "system.svg")
+	source := `frontend: Frontend {
+  web: Web App
+  mobile: Mobile App
+}
+backend: Backend {
+  api: API
+  db: Database { shape: cylinder }
+  api -> db
+}
+frontend.web -> backend.api: calls
+`
+	os.WriteFile(inputFile, []byte(source), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--split"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render --split failed: %v", err)
+	}
+
+	expected := map[string][]string{
+		"system.svg":   {"Frontend", "Backend", "calls"},
+		"frontend.svg": {"Web App", "Mobile App"},
+		"backend.svg":  {"API", "Database"},
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 4 { // input + 3 outputs
+		t.Errorf("Expected 3 output files, got %d entries in %s", len(entries)-1, tmpDir)
+	}
+	for name, labels := range expected {
+		content, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Errorf("Expected %s to be written: %v", name, err)
+			continue
+		}
+		if !strings.Contains(string(content), "<svg") || !strings.HasSuffix(strings.TrimSpace(string(content)), "</svg>") {
+			t.Errorf("%s is not a valid SVG", name)
+		}
+		for _, label := range labels {
+			if !strings.Contains(string(content), label) {
+				t.Errorf("Expected %s to contain %q", name, label)
+			}
+		}
+		if !strings.Contains(out.String(), "→ "+filepath.Join(tmpDir, name)) {
+			t.Errorf("Expected %s to be reported, got:\n%s", name, out.String())
+		}
+	}
+
+	// The overview shows the containers collapsed
+	overview, _ := os.ReadFile(outputFilePath)
+	if strings.Contains(string(overview), "Web App") {
+		t.Error("Overview should not contain the containers' contents")
+	}
+}
+
 func TestRenderCommand_WithSketch(t *testing.T) {
 	tmpDir := t.TempDir()
 	inputFile := filepath.Join(tmpDir, "test.d2")
@@ -163,6 +453,51 @@ func TestRenderCommand_WithTheme(t *testing.T) {
 	}
 }
 
+func TestRenderCommand_DarkTheme(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "dark.svg")
+
+	os.WriteFile(inputFile, []byte("x -> y"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--dark", "--dark-theme", "201"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render with dark theme failed: %v", err)
+	}
+
+	if _, err := os.Stat(outputFilePath); os.IsNotExist(err) {
+		t.Error("Dark themed output file was not created")
+	}
+}
+
+func TestRenderCommand_InvalidDarkTheme(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("x -> y"), 0644)
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"light theme as dark theme", []string{"--dark", "--dark-theme", "3"}, "unsupported dark theme"},
+		{"without --dark", []string{"--dark-theme", "200"}, "--dark-theme requires --dark"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newTestRootCmd()
+			cmd.SetArgs(append([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg")}, tt.args...))
+			err := cmd.Execute()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected %q error, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
 func TestRenderCommand_PNGExport(t *testing.T) {
 	tmpDir := t.TempDir()
 	inputFile := filepath.Join(tmpDir, "test.d2")
@@ -246,6 +581,27 @@ func TestValidateCommand_InvalidSyntax(t *testing.T) {
 	}
 }
 
+func TestValidateCommand_SyntaxOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	validFile := filepath.Join(tmpDir, "valid.d2")
+	invalidFile := filepath.Join(tmpDir, "invalid.d2")
+
+	os.WriteFile(validFile, []byte("server -> database"), 0644)
+	os.WriteFile(invalidFile, []byte("a -> -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"validate", validFile, "--syntax-only"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("validate --syntax-only should succeed for valid file: %v", err)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"validate", invalidFile, "--syntax-only"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("validate --syntax-only should fail for invalid syntax")
+	}
+}
+
 // Integration tests with example files
 func TestRenderCommand_ExampleFiles(t *testing.T) {
 	examplesDir := "../../../examples"
@@ -360,6 +716,19 @@ func TestResolveRenderConfig_AutoDetectPNG(t *testing.T) {
 	}
 }
 
+func TestResolveRenderConfig_AutoDetectWebP(t *testing.T) {
+	outputFile = "output.webp"
+	outputFormat = "svg"
+
+	cfg, err := resolveRenderConfig("diagram.d2")
+	if err != nil {
+		t.Fatalf("resolveRenderConfig failed: %v", err)
+	}
+	if cfg.format != "webp" {
+		t.Errorf("Expected format 'webp' (auto-detected), got '%s'", cfg.format)
+	}
+}
+
 func TestResolveRenderConfig_ExplicitFormat(t *testing.T) {
 	// Reset global flags
 	outputFile = ""
@@ -403,6 +772,7 @@ func TestDoRender_SVG(t *testing.T) {
 	outputFormat = "svg"
 	themeID = 0
 	darkMode = false
+	darkThemeID = 0
 	sketchMode = false
 	padding = 100
 	noCenter = false
@@ -412,7 +782,7 @@ func TestDoRender_SVG(t *testing.T) {
 		t.Fatalf("resolveRenderConfig failed: %v", err)
 	}
 
-	err = doRender(cfg)
+	_, err = doRender(cfg)
 	if err != nil {
 		t.Fatalf("doRender failed: %v", err)
 	}
@@ -437,7 +807,7 @@ func TestDoRender_FileNotFound(t *testing.T) {
 		format:    "svg",
 	}
 
-	err := doRender(cfg)
+	_, err := doRender(cfg)
 	if err == nil {
 		t.Error("Expected error for nonexistent file")
 	}
@@ -459,24 +829,44 @@ func TestDoRender_InvalidD2Syntax(t *testing.T) {
 		format:    "svg",
 	}
 
-	err := doRender(cfg)
+	_, err := doRender(cfg)
 	if err == nil {
 		t.Error("Expected error for invalid D2 syntax")
 	}
 }
 
-func TestFormatTime(t *testing.T) {
-	ts := formatTime()
+func TestRootCommand_LogLevel(t *testing.T) {
+	defer logging.Setup(os.Stderr, "info")
 
-	// Should be in HH:MM:SS format
-	if len(ts) != 8 {
-		t.Errorf("Expected timestamp length 8, got %d (%s)", len(ts), ts)
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+	// A metadata file that can't be parsed is skipped with a warning
+	os.WriteFile(filepath.Join(tmpDir, "test.d2meta"), []byte("{not json"), 0644)
+
+	for _, tt := range []struct {
+		level string
+		warns bool
+	}{
+		{"warn", true},
+		{"error", false},
+	} {
+		var stderr bytes.Buffer
+		cmd := newTestRootCmd()
+		cmd.SetErr(&stderr)
+		cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "-q", "--log-level", tt.level})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		if got := strings.Contains(stderr.String(), "level=WARN msg=\"ignoring layout metadata\""); got != tt.warns {
+			t.Errorf("--log-level %s: expected warning %v, got stderr:\n%s", tt.level, tt.warns, stderr.String())
+		}
 	}
 
-	// Should parse as time
-	_, err := time.Parse("15:04:05", ts)
-	if err != nil {
-		t.Errorf("formatTime returned invalid time format: %v", err)
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "--log-level", "loud"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("Expected an unknown log level to fail")
 	}
 }
 
@@ -491,6 +881,66 @@ func TestWatchFlag_Recognized(t *testing.T) {
 	}
 }
 
+func TestFileWatcher_RelatedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	metaFile := filepath.Join(tmpDir, "test.d2meta")
+	includeDir := filepath.Join(tmpDir, "shared")
+	includeFile := filepath.Join(includeDir, "common.d2")
+	os.Mkdir(includeDir, 0755)
+	os.WriteFile(inputFile, []byte("# @include shared/common.d2\na -> b"), 0644)
+	os.WriteFile(includeFile, []byte("db: Database"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "other.d2"), []byte("x"), 0644)
+
+	w, err := newFileWatcher(inputFile)
+	if err != nil {
+		t.Fatalf("newFileWatcher failed: %v", err)
+	}
+	defer w.Close()
+
+	renders := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.run(stop, func() { renders <- struct{}{} })
+
+	expectRender := func(what string) {
+		t.Helper()
+		select {
+		case <-renders:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected a re-render after %s", what)
+		}
+	}
+	expectNoRender := func(what string) {
+		t.Helper()
+		select {
+		case <-renders:
+			t.Fatalf("Unexpected re-render after %s", what)
+		case <-time.After(3 * watchDebounceDelay):
+		}
+	}
+
+	os.WriteFile(metaFile, []byte(`{"version":1}`), 0644)
+	expectRender("a .d2meta change")
+
+	os.WriteFile(includeFile, []byte("db: Database {shape: cylinder}"), 0644)
+	expectRender("an included file change")
+
+	os.WriteFile(filepath.Join(tmpDir, "other.d2"), []byte("y"), 0644)
+	expectNoRender("an unrelated file change")
+
+	// Rapid changes are debounced into one render
+	for i := 0; i < 5; i++ {
+		os.WriteFile(inputFile, []byte(fmt.Sprintf("a -> b%d", i)), 0644)
+	}
+	expectRender("input file changes")
+	expectNoRender("debounced changes")
+
+	// Dropping the include stops watching it
+	os.WriteFile(includeFile, []byte("db"), 0644)
+	expectNoRender("a change to a file no longer included")
+}
+
 // WP26: Additional comprehensive CLI tests
 
 // PDF Export Tests
@@ -660,7 +1110,9 @@ func TestRenderCommand_PDFWithOptions(t *testing.T) {
 		"--padding", "150",
 	})
 	err := cmd.Execute()
-
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	}
 	if err != nil {
 		t.Fatalf("PDF with options failed: %v", err)
 	}
@@ -786,6 +1238,28 @@ func TestRenderCommand_EmptyD2File(t *testing.T) {
 	}
 }
 
+func TestRenderCommand_CommentOnlyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "draft.d2")
+	outputFilePath := filepath.Join(tmpDir, "draft.svg")
+	if err := os.WriteFile(inputFile, []byte("# Architecture overview\n# TODO: add services\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--grid", "--watermark", "DRAFT", "--fit", "400x300"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render of a comment-only file failed: %v", err)
+	}
+	svg, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !strings.Contains(string(svg), "<svg") {
+		t.Error("Expected an SVG for a comment-only file")
+	}
+}
+
 func TestRenderCommand_ComplexD2(t *testing.T) {
 	tmpDir := t.TempDir()
 	inputFile := filepath.Join(tmpDir, "complex.d2")
@@ -867,3 +1341,1719 @@ func TestValidateCommand_WithVerbose(t *testing.T) {
 		t.Fatalf("Validate with verbose failed: %v", err)
 	}
 }
+
+func TestRenderCommand_EmbedSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "embedded.svg")
+
+	source := "web -> api: requests\napi -> db"
+	os.WriteFile(inputFile, []byte(source), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--embed-source"})
+	err := cmd.Execute()
+
+	if err != nil {
+		t.Fatalf("Render with --embed-source failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(string(content), `<metadata id="d2-source">`) {
+		t.Error("Output should contain a d2-source metadata element")
+	}
+	if !strings.Contains(string(content), source) {
+		t.Error("Output should contain the original D2 source")
+	}
+}
+
+func TestRenderCommand_NoEmbedSourceByDefault(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "plain.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if strings.Contains(string(content), "d2-source") {
+		t.Error("Source should not be embedded unless --embed-source is set")
+	}
+}
+
+func TestRenderCommand_InvalidRoutingMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "--routing", "curvy"})
+	err := cmd.Execute()
+
+	if err == nil {
+		t.Fatal("render command should fail for invalid routing mode")
+	}
+	if !strings.Contains(err.Error(), "unsupported routing mode") {
+		t.Errorf("Expected 'unsupported routing mode' error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_OrthogonalRouting(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	directPath := filepath.Join(tmpDir, "direct.svg")
+	orthogonalPath := filepath.Join(tmpDir, "orthogonal.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b\na -> c\nb -> d\nc -> d"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", directPath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Direct render failed: %v", err)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", orthogonalPath, "--routing", "orthogonal"})
+	if err := cmd.Execute(); errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("Orthogonal render failed: %v", err)
+	}
+
+	direct, _ := os.ReadFile(directPath)
+	orthogonal, _ := os.ReadFile(orthogonalPath)
+
+	if len(orthogonal) == 0 {
+		t.Fatal("Orthogonal output should not be empty")
+	}
+	// JointJS output has no D2 version marker
+	if strings.Contains(string(orthogonal), "data-d2-version") {
+		t.Error("Orthogonal routing should render via JointJS, not the plain D2 path")
+	}
+	if string(direct) == string(orthogonal) {
+		t.Error("Orthogonal output should differ from direct routing")
+	}
+}
+
+func TestApplyRoutingModes(t *testing.T) {
+	routingMode = ""
+
+	// Sources without routing modes get no metadata
+	meta, err := applyRoutingModes(nil, nil, "a -> b")
+	if err != nil || meta != nil {
+		t.Errorf("Expected no metadata without routing modes, got %+v, %v", meta, err)
+	}
+
+	// Keys are the edge IDs of the rendered diagram, after transforms
+	diagram, err := parser.NewD2Parser().Parse("g.x -> y: {routing: orthogonal}\ny -> z")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	collapsed := diagram.CollapseContainer("g")
+	meta, err = applyRoutingModes(nil, collapsed, "")
+	if err != nil {
+		t.Fatalf("applyRoutingModes failed: %v", err)
+	}
+	if meta == nil || meta.RoutingMode["(g -> y)[0]"] != render.RoutingOrthogonal || len(meta.RoutingMode) != 1 {
+		t.Errorf("Expected the collapsed edge (g -> y)[0] to be orthogonal, got %+v", meta)
+	}
+
+	// The --routing mode applies to every rendered edge
+	routingMode = render.RoutingOrthogonal
+	defer func() { routingMode = "" }()
+	meta, err = applyRoutingModes(nil, collapsed, "")
+	if err != nil {
+		t.Fatalf("applyRoutingModes failed: %v", err)
+	}
+	for _, id := range []string{"(g -> y)[0]", "(y -> z)[0]"} {
+		if meta.RoutingMode[id] != render.RoutingOrthogonal {
+			t.Errorf("Expected %s to be orthogonal, got %+v", id, meta.RoutingMode)
+		}
+	}
+}
+
+func TestRenderCommand_MetadataVertices(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	plainPath := filepath.Join(tmpDir, "plain.svg")
+	routedPath := filepath.Join(tmpDir, "routed.svg")
+	source := "a -> b"
+	os.WriteFile(inputFile, []byte(source), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", plainPath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Plain render failed: %v", err)
+	}
+
+	meta := fmt.Sprintf(`{"sourceHash":%q,"vertices":{"(a -> b)[0]":[{"x":300,"y":40},{"x":300,"y":160}]}}`, ir.HashSource(source))
+	os.WriteFile(filepath.Join(tmpDir, "test.d2meta"), []byte(meta), 0644)
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", routedPath})
+	if err := cmd.Execute(); errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("Render with vertices failed: %v", err)
+	}
+
+	plain, _ := os.ReadFile(plainPath)
+	routed, _ := os.ReadFile(routedPath)
+	if strings.Contains(string(routed), "data-d2-version") {
+		t.Error("Vertices should render via JointJS, not the plain D2 path")
+	}
+	if string(plain) == string(routed) {
+		t.Error("Output with vertices should differ from the plain render")
+	}
+}
+
+func TestRenderCommand_StaleMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	plainPath := filepath.Join(tmpDir, "plain.svg")
+	stalePath := filepath.Join(tmpDir, "stale.svg")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", plainPath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Plain render failed: %v", err)
+	}
+
+	// Vertices saved for an earlier version of the source are ignored
+	meta := fmt.Sprintf(`{"sourceHash":%q,"vertices":{"(a -> b)[0]":[{"x":300,"y":40}]}}`, ir.HashSource("a -> c"))
+	os.WriteFile(filepath.Join(tmpDir, "test.d2meta"), []byte(meta), 0644)
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", stalePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with stale metadata failed: %v", err)
+	}
+
+	plain, _ := os.ReadFile(plainPath)
+	stale, _ := os.ReadFile(stalePath)
+	if string(plain) != string(stale) {
+		t.Error("Stale metadata should be ignored")
+	}
+}
+
+func TestExportCommand_MicroservicesCSV(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"export", "../../../examples/07-microservices.d2", "--format", "csv"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("export command failed: %v", err)
+	}
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV output: %v\n%s", err, out.String())
+	}
+	if len(records) != 17 { // Header and 16 nodes
+		t.Fatalf("Expected 17 rows, got %d", len(records))
+	}
+	column := make(map[string]int)
+	for i, id := range records[0][1:] {
+		column[id] = i + 1
+	}
+	cell := func(source, target string) string {
+		for _, record := range records[1:] {
+			if len(record) != 17 {
+				t.Fatalf("Expected 17 columns, got %d in row %s", len(record), record[0])
+			}
+			if record[0] == source {
+				return record[column[target]]
+			}
+		}
+		t.Fatalf("Missing row for %s", source)
+		return ""
+	}
+	for _, tt := range []struct {
+		source, target, want string
+	}{
+		{"web", "gateway", "1"},
+		{"gateway", "services.orders", "1"},
+		{"services.orders", "queue", "1"},
+		{"gateway", "web", "0"},
+		{"queue", "services.notifications", "0"},
+	} {
+		if got := cell(tt.source, tt.target); got != tt.want {
+			t.Errorf("Expected %s edges from %s to %s, got %s", tt.want, tt.source, tt.target, got)
+		}
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"export", "../../../examples/07-microservices.d2", "--format", "xlsx"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("Expected an unsupported export format to fail")
+	}
+}
+
+func TestStatsCommand_MicroservicesExample(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"stats", "../../../examples/07-microservices.d2", "--format", "json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("stats command failed: %v", err)
+	}
+
+	var stats ir.DiagramStats
+	if err := json.Unmarshal(out.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v\n%s", err, out.String())
+	}
+
+	if stats.NodeCount != 16 {
+		t.Errorf("Expected 16 nodes, got %d", stats.NodeCount)
+	}
+	if stats.EdgeCount != 14 {
+		t.Errorf("Expected 14 edges, got %d", stats.EdgeCount)
+	}
+	if stats.ContainerCount != 2 { // services, data
+		t.Errorf("Expected 2 containers, got %d", stats.ContainerCount)
+	}
+	if stats.MaxDepth != 1 {
+		t.Errorf("Expected max depth 1, got %d", stats.MaxDepth)
+	}
+	if stats.OrphanCount != 0 {
+		t.Errorf("Expected no orphans, got %d", stats.OrphanCount)
+	}
+
+	expectedShapes := map[ir.ShapeType]int{
+		ir.ShapePerson:    2,
+		ir.ShapeRectangle: 1,
+		ir.ShapeContainer: 2,
+		ir.ShapeHexagon:   5,
+		ir.ShapeCylinder:  2,
+		ir.ShapeCircle:    1,
+		ir.ShapeDiamond:   1,
+		ir.ShapeCloud:     2,
+	}
+	if len(stats.ShapeCounts) != len(expectedShapes) {
+		t.Errorf("Expected %d shape types, got %v", len(expectedShapes), stats.ShapeCounts)
+	}
+	for shape, count := range expectedShapes {
+		if stats.ShapeCounts[shape] != count {
+			t.Errorf("Expected %d %s shapes, got %d", count, shape, stats.ShapeCounts[shape])
+		}
+	}
+}
+
+func TestStatsCommand_From(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("gateway -> auth\ngateway -> orders -> db\nbatch -> db\nlegacy"), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"stats", inputFile, "--from", "gateway"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("stats command failed: %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "Reachable:    4 of 6 from gateway") {
+		t.Errorf("Expected reachable count in output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Unreachable:  batch, legacy") {
+		t.Errorf("Expected unreachable nodes in output, got:\n%s", output)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"stats", inputFile, "--from", "missing"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown node in --from") {
+		t.Errorf("Expected unknown node error, got: %v", err)
+	}
+}
+
+func TestCleanCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	metaFile := filepath.Join(tmpDir, "test.d2meta")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+	os.WriteFile(metaFile, []byte(`{"version":1,"positions":{"a":{"dx":10,"dy":5}},"sourceHash":"stale"}`), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"clean", inputFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("clean command failed: %v", err)
+	}
+	if _, err := os.Stat(metaFile); !os.IsNotExist(err) {
+		t.Error("Expected .d2meta file to be removed")
+	}
+	if !strings.Contains(out.String(), "Removed "+metaFile) {
+		t.Errorf("Expected removal to be reported, got: %s", out.String())
+	}
+
+	// Nothing left to clean
+	out.Reset()
+	cmd = newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"clean", inputFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("clean command failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "No metadata for "+inputFile) {
+		t.Errorf("Expected missing metadata to be reported, got: %s", out.String())
+	}
+}
+
+func TestCleanCommand_ResetAll(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		os.WriteFile(filepath.Join(tmpDir, name+".d2"), []byte(name+" -> x"), 0644)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "a.d2meta"), []byte(`{"version":1,"positions":{"a":{"dx":10,"dy":5}}}`), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.d2meta"), []byte(`{"version":1,"vertices":{"b -> x":[{"x":1,"y":2}]}}`), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"clean", tmpDir, "--all", "--reset"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("clean command failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Cleaned 2 of 3 files") {
+		t.Errorf("Expected summary, got: %s", out.String())
+	}
+
+	for _, name := range []string{"a", "b"} {
+		meta, err := server.LoadMetadata(filepath.Join(tmpDir, name+".d2"))
+		if err != nil {
+			t.Fatalf("Failed to load %s.d2meta: %v", name, err)
+		}
+		if meta.HasPositions() || meta.HasVertices() {
+			t.Errorf("Expected %s.d2meta to be emptied", name)
+		}
+		if meta.SourceHash != ir.HashSource(name+" -> x") {
+			t.Errorf("Expected %s.d2meta to carry the current source hash", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "c.d2meta")); !os.IsNotExist(err) {
+		t.Error("Reset should not create metadata for files without it")
+	}
+}
+
+func TestStatsCommand_Table(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("a -> b\nlonely"), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"stats", inputFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("stats command failed: %v", err)
+	}
+
+	for _, want := range []string{"Nodes:", "Edges:", "Orphans:", "rectangle"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected table output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStatsCommand_InvalidFormat(t *testing.T) {
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"stats", "../../../examples/01-basic-shapes.d2", "--format", "xml"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("stats command should fail for unsupported format")
+	}
+}
+
+func TestRenderCommand_ExternalCSS(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "styled.svg")
+	cssPath := filepath.Join(tmpDir, "assets", "styles.css")
+
+	os.WriteFile(inputFile, []byte("a -> b: link"), 0644)
+	os.MkdirAll(filepath.Dir(cssPath), 0755)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--css", cssPath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --css failed: %v", err)
+	}
+
+	svg, _ := os.ReadFile(outputFilePath)
+	css, err := os.ReadFile(cssPath)
+	if err != nil {
+		t.Fatalf("CSS file was not created: %v", err)
+	}
+
+	if !strings.Contains(string(svg), `href="assets/styles.css"`) {
+		t.Error("SVG should reference the CSS file relative to the SVG")
+	}
+	if strings.Contains(string(svg), "<style") {
+		t.Error("SVG should not contain embedded <style> elements")
+	}
+
+	// D2 theme classes used in the SVG must be defined in the CSS
+	for _, class := range []string{"fill-N7", "stroke-B1"} {
+		if !strings.Contains(string(svg), class) {
+			t.Errorf("Expected SVG to reference class %s", class)
+		}
+		if !strings.Contains(string(css), "."+class) {
+			t.Errorf("Expected CSS to define class %s", class)
+		}
+	}
+}
+
+func TestRenderCommand_SharedExternalCSS(t *testing.T) {
+	tmpDir := t.TempDir()
+	cssPath := filepath.Join(tmpDir, "styles.css")
+	os.WriteFile(filepath.Join(tmpDir, "a.d2"), []byte("a -> b"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.d2"), []byte("x: {shape: circle}\nx -> y: label"), 0644)
+
+	for _, name := range []string{"a", "b", "a"} {
+		cmd := newTestRootCmd()
+		cmd.SetArgs([]string{"render", filepath.Join(tmpDir, name+".d2"), "-o", filepath.Join(tmpDir, name+".svg"), "--css", cssPath})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Render of %s.d2 with --css failed: %v", name, err)
+		}
+	}
+
+	css, err := os.ReadFile(cssPath)
+	if err != nil {
+		t.Fatalf("CSS file was not created: %v", err)
+	}
+	diagramClass := regexp.MustCompile(`d2-[0-9]+`)
+	styleClass := regexp.MustCompile(`\bs-[0-9a-f]{8}\b`)
+	for _, name := range []string{"a", "b"} {
+		svg, _ := os.ReadFile(filepath.Join(tmpDir, name+".svg"))
+		class := diagramClass.Find(svg)
+		if class == nil {
+			t.Fatalf("Expected a D2 diagram class in %s.svg", name)
+		}
+		if !bytes.Contains(css, append([]byte("."), class...)) {
+			t.Errorf("Expected the CSS to keep the rules of %s.svg (%s)", name, class)
+		}
+		for _, class := range styleClass.FindAll(svg, -1) {
+			if !bytes.Contains(css, append([]byte("."), class...)) {
+				t.Errorf("Expected the CSS to define %s from %s.svg", class, name)
+			}
+		}
+	}
+
+	// Rendering a diagram again doesn't repeat its rules
+	rule := ".s-" + styleClass.FindString(string(css))[2:] + " {"
+	if n := strings.Count(string(css), rule); n != 1 {
+		t.Errorf("Expected %s to be defined once, got %d", rule, n)
+	}
+}
+
+func TestValidateCommand_SelfLoopWarning(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "loop.d2")
+
+	os.WriteFile(inputFile, []byte("idle -> running\nrunning -> running"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"validate", inputFile})
+
+	// Self-loops are warnings, not errors
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("validate should succeed with a self-loop warning: %v", err)
+	}
+}
+
+func TestValidateCommand_Strict(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "dup.d2")
+
+	os.WriteFile(inputFile, []byte("a -> b: x\na -> b: x"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"validate", inputFile, "--strict"})
+
+	// Duplicate edges are warnings, not errors
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("validate --strict should succeed with a duplicate edge warning: %v", err)
+	}
+}
+
+func TestResolveRenderConfig_PageSize(t *testing.T) {
+	outputFile = "diagram.pdf"
+	outputFormat = "svg"
+	pageSize = "A4"
+	landscape = true
+	defer func() { pageSize = ""; landscape = false }()
+
+	cfg, err := resolveRenderConfig("diagram.d2")
+	if err != nil {
+		t.Fatalf("resolveRenderConfig failed: %v", err)
+	}
+	if cfg.opts.PDFPageSize != render.PageSizeA4 {
+		t.Errorf("Expected page size a4, got %q", cfg.opts.PDFPageSize)
+	}
+	if !cfg.opts.PDFLandscape {
+		t.Error("Expected landscape orientation")
+	}
+}
+
+func TestResolveRenderConfig_InvalidPageSize(t *testing.T) {
+	outputFile = ""
+	outputFormat = "pdf"
+	pageSize = "postcard"
+	defer func() { pageSize = "" }()
+
+	_, err := resolveRenderConfig("diagram.d2")
+	if err == nil || !strings.Contains(err.Error(), "unsupported page size") {
+		t.Errorf("Expected 'unsupported page size' error, got: %v", err)
+	}
+}
+
+func TestResolveRenderConfig_LandscapeRequiresPageSize(t *testing.T) {
+	outputFile = ""
+	outputFormat = "pdf"
+	pageSize = ""
+	landscape = true
+	defer func() { landscape = false }()
+
+	if _, err := resolveRenderConfig("diagram.d2"); err == nil {
+		t.Error("Expected error for --landscape without --page-size")
+	}
+}
+
+func TestRenderCommand_PDFPageSizeLandscape(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "a4.pdf")
+
+	os.WriteFile(inputFile, []byte("direction: right\nclient -> api -> db"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--page-size", "a4", "--landscape"})
+	if err := cmd.Execute(); errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("PDF with page size failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.HasPrefix(string(content), "%PDF-") {
+		t.Fatal("Output should be PDF")
+	}
+	// A4 landscape MediaBox is roughly 842 x 595 points
+	if !strings.Contains(string(content), "/MediaBox [0 0 841") && !strings.Contains(string(content), "/MediaBox [0 0 842") {
+		t.Error("Expected an A4 landscape MediaBox")
+	}
+}
+
+func TestRenderCommand_Include(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "include.svg")
+
+	os.WriteFile(filepath.Join(tmpDir, "common.d2"), []byte("db: Shared Database"), 0644)
+	os.WriteFile(inputFile, []byte("# @include common.d2\napi -> db"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with include failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(string(content), "Shared Database") {
+		t.Error("Output should contain the label from the included file")
+	}
+}
+
+func TestRenderCommand_IncludeCycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("# @include test.d2\na -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg")})
+	err := cmd.Execute()
+
+	if err == nil {
+		t.Fatal("Expected error for cyclic include")
+	}
+	if !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expected include cycle error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_Quiet(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "quiet.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--quiet"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --quiet failed: %v", err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("Expected no output in quiet mode, got: %q", out.String())
+	}
+	if _, err := os.Stat(outputFilePath); os.IsNotExist(err) {
+		t.Error("Output file was not created")
+	}
+}
+
+func TestRenderCommand_JSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "result.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --json failed: %v", err)
+	}
+
+	var result struct {
+		Input      string `json:"input"`
+		Output     string `json:"output"`
+		Bytes      int    `json:"bytes"`
+		DurationMs *int64 `json:"durationMs"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+	}
+
+	info, err := os.Stat(outputFilePath)
+	if err != nil {
+		t.Fatalf("Output file was not created: %v", err)
+	}
+	if result.Bytes != int(info.Size()) {
+		t.Errorf("Expected bytes %d, got %d", info.Size(), result.Bytes)
+	}
+	if result.Input != inputFile || result.Output != outputFilePath {
+		t.Errorf("Unexpected input/output: %q → %q", result.Input, result.Output)
+	}
+	if result.DurationMs == nil {
+		t.Error("Expected durationMs in JSON output")
+	}
+}
+
+func TestRenderCommand_JSONError(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "invalid.d2")
+
+	os.WriteFile(inputFile, []byte("a -> {"), 0644)
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "--json"})
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("Expected error for invalid D2 syntax")
+	}
+
+	var result map[string]string
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+	}
+	if result["error"] == "" {
+		t.Errorf("Expected an error message, got: %s", out.String())
+	}
+}
+
+func TestRenderCommand_MergeEdges(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "merged.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b: first\na -> b: second\na -> b: third"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--merge-edges"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --merge-edges failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(string(content), "first, second, third") {
+		t.Error("Output should contain the merged edge label")
+	}
+}
+
+func TestRenderCommand_Palette(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "palette.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--palette", "material"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --palette failed: %v", err)
+	}
+
+	colors, _ := ir.PaletteByName("material")
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(strings.ToUpper(string(content)), colors[0]) {
+		t.Errorf("Output should use the palette's first color %s", colors[0])
+	}
+}
+
+func TestRenderCommand_InvalidPalette(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "--palette", "neon"})
+	err := cmd.Execute()
+
+	if err == nil {
+		t.Fatal("Expected error for unknown palette")
+	}
+	if !strings.Contains(err.Error(), "unsupported palette") {
+		t.Errorf("Expected unsupported palette error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_FromJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "model.json")
+	outputFilePath := filepath.Join(tmpDir, "model.svg")
+
+	diagram := &ir.Diagram{
+		ID: "model",
+		Nodes: []*ir.Node{
+			{ID: "api", Label: "API Gateway", Shape: ir.ShapeRectangle},
+			{ID: "db", Label: "Orders DB", Shape: ir.ShapeCylinder},
+		},
+		Edges: []*ir.Edge{
+			{ID: "api->db", Source: "api", Target: "db", Label: "reads", Direction: ir.DirectionForward},
+		},
+	}
+	data, _ := json.Marshal(diagram)
+	os.WriteFile(inputFile, data, 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--from", "json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render from JSON failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(string(content), "Orders DB") {
+		t.Error("Output should contain the node label from the JSON diagram")
+	}
+}
+
+func TestRenderCommand_NoLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "model.json")
+	outputFilePath := filepath.Join(tmpDir, "model.svg")
+
+	diagram := &ir.Diagram{
+		ID: "model",
+		Nodes: []*ir.Node{
+			{ID: "api", Label: "API", Shape: ir.ShapeRectangle, Width: 120, Height: 60,
+				Position: &ir.Position{X: 40, Y: 300, Source: ir.PositionSourceManual}},
+			{ID: "db", Label: "DB", Shape: ir.ShapeRectangle, Width: 100, Height: 80,
+				Position: &ir.Position{X: 500, Y: 20, Source: ir.PositionSourceManual}},
+		},
+		Edges: []*ir.Edge{
+			{ID: "api->db", Source: "api", Target: "db", Direction: ir.DirectionForward},
+		},
+	}
+	data, _ := json.Marshal(diagram)
+	os.WriteFile(inputFile, data, 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--no-layout", "-q"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render without layout failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	rects := regexp.MustCompile(`<rect x="([0-9.-]+)" y="([0-9.-]+)" width="([0-9.]+)" height="([0-9.]+)"`).FindAllStringSubmatch(string(content), -1)
+	for _, node := range diagram.Nodes {
+		found := false
+		for _, m := range rects {
+			x, _ := strconv.ParseFloat(m[1], 64)
+			y, _ := strconv.ParseFloat(m[2], 64)
+			w, _ := strconv.ParseFloat(m[3], 64)
+			h, _ := strconv.ParseFloat(m[4], 64)
+			if math.Abs(x-node.Position.X) < 0.5 && math.Abs(y-node.Position.Y) < 0.5 && math.Abs(w-node.Width) < 0.5 && math.Abs(h-node.Height) < 0.5 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s drawn at (%g, %g), got rects %v", node.ID, node.Position.X, node.Position.Y, rects)
+		}
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", filepath.Join(tmpDir, "model.d2"), "--no-layout"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--no-layout requires JSON input") {
+		t.Errorf("Expected --no-layout to be rejected for D2 input, got %v", err)
+	}
+}
+
+func TestRenderCommand_FromJSONInvalid(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "model.json")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg")})
+	err := cmd.Execute()
+
+	if err == nil {
+		t.Fatal("Expected error for invalid JSON input")
+	}
+	if !strings.Contains(err.Error(), "failed to parse JSON diagram") {
+		t.Errorf("Expected JSON parse error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_InvalidInputFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "--from", "yaml"})
+	err := cmd.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "unsupported input format") {
+		t.Errorf("Expected unsupported input format error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_Highlight(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "focus.svg")
+
+	os.WriteFile(inputFile, []byte("server -> database\ndatabase -> cache\ncache -> queue"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--highlight", "server,database"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --highlight failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if !strings.Contains(string(content), "opacity:0.25") {
+		t.Error("Output should contain dimmed elements")
+	}
+}
+
+func TestRenderCommand_HighlightUnknownNode(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("server -> database"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "--highlight", "cache"})
+	err := cmd.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "unknown node in --highlight") {
+		t.Errorf("Expected unknown node error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_Focus(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "chain.d2")
+	outputFilePath := filepath.Join(tmpDir, "focus.svg")
+
+	os.WriteFile(inputFile, []byte("a -> b\nb -> c\nc -> d"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--focus", "b", "--depth", "1"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --focus failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	for _, id := range []string{"a", "b", "c"} {
+		if !strings.Contains(string(content), ">"+id+"</text>") {
+			t.Errorf("Expected node %s in the focus render", id)
+		}
+	}
+	if strings.Contains(string(content), ">d</text>") {
+		t.Error("Expected node d, two edges from b, to be left out")
+	}
+	if strings.Count(string(content), `class="connection stroke`) != 2 {
+		t.Errorf("Expected only the edges a -> b and b -> c, got %d", strings.Count(string(content), `class="connection stroke`))
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--focus", "e"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown node in --focus") {
+		t.Errorf("Expected unknown node error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_C4WithDiagramTransforms(t *testing.T) {
+	tmpDir := t.TempDir()
+	classFile := filepath.Join(tmpDir, "classes.d2")
+	os.WriteFile(classFile, []byte("u: User {class: c4-person}\ns: System {class: c4-system}\nu -> s: Uses\nu -> s: Pays"), 0644)
+
+	// Each flag renders from the parsed diagram rather than the source
+	transforms := [][]string{
+		{"--merge-edges"},
+		{"--palette", "pastel"},
+		{"--collapse", "banking"},
+		{"--highlight", "banking"},
+		{"--focus", "banking"},
+		{"--group-by-tag"},
+	}
+	for _, file := range []string{"../../../examples/c4/01-system-context.d2", "../../../examples/c4/04-with-theme.d2", classFile} {
+		for _, flags := range transforms {
+			// The class example has no banking node
+			if file == classFile && len(flags) == 2 && flags[1] == "banking" {
+				flags = []string{flags[0], "s"}
+			}
+			name := filepath.Base(file) + " " + strings.Join(flags, " ")
+			t.Run(name, func(t *testing.T) {
+				outputFilePath := filepath.Join(tmpDir, "c4.svg")
+				cmd := newTestRootCmd()
+				cmd.SetArgs(append([]string{"render", file, "-o", outputFilePath, "--c4"}, flags...))
+				if err := cmd.Execute(); err != nil {
+					t.Fatalf("Render failed: %v", err)
+				}
+				content, _ := os.ReadFile(outputFilePath)
+				if file == classFile && !strings.Contains(string(content), `fill="#08427b"`) {
+					t.Error("Expected the c4-person class fill")
+				}
+			})
+		}
+	}
+}
+
+func TestRenderCommand_CustomFont(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "font.svg")
+
+	os.WriteFile(inputFile, []byte("server -> database"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath,
+		"--font-regular", "../../../testdata/fonts/SourceCodePro-Regular.ttf"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --font-regular failed: %v", err)
+	}
+
+	if _, err := os.Stat(outputFilePath); os.IsNotExist(err) {
+		t.Error("Output file was not created")
+	}
+}
+
+func TestRenderCommand_Timeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+
+	os.WriteFile(inputFile, []byte("a -> b -> c"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"), "--timeout", "1ns"})
+	err := cmd.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "rendering timed out") {
+		t.Errorf("Expected timeout error, got: %v", err)
+	}
+}
+
+func TestValidateCommand_Timeout(t *testing.T) {
+	if err := runWithTimeout(10*time.Millisecond, func() error {
+		time.Sleep(time.Second)
+		return nil
+	}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"validate", inputFile, "--timeout", "1m"})
+	if err := cmd.Execute(); err != nil {
+		t.Errorf("validate with a generous timeout failed: %v", err)
+	}
+}
+
+func TestRenderCommand_Collapse(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFilePath := filepath.Join(tmpDir, "overview.svg")
+
+	os.WriteFile(inputFile, []byte("aws: {\n  vpc: {\n    web: Web Tier\n    db: Database\n    web -> db\n  }\n}\nuser -> aws.vpc.web: visits"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--collapse", "aws.vpc"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --collapse failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(outputFilePath)
+	if strings.Contains(string(content), "Web Tier") {
+		t.Error("Collapsed container contents should not be rendered")
+	}
+	if !strings.Contains(string(content), "visits") {
+		t.Error("Edge into the collapsed container should still be rendered")
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFilePath, "--collapse", "aws.missing"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown container") {
+		t.Errorf("Expected unknown container error, got: %v", err)
+	}
+}
+
+func TestRenderCommand_Minify(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	fullPath := filepath.Join(tmpDir, "full.svg")
+	minPath := filepath.Join(tmpDir, "min.svg")
+
+	os.WriteFile(inputFile, []byte("server: Web Server\nserver -> database"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", fullPath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", minPath, "--minify"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render with --minify failed: %v", err)
+	}
+
+	full, _ := os.ReadFile(fullPath)
+	minified, _ := os.ReadFile(minPath)
+	if len(minified) >= len(full) {
+		t.Errorf("Minified output should be smaller: %d >= %d bytes", len(minified), len(full))
+	}
+	if !strings.Contains(string(minified), "Web Server") {
+		t.Error("Minified output should contain the node label")
+	}
+}
+
+func TestExamplesCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"examples", "list"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("examples list failed: %v", err)
+	}
+	for _, want := range []string{"01-basic-shapes", "07-microservices", "c4/01-system-context"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %s in example list, got:\n%s", want, out.String())
+		}
+	}
+
+	tmpDir := filepath.Join(t.TempDir(), "starter")
+	cmd = newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"examples", "write", "c4/01-system-context", tmpDir})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("examples write failed: %v", err)
+	}
+
+	outputFile := filepath.Join(tmpDir, "01-system-context.d2")
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Expected example to be written: %v", err)
+	}
+	if _, err := parser.NewD2Parser().Parse(string(content)); err != nil {
+		t.Errorf("Written example does not parse: %v", err)
+	}
+
+	// Existing files are not overwritten
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"examples", "write", "01-system-context", tmpDir})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an error for an existing file, got %v", err)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"examples", "write", "no-such-example", tmpDir})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown example") {
+		t.Errorf("Expected an error for an unknown example, got %v", err)
+	}
+}
+
+func TestRenderCommand_NativeRasterizer(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFile := filepath.Join(tmpDir, "test.png")
+	os.WriteFile(inputFile, []byte("server -> database"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFile, "--rasterizer", "native", "-q"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render command failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		t.Error("Expected PNG output")
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "test.svg"), "--rasterizer", "native"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "requires PNG output") {
+		t.Errorf("Expected --rasterizer to be rejected for SVG output, got %v", err)
+	}
+}
+
+func TestRenderCommand_Fit(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	outputFile := filepath.Join(tmpDir, "test.svg")
+	os.WriteFile(inputFile, []byte("server -> database"), 0644)
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFile, "--fit", "1920x1080", "-q"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render command failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`viewBox="0 0 1920 1080" width="1920" height="1080"`)) {
+		t.Errorf("Expected a 1920x1080 SVG, got:\n%.300s", data)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputFile, "--fit", "16:9"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "invalid fit box") {
+		t.Errorf("Expected an invalid --fit value to be rejected, got %v", err)
+	}
+}
+
+func TestRenderCommand_Formats(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	os.WriteFile(inputFile, []byte("server -> database"), 0644)
+
+	renders := 0
+	original := renderSource
+	renderSource = func(ctx context.Context, source string, opts render.Options) ([]byte, error) {
+		renders++
+		return original(ctx, source, opts)
+	}
+	t.Cleanup(func() { renderSource = original })
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-o", filepath.Join(tmpDir, "out.svg"),
+		"--formats", "svg,png", "--rasterizer", "native", "-q"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("render command failed: %v", err)
+	}
+
+	svg, err := os.ReadFile(filepath.Join(tmpDir, "out.svg"))
+	if err != nil || !bytes.Contains(svg, []byte("<svg")) {
+		t.Errorf("Expected an SVG output, got %v", err)
+	}
+	png, err := os.ReadFile(filepath.Join(tmpDir, "out.png"))
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Errorf("Expected a PNG output, got %v", err)
+	}
+	if renders != 1 {
+		t.Errorf("Expected the diagram to be rendered once, got %d", renders)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "-f", "png", "--formats", "svg,png"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--formats") {
+		t.Errorf("Expected --format with --formats to be rejected, got %v", err)
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetArgs([]string{"render", inputFile, "--formats", "svg,gif"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unsupported output format: gif") {
+		t.Errorf("Expected an unknown format to be rejected, got %v", err)
+	}
+}
+
+func TestSchemaCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"schema"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("schema failed: %v", err)
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(out.Bytes(), &schema); err != nil {
+		t.Fatalf("schema output is not JSON: %v", err)
+	}
+	if schema["$ref"] != "#/definitions/Diagram" {
+		t.Errorf("Expected the schema to describe a diagram, got %v", schema["$ref"])
+	}
+}
+
+func TestThemesCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"themes"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("themes failed: %v", err)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if !strings.Contains(out.String(), "Neutral Default") {
+		t.Errorf("Expected the default theme in the list, got:\n%s", out.String())
+	}
+	for id := 0; id <= 8; id++ {
+		if id == 2 {
+			continue // D2 has no theme 2
+		}
+		found := false
+		for _, line := range lines {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == strconv.Itoa(id) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected theme %d in the list, got:\n%s", id, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "Dark Mauve") {
+		t.Errorf("Expected dark themes in the list, got:\n%s", out.String())
+	}
+}
+
+func TestServeCommand_Open(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	opened := make(chan string, 1)
+	defer func(orig func(string) error) { openBrowser = orig }(openBrowser)
+	openBrowser = func(url string) error {
+		opened <- url
+		return nil
+	}
+
+	inputFile := filepath.Join(t.TempDir(), "test.d2")
+	os.WriteFile(inputFile, []byte("a -> b"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"serve", inputFile, "--port", strconv.Itoa(port), "--open"})
+	go func() { done <- cmd.ExecuteContext(ctx) }()
+
+	select {
+	case url := <-opened:
+		if want := fmt.Sprintf("http://localhost:%d", port); url != want {
+			t.Errorf("Expected browser opened at %s, got %s", want, url)
+		}
+	case err := <-done:
+		t.Fatalf("serve exited before opening the browser: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the browser to be opened")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for serve to stop")
+	}
+}
+
+func TestDiffCommand_Image(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldFile := filepath.Join(tmpDir, "old.d2")
+	newFile := filepath.Join(tmpDir, "new.d2")
+	outFile := filepath.Join(tmpDir, "diff.svg")
+	if err := os.WriteFile(oldFile, []byte("api -> db"), 0644); err != nil {
+		t.Fatalf("Failed to write old file: %v", err)
+	}
+	if err := os.WriteFile(newFile, []byte("api -> db\ncache"), 0644); err != nil {
+		t.Fatalf("Failed to write new file: %v", err)
+	}
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"diff", oldFile, newFile, "--image", outFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	if out.String() != "+ node cache\n" {
+		t.Errorf("Expected the added node in the summary, got:\n%s", out.String())
+	}
+
+	svg, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("Failed to read diff image: %v", err)
+	}
+	if !strings.Contains(string(svg), "cache") || !strings.Contains(strings.ToUpper(string(svg)), `FILL="#E8F5E9"`) {
+		t.Error("Expected the added node highlighted in green in the diff image")
+	}
+
+	cmd = newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"diff", oldFile, oldFile, "--image", filepath.Join(tmpDir, "diff.png")})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), ".svg") {
+		t.Errorf("Expected an error for a non-SVG image, got %v", err)
+	}
+}
+
+func TestInputFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	pumlFile := filepath.Join(tmpDir, "sequence.puml")
+	d2File := filepath.Join(tmpDir, "diagram.d2")
+	if err := os.WriteFile(pumlFile, []byte("@startuml\nA -> B\n@enduml"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	if err := os.WriteFile(d2File, []byte("a -> b"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	// The extension selects PlantUML instead of parsing the file as D2
+	for _, command := range []string{"render", "validate"} {
+		cmd := newTestRootCmd()
+		cmd.SetArgs([]string{command, pumlFile})
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "plantuml input") {
+			t.Errorf("%s: expected the PlantUML parser to be selected, got %v", command, err)
+		}
+	}
+
+	// An explicit format overrides the extension
+	for _, command := range []string{"render", "validate"} {
+		cmd := newTestRootCmd()
+		cmd.SetArgs([]string{command, d2File, "--input-format", "mermaid"})
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "mermaid line 1: only flowcharts") {
+			t.Errorf("%s: expected the Mermaid parser to be selected, got %v", command, err)
+		}
+	}
+
+	cmd := newTestRootCmd()
+	cmd.SetArgs([]string{"validate", pumlFile, "--input-format", "d2"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "d2 compilation failed") {
+		t.Errorf("Expected --input-format d2 to parse the file as D2, got %v", err)
+	}
+}
+
+func TestConvertCommand_JSONToD2(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "model.json")
+	outputFilePath := filepath.Join(tmpDir, "model.d2")
+
+	diagram := &ir.Diagram{
+		ID: "model",
+		Nodes: []*ir.Node{
+			{ID: "web", Label: "Web App", Shape: ir.ShapeRectangle},
+			{ID: "api", Label: "API Gateway", Shape: ir.ShapeRectangle},
+			{ID: "db", Label: "Orders DB", Shape: ir.ShapeCylinder},
+		},
+		Edges: []*ir.Edge{
+			{ID: "web->api", Source: "web", Target: "api", Label: "calls", Direction: ir.DirectionForward},
+			{ID: "api->db", Source: "api", Target: "db", Label: "reads", Direction: ir.DirectionForward},
+		},
+	}
+	data, _ := json.Marshal(diagram)
+	if err := os.WriteFile(inputFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", inputFile, "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("convert command failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	converted, err := parser.NewD2Parser().Parse(string(content))
+	if err != nil {
+		t.Fatalf("Generated D2 should parse: %v\n%s", err, content)
+	}
+	if len(converted.Nodes) != len(diagram.Nodes) || len(converted.Edges) != len(diagram.Edges) {
+		t.Errorf("Expected %d nodes and %d edges, got %d and %d\n%s",
+			len(diagram.Nodes), len(diagram.Edges), len(converted.Nodes), len(converted.Edges), content)
+	}
+	if node := converted.GetNode("db"); node == nil || node.Label != "Orders DB" || node.Shape != ir.ShapeCylinder {
+		t.Errorf("Expected db to keep its label and shape, got %+v", node)
+	}
+}
+
+func TestConvertCommand_D2ToJSON(t *testing.T) {
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", "../../../examples/07-microservices.d2", "--to", "json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("convert command failed: %v", err)
+	}
+
+	var diagram ir.Diagram
+	if err := json.Unmarshal(out.Bytes(), &diagram); err != nil {
+		t.Fatalf("Output should be JSON IR: %v", err)
+	}
+	if diagram.GetNode("gateway") == nil || len(diagram.Edges) == 0 {
+		t.Errorf("Expected the microservices nodes and edges, got %d nodes and %d edges", len(diagram.Nodes), len(diagram.Edges))
+	}
+}
+
+func TestConvertCommand_ExampleRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../../examples/*.d2")
+	if err != nil {
+		t.Fatalf("Failed to glob examples: %v", err)
+	}
+	c4Files, _ := filepath.Glob("../../../examples/c4/*.d2")
+	files = append(files, c4Files...)
+	if len(files) == 0 {
+		t.Skip("No example files found")
+	}
+
+	// summary lists a board's nodes and edges with the fields D2 output
+	// carries, in diagram order
+	summary := func(d *ir.Diagram) []string {
+		var lines []string
+		for _, node := range d.Nodes {
+			lines = append(lines, fmt.Sprintf("node %s %q %s %q", node.ID, node.Label, node.Shape, node.Properties["tooltip"]))
+		}
+		for _, edge := range d.Edges {
+			lines = append(lines, fmt.Sprintf("edge %s %s %s %q", edge.Source, edge.Direction, edge.Target, edge.Label))
+		}
+		return lines
+	}
+
+	tmpDir := t.TempDir()
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			outputFilePath := filepath.Join(tmpDir, filepath.Base(file))
+			cmd := newTestRootCmd()
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetArgs([]string{"convert", file, "-o", outputFilePath})
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("convert failed: %v", err)
+			}
+
+			original, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read example: %v", err)
+			}
+			converted, err := os.ReadFile(outputFilePath)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			want, err := parser.NewD2Parser().Parse(string(original))
+			if err != nil {
+				t.Fatalf("Parse of the example failed: %v", err)
+			}
+			got, err := parser.NewD2Parser().Parse(string(converted))
+			if err != nil {
+				t.Fatalf("Converted D2 failed to parse: %v\n%s", err, converted)
+			}
+			if errs := got.Validate(); len(errs) > 0 {
+				t.Fatalf("Converted D2 is invalid: %v\n%s", errs, converted)
+			}
+			if w, g := strings.Join(summary(want), "\n"), strings.Join(summary(got), "\n"); w != g {
+				t.Errorf("Converted diagram differs\nwant:\n%s\ngot:\n%s", w, g)
+			}
+		})
+	}
+}
+
+func TestConvertCommand_Mermaid(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputFilePath := filepath.Join(tmpDir, "microservices.mmd")
+
+	var out bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"convert", "../../../examples/07-microservices.d2", "-o", outputFilePath})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("convert command failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "graph TD\n") || !strings.Contains(string(content), "web -->|HTTPS| gateway") {
+		t.Errorf("Expected a Mermaid flowchart from the output extension, got:\n%s", content)
+	}
+}
+
+func TestConvertCommand_MermaidToD2(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "flow.mmd")
+	outputFilePath := filepath.Join(tmpDir, "flow.d2")
+	flowchart := `flowchart LR
+    user((User)) -->|"orders $5 ${item}"| web[Web shop]
+    subgraph backend [Back end]
+      web --> api{{API}}
+      api -.-> db[(Orders DB)]
+    end
+    api -- retries --> api`
+	if err := os.WriteFile(inputFile, []byte(flowchart), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	cmd := newTestRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"convert", inputFile, "-o", outputFilePath, "--from", "mermaid", "--to", "d2"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("convert command failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	want, err := parser.NewMermaidParser().Parse(flowchart)
+	if err != nil {
+		t.Fatalf("Mermaid parse failed: %v", err)
+	}
+	got, err := parser.NewD2Parser().Parse(string(content))
+	if err != nil {
+		t.Fatalf("Converted D2 does not parse: %v\n%s", err, content)
+	}
+	if len(got.Nodes) != len(want.Nodes) || len(got.Edges) != len(want.Edges) {
+		t.Errorf("Expected %d nodes and %d edges, got %d and %d\n%s",
+			len(want.Nodes), len(want.Edges), len(got.Nodes), len(got.Edges), content)
+	}
+	for _, id := range []string{"user", "backend.web", "backend.api", "backend.db"} {
+		if got.GetNode(id) == nil {
+			t.Errorf("Missing node %s in converted D2:\n%s", id, content)
+		}
+	}
+	if got.Config.Direction != "right" || got.GetNode("backend.db").Shape != ir.ShapeCylinder {
+		t.Errorf("Expected the direction and shapes to be kept:\n%s", content)
+	}
+	if label := got.Edges[0].Label; label != "orders $5 ${item}" {
+		t.Errorf("Expected the link label kept, got %q", label)
+	}
+}
+
+func TestConvertCommand_UnsupportedFormats(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"convert", "../../../examples/01-basic-shapes.d2", "--from", "plantuml"}, "unsupported input format: plantuml"},
+		{[]string{"convert", "../../../examples/01-basic-shapes.d2", "--to", "svg"}, "unsupported output format"},
+	} {
+		cmd := newTestRootCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs(tt.args)
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: expected an error containing %q, got %v", tt.args, tt.want, err)
+		}
+	}
+}
+
+// ttyBuffer is a buffer that reports itself as a terminal to isTerminal.
+type ttyBuffer struct {
+	bytes.Buffer
+}
+
+func (b *ttyBuffer) Stat() (os.FileInfo, error) {
+	return ttyFileInfo{}, nil
+}
+
+// ttyFileInfo describes a character device, as terminals are.
+type ttyFileInfo struct{ os.FileInfo }
+
+func (ttyFileInfo) Mode() os.FileMode { return os.ModeDevice | os.ModeCharDevice }
+
+func TestRenderCommand_Progress(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputFile := filepath.Join(tmpDir, "test.d2")
+	if err := os.WriteFile(inputFile, []byte("a -> b"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	outputBase := filepath.Join(tmpDir, "test.svg")
+
+	// Pipes and logs get no spinner
+	var stderr bytes.Buffer
+	cmd := newTestRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputBase, "--formats", "svg,md"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.ContainsAny(stderr.String(), "\r\033") {
+		t.Errorf("Expected no progress control characters without a terminal, got %q", stderr.String())
+	}
+
+	// A terminal shows the render and each output with a counter
+	var tty ttyBuffer
+	cmd = newTestRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&tty)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputBase, "--formats", "svg,md"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, want := range []string{"Rendering " + inputFile, "[1/2] Writing " + outputBase, "[2/2] Writing "} {
+		if !strings.Contains(tty.String(), want) {
+			t.Errorf("Expected progress %q, got %q", want, tty.String())
+		}
+	}
+	if !strings.HasSuffix(tty.String(), "\r\033[K") {
+		t.Errorf("Expected the progress line to be cleared at the end, got %q", tty.String())
+	}
+
+	// --quiet silences it on a terminal too
+	tty.Reset()
+	cmd = newTestRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&tty)
+	cmd.SetArgs([]string{"render", inputFile, "-o", outputBase, "--quiet"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if tty.Len() != 0 {
+		t.Errorf("Expected no progress with --quiet, got %q", tty.String())
+	}
+}
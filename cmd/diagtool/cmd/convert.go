@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
+	"github.com/mark/dsl-diagram-tool/pkg/render"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <input>",
+	Short: "Convert a diagram between formats",
+	Long: `Convert a diagram from one format to another through the internal
+representation (IR): the input is parsed into IR, which is written out in the
+output format.
+
+Supported formats:
+  - d2: D2 source
+  - json: JSON IR, as accepted by render and produced by the editor
+  - mermaid: Mermaid flowchart
+  - plantuml: PlantUML component diagram (output only)
+
+Formats default to the file extensions: .json is JSON IR, .mmd and .mermaid
+are Mermaid, .puml, .plantuml and .pu are PlantUML, and anything else is D2.
+The output format is D2 when writing to standard output.
+
+Examples:
+  # Generate D2 from JSON IR
+  diagtool convert diagram.json -o diagram.d2
+
+  # Write the IR of a D2 diagram to standard output
+  diagtool convert diagram.d2 --to json
+
+  # Generate a Mermaid flowchart from D2
+  diagtool convert diagram.d2 -o diagram.mmd
+
+  # Migrate a Mermaid flowchart to D2
+  diagtool convert flow.mmd -o flow.d2`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConvert,
+}
+
+var (
+	convertOutput string
+	convertFrom   string
+	convertTo     string
+)
+
+func init() {
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "Output file path (default: standard output)")
+	convertCmd.Flags().StringVar(&convertFrom, "from", "", "Input format: d2, json, mermaid (default: from the input file extension)")
+	convertCmd.Flags().StringVar(&convertTo, "to", "", "Output format: d2, json, mermaid, plantuml (default: from the output file extension, otherwise d2)")
+}
+
+func runConvert(cmd *cobra.Command, args []string) error {
+	inputFile := args[0]
+
+	from := strings.ToLower(convertFrom)
+	if from == "" {
+		from = formatFromPath(inputFile)
+	}
+	to := strings.ToLower(convertTo)
+	if to == "" {
+		to = parser.FormatD2
+		if convertOutput != "" {
+			to = formatFromPath(convertOutput)
+		}
+	}
+
+	// Check the formats before doing any work
+	switch from {
+	case parser.FormatD2, "json", parser.FormatMermaid:
+	default:
+		return fmt.Errorf("unsupported input format: %s (use d2, json, or mermaid)", from)
+	}
+	switch to {
+	case parser.FormatD2, "json", parser.FormatMermaid, parser.FormatPlantUML:
+	default:
+		return fmt.Errorf("unsupported output format: %s (use d2, json, mermaid, or plantuml)", to)
+	}
+
+	content, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	var diagram *ir.Diagram
+	if from == "json" {
+		diagram = &ir.Diagram{}
+		if err := json.Unmarshal(content, diagram); err != nil {
+			return fmt.Errorf("failed to parse JSON diagram: %w", err)
+		}
+	} else {
+		p, err := parser.For(from)
+		if err != nil {
+			return err
+		}
+		if diagram, err = parser.ParseFile(p, string(content), inputFile); err != nil {
+			return fmt.Errorf("failed to parse diagram: %w", err)
+		}
+	}
+
+	var output []byte
+	switch to {
+	case "json":
+		if output, err = json.MarshalIndent(diagram, "", "  "); err != nil {
+			return fmt.Errorf("failed to encode diagram: %w", err)
+		}
+		output = append(output, '\n')
+	case parser.FormatMermaid:
+		output = []byte(ir.ToMermaid(diagram))
+	case parser.FormatPlantUML:
+		output = []byte(ir.ToPlantUML(diagram))
+	default:
+		output = []byte(render.GenerateD2(diagram))
+	}
+
+	if convertOutput == "" {
+		_, err := cmd.OutOrStdout().Write(output)
+		return err
+	}
+	if err := os.WriteFile(convertOutput, output, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", convertOutput)
+	return nil
+}
+
+// formatFromPath returns the format of a diagram file from its extension:
+// json for .json, and otherwise as parser.FormatFromPath.
+func formatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return "json"
+	}
+	return parser.FormatFromPath(path)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
+	"github.com/mark/dsl-diagram-tool/pkg/render"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.d2> <new.d2>",
+	Short: "Show what changed between two D2 diagrams",
+	Long: `Compare two D2 diagram files by node and edge ID and list the nodes
+and edges that were added (+), removed (-), or changed (~). Layout is
+ignored.
+
+With --image, also render the new diagram as an SVG for review, with added
+nodes and edges in green, changed ones in amber, and removed ones ghosted
+in red.
+
+Examples:
+  # List the changes
+  diagtool diff old.d2 new.d2
+
+  # Render a visual diff for a pull request
+  diagtool diff old.d2 new.d2 --image diff.svg`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDiff,
+}
+
+var diffImage string
+
+func init() {
+	diffCmd.Flags().StringVar(&diffImage, "image", "", "Render the new diagram with the changes highlighted to this SVG file")
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	if diffImage != "" && !strings.EqualFold(filepath.Ext(diffImage), ".svg") {
+		return fmt.Errorf("--image must be an .svg file")
+	}
+
+	var diagrams [2]*ir.Diagram
+	for i, inputFile := range args {
+		content, err := os.ReadFile(inputFile)
+		if err != nil {
+			return fmt.Errorf("failed to read input file: %w", err)
+		}
+		diagrams[i], err = parser.NewD2Parser().ParseFile(string(content), inputFile)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", inputFile, err)
+		}
+	}
+	old, new := diagrams[0], diagrams[1]
+
+	diff := ir.Diff(old, new)
+	out := cmd.OutOrStdout()
+	for _, change := range []struct {
+		mark, kind string
+		ids        []string
+	}{
+		{"+", "node", diff.AddedNodes},
+		{"-", "node", diff.RemovedNodes},
+		{"~", "node", slices.Concat(diff.ChangedNodes, diff.StyledNodes)},
+		{"+", "edge", diff.AddedEdges},
+		{"-", "edge", diff.RemovedEdges},
+		{"~", "edge", slices.Concat(diff.ChangedEdges, diff.StyledEdges)},
+	} {
+		for _, id := range change.ids {
+			fmt.Fprintf(out, "%s %s %s\n", change.mark, change.kind, id)
+		}
+	}
+	if diff.ConfigChanged {
+		fmt.Fprintln(out, "~ diagram configuration")
+	}
+	if diff.IsEmpty() {
+		fmt.Fprintln(out, "No changes")
+	}
+
+	if diffImage == "" {
+		return nil
+	}
+	svg, err := render.RenderFromIR(context.Background(), render.DiffDiagram(old, new), render.DefaultOptions())
+	if err != nil {
+		return fmt.Errorf("rendering failed: %w", err)
+	}
+	if err := os.WriteFile(diffImage, svg, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
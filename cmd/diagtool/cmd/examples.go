@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/examples"
+)
+
+var examplesCmd = &cobra.Command{
+	Use:   "examples",
+	Short: "List and write bundled example diagrams",
+	Long: `List and write the example D2 diagrams bundled with diagtool, as
+starting points for your own diagrams.
+
+Examples:
+  # Show the available examples
+  diagtool examples list
+
+  # Write an example to the current directory
+  diagtool examples write 07-microservices
+
+  # Write a C4 example to a new directory
+  diagtool examples write c4/01-system-context diagrams/`,
+}
+
+var examplesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the bundled example diagrams",
+	Args:  cobra.NoArgs,
+	RunE:  runExamplesList,
+}
+
+var examplesWriteCmd = &cobra.Command{
+	Use:   "write <name> [dir]",
+	Short: "Write a bundled example diagram to a directory",
+	Long: `Write a bundled example diagram to a directory, the current directory
+by default. The directory is created if needed; existing files are not
+overwritten.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runExamplesWrite,
+}
+
+func init() {
+	examplesCmd.AddCommand(examplesListCmd)
+	examplesCmd.AddCommand(examplesWriteCmd)
+}
+
+func runExamplesList(cmd *cobra.Command, args []string) error {
+	list, err := examples.List()
+	if err != nil {
+		return fmt.Errorf("failed to list examples: %w", err)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	for _, ex := range list {
+		fmt.Fprintf(w, "%s\t%s\n", ex.Name, ex.Title)
+	}
+	return w.Flush()
+}
+
+func runExamplesWrite(cmd *cobra.Command, args []string) error {
+	ex, content, err := examples.Read(args[0])
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	outputFile := filepath.Join(dir, path.Base(ex.File))
+	if _, err := os.Stat(outputFile); err == nil {
+		return fmt.Errorf("%s already exists", outputFile)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to access output file: %w", err)
+	}
+	if err := os.WriteFile(outputFile, content, 0644); err != nil {
+		return fmt.Errorf("failed to write example: %w", err)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", outputFile)
+	return nil
+}
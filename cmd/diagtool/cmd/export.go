@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <input.d2>",
+	Short: "Export the structure of a D2 diagram as data",
+	Long: `Export the structure of a D2 diagram for analysis in other tools.
+
+The csv format writes a connection matrix: one row and one column per node,
+in diagram order, with the number of edges from the row's node to the
+column's node in each cell.
+
+Examples:
+  # Write the connection matrix for a spreadsheet
+  diagtool export diagram.d2 --format csv > matrix.csv`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExport,
+}
+
+var exportFormat string
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "csv", "Output format: csv")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	inputFile := args[0]
+
+	if exportFormat != "csv" {
+		return fmt.Errorf("unsupported export format: %s (use csv)", exportFormat)
+	}
+
+	content, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+	diagram, err := parser.NewD2Parser().ParseFile(string(content), inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse diagram: %w", err)
+	}
+
+	ids, matrix := diagram.AdjacencyMatrix()
+	w := csv.NewWriter(cmd.OutOrStdout())
+	w.Write(append([]string{""}, ids...))
+	for i, row := range matrix {
+		record := make([]string, 0, len(row)+1)
+		record = append(record, ids[i])
+		for _, count := range row {
+			record = append(record, strconv.Itoa(count))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
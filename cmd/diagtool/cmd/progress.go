@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// spinnerFrames are drawn in turn while a progress step runs.
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// spinnerInterval is how often the spinner advances.
+const spinnerInterval = 100 * time.Millisecond
+
+// progress shows a spinner with a message, such as "[2/4] Writing
+// diagram.png", on standard error while slow render steps run. A nil
+// progress shows nothing, so callers don't need to check for --quiet.
+type progress struct {
+	w io.Writer
+}
+
+// newProgress returns a progress writing to w, or nil if w isn't a
+// terminal: the spinner redraws its line with carriage returns, which
+// would only clutter logs and pipes.
+func newProgress(w io.Writer) *progress {
+	if !isTerminal(w) {
+		return nil
+	}
+	return &progress{w: w}
+}
+
+// isTerminal reports whether w is a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// start shows message with a spinner until the returned function is
+// first called, which clears the line again.
+func (p *progress) start(message string) (stop func()) {
+	if p == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			fmt.Fprintf(p.w, "\r\033[K%s %s", spinnerFrames[frame%len(spinnerFrames)], message)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			fmt.Fprint(p.w, "\r\033[K")
+		})
+	}
+}
+
+// startOutput shows the progress of writing output n of total to path,
+// with an "[n/total]" counter when there is more than one.
+func (p *progress) startOutput(n, total int, path string) (stop func()) {
+	if total > 1 {
+		return p.start(fmt.Sprintf("[%d/%d] Writing %s", n, total, path))
+	}
+	return p.start("Writing " + path)
+}
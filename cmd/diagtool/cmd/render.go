@@ -3,10 +3,14 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -14,35 +18,76 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
 
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
 	"github.com/mark/dsl-diagram-tool/pkg/render"
 )
 
 var (
 	outputFile   string
 	outputFormat string
+	formats      []string
 	themeID      int64
 	darkMode     bool
+	darkThemeID  int64
 	sketchMode   bool
 	padding      int64
 	noCenter     bool
 	watchMode    bool
 	pixelDensity int
 	c4Mode       bool
+	embedSource  bool
+	routingMode  string
+	cssFile      string
+	pageSize     string
+	landscape    bool
+	quiet        bool
+	jsonOutput   bool
+	mergeEdges   bool
+	palette      string
+	inputFormat  string
+	highlight    []string
+	focus        string
+	focusDepth   int
+	groupByTag   bool
+	collapse     []string
+	minify       bool
+	fontRegular  string
+	fontBold     string
+	fontItalic   string
+	embedFonts   bool
+	inlineIcons  bool
+	board        string
+	rasterizer   string
+	fitBox       string
+	watermark    string
+	showGrid     bool
+	noLayout     bool
+
+	renderTimeout   time.Duration
+	splitContainers bool
+	gzipOutput      bool
 )
 
 var renderCmd = &cobra.Command{
 	Use:   "render <input.d2>",
-	Short: "Render a D2 diagram to SVG, PNG, or PDF",
+	Short: "Render a D2 diagram to SVG, PNG, PDF, or Markdown",
 	Long: `Render a D2 diagram file to the specified output format.
 
 Supported output formats:
   - svg (default): Scalable Vector Graphics
   - png: Portable Network Graphics (using headless Chrome)
+  - webp: WebP image, smaller than PNG for web pages (using headless Chrome)
   - pdf: Portable Document Format (using headless Chrome)
+  - md: Markdown with the SVG as an inline image, headed by the diagram's
+    title if it has one
 
-PNG export uses headless Chrome for high-quality conversion with proper font rendering.
+PNG and WebP export use headless Chrome for high-quality conversion with proper font rendering.
 The default pixel density is 3x for crisp, high-DPI output. Use --pixel-density to adjust.
 
+PDF export places each board (layers, scenarios, steps) on its own page.
+
 The output filename is derived from the input filename if not specified.
 For example, 'diagram.d2' will produce 'diagram.svg' by default.
 
@@ -59,6 +104,12 @@ Examples:
   # Render to PNG (explicit format)
   diagtool render diagram.d2 -f png
 
+  # Render SVG and PNG in one go, laying the diagram out once
+  diagtool render diagram.d2 --formats svg,png
+
+  # Render to Markdown for embedding in docs
+  diagtool render diagram.d2 -o diagram.md
+
   # Specify output file
   diagtool render diagram.d2 -o output.svg
 
@@ -68,7 +119,7 @@ Examples:
   # Use sketch/hand-drawn style
   diagtool render diagram.d2 --sketch
 
-  # Use a specific theme (0-8)
+  # Use a specific theme (see 'diagtool themes')
   diagtool render diagram.d2 --theme 3
 
   # Watch mode: auto-regenerate on file changes
@@ -78,7 +129,67 @@ Examples:
   # C4 diagram mode (applies C4-friendly styling)
   diagtool render architecture.d2 --c4
 
-Note: Format is auto-detected from output file extension (.png, .svg, .pdf).
+  # Render a PNG without a browser
+  diagtool render diagram.d2 -o diagram.png --rasterizer native
+
+  # Render to PDF on an A4 landscape page
+  diagtool render diagram.d2 -o diagram.pdf --page-size a4 --landscape
+
+  # Route all edges with right-angle connectors
+  diagtool render diagram.d2 --routing orthogonal
+
+  # Move styles into a shared stylesheet referenced by the SVG
+  diagtool render diagram.d2 --css styles.css
+
+  # Embed the D2 source in the SVG so it can be regenerated later
+  diagtool render diagram.d2 --embed-source
+
+  # Combine parallel edges (a -> b: x, a -> b: y) into one labeled "x, y"
+  diagtool render diagram.d2 --merge-edges
+
+  # Render a compressed .svgz
+  diagtool render diagram.d2 --gzip
+
+  # Render one layer, scenario, or step instead of the base diagram
+  diagtool render diagram.d2 --board scenario1
+
+  # Fit a 16:9 slide, centering the diagram
+  diagtool render diagram.d2 -o slide.png --fit 1920x1080 --pixel-density 1
+
+  # Mark a review build as a draft, on a coordinate grid
+  diagtool render diagram.d2 --watermark DRAFT --grid
+
+  # Render each top-level container to its own file, plus an overview
+  diagtool render diagram.d2 --split
+
+  # Render a diagram built by another tool as JSON IR
+  diagtool render model.json --input-format json
+
+  # Color unstyled nodes from a built-in palette
+  diagtool render diagram.d2 --palette material
+
+  # Draw a container as a single box, hiding its contents
+  diagtool render diagram.d2 --collapse aws.vpc
+
+  # Focus on a few nodes by dimming everything else
+  diagtool render diagram.d2 --highlight server,database
+
+  # Debug one node's connections in a large diagram
+  diagtool render diagram.d2 --focus gateway --depth 2
+
+  # Cluster nodes by their tags field, e.g. api: {tags: [backend]}
+  diagtool render diagram.d2 --group-by-tag
+
+  # Use corporate fonts for measurement and rendering
+  diagtool render diagram.d2 --font-regular Brand-Regular.ttf --font-bold Brand-Bold.ttf
+
+  # Smaller SVG for embedding in web pages
+  diagtool render diagram.d2 --minify
+
+  # Machine-readable output for CI pipelines
+  diagtool render diagram.d2 --json
+
+Note: Format is auto-detected from output file extension (.png, .svg, .pdf, .md).
 Use -f to explicitly override the format.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runRender,
@@ -86,23 +197,92 @@ Use -f to explicitly override the format.`,
 
 func init() {
 	renderCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: input name with format extension)")
-	renderCmd.Flags().StringVarP(&outputFormat, "format", "f", "svg", "Output format: svg, png, pdf")
-	renderCmd.Flags().Int64VarP(&themeID, "theme", "t", 0, "Theme ID (0-8, default: 0)")
+	renderCmd.Flags().StringVarP(&outputFormat, "format", "f", "svg", "Output format: svg, png, webp, pdf, md")
+	renderCmd.Flags().StringSliceVar(&formats, "formats", nil, "Comma-separated output formats to write from one render, e.g. svg,png; -o sets the base name")
+	renderCmd.Flags().Int64VarP(&themeID, "theme", "t", 0, "Theme ID (run 'diagtool themes' for the list, default: 0)")
 	renderCmd.Flags().BoolVarP(&darkMode, "dark", "d", false, "Use dark mode theme")
+	renderCmd.Flags().Int64Var(&darkThemeID, "dark-theme", 0, "Dark theme ID used with --dark (200-201, default: dark counterpart of --theme)")
 	renderCmd.Flags().BoolVarP(&sketchMode, "sketch", "s", false, "Use sketch/hand-drawn style")
 	renderCmd.Flags().Int64VarP(&padding, "padding", "p", 100, "Padding around diagram in pixels")
 	renderCmd.Flags().BoolVar(&noCenter, "no-center", false, "Don't center the diagram")
 	renderCmd.Flags().BoolVarP(&watchMode, "watch", "w", false, "Watch input file for changes and auto-regenerate")
-	renderCmd.Flags().IntVar(&pixelDensity, "pixel-density", 3, "PNG pixel density/DPI multiplier (1=standard, 2=retina, 3-4=high-DPI)")
+	renderCmd.Flags().IntVar(&pixelDensity, "pixel-density", 3, "PNG and WebP pixel density/DPI multiplier (1=standard, 2=retina, 3-4=high-DPI)")
+	renderCmd.Flags().StringVar(&rasterizer, "rasterizer", "", "PNG rasterizer: browser (headless Chrome), native (no browser; approximate) (default: browser)")
 	renderCmd.Flags().BoolVar(&c4Mode, "c4", false, "Use C4 diagram styling (applies Terminal theme)")
+	renderCmd.Flags().StringVar(&pageSize, "page-size", "", "PDF page size: a3, a4, a5, letter, legal, tabloid (default: fit to diagram)")
+	renderCmd.Flags().BoolVar(&landscape, "landscape", false, "Use landscape orientation for PDF pages (requires --page-size)")
+	renderCmd.Flags().StringVar(&routingMode, "routing", "", "Edge routing for all edges: direct, orthogonal (default: use .d2meta)")
+	renderCmd.Flags().StringVar(&cssFile, "css", "", "Write styles to an external CSS file referenced by the SVG, merging into it if it exists (SVG only)")
+	renderCmd.Flags().BoolVar(&gzipOutput, "gzip", false, "Gzip-compress the SVG into an .svgz file (SVG only; implied by an .svgz output path)")
+	renderCmd.Flags().BoolVar(&minify, "minify", false, "Strip comments and redundant whitespace from the SVG (SVG and Markdown only)")
+	renderCmd.Flags().BoolVar(&embedSource, "embed-source", false, "Embed the D2 source in the SVG as <metadata> (SVG only)")
+	renderCmd.Flags().BoolVar(&mergeEdges, "merge-edges", false, "Combine parallel edges between the same nodes into one edge with a joined label")
+	renderCmd.Flags().StringVar(&inputFormat, "input-format", "", "Input format: d2, json, plantuml, mermaid (default: from the file extension, otherwise d2)")
+	renderCmd.Flags().StringVar(&inputFormat, "from", "", "Input format (alias for --input-format)")
+	renderCmd.Flags().MarkDeprecated("from", "use --input-format instead")
+	renderCmd.Flags().StringVar(&palette, "palette", "", "Fill unstyled nodes from a color palette: material, pastel")
+	renderCmd.Flags().StringSliceVar(&highlight, "highlight", nil, "Comma-separated node IDs to highlight; other nodes and edges are dimmed")
+	renderCmd.Flags().StringVar(&focus, "focus", "", "Render only the nodes within --depth edges of this node ID, highlighting it")
+	renderCmd.Flags().IntVar(&focusDepth, "depth", 1, "Number of edges from the --focus node to include, following them either way")
+	renderCmd.Flags().BoolVar(&groupByTag, "group-by-tag", false, "Wrap top-level nodes in a container per tag, from their tags field")
+	renderCmd.Flags().StringSliceVar(&collapse, "collapse", nil, "Comma-separated container IDs to draw as single boxes, hiding their contents")
+	renderCmd.Flags().StringVar(&fitBox, "fit", "", "Scale the diagram to fit a WIDTHxHEIGHT pixel box, e.g. 1920x1080, centering it (not applied with .d2meta layouts)")
+	renderCmd.Flags().StringVar(&watermark, "watermark", "", "Repeat this text diagonally across the diagram, e.g. DRAFT")
+	renderCmd.Flags().BoolVar(&showGrid, "grid", false, "Draw a faint coordinate grid behind the diagram")
+	renderCmd.Flags().BoolVar(&noLayout, "no-layout", false, "Draw JSON IR at its node positions instead of laying it out")
+	renderCmd.Flags().StringVar(&board, "board", "", "Name of a layer, scenario, or step to render instead of the base diagram")
+	renderCmd.Flags().BoolVar(&splitContainers, "split", false, "Also render each top-level container to <id>.<format> next to the output, which becomes an overview with them collapsed")
+	renderCmd.Flags().StringVar(&fontRegular, "font-regular", "", "Path to a TTF font for regular text (default: D2's font)")
+	renderCmd.Flags().StringVar(&fontBold, "font-bold", "", "Path to a TTF font for bold text (default: D2's font)")
+	renderCmd.Flags().StringVar(&fontItalic, "font-italic", "", "Path to a TTF font for italic text (default: D2's font)")
+	renderCmd.Flags().BoolVar(&embedFonts, "embed-fonts", false, "Embed whole fonts in the SVG so added or edited text keeps the diagram's fonts (larger files)")
+	renderCmd.Flags().BoolVar(&inlineIcons, "inline-icons", false, "Embed icons referenced by URL in the SVG so it displays offline")
+	renderCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Don't show progress or print a message on success")
+	renderCmd.Flags().DurationVar(&renderTimeout, "timeout", 30*time.Second, "Maximum time to spend rendering (0 for no limit)")
+	renderCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the result as JSON (input, output, bytes, durationMs) or {\"error\": ...}")
 }
 
 // renderConfig holds the resolved configuration for rendering
 type renderConfig struct {
-	inputFile string
-	outPath   string
-	format    string
-	opts      render.Options
+	inputFile   string
+	inputFormat string // d2, json (IR), plantuml, or mermaid
+	outPath     string
+	format      string
+	formats     []string // All output formats with --formats, starting with format
+	gzip        bool     // Compress SVG output to .svgz
+	opts        render.Options
+	progress    *progress // Spinner on standard error during slow steps, nil for none
+}
+
+// outputs returns the configuration for writing each output format, with
+// the output path's extension changed to match for additional formats.
+func (c *renderConfig) outputs() []*renderConfig {
+	if len(c.formats) <= 1 {
+		return []*renderConfig{c}
+	}
+	base := strings.TrimSuffix(c.outPath, filepath.Ext(c.outPath))
+	var outputs []*renderConfig
+	for _, format := range c.formats {
+		out := *c
+		out.format = format
+		out.opts.Format = render.Format(format)
+		out.outPath = base + "." + out.extension()
+		outputs = append(outputs, &out)
+	}
+	return outputs
+}
+
+// hasFormat reports whether any output uses format.
+func (c *renderConfig) hasFormat(format string) bool {
+	return c.format == format || slices.Contains(c.formats, format)
+}
+
+// extension returns the file extension (without the dot) for the output.
+func (c *renderConfig) extension() string {
+	if c.gzip {
+		return "svgz"
+	}
+	return c.format
 }
 
 // resolveRenderConfig determines output path and format from flags and input file
@@ -116,23 +296,141 @@ func resolveRenderConfig(inputFile string) (*renderConfig, error) {
 	if format == "svg" && outPath != "" {
 		// Check if user specified a different extension (auto-detect)
 		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(outPath), "."))
-		if ext == "png" || ext == "pdf" {
+		if ext == "png" || ext == "webp" || ext == "pdf" || ext == "md" {
 			format = ext
 		}
 	}
 
-	// Validate format
-	switch format {
-	case "svg", "png", "pdf":
-		// Valid format
+	compress := gzipOutput || strings.EqualFold(filepath.Ext(outPath), ".svgz")
+
+	// Several formats from one render; -o only sets the base name
+	var resolvedFormats []string
+	if len(formats) > 0 {
+		if outputFormat != "svg" {
+			return nil, fmt.Errorf("use either --format or --formats")
+		}
+		for _, f := range formats {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if f != "" && !slices.Contains(resolvedFormats, f) {
+				resolvedFormats = append(resolvedFormats, f)
+			}
+		}
+		if len(resolvedFormats) == 0 {
+			return nil, fmt.Errorf("--formats requires at least one format")
+		}
+		format = resolvedFormats[0]
+		if outPath != "" {
+			ext := format
+			if compress {
+				ext = "svgz"
+			}
+			outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) + "." + ext
+		}
+	}
+
+	for _, f := range append([]string{format}, resolvedFormats...) {
+		if compress && f != "svg" {
+			return nil, fmt.Errorf("--gzip requires SVG output, not %s", f)
+		}
+
+		// Validate format
+		switch f {
+		case "svg", "png", "webp", "pdf", "md":
+			// Valid format
+		default:
+			return nil, fmt.Errorf("unsupported output format: %s (use svg, png, webp, pdf, or md)", f)
+		}
+	}
+
+	// Determine input format
+	// Auto-detect it from the input file extension if --input-format not specified
+	from := strings.ToLower(inputFormat)
+	if from == "" {
+		from = formatFromPath(inputFile)
+	}
+	switch from {
+	case parser.FormatD2, parser.FormatPlantUML, parser.FormatMermaid:
+		if _, err := parser.For(from); err != nil {
+			return nil, err
+		}
+		if noLayout {
+			return nil, fmt.Errorf("--no-layout requires JSON input with node positions")
+		}
+		if from != parser.FormatD2 && (routingMode != "" || c4Mode) {
+			return nil, fmt.Errorf("--routing and --c4 require D2 input")
+		}
+	case "json":
+		if routingMode != "" || c4Mode {
+			return nil, fmt.Errorf("--routing and --c4 require D2 input")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s (use d2, json, plantuml, or mermaid)", from)
+	}
+
+	// Validate routing mode
+	switch routingMode {
+	case "", render.RoutingDirect, render.RoutingOrthogonal:
+		// Valid routing mode
 	default:
-		return nil, fmt.Errorf("unsupported output format: %s (use svg, png, or pdf)", format)
+		return nil, fmt.Errorf("unsupported routing mode: %s (use direct or orthogonal)", routingMode)
+	}
+
+	// Validate PDF page size
+	resolvedPageSize, err := render.ParsePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if landscape && resolvedPageSize == "" {
+		return nil, fmt.Errorf("--landscape requires --page-size")
+	}
+
+	// Validate PNG rasterizer
+	resolvedRasterizer, err := render.ParseRasterizer(rasterizer)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedRasterizer != "" && format != "png" && !slices.Contains(resolvedFormats, "png") {
+		return nil, fmt.Errorf("--rasterizer requires PNG output, not %s", format)
+	}
+
+	// Validate focus
+	if focusDepth < 0 {
+		return nil, fmt.Errorf("--depth must be at least 0, got %d", focusDepth)
+	}
+	if focus != "" && splitContainers {
+		return nil, fmt.Errorf("--focus cannot be used with --split")
+	}
+
+	// Validate fit box
+	fitWidth, fitHeight, err := render.ParseFitBox(fitBox)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate dark theme
+	if darkThemeID != 0 {
+		if !darkMode {
+			return nil, fmt.Errorf("--dark-theme requires --dark")
+		}
+		if !render.IsDarkTheme(darkThemeID) {
+			return nil, fmt.Errorf("unsupported dark theme: %d (use 200 or 201)", darkThemeID)
+		}
+	}
+
+	// Validate palette
+	if palette != "" {
+		if _, ok := ir.PaletteByName(palette); !ok {
+			return nil, fmt.Errorf("unsupported palette: %s (use %s)", palette, strings.Join(ir.PaletteNames(), " or "))
+		}
 	}
 
 	// Derive output path if not specified
 	if outPath == "" {
 		base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
 		outPath = base + "." + format
+		if compress {
+			outPath = base + ".svgz"
+		}
 	}
 
 	// Create render options
@@ -146,26 +444,51 @@ func resolveRenderConfig(inputFile string) (*renderConfig, error) {
 		Format:       render.Format(format),
 		ThemeID:      resolvedThemeID,
 		DarkMode:     darkMode,
+		DarkThemeID:  darkThemeID,
 		Sketch:       sketchMode,
 		Padding:      padding,
 		Center:       !noCenter,
 		Scale:        1.0,
 		PixelDensity: pixelDensity,
+		Rasterizer:   resolvedRasterizer,
+		PDFPageSize:  resolvedPageSize,
+		PDFLandscape: landscape,
+		Highlight:    highlight,
+		FocusNode:    focus,
+		FocusDepth:   focusDepth,
+		GroupByTag:   groupByTag,
+		FontRegular:  fontRegular,
+		FontBold:     fontBold,
+		FontItalic:   fontItalic,
+		EmbedFonts:   embedFonts,
+		InlineIcons:  inlineIcons,
+		Board:        board,
+		FitWidth:     fitWidth,
+		FitHeight:    fitHeight,
+		Watermark:    watermark,
+		ShowGrid:     showGrid,
+		NoLayout:     noLayout,
 	}
 
 	return &renderConfig{
-		inputFile: inputFile,
-		outPath:   outPath,
-		format:    format,
-		opts:      opts,
+		inputFile:   inputFile,
+		inputFormat: from,
+		outPath:     outPath,
+		format:      format,
+		formats:     resolvedFormats,
+		gzip:        compress,
+		opts:        opts,
 	}, nil
 }
 
+// metadataPath returns the path of the .d2meta file alongside a D2 file.
+func metadataPath(d2FilePath string) string {
+	return strings.TrimSuffix(d2FilePath, filepath.Ext(d2FilePath)) + ".d2meta"
+}
+
 // loadMetadata loads the .d2meta file if it exists alongside the D2 file.
 func loadMetadata(d2FilePath string) (*render.Metadata, error) {
-	metaPath := strings.TrimSuffix(d2FilePath, filepath.Ext(d2FilePath)) + ".d2meta"
-
-	data, err := os.ReadFile(metaPath)
+	data, err := os.ReadFile(metadataPath(d2FilePath))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil // No metadata file, not an error
@@ -181,18 +504,76 @@ func loadMetadata(d2FilePath string) (*render.Metadata, error) {
 	return &meta, nil
 }
 
-// doRender performs a single render operation
-func doRender(cfg *renderConfig) error {
+// renderedFile is an output file written by a render.
+type renderedFile struct {
+	path  string
+	bytes int
+}
+
+// doRender performs a single render operation and returns the number of bytes written
+func doRender(cfg *renderConfig) (int, error) {
+	files, err := renderFiles(cfg)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, f := range files {
+		total += f.bytes
+	}
+	return total, nil
+}
+
+// renderSource renders D2 source to SVG. It is a variable so tests can
+// count how often a diagram is laid out.
+var renderSource = render.RenderFromSource
+
+// renderFiles performs a single render operation and returns the files
+// written: the main output in each format first, then with --split one per
+// container and format. The diagram is rendered to SVG once and converted
+// to every format from there.
+func renderFiles(cfg *renderConfig) ([]renderedFile, error) {
 	// Read input file
 	content, err := os.ReadFile(cfg.inputFile)
 	if err != nil {
-		return fmt.Errorf("failed to read input file: %w", err)
+		return nil, fmt.Errorf("failed to read input file: %w", err)
 	}
 
+	// Bound the whole render, including layout and PNG/PDF export
 	ctx := context.Background()
+	if renderTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, renderTimeout)
+		defer cancel()
+	}
+
+	// JSON IR and other DSLs are rendered from the IR; D2 is rendered from
+	// source
+	var resolved string
+	var diagram *ir.Diagram
+	switch cfg.inputFormat {
+	case "json":
+		diagram = &ir.Diagram{}
+		if err := json.Unmarshal(content, diagram); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON diagram: %w", err)
+		}
+	case parser.FormatPlantUML, parser.FormatMermaid:
+		p, err := parser.For(cfg.inputFormat)
+		if err != nil {
+			return nil, err
+		}
+		if diagram, err = parser.ParseFile(p, string(content), cfg.inputFile); err != nil {
+			return nil, fmt.Errorf("failed to parse diagram: %w", err)
+		}
+	default:
+		// Expand "# @include" directives relative to the input file
+		resolved, err = parser.ResolveIncludes(string(content), cfg.inputFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Apply C4 theme classes if in C4 mode
-	source := string(content)
+	source := resolved
 	if c4Mode {
 		source = render.ApplyC4Theme(source)
 	}
@@ -201,123 +582,534 @@ func doRender(cfg *renderConfig) error {
 	metadata, err := loadMetadata(cfg.inputFile)
 	if err != nil {
 		// Log warning but continue without metadata
-		fmt.Printf("Warning: %v\n", err)
+		logging.Warn("ignoring layout metadata", "err", err)
+		metadata = nil
+	}
+
+	// Like the editor, ignore positions and vertices saved for a different
+	// version of the source. Hand-written metadata without a hash is kept.
+	if metadata != nil && metadata.SourceHash != "" && metadata.SourceHash != ir.HashSource(string(content)) {
+		logging.Warn("ignoring layout metadata saved for a different source", "file", metadataPath(cfg.inputFile))
 		metadata = nil
 	}
 
+	// Layout metadata describes the whole base diagram, not the selected
+	// board or a focus neighborhood, and moves nodes relative to a computed
+	// layout
+	customLayout := cfg.opts.Board == "" && !cfg.opts.NoLayout && cfg.opts.FocusNode == ""
+	if !customLayout {
+		metadata = nil
+	}
+
+	// Diagram transforms render from the parsed diagram instead of the source
+	diagram, err = transformDiagram(diagram, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if customLayout {
+		if metadata, err = applyRoutingModes(metadata, diagram, source); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range cfg.opts.Highlight {
+		if diagram.GetNode(id) == nil {
+			return nil, fmt.Errorf("unknown node in --highlight: %s", id)
+		}
+	}
+	if cfg.opts.FocusNode != "" && diagram.GetNode(cfg.opts.FocusNode) == nil {
+		return nil, fmt.Errorf("unknown node in --focus: %s", cfg.opts.FocusNode)
+	}
+
+	// A parsed diagram is already the selected board
+	irOpts := cfg.opts
+	irOpts.Board = ""
+
+	// Write each output with its place among all outputs
+	total, written := len(cfg.outputs()), 0
+	writeFile := func(out *renderConfig, svg []byte, pages [][]byte, source, path string) (renderedFile, error) {
+		written++
+		stop := cfg.progress.startOutput(written, total, path)
+		defer stop()
+		n, err := writeOutput(ctx, out, svg, pages, source, path)
+		return renderedFile{path: path, bytes: n}, err
+	}
+
+	// Render each top-level container on its own, next to an overview in
+	// which they are collapsed
+	var files []renderedFile
+	if splitContainers {
+		if diagram == nil {
+			if diagram, err = parser.NewD2Parser().Parse(source); err != nil {
+				return nil, fmt.Errorf("failed to parse diagram: %w", err)
+			}
+			if diagram, err = render.SelectBoard(diagram, board); err != nil {
+				return nil, err
+			}
+		}
+		containers := topLevelContainers(diagram)
+		total *= len(containers) + 1
+		for _, id := range containers {
+			stop := cfg.progress.start("Rendering container " + id)
+			svg, err := render.RenderFromIR(ctx, diagram.ExtractSubgraph(id), irOpts)
+			stop()
+			if err != nil {
+				return nil, fmt.Errorf("rendering container %s failed: %w", id, err)
+			}
+			for _, out := range cfg.outputs() {
+				path := splitOutputPath(out, id)
+				if path == out.outPath {
+					return nil, fmt.Errorf("output for container %s would overwrite %s", id, out.outPath)
+				}
+				file, err := writeFile(out, svg, nil, "", path)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, file)
+			}
+		}
+		for _, id := range containers {
+			diagram = diagram.CollapseContainer(id)
+		}
+	}
+
 	// First, render D2 source to SVG (base rendering)
-	d2Svg, err := render.RenderFromSource(ctx, source, cfg.opts)
+	stop := cfg.progress.start("Rendering " + cfg.inputFile)
+	defer stop()
+	var d2Svg []byte
+	if diagram != nil {
+		d2Svg, err = render.RenderFromIR(ctx, diagram, irOpts)
+	} else {
+		d2Svg, err = renderSource(ctx, source, cfg.opts)
+	}
 	if err != nil {
-		return fmt.Errorf("rendering failed: %w", err)
+		return nil, fmt.Errorf("rendering failed: %w", err)
 	}
 
 	// Check if we have metadata to apply
-	hasMetadata := metadata != nil && (len(metadata.Positions) > 0 || len(metadata.Vertices) > 0)
+	// Apply metadata (positions, vertices, routing) via JointJS if present
+	svg := d2Svg
+	if metadata.HasLayout() {
+		svg, err = render.RenderWithJointJS(ctx, d2Svg, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("rendering with metadata failed: %w", err)
+		}
+	}
 
+	// Put each board (layers, scenarios, steps) on its own PDF page. Layout
+	// metadata and transforms only apply to the root board, so keep it as
+	// one page.
+	var pages [][]byte
+	if cfg.hasFormat("pdf") && !metadata.HasLayout() && diagram == nil {
+		pages, err = render.RenderBoardsFromSource(ctx, source, cfg.opts)
+		if err != nil {
+			return nil, fmt.Errorf("rendering failed: %w", err)
+		}
+	}
+
+	stop()
+
+	// Write every format from the same SVG, main outputs first
+	var outputs []renderedFile
+	for _, out := range cfg.outputs() {
+		file, err := writeFile(out, svg, pages, resolved, out.outPath)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, file)
+	}
+	return append(outputs, files...), nil
+}
+
+// writeOutput converts a rendered SVG to the output format and writes it to
+// path, returning the number of bytes written. PDFs get one page per SVG in
+// pages, or just svg if pages is empty. source is embedded with
+// --embed-source unless empty.
+func writeOutput(ctx context.Context, cfg *renderConfig, svg []byte, pages [][]byte, source, path string) (int, error) {
 	var output []byte
+	var err error
 
-	if hasMetadata {
-		// Use JointJS-based rendering with metadata
-		output, err = render.RenderWithMetadata(ctx, d2Svg, metadata, render.Format(cfg.format), cfg.opts.PixelDensity)
+	switch cfg.format {
+	case "svg", "md":
+		output = svg
+	case "png":
+		output, err = render.RasterizeSVG(ctx, svg, cfg.opts.PixelDensity, cfg.opts.Rasterizer)
 		if err != nil {
-			return fmt.Errorf("rendering with metadata failed: %w", err)
+			return 0, fmt.Errorf("PNG rendering failed: %w", err)
 		}
-	} else {
-		// No metadata, use standard rendering
-		switch cfg.format {
-		case "svg":
-			output = d2Svg
-		case "png":
-			output, err = render.SVGToPNG(ctx, d2Svg, cfg.opts.PixelDensity)
-			if err != nil {
-				return fmt.Errorf("PNG rendering failed: %w", err)
-			}
-		case "pdf":
-			output, err = render.SVGToPDF(ctx, d2Svg)
-			if err != nil {
-				return fmt.Errorf("PDF rendering failed: %w", err)
-			}
+	case "webp":
+		output, err = render.SVGToWebP(ctx, svg, cfg.opts.PixelDensity)
+		if err != nil {
+			return 0, fmt.Errorf("WebP rendering failed: %w", err)
+		}
+	case "pdf":
+		if len(pages) == 0 {
+			pages = [][]byte{svg}
+		}
+		output, err = render.SVGPagesToPDF(ctx, pages, cfg.opts)
+		if err != nil {
+			return 0, fmt.Errorf("PDF rendering failed: %w", err)
+		}
+	}
+
+	// Embed the original source for reproducibility (SVG only)
+	if embedSource && source != "" && cfg.format == "svg" {
+		output = render.EmbedSource(output, source)
+	}
+
+	// Move styles into an external stylesheet (SVG only)
+	if cssFile != "" && cfg.format == "svg" {
+		href, err := filepath.Rel(filepath.Dir(path), cssFile)
+		if err != nil {
+			href = cssFile
+		}
+		var css string
+		output, css = render.ExternalizeStyles(output, filepath.ToSlash(href))
+
+		// Diagrams rendered with the same --css share the stylesheet
+		existing, err := os.ReadFile(cssFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("failed to read CSS file: %w", err)
+		}
+		css = render.MergeStylesheets(string(existing), css)
+		if err := os.WriteFile(cssFile, []byte(css), 0644); err != nil {
+			return 0, fmt.Errorf("failed to write CSS file: %w", err)
+		}
+	}
+
+	// Strip redundant whitespace and comments (SVG and Markdown only)
+	if minify && (cfg.format == "svg" || cfg.format == "md") {
+		output = render.MinifySVG(output)
+	}
+
+	// Wrap the finished SVG in Markdown
+	if cfg.format == "md" {
+		output = render.SVGToMarkdown(output, render.SVGTitle(output))
+	}
+
+	// Compress to .svgz
+	if cfg.gzip {
+		output, err = render.GzipSVG(output)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compress SVG: %w", err)
 		}
 	}
 
 	// Write output file
-	if err := os.WriteFile(cfg.outPath, output, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	if err := os.WriteFile(path, output, 0644); err != nil {
+		return 0, fmt.Errorf("failed to write output file: %w", err)
 	}
 
-	return nil
+	return len(output), nil
+}
+
+// topLevelContainers returns the IDs of the containers at the root of the
+// diagram, in diagram order.
+func topLevelContainers(diagram *ir.Diagram) []string {
+	var ids []string
+	for _, node := range diagram.GetRootNodes() {
+		if node.IsContainer() {
+			ids = append(ids, node.ID)
+		}
+	}
+	return ids
+}
+
+// splitOutputPath returns the --split output path for a top-level container:
+// the container ID with the output's extension, next to the main output.
+func splitOutputPath(cfg *renderConfig, id string) string {
+	name := strings.NewReplacer("/", "_", `\`, "_").Replace(id)
+	return filepath.Join(filepath.Dir(cfg.outPath), name+"."+cfg.extension())
+}
+
+// renderResult is the --json output for a successful render
+type renderResult struct {
+	Input      string   `json:"input"`
+	Output     string   `json:"output"`
+	Bytes      int      `json:"bytes"` // Total across all files written
+	DurationMs int64    `json:"durationMs"`
+	Outputs    []string `json:"outputs,omitempty"` // All main outputs with --formats, starting with Output
+	Parts      []string `json:"parts,omitempty"`   // Per-container files written with --split
+}
+
+// applyRoutingModes adds the routing modes declared on edges, where the
+// metadata has none, and then the --routing mode to metadata, keyed by the
+// edge IDs of what is rendered: diagram, after its transforms, or source if
+// diagram is nil. Metadata is only created when some edge is routed other
+// than directly, and source is only parsed if it mentions routing.
+func applyRoutingModes(metadata *render.Metadata, diagram *ir.Diagram, source string) (*render.Metadata, error) {
+	declared, modeSource := diagram, ""
+	if diagram == nil && strings.Contains(source, "routing") {
+		var err error
+		if declared, err = parser.NewD2Parser().Parse(source); err != nil {
+			return nil, fmt.Errorf("failed to parse diagram: %w", err)
+		}
+		modeSource = source
+	}
+	if declared != nil && slices.ContainsFunc(declared.Edges, func(edge *ir.Edge) bool {
+		return edge.RoutingMode != "" && edge.RoutingMode != ir.RoutingDirect
+	}) {
+		if metadata == nil {
+			metadata = &render.Metadata{}
+		}
+		if err := metadata.SetEdgeRoutingModes(modeSource, declared); err != nil {
+			return nil, fmt.Errorf("failed to apply routing modes: %w", err)
+		}
+	}
+
+	// The diagram-wide mode overrides per-edge modes
+	if routingMode == "" || (metadata == nil && routingMode == render.RoutingDirect) {
+		return metadata, nil
+	}
+	if metadata == nil {
+		metadata = &render.Metadata{}
+	}
+	if diagram != nil {
+		source = render.GenerateD2(diagram)
+	}
+	if err := metadata.SetAllRoutingModes(source, routingMode); err != nil {
+		return nil, fmt.Errorf("failed to apply routing mode: %w", err)
+	}
+	return metadata, nil
+}
+
+// transformDiagram applies the diagram transforms requested by flags.
+// Highlighting, focusing and grouping by tag are applied by the renderer but
+// also need the parsed diagram.
+// D2 source is only parsed when diagram is nil and a transform needs it;
+// nil means render from source. A returned diagram is the board selected
+// with --board.
+func transformDiagram(diagram *ir.Diagram, source string) (*ir.Diagram, error) {
+	if diagram == nil {
+		if !mergeEdges && palette == "" && len(collapse) == 0 && len(highlight) == 0 && focus == "" && !groupByTag {
+			return nil, nil
+		}
+		parsed, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse diagram: %w", err)
+		}
+		diagram = parsed
+	}
+
+	// Transforms apply to the selected board
+	diagram, err := render.SelectBoard(diagram, board)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range collapse {
+		if diagram.GetNode(id) == nil {
+			return nil, fmt.Errorf("unknown container in --collapse: %s", id)
+		}
+		diagram = diagram.CollapseContainer(id)
+	}
+	if mergeEdges {
+		diagram = diagram.MergeParallelEdges(", ")
+	}
+	if colors, ok := ir.PaletteByName(palette); ok {
+		diagram.ApplyPalette(colors)
+	}
+
+	return diagram, nil
 }
 
 func runRender(cmd *cobra.Command, args []string) error {
 	inputFile := args[0]
+	out := cmd.OutOrStdout()
 
 	// Resolve configuration
 	cfg, err := resolveRenderConfig(inputFile)
 	if err != nil {
-		return err
+		return reportRenderError(out, err)
 	}
 
 	// Single render mode
 	if !watchMode {
-		if err := doRender(cfg); err != nil {
-			return err
+		if !quiet && !jsonOutput {
+			cfg.progress = newProgress(cmd.ErrOrStderr())
+		}
+		start := time.Now()
+		files, err := renderFiles(cfg)
+		if err != nil {
+			return reportRenderError(out, err)
+		}
+
+		switch {
+		case jsonOutput:
+			result := renderResult{
+				Input:      cfg.inputFile,
+				Output:     cfg.outPath,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			mainOutputs := len(cfg.outputs())
+			for i, f := range files {
+				result.Bytes += f.bytes
+				switch {
+				case mainOutputs > 1 && i < mainOutputs:
+					result.Outputs = append(result.Outputs, f.path)
+				case i >= mainOutputs:
+					result.Parts = append(result.Parts, f.path)
+				}
+			}
+			return json.NewEncoder(out).Encode(result)
+		case !quiet:
+			for _, f := range files {
+				fmt.Fprintf(out, "Rendered %s → %s\n", cfg.inputFile, f.path)
+			}
 		}
-		fmt.Printf("Rendered %s → %s\n", cfg.inputFile, cfg.outPath)
 		return nil
 	}
 
+	if jsonOutput {
+		return reportRenderError(out, fmt.Errorf("--json cannot be used with --watch"))
+	}
+
 	// Watch mode
 	return runWatchMode(cfg)
 }
 
+// reportRenderError prints err as {"error": ...} when --json is set, so
+// failures are machine-readable too, and returns it unchanged.
+func reportRenderError(w io.Writer, err error) error {
+	if jsonOutput {
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+	return err
+}
+
 // runWatchMode watches the input file and re-renders on changes
 func runWatchMode(cfg *renderConfig) error {
+	w, err := newFileWatcher(cfg.inputFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Setup signal handling for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		<-sigChan
+		fmt.Printf("\nStopping watch mode.\n")
+		close(stop)
+	}()
+
+	renderOnce := func() {
+		start := time.Now()
+		if _, err := doRender(cfg); err != nil {
+			logging.Error("render failed", "input", cfg.inputFile, "err", err)
+		} else {
+			logging.Info("rendered", "input", cfg.inputFile, "output", cfg.outPath,
+				"duration", time.Since(start).Round(time.Millisecond))
+		}
+	}
+
+	// Do initial render
+	fmt.Printf("Watching %s for changes (Ctrl+C to stop)...\n", cfg.inputFile)
+	renderOnce()
+
+	w.run(stop, renderOnce)
+	return nil
+}
+
+// watchDebounceDelay is how long watch mode waits after the last change
+// before re-rendering, to avoid multiple renders for rapid changes.
+const watchDebounceDelay = 100 * time.Millisecond
+
+// fileWatcher watches an input file together with its .d2meta file and the
+// files it includes.
+type fileWatcher struct {
+	watcher   *fsnotify.Watcher
+	inputFile string          // Absolute path of the input file
+	files     map[string]bool // Absolute paths whose changes trigger a render
+	dirs      map[string]bool // Directories being watched
+}
+
+// newFileWatcher starts watching inputFile and its related files.
+func newFileWatcher(inputFile string) (*fileWatcher, error) {
 	// Get absolute path for reliable watching
-	absPath, err := filepath.Abs(cfg.inputFile)
+	absPath, err := filepath.Abs(inputFile)
 	if err != nil {
-		return fmt.Errorf("failed to resolve input path: %w", err)
+		return nil, fmt.Errorf("failed to resolve input path: %w", err)
 	}
 
-	// Create watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return fmt.Errorf("failed to create file watcher: %w", err)
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
 	}
-	defer watcher.Close()
 
-	// Watch the directory containing the file (more reliable for editor saves)
-	dir := filepath.Dir(absPath)
-	if err := watcher.Add(dir); err != nil {
-		return fmt.Errorf("failed to watch directory: %w", err)
+	w := &fileWatcher{
+		watcher:   watcher,
+		inputFile: absPath,
+		dirs:      make(map[string]bool),
 	}
+	if err := w.refresh(); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return w, nil
+}
 
-	// Setup signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// Close stops watching.
+func (w *fileWatcher) Close() error {
+	return w.watcher.Close()
+}
 
-	// Do initial render
-	fmt.Printf("Watching %s for changes (Ctrl+C to stop)...\n", cfg.inputFile)
-	if err := doRender(cfg); err != nil {
-		fmt.Printf("[%s] Error: %v\n", formatTime(), err)
-	} else {
-		fmt.Printf("[%s] Rendered %s → %s\n", formatTime(), cfg.inputFile, cfg.outPath)
+// refresh recomputes the watched files from the input file's current
+// includes. Directories rather than files are watched, which is more
+// reliable for editors that save by replacing the file.
+func (w *fileWatcher) refresh() error {
+	files := map[string]bool{
+		w.inputFile:               true,
+		metadataPath(w.inputFile): true,
 	}
 
-	// Debounce timer to avoid multiple renders for rapid changes
-	var debounceTimer *time.Timer
-	const debounceDelay = 100 * time.Millisecond
+	// Includes are best effort: a broken include is reported by the render
+	if content, err := os.ReadFile(w.inputFile); err == nil {
+		included, _ := parser.IncludedFiles(string(content), w.inputFile)
+		for _, path := range included {
+			files[path] = true
+		}
+	}
 
-	baseName := filepath.Base(absPath)
+	for path := range files {
+		dir := filepath.Dir(path)
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			if dir == filepath.Dir(w.inputFile) {
+				return fmt.Errorf("failed to watch directory: %w", err)
+			}
+			continue
+		}
+		w.dirs[dir] = true
+	}
+
+	w.files = files
+	return nil
+}
+
+// run calls onChange, debounced, after each change to a watched file until
+// stop is closed or the watcher is closed.
+func (w *fileWatcher) run(stop <-chan struct{}, onChange func()) {
+	var debounceTimer *time.Timer
+	defer func() {
+		if debounceTimer != nil {
+			debounceTimer.Stop()
+		}
+	}()
 
 	for {
 		select {
-		case event, ok := <-watcher.Events:
+		case event, ok := <-w.watcher.Events:
 			if !ok {
-				return nil
+				return
 			}
 
-			// Only react to changes to our specific file
-			if filepath.Base(event.Name) != baseName {
+			// Only react to changes to the input file and its related files
+			if !w.files[filepath.Clean(event.Name)] {
 				continue
 			}
 
@@ -326,32 +1118,27 @@ func runWatchMode(cfg *renderConfig) error {
 				continue
 			}
 
+			logging.Debug("file changed", "file", event.Name)
+
+			// The input file may have gained or lost includes
+			if err := w.refresh(); err != nil {
+				logging.Error("watch error", "err", err)
+			}
+
 			// Debounce: reset timer on each event
 			if debounceTimer != nil {
 				debounceTimer.Stop()
 			}
-			debounceTimer = time.AfterFunc(debounceDelay, func() {
-				if err := doRender(cfg); err != nil {
-					fmt.Printf("[%s] Error: %v\n", formatTime(), err)
-				} else {
-					fmt.Printf("[%s] Rendered %s → %s\n", formatTime(), cfg.inputFile, cfg.outPath)
-				}
-			})
+			debounceTimer = time.AfterFunc(watchDebounceDelay, onChange)
 
-		case err, ok := <-watcher.Errors:
+		case err, ok := <-w.watcher.Errors:
 			if !ok {
-				return nil
+				return
 			}
-			fmt.Printf("[%s] Watch error: %v\n", formatTime(), err)
+			logging.Error("watch error", "err", err)
 
-		case <-sigChan:
-			fmt.Printf("\nStopping watch mode.\n")
-			return nil
+		case <-stop:
+			return
 		}
 	}
 }
-
-// formatTime returns a formatted timestamp for watch mode output
-func formatTime() string {
-	return time.Now().Format("15:04:05")
-}
@@ -6,6 +6,8 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
 )
 
 // Version information (set at build time)
@@ -34,21 +36,39 @@ Examples:
   diagtool validate diagram.d2
 
 For more information, visit: https://github.com/mark/dsl-diagram-tool`,
-	SilenceUsage:  true,
-	SilenceErrors: true,
+	SilenceUsage:      true,
+	SilenceErrors:     true,
+	PersistentPreRunE: setupLogging,
 }
 
+// logLevel is the minimum level of diagnostic messages written to stderr.
+var logLevel string
+
 // Execute runs the root command.
 func Execute() error {
 	return rootCmd.Execute()
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Diagnostic messages to show on stderr: debug, info, warn, error")
 	rootCmd.AddCommand(renderCmd)
 	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(examplesCmd)
+	rootCmd.AddCommand(themesCmd)
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(convertCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
+// setupLogging applies --log-level before any command runs.
+func setupLogging(cmd *cobra.Command, args []string) error {
+	return logging.Setup(cmd.ErrOrStderr(), logLevel)
+}
+
 // exitWithError prints an error message and exits with code 1.
 func exitWithError(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the diagram IR",
+	Long: `Print a JSON Schema (draft-07) describing diagrams in the JSON IR
+format, as read by 'diagtool render --input-format json'. Tools that generate
+diagrams in other languages can validate their output against it.
+
+Examples:
+  # Save the schema for a JSON Schema validator
+  diagtool schema > diagram.schema.json`,
+	Args: cobra.NoArgs,
+	RunE: runSchema,
+}
+
+func runSchema(cmd *cobra.Command, args []string) error {
+	out := cmd.OutOrStdout()
+	if _, err := out.Write(ir.JSONSchema()); err != nil {
+		return err
+	}
+	_, err := out.Write([]byte("\n"))
+	return err
+}
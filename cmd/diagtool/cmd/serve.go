@@ -2,13 +2,18 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	neturl "net/url"
 	"os"
+	"os/exec"
 	"os/signal"
+	"runtime"
 	"syscall"
 
 	"github.com/spf13/cobra"
 
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
 	"github.com/mark/dsl-diagram-tool/pkg/server"
 )
 
@@ -30,23 +35,51 @@ Examples:
   # Start on a specific port
   diagtool serve diagram.d2 --port 3000
 
+  # Open the editor in the default browser
+  diagtool serve diagram.d2 --open
+
   # Start without a file (empty editor)
   diagtool serve
 
+  # Browse all diagrams in a project directory
+  diagtool serve docs/overview.d2 --root docs
+
   # C4 diagram mode (applies C4-friendly styling)
-  diagtool serve architecture.d2 --c4`,
+  diagtool serve architecture.d2 --c4
+
+  # Limit each client to 2 renders per second
+  diagtool serve diagram.d2 --rate-limit 2
+
+  # Require a token on shared machines (open the printed URL to authenticate)
+  diagtool serve diagram.d2 --token s3cret --localhost-only`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runServe,
 }
 
 var (
-	servePort   int
-	serveC4Mode bool
+	servePort          int
+	serveC4Mode        bool
+	serveToken         string
+	serveRoot          string
+	serveLocalhostOnly bool
+	serveMaxNodes      int
+	serveMaxEdges      int
+	serveMetrics       bool
+	serveRateLimit     float64
+	serveOpen          bool
 )
 
 func init() {
 	serveCmd.Flags().IntVarP(&servePort, "port", "p", 8080, "port to listen on")
 	serveCmd.Flags().BoolVar(&serveC4Mode, "c4", false, "Use C4 diagram styling (applies Terminal theme)")
+	serveCmd.Flags().StringVar(&serveRoot, "root", "", "directory of .d2 files the editor can browse and edit (default: only the given file)")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "require this token on API and WebSocket requests")
+	serveCmd.Flags().BoolVar(&serveLocalhostOnly, "localhost-only", false, "only accept WebSocket connections from localhost pages")
+	serveCmd.Flags().IntVar(&serveMaxNodes, "max-nodes", 5000, "reject diagrams with more nodes than this, 0 for no limit")
+	serveCmd.Flags().IntVar(&serveMaxEdges, "max-edges", 5000, "reject diagrams with more edges than this, 0 for no limit")
+	serveCmd.Flags().BoolVar(&serveMetrics, "metrics", false, "serve Prometheus metrics at /metrics")
+	serveCmd.Flags().Float64Var(&serveRateLimit, "rate-limit", 0, "render, parse, and validate requests per second allowed from each client IP, 0 for no limit")
+	serveCmd.Flags().BoolVar(&serveOpen, "open", false, "open the editor in the default browser")
 	rootCmd.AddCommand(serveCmd)
 }
 
@@ -61,29 +94,44 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 
 	srv, err := server.New(server.Options{
-		Port:     servePort,
-		FilePath: filePath,
-		C4Mode:   serveC4Mode,
+		Port:          servePort,
+		FilePath:      filePath,
+		Root:          serveRoot,
+		C4Mode:        serveC4Mode,
+		Token:         serveToken,
+		LocalhostOnly: serveLocalhostOnly,
+		MaxNodes:      serveMaxNodes,
+		MaxEdges:      serveMaxEdges,
+		Metrics:       serveMetrics,
+		RateLimit:     serveRateLimit,
+		Version:       Version,
 	})
 	if err != nil {
 		return err
 	}
 
 	// Handle shutdown signals
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(cmd.Context())
 	defer cancel()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
 
 	go func() {
-		<-sigCh
-		fmt.Println("\nShutting down...")
-		cancel()
+		select {
+		case <-sigCh:
+			fmt.Println("\nShutting down...")
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
 
 	// Print startup message
 	url := fmt.Sprintf("http://localhost:%d", servePort)
+	if serveToken != "" {
+		url += "/?token=" + neturl.QueryEscape(serveToken)
+	}
 	fmt.Printf("Starting diagram editor server...\n")
 	fmt.Printf("  URL: %s\n", url)
 	if filePath != "" {
@@ -91,5 +139,34 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Printf("\nPress Ctrl+C to stop\n\n")
 
+	// The server is listening long before a browser gets to the page
+	if serveOpen {
+		if err := openBrowser(url); err != nil {
+			logging.Debug("failed to open browser", "error", err)
+		}
+	}
+
 	return srv.Start(ctx)
 }
+
+// openBrowser opens url in the default browser. It is a variable so tests
+// can check the URL without starting a browser.
+var openBrowser = func(url string) error {
+	var browser *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		browser = exec.Command("open", url)
+	case "windows":
+		browser = exec.Command("cmd", "/c", "start", "", url)
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return errors.New("no graphical display")
+		}
+		browser = exec.Command("xdg-open", url)
+	}
+	if err := browser.Start(); err != nil {
+		return err
+	}
+	go browser.Wait()
+	return nil
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats <input.d2>",
+	Short: "Show structural statistics for a D2 diagram",
+	Long: `Show structural statistics for a D2 diagram file.
+
+Reports node, edge, and container counts, the maximum nesting depth,
+the number of orphan nodes (nodes with no connections), and a histogram
+of shape types.
+
+With --from, also reports which nodes can be reached from the given entry
+points by following edges in the direction of their arrows.
+
+Examples:
+  # Print statistics as a table
+  diagtool stats diagram.d2
+
+  # Print statistics as JSON
+  diagtool stats diagram.d2 --format json
+
+  # Find nodes that can't be reached from the gateway
+  diagtool stats diagram.d2 --from gateway`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStats,
+}
+
+var (
+	statsFormat string
+	statsFrom   []string
+)
+
+func init() {
+	statsCmd.Flags().StringVarP(&statsFormat, "format", "f", "table", "Output format: table, json")
+	statsCmd.Flags().StringSliceVar(&statsFrom, "from", nil, "Entry node IDs to report reachability from (comma-separated)")
+}
+
+// statsResult is the stats output: the diagram statistics plus, with
+// --from, reachability from the entry nodes.
+type statsResult struct {
+	ir.DiagramStats
+	Reachability *reachability `json:"reachability,omitempty"`
+}
+
+// reachability reports which non-container nodes can be reached from a set
+// of entry nodes.
+type reachability struct {
+	From        []string `json:"from"`
+	Reachable   int      `json:"reachable_count"` // Reachable non-container nodes, including the entry nodes
+	Total       int      `json:"total_count"`     // Non-container nodes
+	Unreachable []string `json:"unreachable"`
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	inputFile := args[0]
+
+	if statsFormat != "table" && statsFormat != "json" {
+		return fmt.Errorf("unsupported stats format: %s (use table or json)", statsFormat)
+	}
+
+	// Read input file
+	content, err := os.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	// Parse the file
+	p := parser.NewD2Parser()
+	diagram, err := p.ParseFile(string(content), inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse diagram: %w", err)
+	}
+
+	result := statsResult{DiagramStats: diagram.Stats()}
+	if len(statsFrom) > 0 {
+		for _, id := range statsFrom {
+			if diagram.GetNode(id) == nil {
+				return fmt.Errorf("unknown node in --from: %s", id)
+			}
+		}
+		unreachable := diagram.UnreachableNodes(statsFrom)
+		total := result.NodeCount - result.ContainerCount
+		result.Reachability = &reachability{
+			From:        statsFrom,
+			Reachable:   total - len(unreachable),
+			Total:       total,
+			Unreachable: append([]string{}, unreachable...), // [] rather than null in JSON
+		}
+	}
+
+	if statsFormat == "json" {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	printStatsTable(cmd, inputFile, result)
+	return nil
+}
+
+// printStatsTable writes diagram statistics as an aligned table.
+func printStatsTable(cmd *cobra.Command, inputFile string, result statsResult) {
+	stats := result.DiagramStats
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Statistics for %s\n\n", inputFile)
+	fmt.Fprintf(w, "Nodes:\t%d\n", stats.NodeCount)
+	fmt.Fprintf(w, "Edges:\t%d\n", stats.EdgeCount)
+	fmt.Fprintf(w, "Containers:\t%d\n", stats.ContainerCount)
+	fmt.Fprintf(w, "Max depth:\t%d\n", stats.MaxDepth)
+	fmt.Fprintf(w, "Orphans:\t%d\n", stats.OrphanCount)
+	if r := result.Reachability; r != nil {
+		fmt.Fprintf(w, "Reachable:\t%d of %d from %s\n", r.Reachable, r.Total, strings.Join(r.From, ", "))
+		if len(r.Unreachable) > 0 {
+			fmt.Fprintf(w, "Unreachable:\t%s\n", strings.Join(r.Unreachable, ", "))
+		}
+	}
+
+	if len(stats.ShapeCounts) > 0 {
+		// Sort shapes by count (descending), then name for stable output
+		shapes := make([]ir.ShapeType, 0, len(stats.ShapeCounts))
+		for shape := range stats.ShapeCounts {
+			shapes = append(shapes, shape)
+		}
+		sort.Slice(shapes, func(i, j int) bool {
+			ci, cj := stats.ShapeCounts[shapes[i]], stats.ShapeCounts[shapes[j]]
+			if ci != cj {
+				return ci > cj
+			}
+			return shapes[i] < shapes[j]
+		})
+
+		fmt.Fprintf(w, "\nShape\tCount\n")
+		for _, shape := range shapes {
+			fmt.Fprintf(w, "%s\t%d\n", shape, stats.ShapeCounts[shape])
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mark/dsl-diagram-tool/pkg/render"
+)
+
+var themesCmd = &cobra.Command{
+	Use:   "themes",
+	Short: "List the built-in themes",
+	Long: `List D2's built-in themes with the IDs to pass to --theme and
+--dark-theme, and the dark theme --dark uses for each light theme.
+
+Examples:
+  # Show the available themes
+  diagtool themes
+
+  # Render with one of them
+  diagtool render diagram.d2 --theme 300`,
+	Args: cobra.NoArgs,
+	RunE: runThemes,
+}
+
+func runThemes(cmd *cobra.Command, args []string) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tDARK VARIANT")
+	for _, theme := range render.Themes() {
+		kind, variant := "light", "-"
+		if theme.Dark {
+			kind = "dark"
+		} else if theme.DarkVariant != 0 {
+			variant = fmt.Sprintf("%d", theme.DarkVariant)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", theme.ID, theme.Name, kind, variant)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "\nWith --dark, themes without a dark variant use theme %d.\n", render.DefaultDarkThemeID)
+	return nil
+}
@@ -3,9 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
 	"github.com/mark/dsl-diagram-tool/pkg/parser"
 )
 
@@ -15,6 +17,8 @@ var validateCmd = &cobra.Command{
 	Long: `Validate a D2 diagram file for syntax errors and structural issues.
 
 This command parses the input file and reports any errors found.
+Suspicious but valid constructs, such as self-loop edges, are reported
+as warnings without failing validation.
 It does not produce any output files.
 
 Examples:
@@ -22,15 +26,40 @@ Examples:
   diagtool validate diagram.d2
 
   # Validate and show details on success
-  diagtool validate diagram.d2 -v`,
+  diagtool validate diagram.d2 -v
+
+  # Allow self-loop edges (e.g. for state machines)
+  diagtool validate diagram.d2 --allow-self-loops
+
+  # Also warn about duplicate edges and empty containers
+  diagtool validate diagram.d2 --strict
+
+  # Validate a file whose extension doesn't match its format
+  diagtool validate diagram.txt --input-format d2
+
+  # Quick syntax check only (e.g. in pre-commit hooks on large files)
+  diagtool validate diagram.d2 --syntax-only`,
 	Args: cobra.ExactArgs(1),
 	RunE: runValidate,
 }
 
-var verbose bool
+var (
+	verbose        bool
+	allowSelfLoops bool
+	syntaxOnly     bool
+	strictLint     bool
+	validateFormat string
+
+	validateTimeout time.Duration
+)
 
 func init() {
 	validateCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show detailed output on success")
+	validateCmd.Flags().BoolVar(&allowSelfLoops, "allow-self-loops", false, "Don't warn about edges from a node to itself")
+	validateCmd.Flags().BoolVar(&strictLint, "strict", false, "Also warn about duplicate edges and empty containers")
+	validateCmd.Flags().StringVar(&validateFormat, "input-format", "", "Input format: d2, plantuml, mermaid (default: from the file extension, otherwise d2)")
+	validateCmd.Flags().BoolVar(&syntaxOnly, "syntax-only", false, "Only check D2 syntax, skipping structural validation")
+	validateCmd.Flags().DurationVar(&validateTimeout, "timeout", 30*time.Second, "Maximum time to spend validating (0 for no limit)")
 }
 
 func runValidate(cmd *cobra.Command, args []string) error {
@@ -42,9 +71,43 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to read input file: %w", err)
 	}
 
+	format := validateFormat
+	if format == "" {
+		format = parser.FormatFromPath(inputFile)
+	}
+	p, err := parser.For(format)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	// Syntax-only mode stops after compilation
+	if syntaxOnly {
+		d2Parser, ok := p.(*parser.D2Parser)
+		if !ok {
+			return fmt.Errorf("--syntax-only requires D2 input")
+		}
+		err := runWithTimeout(validateTimeout, func() error {
+			return d2Parser.CheckSyntax(string(content), inputFile)
+		})
+		if err != nil {
+			return fmt.Errorf("validation failed: %w", err)
+		}
+		fmt.Printf("✓ %s syntax is valid\n", inputFile)
+		if verbose {
+			fmt.Printf("  Time: %s\n", time.Since(start).Round(time.Microsecond))
+		}
+		return nil
+	}
+
 	// Parse the file
-	p := parser.NewD2Parser()
-	diagram, err := p.Parse(string(content))
+	var diagram *ir.Diagram
+	err = runWithTimeout(validateTimeout, func() error {
+		var err error
+		diagram, err = parser.ParseFile(p, string(content), inputFile)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("validation failed: %w", err)
 	}
@@ -59,11 +122,22 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("found %d validation error(s)", len(validationErrors))
 	}
 
+	// Report warnings without failing
+	warnings := diagram.Lint(ir.LintOptions{
+		AllowSelfLoops:  allowSelfLoops,
+		EmptyContainers: strictLint,
+		DuplicateEdges:  strictLint,
+	})
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
 	// Success
 	if verbose {
 		fmt.Printf("✓ %s is valid\n", inputFile)
 		fmt.Printf("  Nodes: %d\n", len(diagram.Nodes))
 		fmt.Printf("  Edges: %d\n", len(diagram.Edges))
+		fmt.Printf("  Time: %s\n", time.Since(start).Round(time.Microsecond))
 	} else {
 		fmt.Printf("✓ %s is valid (%d nodes, %d edges)\n",
 			inputFile, len(diagram.Nodes), len(diagram.Edges))
@@ -71,3 +145,23 @@ func runValidate(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// runWithTimeout runs fn, giving up after timeout (no limit if zero).
+// The parser doesn't take a context, so fn is abandoned rather than cancelled.
+func runWithTimeout(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
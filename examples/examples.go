@@ -0,0 +1,91 @@
+// Package examples bundles the example D2 diagrams into the diagtool binary,
+// so new users can write them out as starter files.
+package examples
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed [0-9]*.d2 c4/*.d2
+var files embed.FS
+
+// Example is a bundled example diagram.
+type Example struct {
+	Name  string // Path without the .d2 extension, e.g. "c4/01-system-context"
+	Title string // First comment line of the file
+	File  string // Path of the file in the bundle
+}
+
+// List returns the bundled examples, sorted by name.
+func List() ([]Example, error) {
+	var examples []Example
+	err := fs.WalkDir(files, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := files.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		examples = append(examples, Example{
+			Name:  strings.TrimSuffix(p, ".d2"),
+			Title: title(string(content)),
+			File:  p,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(examples, func(i, j int) bool { return examples[i].Name < examples[j].Name })
+	return examples, nil
+}
+
+// Read returns the D2 source of the named example. A name without its
+// directory, such as "01-system-context", is accepted when it is unique.
+func Read(name string) (Example, []byte, error) {
+	examples, err := List()
+	if err != nil {
+		return Example{}, nil, err
+	}
+
+	name = strings.TrimSuffix(name, ".d2")
+	var matches []Example
+	for _, ex := range examples {
+		if ex.Name == name {
+			matches = []Example{ex}
+			break
+		}
+		if path.Base(ex.Name) == name {
+			matches = append(matches, ex)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Example{}, nil, fmt.Errorf("unknown example: %s (run 'diagtool examples list' to see available examples)", name)
+	case 1:
+	default:
+		return Example{}, nil, fmt.Errorf("ambiguous example name: %s", name)
+	}
+
+	content, err := files.ReadFile(matches[0].File)
+	if err != nil {
+		return Example{}, nil, err
+	}
+	return matches[0], content, nil
+}
+
+// title returns the text of the first comment line in source.
+func title(source string) string {
+	line, _, _ := strings.Cut(source, "\n")
+	if !strings.HasPrefix(line, "#") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "#"))
+}
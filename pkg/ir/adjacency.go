@@ -0,0 +1,31 @@
+package ir
+
+// AdjacencyMatrix returns the node IDs in diagram order and a matrix of edge
+// counts between them: matrix[i][j] is the number of edges from ids[i] to
+// ids[j]. Edges are counted from source to target whatever their arrows, and
+// edges that reference unknown nodes are skipped.
+func (d *Diagram) AdjacencyMatrix() ([]string, [][]int) {
+	ids := make([]string, len(d.Nodes))
+	index := make(map[string]int, len(d.Nodes))
+	for i, node := range d.Nodes {
+		ids[i] = node.ID
+		index[node.ID] = i
+	}
+
+	matrix := make([][]int, len(ids))
+	for i := range matrix {
+		matrix[i] = make([]int, len(ids))
+	}
+	for _, edge := range d.Edges {
+		src, ok := index[edge.Source]
+		if !ok {
+			continue
+		}
+		dst, ok := index[edge.Target]
+		if !ok {
+			continue
+		}
+		matrix[src][dst]++
+	}
+	return ids, matrix
+}
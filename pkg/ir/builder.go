@@ -0,0 +1,213 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Builder constructs a diagram from Go code, deriving hierarchical node IDs
+// and Container fields from the nesting:
+//
+//	b := ir.NewBuilder()
+//	b.Container("aws").Child("vpc", func(vpc *ir.NodeBuilder) {
+//		vpc.Child("server", func(n *ir.NodeBuilder) { n.Label("Web") })
+//	})
+//	b.Node("db").Shape(ir.ShapeCylinder)
+//	b.Edge("aws.vpc.server", "db").Label("SQL")
+//	diagram := b.Build()
+//
+// Like D2, nodes are created on first use: a dotted ID creates the
+// containers above it, and an edge creates nodes it connects that don't
+// exist yet. Using an ID again returns the same node.
+type Builder struct {
+	diagram *Diagram
+	nodes   map[string]*Node
+	edges   map[string]int // Number of edges between each source and target
+}
+
+// NodeBuilder sets the fields of a node added with a Builder.
+type NodeBuilder struct {
+	builder *Builder
+	node    *Node
+}
+
+// EdgeBuilder sets the fields of an edge added with a Builder.
+type EdgeBuilder struct {
+	edge *Edge
+}
+
+// NewBuilder returns a builder for an empty diagram.
+func NewBuilder() *Builder {
+	return &Builder{
+		diagram: &Diagram{Metadata: make(map[string]string)},
+		nodes:   make(map[string]*Node),
+		edges:   make(map[string]int),
+	}
+}
+
+// ID sets the diagram's ID.
+func (b *Builder) ID(id string) *Builder {
+	b.diagram.ID = id
+	return b
+}
+
+// Title sets the diagram's title.
+func (b *Builder) Title(title string) *Builder {
+	b.diagram.Metadata["title"] = title
+	return b
+}
+
+// Node returns the node with the given ID, adding it as a rectangle labeled
+// with the last part of its ID if it doesn't exist.
+func (b *Builder) Node(id string) *NodeBuilder {
+	return &NodeBuilder{builder: b, node: b.ensureNode(id)}
+}
+
+// Container returns the node with the given ID like Node, as a container.
+func (b *Builder) Container(id string) *NodeBuilder {
+	return b.Node(id).Shape(ShapeContainer)
+}
+
+// Edge adds a forward edge from source to target, adding either node if it
+// doesn't exist. Edge IDs follow the parser's "source-target-index" form.
+func (b *Builder) Edge(source, target string) *EdgeBuilder {
+	b.ensureNode(source)
+	b.ensureNode(target)
+
+	key := source + "\x00" + target
+	edge := &Edge{
+		ID:        fmt.Sprintf("%s-%s-%d", source, target, b.edges[key]),
+		Source:    source,
+		Target:    target,
+		Direction: DirectionForward,
+	}
+	b.edges[key]++
+	b.diagram.Edges = append(b.diagram.Edges, edge)
+	return &EdgeBuilder{edge: edge}
+}
+
+// Build returns the diagram built so far. Later changes to the builder
+// don't affect it.
+func (b *Builder) Build() *Diagram {
+	return b.diagram.Clone()
+}
+
+// ensureNode returns the node with the given ID, adding it and any missing
+// containers above it.
+func (b *Builder) ensureNode(id string) *Node {
+	if node, ok := b.nodes[id]; ok {
+		return node
+	}
+
+	node := &Node{ID: id, Label: id, Shape: ShapeRectangle}
+	if i := strings.LastIndex(id, "."); i >= 0 {
+		parent := b.ensureNode(id[:i])
+		parent.Shape = ShapeContainer
+		node.Container = parent.ID
+		node.Label = id[i+1:]
+	}
+	b.nodes[id] = node
+	b.diagram.Nodes = append(b.diagram.Nodes, node)
+	return node
+}
+
+// ID returns the node's hierarchical ID, for use in edges.
+func (n *NodeBuilder) ID() string {
+	return n.node.ID
+}
+
+// Label sets the node's label.
+func (n *NodeBuilder) Label(label string) *NodeBuilder {
+	n.node.Label = label
+	return n
+}
+
+// Shape sets the node's shape.
+func (n *NodeBuilder) Shape(shape ShapeType) *NodeBuilder {
+	n.node.Shape = shape
+	return n
+}
+
+// Style sets the node's style.
+func (n *NodeBuilder) Style(style Style) *NodeBuilder {
+	n.node.Style = style
+	return n
+}
+
+// Spacing sets the multiplier of the gaps between the node's children; see
+// Node.Spacing.
+func (n *NodeBuilder) Spacing(multiplier float64) *NodeBuilder {
+	n.node.Spacing = multiplier
+	return n
+}
+
+// Classes sets the names of the classes the node applies.
+func (n *NodeBuilder) Classes(names ...string) *NodeBuilder {
+	n.node.Classes = names
+	return n
+}
+
+// Tags sets the node's tags.
+func (n *NodeBuilder) Tags(tags ...string) *NodeBuilder {
+	n.node.Tags = tags
+	return n
+}
+
+// Child adds a node inside this one, whose ID is this node's ID followed by
+// a dot and id, making this node a container. The optional functions set up
+// the child, for example adding children of its own. Child returns this
+// node, so siblings can be chained.
+func (n *NodeBuilder) Child(id string, build ...func(child *NodeBuilder)) *NodeBuilder {
+	child := n.builder.Node(n.node.ID + "." + id)
+	for _, fn := range build {
+		fn(child)
+	}
+	return n
+}
+
+// ID returns the edge's ID.
+func (e *EdgeBuilder) ID() string {
+	return e.edge.ID
+}
+
+// Label sets the edge's label.
+func (e *EdgeBuilder) Label(label string) *EdgeBuilder {
+	e.edge.Label = label
+	return e
+}
+
+// Forward draws the edge with an arrowhead at the target, the default.
+func (e *EdgeBuilder) Forward() *EdgeBuilder {
+	e.edge.Direction = DirectionForward
+	return e
+}
+
+// Backward draws the edge with an arrowhead at the source.
+func (e *EdgeBuilder) Backward() *EdgeBuilder {
+	e.edge.Direction = DirectionBackward
+	return e
+}
+
+// Both draws the edge with arrowheads at both ends.
+func (e *EdgeBuilder) Both() *EdgeBuilder {
+	e.edge.Direction = DirectionBoth
+	return e
+}
+
+// Undirected draws the edge without arrowheads.
+func (e *EdgeBuilder) Undirected() *EdgeBuilder {
+	e.edge.Direction = DirectionNone
+	return e
+}
+
+// Style sets the edge's style.
+func (e *EdgeBuilder) Style(style Style) *EdgeBuilder {
+	e.edge.Style = style
+	return e
+}
+
+// Weight sets the edge's layout weight; see Edge.Weight.
+func (e *EdgeBuilder) Weight(weight int) *EdgeBuilder {
+	e.edge.Weight = weight
+	return e
+}
@@ -1,5 +1,7 @@
 package ir
 
+import "strings"
+
 // Diagram represents a complete diagram with all nodes and edges.
 type Diagram struct {
 	// Identity
@@ -9,9 +11,18 @@ type Diagram struct {
 	Nodes []*Node `json:"nodes"` // All nodes in the diagram
 	Edges []*Edge `json:"edges"` // All connections
 
+	// Boards
+	Boards []*Diagram `json:"boards,omitempty"` // Nested boards (layers, scenarios, steps), in source order
+
+	// Classes
+	Classes Classes `json:"classes,omitempty"` // Named classes that nodes and edges can apply
+
 	// Metadata
 	Metadata map[string]string `json:"metadata,omitempty"` // Diagram-level metadata (title, author, etc.)
 
+	// FolderOnly is true for boards that only group other boards and have no content of their own
+	FolderOnly bool `json:"folder_only,omitempty"`
+
 	// Configuration
 	Config DiagramConfig `json:"config,omitempty"` // Rendering configuration
 }
@@ -20,7 +31,7 @@ type Diagram struct {
 type DiagramConfig struct {
 	Theme        string `json:"theme,omitempty"`         // Theme name
 	LayoutEngine string `json:"layout_engine,omitempty"` // Layout engine (dagre, elk, tala)
-	Direction    string `json:"direction,omitempty"`     // Layout direction (TB, LR, etc.)
+	Direction    string `json:"direction,omitempty"`     // Layout direction: down, up, right, left (or TB, BT, LR, RL); set from D2's root direction
 }
 
 // GetNode returns a node by ID, or nil if not found.
@@ -43,6 +54,31 @@ func (d *Diagram) GetEdge(id string) *Edge {
 	return nil
 }
 
+// Board returns the nested board (layer, scenario, or step) with the given
+// name, searching depth-first, or nil if there is none.
+func (d *Diagram) Board(name string) *Diagram {
+	for _, board := range d.Boards {
+		if board.ID == name {
+			return board
+		}
+		if found := board.Board(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// BoardNames returns the names of all nested boards, depth-first in source
+// order.
+func (d *Diagram) BoardNames() []string {
+	var names []string
+	for _, board := range d.Boards {
+		names = append(names, board.ID)
+		names = append(names, board.BoardNames()...)
+	}
+	return names
+}
+
 // GetNodesByContainer returns all nodes within a specific container.
 func (d *Diagram) GetNodesByContainer(containerID string) []*Node {
 	var nodes []*Node
@@ -54,6 +90,30 @@ func (d *Diagram) GetNodesByContainer(containerID string) []*Node {
 	return nodes
 }
 
+// FindNodesByLabel returns all nodes whose label contains substr,
+// ignoring case. An empty substr matches every node.
+func (d *Diagram) FindNodesByLabel(substr string) []*Node {
+	substr = strings.ToLower(substr)
+	var nodes []*Node
+	for _, node := range d.Nodes {
+		if strings.Contains(strings.ToLower(node.Label), substr) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// FindNodesByShape returns all nodes with the given shape.
+func (d *Diagram) FindNodesByShape(shape ShapeType) []*Node {
+	var nodes []*Node
+	for _, node := range d.Nodes {
+		if node.Shape == shape {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
 // GetEdgesByNode returns all edges connected to a specific node.
 func (d *Diagram) GetEdgesByNode(nodeID string) []*Edge {
 	var edges []*Edge
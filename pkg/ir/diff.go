@@ -0,0 +1,136 @@
+package ir
+
+import (
+	"reflect"
+	"slices"
+)
+
+// DiagramDiff describes how one diagram differs from another. Layout output
+// (positions, sizes and edge points) is ignored.
+type DiagramDiff struct {
+	AddedNodes   []string // IDs of nodes only in the new diagram
+	RemovedNodes []string // IDs of nodes only in the old diagram
+	ChangedNodes []string // IDs of nodes whose label, shape, hierarchy, classes or properties changed
+	StyledNodes  []string // IDs of nodes whose only change is their style
+
+	AddedEdges   []string // IDs of edges only in the new diagram
+	RemovedEdges []string // IDs of edges only in the old diagram
+	ChangedEdges []string // IDs of edges whose label, endpoints, arrows, classes or properties changed
+	StyledEdges  []string // IDs of edges whose only change is their style
+
+	ConfigChanged bool // Diagram configuration, metadata, classes or boards changed
+}
+
+// Diff compares two diagrams by node and edge ID. Added and changed entries
+// are listed in the new diagram's order, removed entries in the old one's.
+func Diff(old, new *Diagram) *DiagramDiff {
+	diff := &DiagramDiff{}
+
+	oldNodes := make(map[string]*Node, len(old.Nodes))
+	for _, node := range old.Nodes {
+		oldNodes[node.ID] = node
+	}
+	newNodes := make(map[string]bool, len(new.Nodes))
+	for _, node := range new.Nodes {
+		newNodes[node.ID] = true
+		prev, ok := oldNodes[node.ID]
+		switch {
+		case !ok:
+			diff.AddedNodes = append(diff.AddedNodes, node.ID)
+		case !sameNode(prev, node):
+			diff.ChangedNodes = append(diff.ChangedNodes, node.ID)
+		case prev.Style != node.Style:
+			diff.StyledNodes = append(diff.StyledNodes, node.ID)
+		}
+	}
+	for _, node := range old.Nodes {
+		if !newNodes[node.ID] {
+			diff.RemovedNodes = append(diff.RemovedNodes, node.ID)
+		}
+	}
+
+	oldEdges := make(map[string]*Edge, len(old.Edges))
+	for _, edge := range old.Edges {
+		oldEdges[edge.ID] = edge
+	}
+	newEdges := make(map[string]bool, len(new.Edges))
+	for _, edge := range new.Edges {
+		newEdges[edge.ID] = true
+		prev, ok := oldEdges[edge.ID]
+		switch {
+		case !ok:
+			diff.AddedEdges = append(diff.AddedEdges, edge.ID)
+		case !sameEdge(prev, edge):
+			diff.ChangedEdges = append(diff.ChangedEdges, edge.ID)
+		case prev.Style != edge.Style:
+			diff.StyledEdges = append(diff.StyledEdges, edge.ID)
+		}
+	}
+	for _, edge := range old.Edges {
+		if !newEdges[edge.ID] {
+			diff.RemovedEdges = append(diff.RemovedEdges, edge.ID)
+		}
+	}
+
+	diff.ConfigChanged = old.Config != new.Config ||
+		!reflect.DeepEqual(old.Metadata, new.Metadata) ||
+		!reflect.DeepEqual(old.Classes, new.Classes) ||
+		old.FolderOnly != new.FolderOnly ||
+		!sameBoards(old.Boards, new.Boards)
+
+	return diff
+}
+
+// IsEmpty reports whether the two diagrams are equivalent.
+func (d *DiagramDiff) IsEmpty() bool {
+	return d.IsStyleOnly() && len(d.StyledNodes) == 0 && len(d.StyledEdges) == 0
+}
+
+// IsStyleOnly reports whether every difference is a node or edge style
+// change. An empty diff is style-only.
+func (d *DiagramDiff) IsStyleOnly() bool {
+	return len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0 && len(d.ChangedNodes) == 0 &&
+		len(d.AddedEdges) == 0 && len(d.RemovedEdges) == 0 && len(d.ChangedEdges) == 0 &&
+		!d.ConfigChanged
+}
+
+// sameNode reports whether two nodes match in everything but style and layout.
+func sameNode(a, b *Node) bool {
+	return a.Label == b.Label &&
+		a.Shape == b.Shape &&
+		a.Container == b.Container &&
+		a.Direction == b.Direction &&
+		a.Near == b.Near &&
+		a.Spacing == b.Spacing &&
+		slices.Equal(a.Classes, b.Classes) &&
+		slices.Equal(a.Tags, b.Tags) &&
+		reflect.DeepEqual(a.Properties, b.Properties)
+}
+
+// sameEdge reports whether two edges match in everything but style and layout.
+func sameEdge(a, b *Edge) bool {
+	return a.Label == b.Label &&
+		a.Source == b.Source &&
+		a.Target == b.Target &&
+		a.SourcePort == b.SourcePort &&
+		a.TargetPort == b.TargetPort &&
+		a.Direction == b.Direction &&
+		a.SourceArrowhead == b.SourceArrowhead &&
+		a.TargetArrowhead == b.TargetArrowhead &&
+		a.Weight == b.Weight &&
+		slices.Equal(a.Classes, b.Classes) &&
+		reflect.DeepEqual(a.Properties, b.Properties)
+}
+
+// sameBoards reports whether two board lists are equivalent.
+func sameBoards(a, b []*Diagram) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || !Diff(a[i], b[i]).IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
@@ -9,20 +9,45 @@ type Edge struct {
 	// Connection
 	Source     string    `json:"source"`                // Source node ID
 	Target     string    `json:"target"`                // Target node ID
-	SourcePort string    `json:"source_port,omitempty"` // Connection point on source (for SQL tables, etc.)
+	SourcePort string    `json:"source_port,omitempty"` // Connection point on source by name, e.g. a SQL table column
 	TargetPort string    `json:"target_port,omitempty"` // Connection point on target
 	Direction  Direction `json:"direction"`             // Arrow direction
 
+	// Arrowheads (D2 arrowhead shapes such as triangle, diamond, circle, cf-many)
+	SourceArrowhead string `json:"source_arrowhead,omitempty"` // Arrowhead shape at the source end
+	TargetArrowhead string `json:"target_arrowhead,omitempty"` // Arrowhead shape at the target end
+
 	// Visual
-	Style Style `json:"style,omitempty"` // Visual styling
+	Style   Style    `json:"style,omitempty"`   // Visual styling, including styles from classes
+	Classes []string `json:"classes,omitempty"` // Names of applied classes, in order
+
+	// Layout hints
+	Weight      int    `json:"weight,omitempty"`       // Layout priority; heavier edges are kept shorter and straighter (default: 1)
+	RoutingMode string `json:"routing_mode,omitempty"` // Route drawn by the editor and metadata renders: direct or orthogonal (default: direct)
 
 	// Layout (populated by layout engine)
 	Points []Point `json:"points,omitempty"` // Path coordinates
+	Curved bool    `json:"curved,omitempty"` // Route is drawn as a curve rather than straight segments
 
 	// Extensibility
 	Properties map[string]interface{} `json:"properties,omitempty"` // Custom properties
 }
 
+// Edge routing modes, matching the values the browser editor stores.
+const (
+	RoutingDirect     = "direct"
+	RoutingOrthogonal = "orthogonal"
+)
+
+// MaxEdgeWeight is the largest edge weight layouts honor.
+const MaxEdgeWeight = 100
+
+// LayoutWeight returns the edge's weight for layout: 1 if unset, and at
+// most MaxEdgeWeight.
+func (e *Edge) LayoutWeight() int {
+	return min(max(e.Weight, 1), MaxEdgeWeight)
+}
+
 // Point represents a coordinate in the edge path.
 type Point struct {
 	X float64 `json:"x"`
@@ -0,0 +1,281 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToMermaid generates a Mermaid flowchart from the diagram: a "graph"
+// header in the diagram's direction, nodes with the closest Mermaid shape,
+// containers as subgraphs, and edges with their labels. Styles, ports and
+// arrowhead shapes have no Mermaid equivalent and are dropped.
+func ToMermaid(d *Diagram) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph %s\n", mermaidDirection(d.Config.Direction))
+	ids := identifiers(d, mermaidKeywords)
+	written := make(map[*Node]bool)
+	for _, node := range d.GetRootNodes() {
+		writeMermaidNode(d, &b, node, ids, written, 1)
+	}
+	for _, edge := range d.Edges {
+		source, target := ids[edge.Source], ids[edge.Target]
+		arrow := "-->"
+		switch edge.Direction {
+		case DirectionBackward:
+			// Mermaid has no left arrow, so the edge is turned around
+			source, target = target, source
+		case DirectionBoth:
+			arrow = "<-->"
+		case DirectionNone:
+			arrow = "---"
+		}
+		if edge.Label != "" {
+			arrow += "|" + mermaidText(edge.Label) + "|"
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", source, arrow, target)
+	}
+	return b.String()
+}
+
+// writeMermaidNode writes a node, or a container as a subgraph of its
+// children, under its identifier in ids. Nodes in written are skipped, so a
+// container cycle can't recurse forever.
+func writeMermaidNode(d *Diagram, b *strings.Builder, node *Node, ids map[string]string, written map[*Node]bool, indent int) {
+	if written[node] {
+		return
+	}
+	written[node] = true
+	prefix := strings.Repeat("  ", indent)
+	id := ids[node.ID]
+	label := `"` + mermaidText(nodeLabel(node)) + `"`
+
+	children := d.GetNodesByContainer(node.ID)
+	if node.IsContainer() || len(children) > 0 {
+		fmt.Fprintf(b, "%ssubgraph %s[%s]\n", prefix, id, label)
+		for _, child := range children {
+			writeMermaidNode(d, b, child, ids, written, indent+1)
+		}
+		fmt.Fprintf(b, "%send\n", prefix)
+		return
+	}
+
+	left, right := "[", "]"
+	switch node.Shape {
+	case ShapeCircle:
+		left, right = "((", "))"
+	case ShapeOval:
+		left, right = "([", "])"
+	case ShapeDiamond:
+		left, right = "{", "}"
+	case ShapeHexagon:
+		left, right = "{{", "}}"
+	case ShapeParallelogram:
+		left, right = "[/", "/]"
+	case ShapeCylinder:
+		left, right = "[(", ")]"
+	case ShapeQueue, ShapeCloud, ShapePerson:
+		left, right = "(", ")"
+	}
+	fmt.Fprintf(b, "%s%s%s%s%s\n", prefix, id, left, label, right)
+}
+
+// mermaidDirection returns the Mermaid direction for a diagram direction,
+// top to bottom by default.
+func mermaidDirection(direction string) string {
+	switch strings.ToLower(strings.TrimSpace(direction)) {
+	case "up", "bt":
+		return "BT"
+	case "right", "lr":
+		return "LR"
+	case "left", "rl":
+		return "RL"
+	default:
+		return "TD"
+	}
+}
+
+// mermaidText escapes a label for a quoted Mermaid string or edge label.
+func mermaidText(text string) string {
+	return strings.NewReplacer(`"`, "#quot;", "|", "#124;", "\n", "<br>").Replace(text)
+}
+
+// ToPlantUML generates a PlantUML component diagram from the diagram:
+// nodes as the closest PlantUML element, containers as rectangles around
+// their children, and edges as arrows with their labels, between
+// "@startuml" and "@enduml". PlantUML only lays out top to bottom or left
+// to right, so up and left diagrams keep the default top to bottom.
+func ToPlantUML(d *Diagram) string {
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	if title := d.Metadata["title"]; title != "" {
+		fmt.Fprintf(&b, "title %s\n", plantUMLText(title))
+	}
+	switch strings.ToLower(strings.TrimSpace(d.Config.Direction)) {
+	case "right", "lr":
+		b.WriteString("left to right direction\n")
+	}
+	b.WriteString("\n")
+
+	ids := identifiers(d, plantUMLKeywords)
+	written := make(map[*Node]bool)
+	for _, node := range d.GetRootNodes() {
+		writePlantUMLNode(d, &b, node, ids, written, 0)
+	}
+	if len(d.Edges) > 0 {
+		b.WriteString("\n")
+	}
+	for _, edge := range d.Edges {
+		arrow := "-->"
+		switch edge.Direction {
+		case DirectionBackward:
+			arrow = "<--"
+		case DirectionBoth:
+			arrow = "<-->"
+		case DirectionNone:
+			arrow = "--"
+		}
+		fmt.Fprintf(&b, "%s %s %s", ids[edge.Source], arrow, ids[edge.Target])
+		if edge.Label != "" {
+			fmt.Fprintf(&b, " : %s", plantUMLText(edge.Label))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// writePlantUMLNode writes a node, or a container as a rectangle around its
+// children, under its identifier in ids, skipping nodes in written like
+// writeMermaidNode.
+func writePlantUMLNode(d *Diagram, b *strings.Builder, node *Node, ids map[string]string, written map[*Node]bool, indent int) {
+	if written[node] {
+		return
+	}
+	written[node] = true
+	prefix := strings.Repeat("  ", indent)
+	declaration := fmt.Sprintf(`"%s" as %s`, plantUMLText(nodeLabel(node)), ids[node.ID])
+
+	children := d.GetNodesByContainer(node.ID)
+	if node.IsContainer() || len(children) > 0 {
+		fmt.Fprintf(b, "%srectangle %s {\n", prefix, declaration)
+		for _, child := range children {
+			writePlantUMLNode(d, b, child, ids, written, indent+1)
+		}
+		fmt.Fprintf(b, "%s}\n", prefix)
+		return
+	}
+
+	element := "component"
+	switch node.Shape {
+	case ShapePerson:
+		element = "actor"
+	case ShapeCylinder:
+		element = "database"
+	case ShapeCloud:
+		element = "cloud"
+	case ShapeQueue:
+		element = "queue"
+	case ShapeCircle:
+		element = "circle"
+	case ShapeOval:
+		element = "usecase"
+	case ShapeHexagon:
+		element = "hexagon"
+	}
+	fmt.Fprintf(b, "%s%s %s\n", prefix, element, declaration)
+}
+
+// plantUMLText escapes a label for a quoted PlantUML name or arrow label.
+func plantUMLText(text string) string {
+	return strings.NewReplacer(`"`, "'", "\n", `\n`).Replace(text)
+}
+
+// nodeLabel returns the node's label, or its local ID if it has none.
+func nodeLabel(node *Node) string {
+	if node.Label != "" {
+		return node.Label
+	}
+	if i := strings.LastIndex(node.ID, "."); i >= 0 {
+		return node.ID[i+1:]
+	}
+	return node.ID
+}
+
+// mermaidKeywords are the words Mermaid flowcharts don't accept as node
+// identifiers, in lowercase. A node called "end" would close a subgraph.
+var mermaidKeywords = keywordSet(
+	"end", "graph", "flowchart", "subgraph", "direction",
+	"style", "classdef", "class", "click", "linkstyle", "call", "href",
+)
+
+// plantUMLKeywords are the words PlantUML reads as commands rather than
+// names at the start of a line, in lowercase. Element types such as queue
+// are accepted as names.
+var plantUMLKeywords = keywordSet(
+	"as", "end", "title", "note", "legend", "header", "footer", "skinparam",
+	"left", "top", "together", "hide", "show", "remove", "scale",
+)
+
+func keywordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+// identifiers maps every node ID in the diagram, and every edge end, to an
+// identifier unique within the diagram. IDs that are already identifiers
+// keep them; others, such as "a.b" next to "a_b" or "api gw" next to
+// "api-gw", get a numeric suffix where their identifier is taken. Keywords,
+// compared ignoring case, get an underscore appended.
+func identifiers(d *Diagram, keywords map[string]bool) map[string]string {
+	ids := make(map[string]string)
+	taken := make(map[string]bool)
+	assign := func(id string, exactOnly bool) {
+		if _, ok := ids[id]; ok {
+			return
+		}
+		base := identifier(id)
+		if keywords[strings.ToLower(base)] {
+			base += "_"
+		}
+		if exactOnly && (base != id || taken[base]) {
+			return
+		}
+		name := base
+		for n := 2; taken[name] || keywords[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		ids[id] = name
+		taken[name] = true
+	}
+
+	var all []string
+	for _, node := range d.Nodes {
+		all = append(all, node.ID)
+	}
+	for _, edge := range d.Edges {
+		all = append(all, edge.Source, edge.Target)
+	}
+	// IDs that need no changes first, so they are kept whatever the order
+	for _, id := range all {
+		assign(id, true)
+	}
+	for _, id := range all {
+		assign(id, false)
+	}
+	return ids
+}
+
+// identifier returns a node ID with every character other than letters,
+// digits and underscores replaced by an underscore, as Mermaid and PlantUML
+// don't accept dots and other punctuation in IDs.
+func identifier(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, id)
+}
@@ -0,0 +1,91 @@
+package ir
+
+// DiagramGeometry holds the laid-out geometry of a diagram in absolute
+// coordinates, for use by external renderers.
+type DiagramGeometry struct {
+	Nodes map[string]Rect    `json:"nodes"` // Node bounding boxes by node ID
+	Edges map[string][]Point `json:"edges"` // Edge polylines by edge ID
+}
+
+// Rect represents an axis-aligned bounding box.
+type Rect struct {
+	X      float64 `json:"x"`      // Left edge
+	Y      float64 `json:"y"`      // Top edge
+	Width  float64 `json:"width"`  // Box width
+	Height float64 `json:"height"` // Box height
+}
+
+// Geometry returns the node boxes and edge polylines of a laid-out diagram.
+// Nodes without a position and edges without points are omitted.
+// The returned geometry does not share memory with the diagram.
+func (d *Diagram) Geometry() *DiagramGeometry {
+	geometry := &DiagramGeometry{
+		Nodes: make(map[string]Rect, len(d.Nodes)),
+		Edges: make(map[string][]Point, len(d.Edges)),
+	}
+
+	for _, node := range d.Nodes {
+		if node.Position == nil {
+			continue
+		}
+		geometry.Nodes[node.ID] = Rect{
+			X:      node.Position.X,
+			Y:      node.Position.Y,
+			Width:  node.Width,
+			Height: node.Height,
+		}
+	}
+
+	for _, edge := range d.Edges {
+		if len(edge.Points) == 0 {
+			continue
+		}
+		points := make([]Point, len(edge.Points))
+		copy(points, edge.Points)
+		geometry.Edges[edge.ID] = points
+	}
+
+	return geometry
+}
+
+// EdgeCrossings returns the number of times the edges of a laid-out diagram
+// cross each other, a measure of layout quality: each pair of segments from
+// two different edges that cross counts once. Edges meeting only at an end,
+// such as two edges leaving the same point, or running along each other do
+// not count. Edges without at least two points are ignored.
+func (d *Diagram) EdgeCrossings() int {
+	crossings := 0
+	for i, a := range d.Edges {
+		for _, b := range d.Edges[i+1:] {
+			for j := 1; j < len(a.Points); j++ {
+				for k := 1; k < len(b.Points); k++ {
+					if segmentsCross(a.Points[j-1], a.Points[j], b.Points[k-1], b.Points[k]) {
+						crossings++
+					}
+				}
+			}
+		}
+	}
+	return crossings
+}
+
+// segmentsCross reports whether segments p1-p2 and q1-q2 cross at a point
+// inside both of them.
+func segmentsCross(p1, p2, q1, q2 Point) bool {
+	return orientation(p1, p2, q1)*orientation(p1, p2, q2) < 0 &&
+		orientation(q1, q2, p1)*orientation(q1, q2, p2) < 0
+}
+
+// orientation returns the sign of the turn from a-b to a-c: positive for
+// counter-clockwise, negative for clockwise, and zero if collinear.
+func orientation(a, b, c Point) float64 {
+	cross := (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+	switch {
+	case cross > 0:
+		return 1
+	case cross < 0:
+		return -1
+	default:
+		return 0
+	}
+}
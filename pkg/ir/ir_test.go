@@ -1,6 +1,13 @@
 package ir
 
 import (
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -33,6 +40,87 @@ func TestDiagram_GetNode(t *testing.T) {
 	}
 }
 
+func TestDiagram_FindNodes(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "api", Label: "API Service", Shape: ShapeHexagon},
+			{ID: "db", Label: "Orders DB", Shape: ShapeCylinder},
+			{ID: "worker", Label: "Worker service", Shape: ShapeRectangle},
+		},
+	}
+
+	if got := diagram.FindNodesByLabel("SERVICE"); len(got) != 2 || got[0].ID != "api" || got[1].ID != "worker" {
+		t.Errorf("FindNodesByLabel(\"SERVICE\") = %v, expected api and worker", got)
+	}
+	if got := diagram.FindNodesByLabel("queue"); got != nil {
+		t.Errorf("FindNodesByLabel(\"queue\") = %v, expected nil", got)
+	}
+	if got := diagram.FindNodesByShape(ShapeCylinder); len(got) != 1 || got[0].ID != "db" {
+		t.Errorf("FindNodesByShape(cylinder) = %v, expected db", got)
+	}
+}
+
+func TestDiagram_Geometry(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "a", Position: &Position{X: 10, Y: 20}, Width: 100, Height: 50},
+			{ID: "b"},
+		},
+		Edges: []*Edge{
+			{ID: "a->b", Source: "a", Target: "b", Points: []Point{{X: 60, Y: 70}, {X: 60, Y: 120}}},
+			{ID: "b->a", Source: "b", Target: "a"},
+		},
+	}
+
+	geometry := diagram.Geometry()
+
+	if got := geometry.Nodes["a"]; got != (Rect{X: 10, Y: 20, Width: 100, Height: 50}) {
+		t.Errorf("Box for a = %+v", got)
+	}
+	if _, ok := geometry.Nodes["b"]; ok {
+		t.Error("Node without position should be omitted")
+	}
+	if len(geometry.Edges) != 1 || len(geometry.Edges["a->b"]) != 2 {
+		t.Errorf("Expected one polyline with 2 points, got %v", geometry.Edges)
+	}
+
+	// Geometry is a copy
+	geometry.Edges["a->b"][0].X = 0
+	if diagram.Edges[0].Points[0].X != 60 {
+		t.Error("Modifying geometry should not modify the diagram")
+	}
+}
+
+func TestDiagram_EdgeCrossings(t *testing.T) {
+	// A 2x2 grid of nodes with both diagonals, which cross in the middle,
+	// and the sides, which only meet at the corners
+	diagonals := &Diagram{
+		Edges: []*Edge{
+			{ID: "a-d", Points: []Point{{X: 0, Y: 0}, {X: 100, Y: 100}}},
+			{ID: "b-c", Points: []Point{{X: 100, Y: 0}, {X: 0, Y: 100}}},
+			{ID: "a-b", Points: []Point{{X: 0, Y: 0}, {X: 100, Y: 0}}},
+			{ID: "c-d", Points: []Point{{X: 0, Y: 100}, {X: 100, Y: 100}}},
+			// Bends across both diagonals
+			{ID: "zigzag", Points: []Point{{X: -10, Y: 20}, {X: 110, Y: 20}, {X: 110, Y: 80}, {X: -10, Y: 80}}},
+		},
+	}
+	if got := diagonals.EdgeCrossings(); got != 5 {
+		t.Errorf("expected 5 crossings, got %d", got)
+	}
+
+	chain := &Diagram{
+		Edges: []*Edge{
+			{ID: "a-b", Points: []Point{{X: 0, Y: 0}, {X: 0, Y: 100}}},
+			{ID: "b-c", Points: []Point{{X: 0, Y: 100}, {X: 50, Y: 150}, {X: 0, Y: 200}}},
+			{ID: "c-d", Points: []Point{{X: 0, Y: 200}, {X: 0, Y: 300}}},
+			{ID: "unrouted"},
+		},
+	}
+	if got := chain.EdgeCrossings(); got != 0 {
+		t.Errorf("expected no crossings in a chain, got %d", got)
+	}
+}
+
 func TestDiagram_GetRootNodes(t *testing.T) {
 	diagram := &Diagram{
 		Nodes: []*Node{
@@ -190,6 +278,59 @@ func TestStyle_Merge(t *testing.T) {
 	}
 }
 
+func TestParseStrokeDash(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"dotted", StrokeDashPresets["dotted"], true},
+		{"Dashed", StrokeDashPresets["dashed"], true},
+		{"solid", 0, true},
+		{"4", 4, true},
+		{"wavy", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseStrokeDash(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseStrokeDash(%q) = %d, %v; expected %d, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for dash, want := range map[int]int{-1: 0, 3: 3, 25: MaxStrokeDash} {
+		if got := (Style{StrokeDash: dash}).D2StrokeDash(); got != want {
+			t.Errorf("D2StrokeDash() for %d = %d, expected %d", dash, got, want)
+		}
+	}
+}
+
+func TestClasses_Applied(t *testing.T) {
+	classes := Classes{
+		"c4-system": {Style: Style{Fill: "#1168bd", FontColor: "#ffffff"}},
+		"db":        {Shape: "cylinder", Style: Style{Fill: "#eeeeee"}},
+	}
+
+	applied := classes.Applied([]string{"c4-system", "db", "missing"})
+	if applied.Shape != "cylinder" {
+		t.Errorf("expected shape cylinder, got %q", applied.Shape)
+	}
+	if applied.Style.Fill != "#eeeeee" {
+		t.Errorf("expected the later class's fill #eeeeee, got %s", applied.Style.Fill)
+	}
+	if applied.Style.FontColor != "#ffffff" {
+		t.Errorf("expected font color #ffffff from c4-system, got %s", applied.Style.FontColor)
+	}
+
+	style := Style{Fill: "#eeeeee", FontColor: "#000000", Bold: true}
+	rest := style.Without(applied.Style)
+	if rest.Fill != "" {
+		t.Errorf("expected fill provided by the class to be cleared, got %s", rest.Fill)
+	}
+	if rest.FontColor != "#000000" || !rest.Bold {
+		t.Errorf("expected overrides to remain, got %+v", rest)
+	}
+}
+
 func TestDiagram_Validate(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -235,6 +376,32 @@ func TestDiagram_Validate(t *testing.T) {
 			expectErr: true,
 			errCount:  1,
 		},
+		{
+			name: "edge weight out of range",
+			diagram: &Diagram{
+				Nodes: []*Node{
+					{ID: "a", Shape: ShapeRectangle},
+					{ID: "b", Shape: ShapeRectangle},
+				},
+				Edges: []*Edge{
+					{ID: "e1", Source: "a", Target: "b", Direction: DirectionForward, Weight: -1},
+					{ID: "e2", Source: "a", Target: "b", Direction: DirectionForward, Weight: MaxEdgeWeight + 1},
+				},
+			},
+			expectErr: true,
+			errCount:  2,
+		},
+		{
+			name: "node spacing out of range",
+			diagram: &Diagram{
+				Nodes: []*Node{
+					{ID: "a", Shape: ShapeContainer, Spacing: -1},
+					{ID: "b", Shape: ShapeContainer, Spacing: MaxNodeSpacing + 1},
+				},
+			},
+			expectErr: true,
+			errCount:  2,
+		},
 		{
 			name: "invalid container reference",
 			diagram: &Diagram{
@@ -272,3 +439,899 @@ func TestDiagram_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestDiagram_Validate_ContainerCycle(t *testing.T) {
+	// IDs follow the Container chain so only the cycles are reported
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "b.a", Shape: ShapeContainer, Container: "a.b"},
+			{ID: "a.b", Shape: ShapeContainer, Container: "b.a"},
+			{ID: "b.a.c", Shape: ShapeRectangle, Container: "b.a"},
+			{ID: "self", Shape: ShapeContainer, Container: "self"},
+			{ID: "root", Shape: ShapeContainer},
+			{ID: "root.leaf", Shape: ShapeRectangle, Container: "root"},
+		},
+	}
+
+	var cycles []string
+	for _, err := range diagram.Validate() {
+		if strings.Contains(err.Error(), "container cycle") {
+			cycles = append(cycles, err.Error())
+		}
+	}
+	want := []string{
+		"node.Container: container cycle: b.a -> a.b -> b.a",
+		"node.Container: container cycle: self -> self",
+	}
+	if !slices.Equal(cycles, want) {
+		t.Errorf("Expected cycles %q, got %q", want, cycles)
+	}
+
+	// Generators still finish, writing the nodes they can reach
+	if out := ToMermaid(diagram); !strings.Contains(out, "root_leaf") {
+		t.Errorf("Expected the reachable nodes in the output, got:\n%s", out)
+	}
+}
+
+func TestDiagram_Stats(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "user", Shape: ShapePerson},
+			{ID: "cloud", Shape: ShapeContainer},
+			{ID: "cloud.api", Shape: ShapeRectangle, Container: "cloud"},
+			{ID: "cloud.db", Shape: ShapeContainer, Container: "cloud"},
+			{ID: "cloud.db.primary", Shape: ShapeCylinder, Container: "cloud.db"},
+			{ID: "notes", Shape: ShapeRectangle},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "user", Target: "cloud.api"},
+			{ID: "e2", Source: "cloud.api", Target: "cloud.db.primary"},
+		},
+	}
+
+	stats := diagram.Stats()
+
+	if stats.NodeCount != 6 {
+		t.Errorf("expected 6 nodes, got %d", stats.NodeCount)
+	}
+	if stats.EdgeCount != 2 {
+		t.Errorf("expected 2 edges, got %d", stats.EdgeCount)
+	}
+	if stats.ContainerCount != 2 {
+		t.Errorf("expected 2 containers, got %d", stats.ContainerCount)
+	}
+	if stats.MaxDepth != 2 {
+		t.Errorf("expected max depth 2, got %d", stats.MaxDepth)
+	}
+	if stats.OrphanCount != 1 { // notes
+		t.Errorf("expected 1 orphan, got %d", stats.OrphanCount)
+	}
+	if stats.ShapeCounts[ShapeRectangle] != 2 || stats.ShapeCounts[ShapeContainer] != 2 {
+		t.Errorf("unexpected shape counts: %v", stats.ShapeCounts)
+	}
+}
+
+func TestDiagram_Stats_Empty(t *testing.T) {
+	stats := (&Diagram{}).Stats()
+	if stats.NodeCount != 0 || stats.EdgeCount != 0 || stats.MaxDepth != 0 || len(stats.ShapeCounts) != 0 {
+		t.Errorf("expected zero stats for empty diagram, got %+v", stats)
+	}
+}
+
+func TestDiagram_Lint_SelfLoops(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "a", Shape: ShapeRectangle},
+			{ID: "b", Shape: ShapeRectangle},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "a", Target: "b", Direction: DirectionForward},
+			{ID: "e2", Source: "a", Target: "a", Direction: DirectionForward},
+		},
+	}
+
+	warnings := diagram.Lint(LintOptions{})
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "self-loop") || !strings.Contains(warnings[0].Message, "e2") {
+		t.Errorf("unexpected warning message: %s", warnings[0].Message)
+	}
+
+	// Self-loops are still valid
+	if errs := diagram.Validate(); len(errs) != 0 {
+		t.Errorf("self-loop should not be a validation error, got: %v", errs)
+	}
+
+	if warnings := diagram.Lint(LintOptions{AllowSelfLoops: true}); len(warnings) != 0 {
+		t.Errorf("expected no warnings with AllowSelfLoops, got: %v", warnings)
+	}
+}
+
+func TestDiagram_Lint_EmptyContainers(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "cloud", Shape: ShapeContainer},
+			{ID: "cloud.api", Shape: ShapeRectangle, Container: "cloud"},
+			{ID: "onprem", Shape: ShapeContainer},
+		},
+	}
+
+	// Off by default
+	if warnings := diagram.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("expected no warnings by default, got: %v", warnings)
+	}
+	if errs := diagram.Validate(); len(errs) != 0 {
+		t.Errorf("empty container should not be a validation error, got: %v", errs)
+	}
+
+	warnings := diagram.Lint(LintOptions{EmptyContainers: true})
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "onprem") {
+		t.Errorf("expected warning to name the empty container, got: %s", warnings[0].Message)
+	}
+}
+
+func TestDiagram_Lint_DuplicateEdges(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{{ID: "a"}, {ID: "b"}},
+		Edges: []*Edge{
+			{ID: "a-b-0", Source: "a", Target: "b", Direction: DirectionForward, Label: "x"},
+			{ID: "a-b-1", Source: "a", Target: "b", Direction: DirectionForward, Label: "x"},
+			{ID: "a-b-2", Source: "a", Target: "b", Direction: DirectionForward, Label: "y"},
+		},
+	}
+
+	// Off by default
+	if warnings := diagram.Lint(LintOptions{}); len(warnings) != 0 {
+		t.Errorf("expected no warnings by default, got: %v", warnings)
+	}
+
+	warnings := diagram.Lint(LintOptions{DuplicateEdges: true})
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "a-b-0, a-b-1") || strings.Contains(warnings[0].Message, "a-b-2") {
+		t.Errorf("expected warning to name only the identical edges, got: %s", warnings[0].Message)
+	}
+
+	// Parallel edges with different labels are intentional
+	diagram.Edges[1].Label = "z"
+	if warnings := diagram.Lint(LintOptions{DuplicateEdges: true}); len(warnings) != 0 {
+		t.Errorf("expected no warnings for differently labeled edges, got: %v", warnings)
+	}
+}
+
+func TestDiagram_Walk(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "cloud.api", Container: "cloud"},
+			{ID: "user"},
+			{ID: "cloud", Shape: ShapeContainer},
+			{ID: "cloud.db", Shape: ShapeContainer, Container: "cloud"},
+			{ID: "cloud.db.primary", Container: "cloud.db"},
+			{ID: "stray", Container: "missing"},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "user", Target: "cloud.api"},
+			{ID: "e2", Source: "cloud.api", Target: "cloud.db.primary"},
+		},
+	}
+
+	var nodeOrder []string
+	var edgeOrder []string
+	err := diagram.Walk(
+		func(n *Node) error {
+			nodeOrder = append(nodeOrder, n.ID)
+			return nil
+		},
+		func(e *Edge) error {
+			edgeOrder = append(edgeOrder, e.ID)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Walk returned error: %v", err)
+	}
+
+	expectedNodes := []string{"user", "cloud", "cloud.api", "cloud.db", "cloud.db.primary", "stray"}
+	if strings.Join(nodeOrder, ",") != strings.Join(expectedNodes, ",") {
+		t.Errorf("node order = %v, expected %v", nodeOrder, expectedNodes)
+	}
+	if strings.Join(edgeOrder, ",") != "e1,e2" {
+		t.Errorf("edge order = %v, expected [e1 e2]", edgeOrder)
+	}
+}
+
+func TestDiagram_Walk_PropagatesErrors(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+		Edges: []*Edge{{ID: "e1", Source: "a", Target: "b"}},
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err := diagram.Walk(func(n *Node) error {
+		visited++
+		if n.ID == "b" {
+			return stop
+		}
+		return nil
+	}, func(e *Edge) error {
+		t.Error("edges should not be visited after a node error")
+		return nil
+	})
+
+	if !errors.Is(err, stop) {
+		t.Errorf("expected stop error, got %v", err)
+	}
+	if visited != 2 {
+		t.Errorf("expected walk to stop after 2 nodes, visited %d", visited)
+	}
+
+	// Edge errors propagate too, and nil node callbacks are allowed
+	err = diagram.Walk(nil, func(e *Edge) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("expected stop error from edge callback, got %v", err)
+	}
+}
+
+func TestDiagram_MergeParallelEdges(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{{ID: "a"}, {ID: "b"}},
+		Edges: []*Edge{
+			{ID: "e1", Source: "a", Target: "b", Label: "first", Direction: DirectionForward},
+			{ID: "e2", Source: "a", Target: "b", Label: "second", Direction: DirectionForward},
+			{ID: "e3", Source: "b", Target: "a", Label: "reply", Direction: DirectionForward},
+			{ID: "e4", Source: "a", Target: "b", Direction: DirectionForward},
+			{ID: "e5", Source: "a", Target: "b", Label: "third", Direction: DirectionForward},
+			{ID: "e6", Source: "a", Target: "b", Label: "sync", Direction: DirectionBoth},
+		},
+	}
+
+	merged := diagram.MergeParallelEdges("")
+
+	if len(merged.Edges) != 3 {
+		t.Fatalf("Expected 3 edges after merging, got %d", len(merged.Edges))
+	}
+	if merged.Edges[0].ID != "e1" || merged.Edges[0].Label != "first, second, third" {
+		t.Errorf("Expected e1 labeled 'first, second, third', got %s '%s'", merged.Edges[0].ID, merged.Edges[0].Label)
+	}
+	if merged.Edges[1].ID != "e3" || merged.Edges[1].Label != "reply" {
+		t.Errorf("Reverse edge should not be merged, got %s '%s'", merged.Edges[1].ID, merged.Edges[1].Label)
+	}
+	if merged.Edges[2].ID != "e6" {
+		t.Errorf("Edge with a different direction should not be merged, got %s", merged.Edges[2].ID)
+	}
+
+	// The original diagram is untouched
+	if len(diagram.Edges) != 6 || diagram.Edges[0].Label != "first" {
+		t.Error("MergeParallelEdges modified the original diagram")
+	}
+
+	// Custom separator
+	if label := diagram.MergeParallelEdges(" / ").Edges[0].Label; label != "first / second / third" {
+		t.Errorf("Expected custom separator, got '%s'", label)
+	}
+}
+
+func TestDiagram_CollapseContainer(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "vpc", Shape: ShapeContainer},
+			{ID: "vpc.web", Shape: ShapeRectangle, Container: "vpc"},
+			{ID: "vpc.db", Shape: ShapeCylinder, Container: "vpc"},
+			{ID: "user", Shape: ShapePerson},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "user", Target: "vpc.web", Label: "visits", Direction: DirectionForward, Points: []Point{{X: 1, Y: 2}}},
+			{ID: "e2", Source: "vpc.web", Target: "vpc.db", Direction: DirectionForward},
+			{ID: "e3", Source: "user", Target: "vpc.db", Direction: DirectionForward},
+		},
+	}
+
+	collapsed := diagram.CollapseContainer("vpc")
+
+	if len(collapsed.Nodes) != 2 || collapsed.GetNode("vpc") == nil || collapsed.GetNode("user") == nil {
+		t.Fatalf("Expected nodes vpc and user, got %d nodes", len(collapsed.Nodes))
+	}
+	if collapsed.GetNode("vpc").IsContainer() {
+		t.Error("Collapsed container should be a leaf node")
+	}
+
+	// The external edge now points at the container; the internal edge and
+	// the resulting parallel edge are gone
+	if len(collapsed.Edges) != 1 {
+		t.Fatalf("Expected 1 edge, got %d", len(collapsed.Edges))
+	}
+	edge := collapsed.Edges[0]
+	if edge.ID != "e1" || edge.Source != "user" || edge.Target != "vpc" || edge.Label != "visits" {
+		t.Errorf("Expected e1 user -> vpc, got %s %s -> %s", edge.ID, edge.Source, edge.Target)
+	}
+	if edge.Points != nil {
+		t.Error("Redirected edge should drop its stale route")
+	}
+
+	// The original is untouched
+	if len(diagram.Nodes) != 4 || diagram.Edges[0].Target != "vpc.web" {
+		t.Error("CollapseContainer should not modify the original diagram")
+	}
+
+	// Unknown containers leave the diagram unchanged
+	if unchanged := diagram.CollapseContainer("missing"); len(unchanged.Nodes) != 4 || len(unchanged.Edges) != 3 {
+		t.Error("Collapsing an unknown container should not change the diagram")
+	}
+}
+
+func TestDiagram_Clone(t *testing.T) {
+	original := &Diagram{
+		ID: "test",
+		Nodes: []*Node{{
+			ID:         "web",
+			Style:      Style{Fill: "#ffffff"},
+			Position:   &Position{X: 10, Y: 20},
+			Properties: map[string]interface{}{"tags": []interface{}{"frontend"}, "owner": map[string]interface{}{"team": "web"}},
+		}},
+		Edges: []*Edge{{
+			ID: "e1", Source: "web", Target: "api",
+			Points:     []Point{{X: 0, Y: 0}, {X: 5, Y: 5}},
+			Properties: map[string]interface{}{"tooltip": "calls"},
+		}},
+		Metadata: map[string]string{"title": "Shop"},
+		Boards:   []*Diagram{{ID: "next", Nodes: []*Node{{ID: "a"}}}},
+	}
+
+	clone := original.Clone()
+	clone.Nodes[0].Style.Fill = "#000000"
+	clone.Nodes[0].Position.X = 99
+	clone.Nodes[0].Properties["tags"].([]interface{})[0] = "backend"
+	clone.Nodes[0].Properties["owner"].(map[string]interface{})["team"] = "platform"
+	clone.Edges[0].Points[1].X = 42
+	clone.Edges[0].Properties["tooltip"] = "changed"
+	clone.Metadata["title"] = "Other"
+	clone.Boards[0].Nodes[0].ID = "b"
+
+	node, edge := original.Nodes[0], original.Edges[0]
+	if node.Style.Fill != "#ffffff" || node.Position.X != 10 {
+		t.Errorf("Expected original node unchanged, got style %+v and position %+v", node.Style, node.Position)
+	}
+	if node.Properties["tags"].([]interface{})[0] != "frontend" || node.Properties["owner"].(map[string]interface{})["team"] != "web" {
+		t.Errorf("Expected original nested properties unchanged, got %v", node.Properties)
+	}
+	if edge.Points[1].X != 5 || edge.Properties["tooltip"] != "calls" {
+		t.Errorf("Expected original edge unchanged, got points %v and properties %v", edge.Points, edge.Properties)
+	}
+	if original.Metadata["title"] != "Shop" {
+		t.Errorf("Expected original metadata unchanged, got %v", original.Metadata)
+	}
+	if original.Boards[0].Nodes[0].ID != "a" {
+		t.Error("Expected original boards unchanged")
+	}
+}
+
+func TestDiagram_SortStable(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{{ID: "web"}, {ID: "api"}, {ID: "api.db", Container: "api"}},
+		Edges: []*Edge{
+			{ID: "e3", Source: "web", Target: "api"},
+			{ID: "e2", Source: "api", Target: "web"},
+			{ID: "e1", Source: "web", Target: "api"},
+		},
+		Boards: []*Diagram{{ID: "next", Nodes: []*Node{{ID: "b"}, {ID: "a"}}}},
+	}
+
+	diagram.SortStable()
+
+	var nodes, edges []string
+	for _, node := range diagram.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	for _, edge := range diagram.Edges {
+		edges = append(edges, edge.ID)
+	}
+	if got := strings.Join(nodes, ","); got != "api,api.db,web" {
+		t.Errorf("Expected nodes sorted by ID, got %s", got)
+	}
+	if got := strings.Join(edges, ","); got != "e2,e1,e3" {
+		t.Errorf("Expected edges sorted by source, target and ID, got %s", got)
+	}
+	if board := diagram.Boards[0]; board.Nodes[0].ID != "a" {
+		t.Error("Expected nested boards to be sorted")
+	}
+}
+
+func TestDiagram_Reachable(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "gateway"}, {ID: "auth"}, {ID: "orders"}, {ID: "db"},
+			{ID: "cache"}, {ID: "audit"}, {ID: "batch"}, {ID: "legacy"},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "gateway", Target: "auth", Direction: DirectionForward},
+			{ID: "e2", Source: "gateway", Target: "orders", Direction: DirectionForward},
+			{ID: "e3", Source: "orders", Target: "db", Direction: DirectionForward},
+			{ID: "e4", Source: "orders", Target: "cache", Direction: DirectionNone},
+			{ID: "e5", Source: "audit", Target: "auth", Direction: DirectionBackward},
+			{ID: "e6", Source: "batch", Target: "db", Direction: DirectionForward},
+		},
+	}
+
+	reachable := diagram.Reachable([]string{"gateway"})
+	for _, id := range []string{"gateway", "auth", "orders", "db", "cache", "audit"} {
+		if !reachable[id] {
+			t.Errorf("Expected %s to be reachable", id)
+		}
+	}
+	if len(reachable) != 6 {
+		t.Errorf("Expected 6 reachable nodes, got %v", reachable)
+	}
+
+	unreachable := diagram.UnreachableNodes([]string{"gateway", "missing"})
+	if got := strings.Join(unreachable, ","); got != "batch,legacy" {
+		t.Errorf("Expected batch and legacy to be unreachable, got %s", got)
+	}
+	if got := strings.Join(diagram.OrphanNodes(), ","); got != "legacy" {
+		t.Errorf("Expected legacy to be the only orphan, got %s", got)
+	}
+}
+
+func TestDiagram_ExtractNeighborhood(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "a"}, {ID: "b"}, {ID: "c"},
+			{ID: "vpc", Shape: ShapeContainer},
+			{ID: "vpc.d", Container: "vpc"},
+			{ID: "vpc.e", Container: "vpc"},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "a", Target: "b"},
+			{ID: "e2", Source: "c", Target: "b", Direction: DirectionBackward},
+			{ID: "e3", Source: "c", Target: "vpc.d"},
+			{ID: "e4", Source: "vpc.d", Target: "vpc.e"},
+		},
+	}
+
+	if got := len(diagram.Neighborhood("b", 0)); got != 1 {
+		t.Errorf("Expected only the focus node at depth 0, got %d nodes", got)
+	}
+	if got := len(diagram.Neighborhood("missing", 2)); got != 0 {
+		t.Errorf("Expected no nodes around an unknown node, got %d", got)
+	}
+
+	// Edges are followed against their arrows, and the container of vpc.d
+	// is kept without its other children
+	sub := diagram.ExtractNeighborhood("b", 2)
+	var ids []string
+	for _, node := range sub.Nodes {
+		ids = append(ids, node.ID)
+	}
+	if got := strings.Join(ids, ","); got != "a,b,c,vpc,vpc.d" {
+		t.Errorf("Expected nodes a,b,c,vpc,vpc.d, got %s", got)
+	}
+	if len(sub.Edges) != 3 || sub.GetEdge("e4") != nil {
+		t.Errorf("Expected the 3 edges between kept nodes, got %d", len(sub.Edges))
+	}
+	if len(diagram.Nodes) != 6 || len(diagram.Edges) != 4 {
+		t.Error("ExtractNeighborhood should not modify the original diagram")
+	}
+}
+
+func TestDiagram_AdjacencyMatrix(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{{ID: "api"}, {ID: "db"}, {ID: "cache"}},
+		Edges: []*Edge{
+			{ID: "e1", Source: "api", Target: "db"},
+			{ID: "e2", Source: "api", Target: "db", Label: "replica"},
+			{ID: "e3", Source: "cache", Target: "api", Direction: DirectionBackward},
+			{ID: "e4", Source: "api", Target: "missing"},
+		},
+	}
+
+	ids, matrix := diagram.AdjacencyMatrix()
+	if got := strings.Join(ids, ","); got != "api,db,cache" {
+		t.Errorf("Expected nodes in diagram order, got %s", got)
+	}
+	want := [][]int{{0, 2, 0}, {0, 0, 0}, {1, 0, 0}}
+	if !slices.EqualFunc(matrix, want, slices.Equal) {
+		t.Errorf("Expected matrix %v, got %v", want, matrix)
+	}
+}
+
+func TestDiagram_Board(t *testing.T) {
+	diagram := &Diagram{
+		ID:         "diagram",
+		FolderOnly: true,
+		Boards: []*Diagram{
+			{ID: "one", Nodes: []*Node{{ID: "a"}}},
+			{ID: "two", Nodes: []*Node{{ID: "b"}}, Boards: []*Diagram{
+				{ID: "nested", Nodes: []*Node{{ID: "c"}}},
+			}},
+		},
+	}
+
+	if got := strings.Join(diagram.BoardNames(), ","); got != "one,two,nested" {
+		t.Errorf("Expected board names one,two,nested, got %s", got)
+	}
+	if board := diagram.Board("nested"); board == nil || board.GetNode("c") == nil {
+		t.Error("Expected to find the nested board")
+	}
+	if board := diagram.Board("two"); board == nil || board.GetNode("b") == nil {
+		t.Error("Expected to find board two")
+	}
+	if diagram.Board("missing") != nil {
+		t.Error("Expected nil for an unknown board")
+	}
+}
+
+func TestDiagram_ExtractSubgraph(t *testing.T) {
+	diagram := &Diagram{
+		Metadata: map[string]string{"title": "System"},
+		Nodes: []*Node{
+			{ID: "vpc", Label: "VPC", Shape: ShapeContainer},
+			{ID: "vpc.web", Shape: ShapeRectangle, Container: "vpc"},
+			{ID: "vpc.db", Shape: ShapeCylinder, Container: "vpc"},
+			{ID: "user", Shape: ShapePerson},
+		},
+		Edges: []*Edge{
+			{ID: "e1", Source: "user", Target: "vpc.web"},
+			{ID: "e2", Source: "vpc.web", Target: "vpc.db"},
+		},
+	}
+
+	sub := diagram.ExtractSubgraph("vpc")
+
+	if len(sub.Nodes) != 3 || sub.GetNode("user") != nil {
+		t.Errorf("Expected the container and its 2 children, got %d nodes", len(sub.Nodes))
+	}
+	if len(sub.Edges) != 1 || sub.Edges[0].ID != "e2" {
+		t.Errorf("Expected only the internal edge, got %d edges", len(sub.Edges))
+	}
+	if sub.Metadata["title"] != "VPC" {
+		t.Errorf("Expected title VPC, got %q", sub.Metadata["title"])
+	}
+	if len(diagram.Nodes) != 4 || diagram.Metadata["title"] != "System" {
+		t.Error("ExtractSubgraph should not modify the original diagram")
+	}
+
+	if empty := diagram.ExtractSubgraph("missing"); len(empty.Nodes) != 0 || len(empty.Edges) != 0 {
+		t.Error("Extracting an unknown container should give an empty diagram")
+	}
+}
+
+func TestDiagram_ApplyPalette(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "a"},
+			{ID: "styled", Style: Style{Fill: "#000000"}},
+			{ID: "group", Shape: ShapeContainer},
+			{ID: "group.b", Container: "group"},
+			{ID: "group.c", Container: "group"},
+		},
+	}
+
+	palette := []string{"#111111", "#222222"}
+	diagram.ApplyPalette(palette)
+
+	expected := map[string]string{
+		"a":       "#111111",
+		"styled":  "#000000", // Pre-styled nodes keep their fill
+		"group":   "",        // Containers are not filled
+		"group.b": "#222222",
+		"group.c": "#111111", // Colors wrap around
+	}
+	for id, fill := range expected {
+		if got := diagram.GetNode(id).Style.Fill; got != fill {
+			t.Errorf("Node %s: expected fill '%s', got '%s'", id, fill, got)
+		}
+	}
+}
+
+func TestPaletteByName(t *testing.T) {
+	for _, name := range PaletteNames() {
+		palette, ok := PaletteByName(strings.ToUpper(name))
+		if !ok || len(palette) == 0 {
+			t.Errorf("Expected built-in palette %q", name)
+		}
+	}
+
+	if _, ok := PaletteByName("nonexistent"); ok {
+		t.Error("Expected unknown palette to be rejected")
+	}
+
+	// Callers can't modify the built-in palettes
+	palette, _ := PaletteByName("pastel")
+	palette[0] = "#000000"
+	if again, _ := PaletteByName("pastel"); again[0] == "#000000" {
+		t.Error("PaletteByName should return a copy")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := &Diagram{
+		Nodes: []*Node{
+			{ID: "a", Label: "A", Shape: ShapeRectangle, Position: &Position{X: 10}},
+			{ID: "b", Label: "B", Shape: ShapeRectangle},
+			{ID: "c", Label: "C", Shape: ShapeRectangle},
+		},
+		Edges: []*Edge{
+			{ID: "a-b-0", Source: "a", Target: "b", Direction: DirectionForward},
+		},
+	}
+
+	// Layout output is not a difference
+	same := old.Clone()
+	same.Nodes[0].Position = &Position{X: 99}
+	same.Edges[0].Points = []Point{{X: 1, Y: 1}}
+	if diff := Diff(old, same); !diff.IsEmpty() || !diff.IsStyleOnly() {
+		t.Errorf("Expected empty diff, got %+v", diff)
+	}
+
+	restyled := old.Clone()
+	restyled.Nodes[1].Style.Fill = "#ff0000"
+	restyled.Edges[0].Style.Stroke = "#00ff00"
+	diff := Diff(old, restyled)
+	if diff.IsEmpty() || !diff.IsStyleOnly() {
+		t.Errorf("Expected style-only diff, got %+v", diff)
+	}
+	if len(diff.StyledNodes) != 1 || diff.StyledNodes[0] != "b" || len(diff.StyledEdges) != 1 {
+		t.Errorf("Expected node b and one edge restyled, got %+v", diff)
+	}
+
+	changed := old.Clone()
+	changed.Nodes[0].Label = "Alpha"
+	changed.Nodes = changed.Nodes[:2]
+	changed.Nodes = append(changed.Nodes, &Node{ID: "d", Shape: ShapeCircle})
+	changed.Edges[0].Direction = DirectionBoth
+	changed.Edges = append(changed.Edges, &Edge{ID: "b-d-1", Source: "b", Target: "d"})
+	diff = Diff(old, changed)
+	if diff.IsStyleOnly() {
+		t.Error("Structural changes should not be style-only")
+	}
+	if strings.Join(diff.ChangedNodes, ",") != "a" || strings.Join(diff.AddedNodes, ",") != "d" || strings.Join(diff.RemovedNodes, ",") != "c" {
+		t.Errorf("Unexpected node diff: %+v", diff)
+	}
+	if strings.Join(diff.ChangedEdges, ",") != "a-b-0" || strings.Join(diff.AddedEdges, ",") != "b-d-1" || len(diff.RemovedEdges) != 0 {
+		t.Errorf("Unexpected edge diff: %+v", diff)
+	}
+
+	reconfigured := old.Clone()
+	reconfigured.Config.Direction = "right"
+	if diff := Diff(old, reconfigured); !diff.ConfigChanged || diff.IsStyleOnly() {
+		t.Errorf("Direction change should be a config change, got %+v", diff)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder().ID("arch").Title("Architecture")
+	b.Container("aws").Child("vpc", func(vpc *NodeBuilder) {
+		vpc.Child("server", func(n *NodeBuilder) { n.Label("Web") })
+	}).Child("queue", func(n *NodeBuilder) { n.Shape(ShapeQueue) })
+	b.Node("db").Label("Orders").Shape(ShapeCylinder)
+	b.Edge("aws.vpc.server", "db").Label("SQL").Forward()
+	b.Edge("aws.vpc.server", "db").Label("replica").Undirected()
+	b.Edge("cdn", "aws.vpc.server")
+	diagram := b.Build()
+
+	if errs := diagram.Validate(); len(errs) != 0 {
+		t.Fatalf("built diagram should be valid, got: %v", errs)
+	}
+	if diagram.ID != "arch" || diagram.Metadata["title"] != "Architecture" {
+		t.Errorf("unexpected diagram ID or title: %q, %v", diagram.ID, diagram.Metadata)
+	}
+
+	nodes := []struct {
+		id, container, label string
+		shape                ShapeType
+	}{
+		{"aws", "", "aws", ShapeContainer},
+		{"aws.vpc", "aws", "vpc", ShapeContainer},
+		{"aws.vpc.server", "aws.vpc", "Web", ShapeRectangle},
+		{"aws.queue", "aws", "queue", ShapeQueue},
+		{"db", "", "Orders", ShapeCylinder},
+		{"cdn", "", "cdn", ShapeRectangle},
+	}
+	if len(diagram.Nodes) != len(nodes) {
+		t.Errorf("expected %d nodes, got %d", len(nodes), len(diagram.Nodes))
+	}
+	for _, want := range nodes {
+		node := diagram.GetNode(want.id)
+		if node == nil {
+			t.Errorf("missing node %s", want.id)
+			continue
+		}
+		if node.Container != want.container || node.Label != want.label || node.Shape != want.shape {
+			t.Errorf("node %s: got container %q, label %q, shape %q; want %q, %q, %q",
+				want.id, node.Container, node.Label, node.Shape, want.container, want.label, want.shape)
+		}
+	}
+
+	var ids []string
+	for _, edge := range diagram.Edges {
+		ids = append(ids, edge.ID)
+	}
+	if got := strings.Join(ids, ","); got != "aws.vpc.server-db-0,aws.vpc.server-db-1,cdn-aws.vpc.server-0" {
+		t.Errorf("unexpected edge IDs: %s", got)
+	}
+	if diagram.Edges[0].Label != "SQL" || diagram.Edges[0].Direction != DirectionForward || diagram.Edges[1].Direction != DirectionNone {
+		t.Errorf("unexpected edges: %+v, %+v", diagram.Edges[0], diagram.Edges[1])
+	}
+
+	// Built diagrams are independent of the builder
+	b.Node("later")
+	if diagram.GetNode("later") != nil {
+		t.Error("changes after Build should not affect the built diagram")
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	var schema struct {
+		Schema      string `json:"$schema"`
+		Ref         string `json:"$ref"`
+		Definitions map[string]struct {
+			Enum     []string                   `json:"enum"`
+			Required []string                   `json:"required"`
+			Props    map[string]json.RawMessage `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(JSONSchema(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if !strings.Contains(schema.Schema, "draft-07") || schema.Ref != "#/definitions/Diagram" {
+		t.Errorf("unexpected schema header: %q, %q", schema.Schema, schema.Ref)
+	}
+	for _, name := range []string{"Diagram", "Node", "Edge", "Style", "Position"} {
+		if _, ok := schema.Definitions[name]; !ok {
+			t.Errorf("missing definition for %s", name)
+		}
+	}
+	if node := schema.Definitions["Node"]; !slices.Contains(node.Required, "id") || node.Props["container"] == nil {
+		t.Errorf("unexpected Node definition: %+v", node)
+	}
+
+	// Every ShapeType constant declared in types.go is in the enum
+	file, err := parser.ParseFile(token.NewFileSet(), "types.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse types.go: %v", err)
+	}
+	shapes := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); !ok || ident.Name != "ShapeType" {
+				continue
+			}
+			shape := strings.Trim(value.Values[0].(*ast.BasicLit).Value, `"`)
+			shapes++
+			if !slices.Contains(schema.Definitions["ShapeType"].Enum, shape) {
+				t.Errorf("ShapeType enum is missing %q", shape)
+			}
+		}
+	}
+	if shapes == 0 || len(schema.Definitions["ShapeType"].Enum) != shapes {
+		t.Errorf("expected %d shapes in the enum, got %v", shapes, schema.Definitions["ShapeType"].Enum)
+	}
+	if got := strings.Join(schema.Definitions["Direction"].Enum, ","); got != "forward,backward,both,none" {
+		t.Errorf("unexpected Direction enum: %s", got)
+	}
+}
+
+func TestToMermaidAndPlantUML_Edges(t *testing.T) {
+	diagram := &Diagram{
+		Config: DiagramConfig{Direction: "right"},
+		Nodes: []*Node{
+			{ID: "a", Label: `Say "hi"`},
+			{ID: "b", Shape: ShapeCylinder},
+		},
+		Edges: []*Edge{
+			{Source: "a", Target: "b", Direction: DirectionForward, Label: "x|y"},
+			{Source: "a", Target: "b", Direction: DirectionBackward},
+			{Source: "a", Target: "b", Direction: DirectionBoth},
+			{Source: "a", Target: "b", Direction: DirectionNone},
+		},
+	}
+
+	mermaid := ToMermaid(diagram)
+	for _, want := range []string{
+		"graph LR\n",
+		`a["Say #quot;hi#quot;"]`,
+		`b[("b")]`,
+		"a -->|x#124;y| b\n",
+		"b --> a\n",
+		"a <--> b\n",
+		"a --- b\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Expected %q in Mermaid output:\n%s", want, mermaid)
+		}
+	}
+
+	plantUML := ToPlantUML(diagram)
+	for _, want := range []string{
+		"left to right direction\n",
+		`component "Say 'hi'" as a`,
+		`database "b" as b`,
+		"a --> b : x|y\n",
+		"a <-- b\n",
+		"a <--> b\n",
+		"a -- b\n",
+	} {
+		if !strings.Contains(plantUML, want) {
+			t.Errorf("Expected %q in PlantUML output:\n%s", want, plantUML)
+		}
+	}
+	if !strings.HasSuffix(plantUML, "@enduml\n") {
+		t.Errorf("Expected PlantUML output to end with @enduml:\n%s", plantUML)
+	}
+}
+
+func TestToMermaidAndPlantUML_CollidingIDs(t *testing.T) {
+	diagram := &Diagram{
+		Nodes: []*Node{
+			{ID: "a", Label: "group"},
+			{ID: "a.b", Label: "dotted", Container: "a"},
+			{ID: "a_b", Label: "underscored"},
+			{ID: "api gw", Label: "spaced"},
+			{ID: "api-gw", Label: "dashed"},
+			{ID: "end", Label: "End"},
+			{ID: "as", Label: "As"},
+		},
+		Edges: []*Edge{
+			{Source: "a.b", Target: "a_b", Direction: DirectionForward},
+			{Source: "api gw", Target: "api-gw", Direction: DirectionForward},
+			{Source: "end", Target: "as", Direction: DirectionForward},
+		},
+	}
+
+	mermaid := ToMermaid(diagram)
+	for _, want := range []string{
+		`a_b["underscored"]`,
+		`a_b_2["dotted"]`,
+		`api_gw["spaced"]`,
+		`api_gw_2["dashed"]`,
+		`end_["End"]`,
+		"a_b_2 --> a_b\n",
+		"api_gw --> api_gw_2\n",
+		"end_ --> as\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Expected %q in Mermaid output:\n%s", want, mermaid)
+		}
+	}
+	if strings.Contains(mermaid, " end[") || strings.Contains(mermaid, "> end\n") {
+		t.Errorf("Expected the keyword end not to be used as a node ID:\n%s", mermaid)
+	}
+
+	plantUML := ToPlantUML(diagram)
+	for _, want := range []string{
+		`component "underscored" as a_b`,
+		`component "dotted" as a_b_2`,
+		`component "End" as end_`,
+		`component "As" as as_`,
+		"a_b_2 --> a_b\n",
+		"api_gw --> api_gw_2\n",
+		"end_ --> as_\n",
+	} {
+		if !strings.Contains(plantUML, want) {
+			t.Errorf("Expected %q in PlantUML output:\n%s", want, plantUML)
+		}
+	}
+}
+
+func TestHashSource(t *testing.T) {
+	hash := HashSource("a -> b")
+	if len(hash) != 16 || strings.Trim(hash, "0123456789abcdef") != "" {
+		t.Errorf("Expected 16 hex digits, got %q", hash)
+	}
+	if HashSource("a -> b") != hash {
+		t.Error("Expected the same source to hash the same")
+	}
+	if HashSource("a -> c") == hash {
+		t.Error("Expected different sources to hash differently")
+	}
+}
@@ -1,5 +1,7 @@
 package ir
 
+import "math"
+
 // Node represents a visual element (shape) in the diagram.
 type Node struct {
 	// Identity
@@ -10,10 +12,19 @@ type Node struct {
 	Shape ShapeType `json:"shape"` // Shape type
 
 	// Hierarchy
-	Container string `json:"container,omitempty"` // Parent container ID
+	Container string  `json:"container,omitempty"` // Parent container ID
+	Direction string  `json:"direction,omitempty"` // Layout direction of the container's children (up, down, left, right)
+	Near      string  `json:"near,omitempty"`      // Placement near a canvas position (e.g., "top-center") or another node ID
+	Spacing   float64 `json:"spacing,omitempty"`   // Multiplier of the gaps between the container's children (default: 1)
 
 	// Visual
-	Style Style `json:"style,omitempty"` // Visual styling
+	Style   Style    `json:"style,omitempty"`   // Visual styling, including styles from classes
+	Classes []string `json:"classes,omitempty"` // Names of applied classes, in order
+	Tags    []string `json:"tags,omitempty"`    // Logical categories, such as "backend", for grouping
+
+	// Size hints, in pixels, for consistent node sizes
+	MinWidth  float64 `json:"min_width,omitempty"`  // Smallest width layout gives the node
+	MinHeight float64 `json:"min_height,omitempty"` // Smallest height layout gives the node
 
 	// Layout (populated by layout engine)
 	Position *Position `json:"position,omitempty"` // Spatial position
@@ -31,6 +42,32 @@ type Position struct {
 	Source PositionSource `json:"source"` // How position was determined
 }
 
+// MaxNodeSpacing is the largest container spacing multiplier layouts honor.
+const MaxNodeSpacing = 10
+
+// LayoutSpacing returns the container's spacing multiplier for layout: 1 if
+// unset, and at most MaxNodeSpacing.
+func (n *Node) LayoutSpacing() float64 {
+	if n.Spacing <= 0 {
+		return 1
+	}
+	return min(n.Spacing, MaxNodeSpacing)
+}
+
+// MinSize returns the node's minimum width and height rounded up to whole
+// pixels, as D2 takes them, and 0 for hints that aren't set. Circles and
+// squares must be as wide as they are high, so when both hints are set they
+// get the larger one on both sides.
+func (n *Node) MinSize() (width, height int) {
+	width = max(int(math.Ceil(n.MinWidth)), 0)
+	height = max(int(math.Ceil(n.MinHeight)), 0)
+	if (n.Shape == ShapeCircle || n.Shape == ShapeSquare) && width > 0 && height > 0 {
+		width = max(width, height)
+		height = width
+	}
+	return width, height
+}
+
 // IsContainer returns true if this node is a container.
 func (n *Node) IsContainer() bool {
 	return n.Shape == ShapeContainer
@@ -0,0 +1,71 @@
+package ir
+
+import (
+	"sort"
+	"strings"
+)
+
+// Built-in color palettes for ApplyPalette.
+var palettes = map[string][]string{
+	"pastel": {
+		"#FFD6D6", // Rose
+		"#FFE8C7", // Peach
+		"#FFF6BF", // Butter
+		"#D9F2D0", // Mint
+		"#CDEBF7", // Sky
+		"#DCD6F7", // Lavender
+		"#F7D6EC", // Pink
+	},
+	"material": {
+		"#EF9A9A", // Red 200
+		"#90CAF9", // Blue 200
+		"#A5D6A7", // Green 200
+		"#FFE082", // Amber 200
+		"#CE93D8", // Purple 200
+		"#80CBC4", // Teal 200
+		"#FFAB91", // Deep Orange 200
+		"#B0BEC5", // Blue Grey 200
+	},
+}
+
+// PaletteByName returns a copy of a built-in palette (case-insensitive).
+func PaletteByName(name string) ([]string, bool) {
+	palette, ok := palettes[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), palette...), true
+}
+
+// PaletteNames returns the names of the built-in palettes, sorted.
+func PaletteNames() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyPalette assigns fill colors from palette, round-robin in node order,
+// to nodes without an explicit fill. Nodes that already have a fill are left
+// untouched, as are containers, which keep the theme's background so their
+// children stand out. Nested boards are colored the same way.
+func (d *Diagram) ApplyPalette(palette []string) {
+	if len(palette) == 0 {
+		return
+	}
+
+	i := 0
+	for _, node := range d.Nodes {
+		if node.Style.Fill != "" || node.IsContainer() {
+			continue
+		}
+		node.Style.Fill = palette[i%len(palette)]
+		i++
+	}
+
+	for _, board := range d.Boards {
+		board.ApplyPalette(palette)
+	}
+}
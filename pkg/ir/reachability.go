@@ -0,0 +1,88 @@
+package ir
+
+// Reachable returns the IDs of the nodes that can be reached from the given
+// node IDs by following edges in the direction of their arrows. Edges with
+// arrows at both ends or none are followed both ways. The starting nodes are
+// included; IDs of unknown nodes are ignored.
+func (d *Diagram) Reachable(from []string) map[string]bool {
+	next := make(map[string][]string)
+	for _, edge := range d.Edges {
+		if edge.Direction != DirectionBackward {
+			next[edge.Source] = append(next[edge.Source], edge.Target)
+		}
+		if edge.Direction == DirectionBackward || edge.Direction == DirectionBoth || edge.Direction == DirectionNone {
+			next[edge.Target] = append(next[edge.Target], edge.Source)
+		}
+	}
+
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, id := range from {
+		if d.GetNode(id) != nil && !reachable[id] {
+			reachable[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, target := range next[id] {
+			if !reachable[target] {
+				reachable[target] = true
+				queue = append(queue, target)
+			}
+		}
+	}
+	return reachable
+}
+
+// UnreachableNodes returns the IDs of non-container nodes that cannot be
+// reached from the given node IDs, in diagram order. Orphan nodes are
+// always unreachable unless they are one of the starting nodes.
+func (d *Diagram) UnreachableNodes(from []string) []string {
+	reachable := d.Reachable(from)
+	var unreachable []string
+	for _, node := range d.Nodes {
+		if !node.IsContainer() && !reachable[node.ID] {
+			unreachable = append(unreachable, node.ID)
+		}
+	}
+	return unreachable
+}
+
+// Neighborhood returns the IDs of the nodes within depth edges of the node
+// id, following edges both ways whatever their arrows. The node itself is
+// included, at depth 0; an unknown id gives an empty set.
+func (d *Diagram) Neighborhood(id string, depth int) map[string]bool {
+	known := make(map[string]bool, len(d.Nodes))
+	for _, node := range d.Nodes {
+		known[node.ID] = true
+	}
+	neighbors := make(map[string][]string)
+	for _, edge := range d.Edges {
+		if known[edge.Source] && known[edge.Target] {
+			neighbors[edge.Source] = append(neighbors[edge.Source], edge.Target)
+			neighbors[edge.Target] = append(neighbors[edge.Target], edge.Source)
+		}
+	}
+
+	within := make(map[string]bool)
+	if !known[id] {
+		return within
+	}
+	within[id] = true
+	frontier := []string{id}
+	for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+		var next []string
+		for _, current := range frontier {
+			for _, neighbor := range neighbors[current] {
+				if !within[neighbor] {
+					within[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+	return within
+}
@@ -0,0 +1,119 @@
+package ir
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JSONSchema returns a JSON Schema (draft-07) for diagrams in the JSON form
+// the parser writes and the renderer reads, so tools written in other
+// languages can check the IR they produce. The schema is derived from the
+// IR types, with shapes and edge directions limited to the known values.
+func JSONSchema() []byte {
+	s := &schemaGenerator{definitions: make(map[string]any)}
+	s.enums = map[reflect.Type][]string{
+		reflect.TypeFor[ShapeType](): enumValues(shapeTypes),
+		reflect.TypeFor[Direction](): enumValues(directions),
+	}
+	root := s.schema(reflect.TypeFor[Diagram](), false)
+
+	schema := map[string]any{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$ref":        root["$ref"],
+		"definitions": s.definitions,
+	}
+	data, _ := json.MarshalIndent(schema, "", "  ")
+	return data
+}
+
+// schemaGenerator collects the definitions of the named types a schema
+// refers to.
+type schemaGenerator struct {
+	definitions map[string]any
+	enums       map[reflect.Type][]string
+}
+
+// schema returns the schema for values of type t. Structs and enums become
+// definitions referred to by name. Slices and maps that are not omitted when
+// empty may be null, as Go writes nil ones.
+func (s *schemaGenerator) schema(t reflect.Type, nullable bool) map[string]any {
+	if values, ok := s.enums[t]; ok {
+		if _, done := s.definitions[t.Name()]; !done {
+			s.definitions[t.Name()] = map[string]any{"type": "string", "enum": values}
+		}
+		return map[string]any{"$ref": "#/definitions/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schema(t.Elem(), false)
+	case reflect.Struct:
+		if _, done := s.definitions[t.Name()]; !done {
+			// Registered first, so recursive types refer to themselves
+			s.definitions[t.Name()] = nil
+			s.definitions[t.Name()] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Slice:
+		return map[string]any{"type": nullableType("array", nullable), "items": s.schema(t.Elem(), false)}
+	case reflect.Map:
+		return map[string]any{"type": nullableType("object", nullable), "additionalProperties": s.schema(t.Elem(), false)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		// Any JSON value, e.g. for custom properties
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the object schema for a struct type, with the fields
+// that are always written as required properties.
+func (s *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		omitEmpty := strings.Contains(options, "omitempty")
+		properties[name] = s.schema(field.Type, !omitEmpty)
+		if !omitEmpty && field.Type.Kind() != reflect.Slice && field.Type.Kind() != reflect.Map {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// nullableType returns a JSON Schema type that also allows null if nullable.
+func nullableType(name string, nullable bool) any {
+	if nullable {
+		return []string{name, "null"}
+	}
+	return name
+}
+
+// enumValues converts enum constants to their JSON values.
+func enumValues[T ~string](values []T) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = string(v)
+	}
+	return result
+}
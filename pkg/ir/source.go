@@ -0,0 +1,14 @@
+package ir
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashSource returns a short SHA-256 hash of diagram source. Layout
+// metadata such as .d2meta files records it to detect source edits made
+// since the layout was saved.
+func HashSource(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(hash[:8]) // First 8 bytes is enough
+}
@@ -0,0 +1,53 @@
+package ir
+
+// DiagramStats summarizes the structure of a diagram.
+type DiagramStats struct {
+	NodeCount      int               `json:"node_count"`      // Total nodes, including containers
+	EdgeCount      int               `json:"edge_count"`      // Total edges
+	ContainerCount int               `json:"container_count"` // Nodes that contain other nodes
+	MaxDepth       int               `json:"max_depth"`       // Deepest nesting level (0 for a flat diagram)
+	OrphanCount    int               `json:"orphan_count"`    // Non-container nodes with no connected edges
+	ShapeCounts    map[ShapeType]int `json:"shape_counts"`    // Number of nodes per shape type
+}
+
+// Stats computes structural statistics for the diagram.
+func (d *Diagram) Stats() DiagramStats {
+	stats := DiagramStats{
+		NodeCount:   len(d.Nodes),
+		EdgeCount:   len(d.Edges),
+		ShapeCounts: make(map[ShapeType]int),
+	}
+
+	for _, node := range d.Nodes {
+		stats.ShapeCounts[node.Shape]++
+
+		if level := node.GetHierarchyLevel(); level > stats.MaxDepth {
+			stats.MaxDepth = level
+		}
+
+		if node.IsContainer() {
+			stats.ContainerCount++
+		}
+	}
+	stats.OrphanCount = len(d.OrphanNodes())
+
+	return stats
+}
+
+// OrphanNodes returns the IDs of non-container nodes with no connected
+// edges, in diagram order.
+func (d *Diagram) OrphanNodes() []string {
+	connected := make(map[string]bool)
+	for _, edge := range d.Edges {
+		connected[edge.Source] = true
+		connected[edge.Target] = true
+	}
+
+	var orphans []string
+	for _, node := range d.Nodes {
+		if !node.IsContainer() && !connected[node.ID] {
+			orphans = append(orphans, node.ID)
+		}
+	}
+	return orphans
+}
@@ -1,13 +1,18 @@
 package ir
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Style represents visual styling properties for nodes and edges.
 type Style struct {
 	// Visual properties
 	Fill         string  `json:"fill,omitempty"`          // Fill color (hex, named, gradient)
 	Stroke       string  `json:"stroke,omitempty"`        // Border/line color
 	StrokeWidth  int     `json:"stroke_width,omitempty"`  // Border/line width
-	StrokeDash   int     `json:"stroke_dash,omitempty"`   // Dash pattern length
-	BorderRadius int     `json:"border_radius,omitempty"` // Corner rounding (shapes only)
+	StrokeDash   int     `json:"stroke_dash,omitempty"`   // Dash length, 0 (solid) to MaxStrokeDash; see StrokeDashPresets
+	BorderRadius int     `json:"border_radius,omitempty"` // Corner rounding (shapes), or bend rounding (edges)
 	Opacity      float64 `json:"opacity,omitempty"`       // Transparency 0.0-1.0
 
 	// Effects
@@ -29,6 +34,33 @@ type Style struct {
 	Animated bool `json:"animated,omitempty"` // Animated connection
 }
 
+// MaxStrokeDash is the longest dash D2 draws.
+const MaxStrokeDash = 10
+
+// StrokeDashPresets maps names for common line patterns to dash lengths:
+// short dashes read as dotted, long ones as dashed.
+var StrokeDashPresets = map[string]int{
+	"solid":  0,
+	"dotted": 2,
+	"dashed": 5,
+}
+
+// ParseStrokeDash returns the dash length for a number or a name in
+// StrokeDashPresets, and false for anything else.
+func ParseStrokeDash(value string) (int, bool) {
+	if dash, ok := StrokeDashPresets[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return dash, true
+	}
+	dash, err := strconv.Atoi(strings.TrimSpace(value))
+	return dash, err == nil
+}
+
+// D2StrokeDash returns the style's dash length limited to what D2 accepts,
+// 0 to MaxStrokeDash.
+func (s Style) D2StrokeDash() int {
+	return max(0, min(s.StrokeDash, MaxStrokeDash))
+}
+
 // Merge combines this style with another, with the other style taking precedence.
 // Used for cascading styles from containers to children.
 func (s Style) Merge(other Style) Style {
@@ -91,3 +123,93 @@ func (s Style) Merge(other Style) Style {
 
 	return result
 }
+
+// Without returns a copy of this style with every property cleared that has
+// the same value in other. Used to drop styles that a class already provides.
+func (s Style) Without(other Style) Style {
+	result := s
+
+	if s.Fill == other.Fill {
+		result.Fill = ""
+	}
+	if s.Stroke == other.Stroke {
+		result.Stroke = ""
+	}
+	if s.StrokeWidth == other.StrokeWidth {
+		result.StrokeWidth = 0
+	}
+	if s.StrokeDash == other.StrokeDash {
+		result.StrokeDash = 0
+	}
+	if s.BorderRadius == other.BorderRadius {
+		result.BorderRadius = 0
+	}
+	if s.Opacity == other.Opacity {
+		result.Opacity = 0
+	}
+	if s.Shadow == other.Shadow {
+		result.Shadow = false
+	}
+	if s.ThreeD == other.ThreeD {
+		result.ThreeD = false
+	}
+	if s.Multiple == other.Multiple {
+		result.Multiple = false
+	}
+	if s.DoubleBorder == other.DoubleBorder {
+		result.DoubleBorder = false
+	}
+	if s.Font == other.Font {
+		result.Font = ""
+	}
+	if s.FontSize == other.FontSize {
+		result.FontSize = 0
+	}
+	if s.FontColor == other.FontColor {
+		result.FontColor = ""
+	}
+	if s.Bold == other.Bold {
+		result.Bold = false
+	}
+	if s.Italic == other.Italic {
+		result.Italic = false
+	}
+	if s.Underline == other.Underline {
+		result.Underline = false
+	}
+	if s.TextTransform == other.TextTransform {
+		result.TextTransform = ""
+	}
+	if s.Animated == other.Animated {
+		result.Animated = false
+	}
+
+	return result
+}
+
+// Class is a named set of attributes that nodes and edges apply by name,
+// like a D2 class.
+type Class struct {
+	Shape string `json:"shape,omitempty"` // D2 shape name, e.g. "person" or "c4-person"
+	Style Style  `json:"style,omitempty"` // Style of elements using the class
+}
+
+// Classes maps class names to their definitions.
+type Classes map[string]Class
+
+// Applied returns the combined class for an element using the named
+// classes, with later classes taking precedence. Unknown names are ignored.
+func (c Classes) Applied(names []string) Class {
+	var result Class
+	for _, name := range names {
+		class, ok := c[name]
+		if !ok {
+			continue
+		}
+		if class.Shape != "" {
+			result.Shape = class.Shape
+		}
+		result.Style = result.Style.Merge(class.Style)
+	}
+	return result
+}
@@ -0,0 +1,333 @@
+package ir
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Clone returns a deep copy of the diagram, including nested boards, so
+// transforms can change the copy without touching the original.
+func (d *Diagram) Clone() *Diagram {
+	clone := *d
+
+	clone.Nodes = make([]*Node, len(d.Nodes))
+	for i, node := range d.Nodes {
+		n := *node
+		if node.Position != nil {
+			pos := *node.Position
+			n.Position = &pos
+		}
+		n.Classes = slices.Clone(node.Classes)
+		n.Tags = slices.Clone(node.Tags)
+		n.Properties = copyProperties(node.Properties)
+		clone.Nodes[i] = &n
+	}
+
+	clone.Edges = make([]*Edge, len(d.Edges))
+	for i, edge := range d.Edges {
+		clone.Edges[i] = cloneEdge(edge)
+	}
+
+	if d.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(d.Metadata))
+		for k, v := range d.Metadata {
+			clone.Metadata[k] = v
+		}
+	}
+
+	if d.Classes != nil {
+		clone.Classes = make(Classes, len(d.Classes))
+		for k, v := range d.Classes {
+			clone.Classes[k] = v
+		}
+	}
+
+	if d.Boards != nil {
+		clone.Boards = make([]*Diagram, len(d.Boards))
+		for i, board := range d.Boards {
+			clone.Boards[i] = board.Clone()
+		}
+	}
+
+	return &clone
+}
+
+// SortStable sorts the diagram's nodes by ID and its edges by source, target
+// and ID, recursively for nested boards, so that output generated from the
+// diagram doesn't depend on the order its elements were declared in.
+func (d *Diagram) SortStable() {
+	slices.SortStableFunc(d.Nodes, func(a, b *Node) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	slices.SortStableFunc(d.Edges, func(a, b *Edge) int {
+		return cmp.Or(
+			cmp.Compare(a.Source, b.Source),
+			cmp.Compare(a.Target, b.Target),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+	for _, board := range d.Boards {
+		board.SortStable()
+	}
+}
+
+// cloneEdge returns a copy of an edge that shares no slices or maps with it.
+func cloneEdge(edge *Edge) *Edge {
+	e := *edge
+	if edge.Points != nil {
+		e.Points = append([]Point(nil), edge.Points...)
+	}
+	e.Classes = slices.Clone(edge.Classes)
+	e.Properties = copyProperties(edge.Properties)
+	return &e
+}
+
+// copyProperties returns a deep copy of a properties map.
+func copyProperties(props map[string]interface{}) map[string]interface{} {
+	if props == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		result[k] = copyValue(v)
+	}
+	return result
+}
+
+// copyValue returns a deep copy of a property value. Maps and slices, as
+// decoded from JSON IR, are copied; other values are immutable.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyProperties(v)
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = copyValue(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// parallelEdgeKey identifies edges drawn between the same endpoints.
+type parallelEdgeKey struct {
+	source, target         string
+	sourcePort, targetPort string
+	direction              Direction
+}
+
+// edgeKey returns the parallel edge key of an edge.
+func edgeKey(edge *Edge) parallelEdgeKey {
+	return parallelEdgeKey{edge.Source, edge.Target, edge.SourcePort, edge.TargetPort, edge.Direction}
+}
+
+// MergeParallelEdges returns a copy of the diagram in which edges with the
+// same source, target, ports, and direction are combined into one edge. The
+// merged edge keeps the first edge's ID and style, and its label joins the
+// non-empty labels of the originals with separator (", " if empty). Nested
+// boards are merged too. The original diagram is not modified.
+func (d *Diagram) MergeParallelEdges(separator string) *Diagram {
+	if separator == "" {
+		separator = ", "
+	}
+
+	result := d.Clone()
+	result.mergeParallelEdges(separator)
+	return result
+}
+
+// mergeParallelEdges merges parallel edges in place, recursing into boards.
+func (d *Diagram) mergeParallelEdges(separator string) {
+	merged := make([]*Edge, 0, len(d.Edges))
+	labels := make(map[parallelEdgeKey][]string)
+	first := make(map[parallelEdgeKey]*Edge)
+	for _, edge := range d.Edges {
+		key := edgeKey(edge)
+		if _, ok := first[key]; !ok {
+			first[key] = edge
+			merged = append(merged, edge)
+		}
+		if edge.Label != "" {
+			labels[key] = append(labels[key], edge.Label)
+		}
+	}
+	for key, edge := range first {
+		edge.Label = strings.Join(labels[key], separator)
+	}
+	d.Edges = merged
+
+	for _, board := range d.Boards {
+		board.mergeParallelEdges(separator)
+	}
+}
+
+// CollapseContainer returns a copy of the diagram in which the container id
+// is drawn as a single box: its descendants are removed, edges to or from a
+// descendant are redirected to the container, edges that end up inside it
+// are dropped, and parallel edges created by the redirection are merged
+// (keeping the first). The original diagram is not modified; an unknown
+// id leaves the copy unchanged.
+func (d *Diagram) CollapseContainer(id string) *Diagram {
+	result := d.Clone()
+	container := result.GetNode(id)
+	if container == nil {
+		return result
+	}
+
+	collapsed := result.descendants(id)
+
+	nodes := make([]*Node, 0, len(result.Nodes)-len(collapsed))
+	for _, node := range result.Nodes {
+		if !collapsed[node.ID] {
+			nodes = append(nodes, node)
+		}
+	}
+	result.Nodes = nodes
+	if container.Shape == ShapeContainer {
+		container.Shape = ShapeRectangle
+	}
+
+	// Redirect edges, noting the edges the container already had
+	redirected := make(map[*Edge]bool)
+	existing := make(map[parallelEdgeKey]bool)
+	for _, edge := range result.Edges {
+		if collapsed[edge.Source] {
+			edge.Source, edge.SourcePort = id, ""
+			redirected[edge] = true
+		}
+		if collapsed[edge.Target] {
+			edge.Target, edge.TargetPort = id, ""
+			redirected[edge] = true
+		}
+		if !redirected[edge] && (edge.Source == id || edge.Target == id) {
+			existing[edgeKey(edge)] = true
+		}
+	}
+
+	edges := make([]*Edge, 0, len(result.Edges))
+	for _, edge := range result.Edges {
+		if redirected[edge] {
+			// Edges between the container's contents disappear with them,
+			// and parallel edges collapse into one
+			key := edgeKey(edge)
+			if (edge.Source == id && edge.Target == id) || existing[key] {
+				continue
+			}
+			existing[key] = true
+			// The old route no longer matches the endpoints
+			edge.Points = nil
+		}
+		edges = append(edges, edge)
+	}
+	result.Edges = edges
+
+	return result
+}
+
+// ExtractSubgraph returns a copy of the diagram containing only the
+// container id, its descendants and the edges between them, titled with
+// the container's label. Edges leaving the container and nested boards are
+// dropped. An unknown id gives a diagram without nodes or edges.
+func (d *Diagram) ExtractSubgraph(id string) *Diagram {
+	result := d.Clone()
+	result.Boards = nil
+	container := result.GetNode(id)
+	if container == nil {
+		result.Nodes, result.Edges = nil, nil
+		return result
+	}
+
+	keep := result.descendants(id)
+	keep[id] = true
+
+	nodes := make([]*Node, 0, len(keep))
+	for _, node := range result.Nodes {
+		if keep[node.ID] {
+			nodes = append(nodes, node)
+		}
+	}
+	result.Nodes = nodes
+
+	edges := make([]*Edge, 0, len(result.Edges))
+	for _, edge := range result.Edges {
+		if keep[edge.Source] && keep[edge.Target] {
+			edges = append(edges, edge)
+		}
+	}
+	result.Edges = edges
+
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]string)
+	}
+	result.Metadata["title"] = container.Label
+	if container.Label == "" {
+		result.Metadata["title"] = id
+	}
+
+	return result
+}
+
+// ExtractNeighborhood returns a copy of the diagram containing only the
+// nodes within depth edges of the node id (see Neighborhood), the
+// containers they are nested in, and the edges between those nodes.
+// Nested boards are dropped. An unknown id gives a diagram without nodes or
+// edges.
+func (d *Diagram) ExtractNeighborhood(id string, depth int) *Diagram {
+	result := d.Clone()
+	result.Boards = nil
+	within := result.Neighborhood(id, depth)
+
+	// Keep the containers around the nodes so their IDs stay valid
+	keep := make(map[string]bool, len(within))
+	for nodeID := range within {
+		node := result.GetNode(nodeID)
+		// Bounded by the node count in case of a cyclic Container chain
+		for steps := 0; node != nil && !keep[node.ID] && steps <= len(result.Nodes); steps++ {
+			keep[node.ID] = true
+			node = result.GetNode(node.GetParentID())
+		}
+	}
+
+	nodes := make([]*Node, 0, len(keep))
+	for _, node := range result.Nodes {
+		if keep[node.ID] {
+			nodes = append(nodes, node)
+		}
+	}
+	result.Nodes = nodes
+
+	edges := make([]*Edge, 0, len(result.Edges))
+	for _, edge := range result.Edges {
+		if within[edge.Source] && within[edge.Target] {
+			edges = append(edges, edge)
+		}
+	}
+	result.Edges = edges
+
+	return result
+}
+
+// descendants returns the IDs of all nodes nested in the node id, found by
+// walking each node's parent chain.
+func (d *Diagram) descendants(id string) map[string]bool {
+	parents := make(map[string]string, len(d.Nodes))
+	for _, node := range d.Nodes {
+		parents[node.ID] = node.GetParentID()
+	}
+	result := make(map[string]bool)
+	for _, node := range d.Nodes {
+		parent := parents[node.ID]
+		// Bounded by the node count in case of a cyclic Container chain
+		for steps := 0; parent != "" && steps < len(parents); steps++ {
+			if parent == id {
+				result[node.ID] = true
+				break
+			}
+			parent = parents[parent]
+		}
+	}
+	return result
+}
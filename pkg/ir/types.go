@@ -18,6 +18,7 @@ const (
 	ShapePerson   ShapeType = "person"
 	ShapeCloud    ShapeType = "cloud"
 	ShapeCylinder ShapeType = "cylinder"
+	ShapeQueue    ShapeType = "queue"
 
 	// Container
 	ShapeContainer ShapeType = "container"
@@ -29,6 +30,14 @@ const (
 	ShapeImage    ShapeType = "image"
 )
 
+// shapeTypes lists every ShapeType, in declaration order.
+var shapeTypes = []ShapeType{
+	ShapeRectangle, ShapeSquare, ShapeCircle, ShapeOval, ShapeDiamond, ShapeParallelogram, ShapeHexagon,
+	ShapePerson, ShapeCloud, ShapeCylinder, ShapeQueue,
+	ShapeContainer,
+	ShapeSQLTable, ShapeClass, ShapeCode, ShapeImage,
+}
+
 // Direction represents the direction of an edge.
 type Direction string
 
@@ -40,6 +49,9 @@ const (
 	DirectionNone     Direction = "none"     // --
 )
 
+// directions lists every Direction, in declaration order.
+var directions = []Direction{DirectionForward, DirectionBackward, DirectionBoth, DirectionNone}
+
 // PositionSource indicates how a position was determined.
 type PositionSource string
 
@@ -2,6 +2,7 @@ package ir
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -84,10 +85,36 @@ func (d *Diagram) Validate() []error {
 				Message: fmt.Sprintf("edge %s references non-existent target node: %s", edge.ID, edge.Target),
 			})
 		}
+
+		if edge.Weight < 0 || edge.Weight > MaxEdgeWeight {
+			errors = append(errors, ValidationError{
+				Field:   "edge.Weight",
+				Message: fmt.Sprintf("edge %s has weight %d, outside 0-%d", edge.ID, edge.Weight, MaxEdgeWeight),
+			})
+		}
+
+		if edge.RoutingMode != "" && edge.RoutingMode != RoutingDirect && edge.RoutingMode != RoutingOrthogonal {
+			errors = append(errors, ValidationError{
+				Field:   "edge.RoutingMode",
+				Message: fmt.Sprintf("edge %s has unknown routing mode %q (use %s or %s)", edge.ID, edge.RoutingMode, RoutingDirect, RoutingOrthogonal),
+			})
+		}
 	}
 
-	// Validate container references
+	// Validate container references, spacing and size hints
 	for _, node := range d.Nodes {
+		if node.MinWidth < 0 || node.MinHeight < 0 {
+			errors = append(errors, ValidationError{
+				Field:   "node.MinWidth",
+				Message: fmt.Sprintf("node %s has a negative minimum size %gx%g", node.ID, node.MinWidth, node.MinHeight),
+			})
+		}
+		if node.Spacing < 0 || node.Spacing > MaxNodeSpacing {
+			errors = append(errors, ValidationError{
+				Field:   "node.Spacing",
+				Message: fmt.Sprintf("node %s has spacing %g, outside 0-%d", node.ID, node.Spacing, MaxNodeSpacing),
+			})
+		}
 		if node.Container != "" && !nodeIDs[node.Container] {
 			errors = append(errors, ValidationError{
 				Field:   "node.Container",
@@ -107,6 +134,14 @@ func (d *Diagram) Validate() []error {
 		}
 	}
 
+	// Containers must form a tree
+	for _, cycle := range d.containerCycles() {
+		errors = append(errors, ValidationError{
+			Field:   "node.Container",
+			Message: fmt.Sprintf("container cycle: %s -> %s", strings.Join(cycle, " -> "), cycle[0]),
+		})
+	}
+
 	// Validate style values
 	for _, node := range d.Nodes {
 		errors = append(errors, validateStyle(node.Style, fmt.Sprintf("node %s", node.ID))...)
@@ -118,6 +153,40 @@ func (d *Diagram) Validate() []error {
 	return errors
 }
 
+// containerCycles returns the node IDs along each cycle of Container
+// references, such as a node contained in itself or two nodes containing
+// each other, starting from the first node in Nodes order.
+func (d *Diagram) containerCycles() [][]string {
+	containers := make(map[string]string, len(d.Nodes))
+	for _, node := range d.Nodes {
+		containers[node.ID] = node.Container
+	}
+
+	var cycles [][]string
+	done := make(map[string]bool, len(d.Nodes))
+	for _, node := range d.Nodes {
+		// Follow the chain up until it ends, joins one already followed,
+		// or comes back to a node on it
+		var chain []string
+		onChain := make(map[string]bool)
+		for id := node.ID; id != "" && !done[id]; id = containers[id] {
+			if onChain[id] {
+				cycles = append(cycles, chain[slices.Index(chain, id):])
+				break
+			}
+			if _, ok := containers[id]; !ok {
+				break // Missing containers are reported above
+			}
+			onChain[id] = true
+			chain = append(chain, id)
+		}
+		for _, id := range chain {
+			done[id] = true
+		}
+	}
+	return cycles
+}
+
 // validateStyle checks style values are within valid ranges.
 func validateStyle(style Style, context string) []error {
 	var errors []error
@@ -150,3 +219,89 @@ func validateStyle(style Style, context string) []error {
 
 	return errors
 }
+
+// ValidationWarning represents a non-fatal issue that is often, but not
+// always, a mistake in the diagram source.
+type ValidationWarning struct {
+	Field   string
+	Message string
+}
+
+func (w ValidationWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+// LintOptions configures which checks Lint performs.
+type LintOptions struct {
+	// AllowSelfLoops suppresses warnings for edges that connect a node to
+	// itself, which are expected in state machines.
+	AllowSelfLoops bool
+
+	// EmptyContainers enables warnings for containers without children.
+	// The D2 parser never produces them, but IR from other sources or
+	// from transforms can.
+	EmptyContainers bool
+
+	// DuplicateEdges enables warnings for edges with the same source,
+	// target, direction, and label, which are often copied by accident.
+	// Parallel edges with different labels are not reported.
+	DuplicateEdges bool
+}
+
+// Lint checks the diagram for suspicious but valid constructs.
+// Unlike Validate, the issues it reports do not prevent rendering.
+func (d *Diagram) Lint(opts LintOptions) []ValidationWarning {
+	var warnings []ValidationWarning
+
+	// Check for self-loops (e.g. an accidental "a -> a")
+	if !opts.AllowSelfLoops {
+		for _, edge := range d.Edges {
+			if edge.Source != "" && edge.Source == edge.Target {
+				warnings = append(warnings, ValidationWarning{
+					Field:   "edge.Target",
+					Message: fmt.Sprintf("edge %s is a self-loop on node %s", edge.ID, edge.Source),
+				})
+			}
+		}
+	}
+
+	// Check for containers left without children
+	if opts.EmptyContainers {
+		for _, node := range d.Nodes {
+			if node.Shape == ShapeContainer && len(d.GetNodesByContainer(node.ID)) == 0 {
+				warnings = append(warnings, ValidationWarning{
+					Field:   "node.Shape",
+					Message: fmt.Sprintf("container %s has no children", node.ID),
+				})
+			}
+		}
+	}
+
+	// Check for exact copies of an edge, reported once per group
+	if opts.DuplicateEdges {
+		type edgeKey struct {
+			source, sourcePort, target, targetPort string
+			direction                              Direction
+			label                                  string
+		}
+		var keys []edgeKey
+		groups := make(map[edgeKey][]string)
+		for _, edge := range d.Edges {
+			key := edgeKey{edge.Source, edge.SourcePort, edge.Target, edge.TargetPort, edge.Direction, edge.Label}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], edge.ID)
+		}
+		for _, key := range keys {
+			if ids := groups[key]; len(ids) > 1 {
+				warnings = append(warnings, ValidationWarning{
+					Field:   "edge.ID",
+					Message: fmt.Sprintf("edges %s are duplicates from %s to %s", strings.Join(ids, ", "), key.source, key.target),
+				})
+			}
+		}
+	}
+
+	return warnings
+}
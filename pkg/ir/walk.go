@@ -0,0 +1,66 @@
+package ir
+
+// Walk visits every node in hierarchy order, then every edge.
+// Nodes are visited depth-first: each container is visited before its
+// children, and siblings keep their order in Nodes. Nodes whose parent
+// is missing from the diagram are visited last, so every node is visited
+// exactly once. Either callback may be nil. Walk stops and returns the
+// first error returned by a callback.
+func (d *Diagram) Walk(visit func(*Node) error, visitEdge func(*Edge) error) error {
+	if visit != nil {
+		if err := d.walkNodes(visit); err != nil {
+			return err
+		}
+	}
+
+	if visitEdge != nil {
+		for _, edge := range d.Edges {
+			if err := visitEdge(edge); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkNodes visits nodes depth-first starting from the root nodes.
+func (d *Diagram) walkNodes(visit func(*Node) error) error {
+	ids := make(map[string]bool, len(d.Nodes))
+	children := make(map[string][]*Node)
+	for _, node := range d.Nodes {
+		ids[node.ID] = true
+	}
+	for _, node := range d.Nodes {
+		parent := node.GetParentID()
+		if parent != "" && !ids[parent] {
+			parent = "" // Treat nodes with a missing parent as roots
+		}
+		children[parent] = append(children[parent], node)
+	}
+
+	visited := make(map[*Node]bool, len(d.Nodes))
+	var walk func(nodes []*Node) error
+	walk = func(nodes []*Node) error {
+		for _, node := range nodes {
+			if visited[node] {
+				continue
+			}
+			visited[node] = true
+			if err := visit(node); err != nil {
+				return err
+			}
+			if err := walk(children[node.ID]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(children[""]); err != nil {
+		return err
+	}
+
+	// Visit anything unreachable from the roots (e.g. parent cycles)
+	return walk(d.Nodes)
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"strings"
 
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2format"
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
 	"oss.terrastruct.com/d2/d2lib"
@@ -39,12 +41,30 @@ const (
 	DirectionLeft  Direction = "left"  // Right to left
 )
 
+// ParseDirection converts a direction string to a Direction.
+// Accepts D2 names (down, up, right, left) and abbreviations (TB, BT, LR, RL).
+func ParseDirection(s string) (Direction, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "down", "tb":
+		return DirectionDown, true
+	case "up", "bt":
+		return DirectionUp, true
+	case "right", "lr":
+		return DirectionRight, true
+	case "left", "rl":
+		return DirectionLeft, true
+	default:
+		return "", false
+	}
+}
+
 // Options configures the layout algorithm behavior.
 type Options struct {
 	// Engine selects the layout algorithm (default: Dagre)
 	Engine LayoutEngine
 
 	// Direction sets the primary flow direction (default: down)
+	// When empty or down, DagreLayout uses the diagram's Config.Direction if set
 	Direction Direction
 
 	// NodeSep is the minimum separation between nodes (default: 60)
@@ -58,6 +78,16 @@ type Options struct {
 
 	// Padding is the padding around the diagram (default: 30)
 	Padding int
+
+	// PreservePinned keeps nodes positioned by hand (PositionSourceManual)
+	// where they are, placing the other nodes around them (default: false)
+	PreservePinned bool
+
+	// Seed is passed to layout engines that break ties randomly, so that
+	// repeated layouts are reproducible. Dagre, the only engine used so far,
+	// takes no seed: it is deterministic for the same diagram, and nodes and
+	// edges are handed to it in diagram order.
+	Seed int64
 }
 
 // DefaultOptions returns the default layout options.
@@ -99,8 +129,21 @@ func NewDagreLayoutWithOptions(opts Options) *DagreLayout {
 
 // Apply computes layout for the diagram using Dagre algorithm.
 func (l *DagreLayout) Apply(ctx context.Context, diagram *ir.Diagram) error {
+	// An empty or comment-only diagram has nothing to place
+	if len(diagram.Nodes) == 0 {
+		return nil
+	}
+
+	// Use the diagram's preferred direction unless the caller chose one explicitly
+	direction := l.Options.Direction
+	if direction == "" || direction == DirectionDown {
+		if d, ok := ParseDirection(diagram.Config.Direction); ok {
+			direction = d
+		}
+	}
+
 	// Convert IR back to D2 source for layout computation
-	d2Source := irToD2Source(diagram, l.Options.Direction)
+	d2Source := irToD2Source(diagram, direction)
 
 	// Use d2lib.Compile which handles all setup (fonts, text measurement, etc.)
 	ruler, err := textmeasure.NewRuler()
@@ -109,13 +152,13 @@ func (l *DagreLayout) Apply(ctx context.Context, diagram *ir.Diagram) error {
 	}
 
 	layoutResolver := func(engine string) (d2graph.LayoutGraph, error) {
-		return func(ctx context.Context, g *d2graph.Graph) error {
+		return WithMinSizes(diagram, func(ctx context.Context, g *d2graph.Graph) error {
 			dagreOpts := &d2dagrelayout.ConfigurableOpts{
 				NodeSep: l.Options.NodeSep,
 				EdgeSep: l.Options.EdgeSep,
 			}
 			return d2dagrelayout.Layout(ctx, g, dagreOpts)
-		}, nil
+		}), nil
 	}
 
 	compileOpts := &d2lib.CompileOptions{
@@ -128,8 +171,15 @@ func (l *DagreLayout) Apply(ctx context.Context, diagram *ir.Diagram) error {
 		return fmt.Errorf("layout compilation failed: %w", err)
 	}
 
-	// Copy positions back to IR
+	// Copy positions back to IR, spacing containers' children and keeping
+	// pinned nodes in place
+	var pinned map[string]ir.Position
+	if l.Options.PreservePinned {
+		pinned = pinnedPositions(diagram)
+	}
 	copyLayoutToIR(graph, diagram)
+	applySpacing(diagram)
+	restorePinned(diagram, pinned)
 
 	return nil
 }
@@ -169,8 +219,15 @@ func ApplyFromSource(ctx context.Context, source string, diagram *ir.Diagram, op
 		return fmt.Errorf("compilation failed: %w", err)
 	}
 
-	// Copy positions to IR
+	// Copy positions to IR, spacing containers' children and keeping pinned
+	// nodes in place
+	var pinned map[string]ir.Position
+	if opts.PreservePinned {
+		pinned = pinnedPositions(diagram)
+	}
 	copyLayoutToIR(graph, diagram)
+	applySpacing(diagram)
+	restorePinned(diagram, pinned)
 
 	return nil
 }
@@ -202,9 +259,10 @@ func irToD2Source(diagram *ir.Diagram, direction Direction) string {
 	}
 
 	// Write root-level nodes first (those without containers)
+	written := make(map[*ir.Node]bool)
 	for _, node := range diagram.Nodes {
 		if node.Container == "" && node.GetParentID() == "" {
-			writeNodeToD2(&sb, node, diagram, containers, 0)
+			writeNodeToD2(&sb, node, diagram, containers, written, 0)
 		}
 	}
 
@@ -215,11 +273,31 @@ func irToD2Source(diagram *ir.Diagram, direction Direction) string {
 		writeEdgeToD2(&sb, edge)
 	}
 
+	// Dagre adds up the weights of parallel edges, so extra copies of a
+	// weighted edge keep its endpoints close. Writing them last leaves the
+	// real edges first among parallel edges when copying the layout back.
+	for _, edge := range diagram.Edges {
+		for i := 1; i < edge.LayoutWeight(); i++ {
+			writeEdgeToD2(&sb, &ir.Edge{
+				Source: edge.Source, Target: edge.Target,
+				SourcePort: edge.SourcePort, TargetPort: edge.TargetPort,
+				Direction: edge.Direction,
+			})
+		}
+	}
+
 	return sb.String()
 }
 
 // writeNodeToD2 writes a node and its children to D2 format.
-func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, containers map[string]bool, indent int) {
+func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, containers map[string]bool, written map[*ir.Node]bool, indent int) {
+	// Skip nodes already written; only containers nested in a cycle can
+	// lead back to one
+	if written[node] {
+		return
+	}
+	written[node] = true
+
 	prefix := strings.Repeat("  ", indent)
 
 	// Get the local ID (last part of hierarchical ID)
@@ -230,7 +308,7 @@ func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, cont
 
 	// Write node declaration
 	if node.Label != "" && node.Label != localID {
-		sb.WriteString(fmt.Sprintf("%s%s: %s", prefix, localID, node.Label))
+		sb.WriteString(fmt.Sprintf("%s%s: %s", prefix, localID, d2Text(node.Label)))
 	} else {
 		sb.WriteString(fmt.Sprintf("%s%s", prefix, localID))
 	}
@@ -238,8 +316,9 @@ func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, cont
 	// Check if this is a container or has styling
 	isContainer := containers[node.ID]
 	hasStyle := node.Shape != ir.ShapeRectangle && node.Shape != ir.ShapeContainer
+	flags := footprintStyleFlags(node.Style)
 
-	if isContainer || hasStyle {
+	if isContainer || hasStyle || node.Near != "" || len(flags) > 0 {
 		sb.WriteString(" {\n")
 
 		// Write shape if not default
@@ -247,11 +326,21 @@ func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, cont
 			sb.WriteString(fmt.Sprintf("%s  shape: %s\n", prefix, shapeToD2(node.Shape)))
 		}
 
+		// Write placement
+		if node.Near != "" {
+			sb.WriteString(fmt.Sprintf("%s  near: %s\n", prefix, node.Near))
+		}
+
+		// Write the styles that change the node's footprint
+		for _, flag := range flags {
+			sb.WriteString(fmt.Sprintf("%s  style.%s: true\n", prefix, flag))
+		}
+
 		// Write children
 		if isContainer {
 			children := diagram.GetNodesByContainer(node.ID)
 			for _, child := range children {
-				writeNodeToD2(sb, child, diagram, containers, indent+1)
+				writeNodeToD2(sb, child, diagram, containers, written, indent+1)
 			}
 		}
 
@@ -266,6 +355,26 @@ func writeNodeToD2(sb *strings.Builder, node *ir.Node, diagram *ir.Diagram, cont
 	}
 }
 
+// footprintStyleFlags returns the D2 names of the style flags set in s that
+// draw outside the node's box or inside its border, such as the extra faces
+// of 3d and the stacked copy of multiple, which layout leaves room for.
+func footprintStyleFlags(s ir.Style) []string {
+	var flags []string
+	if s.ThreeD {
+		flags = append(flags, "3d")
+	}
+	if s.Multiple {
+		flags = append(flags, "multiple")
+	}
+	if s.DoubleBorder {
+		flags = append(flags, "double-border")
+	}
+	if s.Shadow {
+		flags = append(flags, "shadow")
+	}
+	return flags
+}
+
 // writeEdgeToD2 writes an edge in D2 format.
 func writeEdgeToD2(sb *strings.Builder, edge *ir.Edge) {
 	arrow := "->"
@@ -278,13 +387,29 @@ func writeEdgeToD2(sb *strings.Builder, edge *ir.Edge) {
 		arrow = "--"
 	}
 
+	// Ports are keys inside the node, such as SQL table columns
+	source, target := edge.Source, edge.Target
+	if edge.SourcePort != "" {
+		source += "." + edge.SourcePort
+	}
+	if edge.TargetPort != "" {
+		target += "." + edge.TargetPort
+	}
+
 	if edge.Label != "" {
-		sb.WriteString(fmt.Sprintf("%s %s %s: %s\n", edge.Source, arrow, edge.Target, edge.Label))
+		sb.WriteString(fmt.Sprintf("%s %s %s: %s\n", source, arrow, target, d2Text(edge.Label)))
 	} else {
-		sb.WriteString(fmt.Sprintf("%s %s %s\n", edge.Source, arrow, edge.Target))
+		sb.WriteString(fmt.Sprintf("%s %s %s\n", source, arrow, target))
 	}
 }
 
+// d2Text returns a label as a D2 string value, quoted and escaped if it
+// has D2 metacharacters, line breaks or surrounding spaces, so the layout
+// measures the same text the renderer draws.
+func d2Text(text string) string {
+	return d2format.Format(d2ast.RawString(text, false))
+}
+
 // shapeToD2 converts IR shape type to D2 shape string.
 func shapeToD2(shape ir.ShapeType) string {
 	switch shape {
@@ -294,6 +419,8 @@ func shapeToD2(shape ir.ShapeType) string {
 		return "cloud"
 	case ir.ShapeCylinder:
 		return "cylinder"
+	case ir.ShapeQueue:
+		return "queue"
 	case ir.ShapeCircle:
 		return "circle"
 	case ir.ShapeOval:
@@ -349,6 +476,9 @@ func copyLayoutToIR(graph *d2graph.Graph, diagram *ir.Diagram) {
 				edge.Points[i] = ir.Point{X: pt.X, Y: pt.Y}
 			}
 		}
+		if d2Edge != nil && d2Edge.IsCurve {
+			edge.Curved = true
+		}
 	}
 }
 
@@ -2,6 +2,10 @@ package layout
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/mark/dsl-diagram-tool/pkg/ir"
@@ -93,6 +97,47 @@ server -> database: SQL
 	}
 }
 
+func TestDagreLayout_Apply_Geometry(t *testing.T) {
+	diagram, err := parser.NewD2Parser().Parse("a -> b -> c")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	geometry := diagram.Geometry()
+
+	if len(geometry.Nodes) != 3 {
+		t.Fatalf("Expected 3 node boxes, got %d", len(geometry.Nodes))
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		box, ok := geometry.Nodes[id]
+		if !ok {
+			t.Errorf("Missing box for node %s", id)
+			continue
+		}
+		if box.Width <= 0 || box.Height <= 0 {
+			t.Errorf("Node %s has an empty box: %+v", id, box)
+		}
+	}
+
+	if len(geometry.Edges) != 2 {
+		t.Fatalf("Expected 2 edge polylines, got %d", len(geometry.Edges))
+	}
+	for id, points := range geometry.Edges {
+		if len(points) < 2 {
+			t.Errorf("Edge %s polyline has %d points, expected at least 2", id, len(points))
+		}
+	}
+
+	// Nodes are laid out top to bottom
+	if geometry.Nodes["a"].Y >= geometry.Nodes["b"].Y || geometry.Nodes["b"].Y >= geometry.Nodes["c"].Y {
+		t.Errorf("Expected a above b above c, got %+v", geometry.Nodes)
+	}
+}
+
 func TestDagreLayout_Apply_WithContainers(t *testing.T) {
 	p := parser.NewD2Parser()
 	source := `
@@ -249,6 +294,29 @@ func TestIrToD2Source_Simple(t *testing.T) {
 	}
 }
 
+func TestIrToD2Source_QuotedLabels(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "a", Label: "a; b", Shape: ir.ShapeRectangle},
+			{ID: "b", Label: "say #hi", Shape: ir.ShapeRectangle},
+		},
+		Edges: []*ir.Edge{
+			{ID: "e1", Source: "a", Target: "b", Direction: ir.DirectionForward, Label: "{x}"},
+		},
+	}
+
+	source := irToD2Source(diagram, DirectionDown)
+	for _, want := range []string{`a: "a; b"`, `b: "say #hi"`, `a -> b: "{x}"`} {
+		if !strings.Contains(source, want) {
+			t.Errorf("Expected %s in layout source:\n%s", want, source)
+		}
+	}
+	if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+}
+
 func TestIrToD2Source_WithContainers(t *testing.T) {
 	diagram := &ir.Diagram{
 		ID: "test",
@@ -353,6 +421,63 @@ func TestGetDiagramBounds_NoPositions(t *testing.T) {
 	}
 }
 
+func TestParseDirection(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect Direction
+		ok     bool
+	}{
+		{"down", DirectionDown, true},
+		{"TB", DirectionDown, true},
+		{"up", DirectionUp, true},
+		{"BT", DirectionUp, true},
+		{"right", DirectionRight, true},
+		{"LR", DirectionRight, true},
+		{"left", DirectionLeft, true},
+		{"rl", DirectionLeft, true},
+		{"", "", false},
+		{"sideways", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseDirection(tt.input)
+		if got != tt.expect || ok != tt.ok {
+			t.Errorf("ParseDirection(%q) = (%q, %v), expected (%q, %v)", tt.input, got, ok, tt.expect, tt.ok)
+		}
+	}
+}
+
+func TestDagreLayout_Apply_ConfigDirection(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse("a -> b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	diagram.Config.Direction = "LR"
+
+	l := NewDagreLayout()
+	if err := l.Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	a := diagram.GetNode("a")
+	b := diagram.GetNode("b")
+	if b.Position.X <= a.Position.X {
+		t.Errorf("Expected b to the right of a with LR config, got a.X=%f b.X=%f", a.Position.X, b.Position.X)
+	}
+
+	// Explicit options take precedence over the config
+	opts := DefaultOptions()
+	opts.Direction = DirectionUp
+	l = NewDagreLayoutWithOptions(opts)
+	if err := l.Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+	if b.Position.Y >= a.Position.Y {
+		t.Errorf("Expected b above a with explicit up direction, got a.Y=%f b.Y=%f", a.Position.Y, b.Position.Y)
+	}
+}
+
 // Helper function to check substring
 func containsSubstring(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsSubstringHelper(s, substr))
@@ -417,3 +542,306 @@ app2 -> db
 		_ = l.Apply(ctx, diagram)
 	}
 }
+
+func TestDagreLayout_Apply_EdgeWeight(t *testing.T) {
+	// b hangs between r and t, which a longer chain keeps four ranks apart;
+	// the heavier of its two edges decides which end it sits next to
+	build := func(weightIn, weightOut int) *ir.Diagram {
+		diagram := &ir.Diagram{ID: "test"}
+		for _, id := range []string{"r", "c1", "c2", "c3", "t", "b"} {
+			diagram.Nodes = append(diagram.Nodes, &ir.Node{ID: id, Shape: ir.ShapeRectangle})
+		}
+		for i, pair := range [][2]string{{"r", "c1"}, {"c1", "c2"}, {"c2", "c3"}, {"c3", "t"}} {
+			diagram.Edges = append(diagram.Edges, &ir.Edge{ID: fmt.Sprintf("chain%d", i), Source: pair[0], Target: pair[1], Direction: ir.DirectionForward})
+		}
+		diagram.Edges = append(diagram.Edges,
+			&ir.Edge{ID: "in", Source: "r", Target: "b", Direction: ir.DirectionForward, Weight: weightIn},
+			&ir.Edge{ID: "out", Source: "b", Target: "t", Direction: ir.DirectionForward, Weight: weightOut},
+		)
+		if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		return diagram
+	}
+	centerY := func(diagram *ir.Diagram, id string) float64 {
+		node := diagram.GetNode(id)
+		return node.Position.Y + node.Height/2
+	}
+
+	heavyOut := build(0, 10)
+	if b, c3 := centerY(heavyOut, "b"), centerY(heavyOut, "c3"); b != c3 {
+		t.Errorf("Expected b in the rank next to t with a heavy b -> t edge (y %.0f, c3 at %.0f)", b, c3)
+	}
+	if points := heavyOut.GetEdge("out").Points; len(points) == 0 {
+		t.Error("Expected the weighted edge to keep its route")
+	}
+
+	heavyIn := build(10, 0)
+	if b, c1 := centerY(heavyIn, "b"), centerY(heavyIn, "c1"); b != c1 {
+		t.Errorf("Expected b in the rank next to r with a heavy r -> b edge (y %.0f, c1 at %.0f)", b, c1)
+	}
+}
+
+func TestDagreLayout_Apply_PreservePinned(t *testing.T) {
+	diagram, err := parser.NewD2Parser().Parse("api -> db\napi -> cache")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Pin api and db far from where dagre would put them; cache is new
+	pinned := map[string]ir.Position{
+		"api": {X: 500, Y: 40, Source: ir.PositionSourceManual},
+		"db":  {X: 380, Y: 300, Source: ir.PositionSourceManual},
+	}
+	for id, pos := range pinned {
+		diagram.GetNode(id).Position = &pos
+	}
+
+	opts := DefaultOptions()
+	opts.PreservePinned = true
+	if err := NewDagreLayoutWithOptions(opts).Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	for id, pos := range pinned {
+		if got := diagram.GetNode(id).Position; got == nil || *got != pos {
+			t.Errorf("Node %s: expected pinned position %+v, got %+v", id, pos, got)
+		}
+	}
+	cache := diagram.GetNode("cache")
+	if cache.Position == nil || cache.Position.Source != ir.PositionSourceLayoutEngine {
+		t.Fatalf("Expected the new node to be laid out, got %+v", cache.Position)
+	}
+	// The new node is placed near the pinned ones, below api like db
+	if cache.Position.Y <= pinned["api"].Y || cache.Position.X < 0 {
+		t.Errorf("Expected cache below api, got %+v", cache.Position)
+	}
+	for _, edge := range diagram.Edges {
+		if len(edge.Points) < 2 {
+			t.Errorf("Edge %s has no route", edge.ID)
+		}
+	}
+
+	// Without the option, pinned positions are recomputed
+	if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+	if got := diagram.GetNode("api").Position; got.Source != ir.PositionSourceLayoutEngine {
+		t.Errorf("Expected api to be laid out without PreservePinned, got %+v", got)
+	}
+}
+
+func TestDagreLayout_Apply_Reproducible(t *testing.T) {
+	source := `gateway -> services.auth
+gateway -> services.orders
+services.orders -> data.db
+services.auth -> data.cache
+services.orders -> data.cache
+queue -> services.orders`
+
+	layout := func() *ir.Diagram {
+		t.Helper()
+		diagram, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		opts := DefaultOptions()
+		opts.Seed = 42
+		if err := NewDagreLayoutWithOptions(opts).Apply(context.Background(), diagram); err != nil {
+			t.Fatalf("Layout failed: %v", err)
+		}
+		return diagram
+	}
+
+	first, second := layout(), layout()
+	for _, node := range first.Nodes {
+		other := second.GetNode(node.ID)
+		if node.Position == nil || other.Position == nil || *node.Position != *other.Position ||
+			node.Width != other.Width || node.Height != other.Height {
+			t.Errorf("Node %s: expected the same placement, got %+v and %+v", node.ID, node.Position, other.Position)
+		}
+	}
+	for i, edge := range first.Edges {
+		if fmt.Sprint(edge.Points) != fmt.Sprint(second.Edges[i].Points) {
+			t.Errorf("Edge %s: expected the same route", edge.ID)
+		}
+	}
+}
+
+func TestDagreLayout_Apply_ContainerSpacing(t *testing.T) {
+	// childGap returns the smallest horizontal gap between a container's
+	// children, which dagre places side by side
+	childGap := func(diagram *ir.Diagram, container string) float64 {
+		children := diagram.GetNodesByContainer(container)
+		sort.Slice(children, func(i, j int) bool { return children[i].Position.X < children[j].Position.X })
+		gap := math.Inf(1)
+		for i := 1; i < len(children); i++ {
+			gap = math.Min(gap, children[i].Position.X-(children[i-1].Position.X+children[i-1].Width))
+		}
+		return gap
+	}
+	layOut := func(dense, sparse float64) *ir.Diagram {
+		diagram, err := parser.NewD2Parser().Parse("dense: {a; b; c}\nsparse: {d; e; f}\ndense -> sparse")
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		diagram.GetNode("dense").Spacing = dense
+		diagram.GetNode("sparse").Spacing = sparse
+		if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+			t.Fatalf("Layout failed: %v", err)
+		}
+		return diagram
+	}
+
+	base := layOut(0, 0)
+	baseGap := childGap(base, "dense")
+	if baseGap <= 0 || math.Abs(childGap(base, "sparse")-baseGap) > 0.5 {
+		t.Fatalf("Expected equal gaps without spacing, got %v and %v", baseGap, childGap(base, "sparse"))
+	}
+
+	diagram := layOut(0.5, 2)
+	if got := childGap(diagram, "dense"); math.Abs(got-baseGap/2) > 0.5 {
+		t.Errorf("Expected dense gap %v, got %v", baseGap/2, got)
+	}
+	if got := childGap(diagram, "sparse"); math.Abs(got-baseGap*2) > 0.5 {
+		t.Errorf("Expected sparse gap %v, got %v", baseGap*2, got)
+	}
+
+	// Containers still wrap their children without overlapping each other
+	for _, node := range diagram.Nodes {
+		parent := diagram.GetNode(node.Container)
+		if parent == nil {
+			continue
+		}
+		if node.Position.X < parent.Position.X || node.Position.X+node.Width > parent.Position.X+parent.Width ||
+			node.Position.Y < parent.Position.Y || node.Position.Y+node.Height > parent.Position.Y+parent.Height {
+			t.Errorf("Node %s lies outside its container %s", node.ID, parent.ID)
+		}
+	}
+	dense, sparse := diagram.GetNode("dense"), diagram.GetNode("sparse")
+	if dense.Position.Y+dense.Height > sparse.Position.Y && sparse.Position.Y+sparse.Height > dense.Position.Y &&
+		dense.Position.X+dense.Width > sparse.Position.X && sparse.Position.X+sparse.Width > dense.Position.X {
+		t.Errorf("Containers overlap: dense %+v %vx%v, sparse %+v %vx%v",
+			dense.Position, dense.Width, dense.Height, sparse.Position, sparse.Width, sparse.Height)
+	}
+}
+
+func TestDagreLayout_Apply_FootprintStyles(t *testing.T) {
+	layOut := func(style ir.Style) *ir.Diagram {
+		diagram, err := parser.NewD2Parser().Parse("a -> b\nc -> b")
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		diagram.GetNode("a").Style = style
+		if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+			t.Fatalf("Layout failed: %v", err)
+		}
+		return diagram
+	}
+
+	source := irToD2Source(&ir.Diagram{Nodes: []*ir.Node{
+		{ID: "a", Shape: ir.ShapeRectangle, Style: ir.Style{ThreeD: true, Multiple: true, DoubleBorder: true, Shadow: true}},
+	}}, DirectionDown)
+	for _, flag := range []string{"style.3d: true", "style.multiple: true", "style.double-border: true", "style.shadow: true"} {
+		if !strings.Contains(source, flag) {
+			t.Errorf("Expected %q in layout source:\n%s", flag, source)
+		}
+	}
+
+	// The 3d faces stick out right of a, so the layout moves c over
+	plain, threeD := layOut(ir.Style{}), layOut(ir.Style{ThreeD: true})
+	plainMinX, _, plainMaxX, _ := GetDiagramBounds(plain)
+	threeDMinX, _, threeDMaxX, _ := GetDiagramBounds(threeD)
+	if threeDMaxX-threeDMinX <= plainMaxX-plainMinX {
+		t.Errorf("Expected the 3d node to take more room, got width %v vs %v",
+			threeDMaxX-threeDMinX, plainMaxX-plainMinX)
+	}
+}
+
+func TestDagreLayout_Apply_MinSize(t *testing.T) {
+	diagram, err := parser.NewD2Parser().Parse("a -> b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	a := diagram.GetNode("a")
+	a.MinWidth, a.MinHeight = 200, 90.5
+	if err := NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	if a.Width < a.MinWidth || a.Height < a.MinHeight {
+		t.Errorf("Expected a to be at least %vx%v, got %vx%v", a.MinWidth, a.MinHeight, a.Width, a.Height)
+	}
+	if b := diagram.GetNode("b"); b.Width >= a.MinWidth {
+		t.Errorf("Expected b without a minimum to keep its measured width, got %v", b.Width)
+	}
+}
+
+func TestDagreLayout_Apply_MinSizeLongLabel(t *testing.T) {
+	ctx := context.Background()
+	source := "a: A label much wider than the minimum width\nhub: Hub {shape: circle}\na -> hub"
+	measured, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := NewDagreLayout().Apply(ctx, measured); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	diagram, _ := parser.NewD2Parser().Parse(source)
+	diagram.GetNode("a").MinWidth = 50
+	diagram.GetNode("hub").MinWidth = 150
+	if err := NewDagreLayout().Apply(ctx, diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	// The label still fits, with the padding it is measured with
+	if a, want := diagram.GetNode("a"), measured.GetNode("a"); a.Width < want.Width || a.Height != want.Height {
+		t.Errorf("Expected a to keep its measured size %vx%v, got %vx%v", want.Width, want.Height, a.Width, a.Height)
+	}
+	if hub := diagram.GetNode("hub"); hub.Width != 150 || hub.Height != 150 {
+		t.Errorf("Expected the circle to grow to 150x150, got %vx%v", hub.Width, hub.Height)
+	}
+}
+
+func TestDagreLayout_Apply_EmptyAndSingleNode(t *testing.T) {
+	ctx := context.Background()
+	p := parser.NewD2Parser()
+
+	// A comment-only file parses to an empty diagram that layout leaves alone
+	commentsOnly := "# Architecture overview\n# TODO: add services\n"
+	diagram, err := p.Parse(commentsOnly)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := NewDagreLayout().Apply(ctx, diagram); err != nil {
+		t.Fatalf("Layout of an empty diagram failed: %v", err)
+	}
+	if err := ApplyFromSource(ctx, commentsOnly, diagram, DefaultOptions()); err != nil {
+		t.Fatalf("ApplyFromSource of an empty diagram failed: %v", err)
+	}
+	if len(diagram.Nodes) != 0 || len(diagram.Edges) != 0 {
+		t.Errorf("Expected layout to add nothing, got %d nodes and %d edges", len(diagram.Nodes), len(diagram.Edges))
+	}
+	if minX, minY, maxX, maxY := GetDiagramBounds(diagram); minX != 0 || minY != 0 || maxX != 0 || maxY != 0 {
+		t.Errorf("Expected empty bounds, got (%v, %v, %v, %v)", minX, minY, maxX, maxY)
+	}
+
+	// A single node without edges is placed with a size
+	for name, apply := range map[string]func(*ir.Diagram) error{
+		"Apply":           func(d *ir.Diagram) error { return NewDagreLayout().Apply(ctx, d) },
+		"ApplyFromSource": func(d *ir.Diagram) error { return ApplyFromSource(ctx, "solo", d, DefaultOptions()) },
+	} {
+		diagram, err := p.Parse("solo")
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if err := apply(diagram); err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		node := diagram.GetNode("solo")
+		if node.Position == nil || node.Width <= 0 || node.Height <= 0 {
+			t.Errorf("%s: expected solo to be placed, got %+v (%vx%v)", name, node.Position, node.Width, node.Height)
+		}
+	}
+}
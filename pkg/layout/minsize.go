@@ -0,0 +1,49 @@
+package layout
+
+import (
+	"context"
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// WithMinSizes wraps a layout engine so that the diagram's nodes are at
+// least their minimum size when it runs. D2 takes width and height as the
+// exact size of a node and fits a longer label without padding, so the
+// minimums are not written to the D2 source but applied here, once D2 has
+// measured the labels: each node gets the larger of its measured and its
+// minimum size. Containers are sized by their children and keep their size.
+func WithMinSizes(diagram *ir.Diagram, engine d2graph.LayoutGraph) d2graph.LayoutGraph {
+	minimums := make(map[string]*ir.Node)
+	for _, node := range diagram.Nodes {
+		if width, height := node.MinSize(); width > 0 || height > 0 {
+			minimums[node.ID] = node
+		}
+	}
+	if len(minimums) == 0 {
+		return engine
+	}
+
+	return func(ctx context.Context, g *d2graph.Graph) error {
+		for _, obj := range g.Objects {
+			if node := minimums[obj.AbsID()]; node != nil && len(obj.ChildrenArray) == 0 {
+				growToMinSize(obj, node)
+			}
+		}
+		return engine(ctx, g)
+	}
+}
+
+// growToMinSize enlarges a measured object to the node's minimum size.
+// Circles and squares stay as wide as they are high.
+func growToMinSize(obj *d2graph.Object, node *ir.Node) {
+	width, height := node.MinSize()
+	obj.Width = math.Max(obj.Width, float64(width))
+	obj.Height = math.Max(obj.Height, float64(height))
+	if node.Shape == ir.ShapeCircle || node.Shape == ir.ShapeSquare {
+		side := math.Max(obj.Width, obj.Height)
+		obj.Width, obj.Height = side, side
+	}
+}
@@ -0,0 +1,115 @@
+package layout
+
+import (
+	"math"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// pinnedPositions returns the positions of the nodes placed by hand, by
+// node ID.
+func pinnedPositions(diagram *ir.Diagram) map[string]ir.Position {
+	pinned := make(map[string]ir.Position)
+	for _, node := range diagram.Nodes {
+		if node.Position != nil && node.Position.Source == ir.PositionSourceManual {
+			pinned[node.ID] = *node.Position
+		}
+	}
+	return pinned
+}
+
+// restorePinned moves pinned nodes back to their positions after layout,
+// and the other nodes with them. Dagre cannot hold nodes in place, so the
+// layout is computed as usual and then anchored to the pinned nodes: nodes
+// inside a pinned container move with it, and other nodes by the average
+// distance the pinned nodes moved. Edges whose ends moved apart are
+// redrawn as straight lines.
+func restorePinned(diagram *ir.Diagram, pinned map[string]ir.Position) {
+	if len(pinned) == 0 {
+		return
+	}
+
+	// How far the layout moved each pinned node from its position
+	deltas := make(map[string]ir.Point)
+	var mean ir.Point
+	for _, node := range diagram.Nodes {
+		pos, ok := pinned[node.ID]
+		if !ok {
+			continue
+		}
+		delta := ir.Point{X: pos.X, Y: pos.Y}
+		if node.Position != nil {
+			delta.X -= node.Position.X
+			delta.Y -= node.Position.Y
+		}
+		deltas[node.ID] = delta
+		mean.X += delta.X / float64(len(pinned))
+		mean.Y += delta.Y / float64(len(pinned))
+	}
+
+	// Unpinned nodes follow their nearest pinned container, or the average
+	for _, node := range diagram.Nodes {
+		if _, ok := deltas[node.ID]; ok {
+			continue
+		}
+		delta := mean
+		for parent := diagram.GetNode(node.Container); parent != nil; parent = diagram.GetNode(parent.Container) {
+			if d, ok := deltas[parent.ID]; ok {
+				delta = d
+				break
+			}
+		}
+		deltas[node.ID] = delta
+	}
+
+	for _, node := range diagram.Nodes {
+		if pos, ok := pinned[node.ID]; ok {
+			node.Position = &ir.Position{X: pos.X, Y: pos.Y, Source: ir.PositionSourceManual}
+		} else if node.Position != nil {
+			node.Position.X += deltas[node.ID].X
+			node.Position.Y += deltas[node.ID].Y
+		}
+	}
+
+	for _, edge := range diagram.Edges {
+		src, dst := diagram.GetNode(edge.Source), diagram.GetNode(edge.Target)
+		if src == nil || dst == nil || src.Position == nil || dst.Position == nil {
+			continue
+		}
+		if delta := deltas[edge.Source]; delta == deltas[edge.Target] {
+			for i := range edge.Points {
+				edge.Points[i].X += delta.X
+				edge.Points[i].Y += delta.Y
+			}
+			continue
+		}
+		srcCenter, dstCenter := center(src), center(dst)
+		edge.Points = []ir.Point{
+			borderPoint(src, srcCenter, dstCenter),
+			borderPoint(dst, dstCenter, srcCenter),
+		}
+	}
+}
+
+// center returns the center of a positioned node.
+func center(node *ir.Node) ir.Point {
+	return ir.Point{X: node.Position.X + node.Width/2, Y: node.Position.Y + node.Height/2}
+}
+
+// borderPoint returns where the line from a node's center c toward p
+// leaves the node's bounding box.
+func borderPoint(node *ir.Node, c, p ir.Point) ir.Point {
+	dx, dy := p.X-c.X, p.Y-c.Y
+	if dx == 0 && dy == 0 {
+		return c
+	}
+	scale := math.Inf(1)
+	if dx != 0 {
+		scale = node.Width / 2 / math.Abs(dx)
+	}
+	if dy != 0 {
+		scale = math.Min(scale, node.Height/2/math.Abs(dy))
+	}
+	scale = math.Min(scale, 1)
+	return ir.Point{X: c.X + dx*scale, Y: c.Y + dy*scale}
+}
@@ -0,0 +1,189 @@
+package layout
+
+import (
+	"sort"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// applySpacing scales the gaps between the children of containers with a
+// spacing multiplier, innermost containers first. Dagre separates all nodes
+// by the same NodeSep, so per-container spacing is applied after layout:
+// children keep their rows and columns, and only the space between them
+// changes. A container that grows pushes the nodes right of and below it
+// away, and one that shrinks leaves them in place.
+func applySpacing(diagram *ir.Diagram) {
+	var containers []*ir.Node
+	for _, node := range diagram.Nodes {
+		if node.LayoutSpacing() != 1 && node.Position != nil {
+			containers = append(containers, node)
+		}
+	}
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].GetHierarchyLevel() > containers[j].GetHierarchyLevel()
+	})
+	for _, container := range containers {
+		spaceChildren(diagram, container)
+	}
+}
+
+// spaceChildren scales the gaps between the children of container by its
+// spacing multiplier and resizes it to match.
+func spaceChildren(diagram *ir.Diagram, container *ir.Node) {
+	var xs, ys []span
+	for _, child := range diagram.GetNodesByContainer(container.ID) {
+		if child.Position != nil {
+			xs = append(xs, span{child.Position.X, child.Position.X + child.Width})
+			ys = append(ys, span{child.Position.Y, child.Position.Y + child.Height})
+		}
+	}
+	if len(xs) == 0 {
+		return
+	}
+	spacing := container.LayoutSpacing()
+	mapX, mapY := newGapScale(xs, spacing), newGapScale(ys, spacing)
+
+	inside := func(id string) bool { return isDescendant(diagram, id, container.ID) }
+	for _, node := range diagram.Nodes {
+		if node.Position != nil && inside(node.ID) {
+			node.Position.X, node.Position.Y = mapX.apply(node.Position.X), mapY.apply(node.Position.Y)
+		}
+	}
+	for _, edge := range diagram.Edges {
+		if inside(edge.Source) && inside(edge.Target) {
+			for i := range edge.Points {
+				edge.Points[i] = ir.Point{X: mapX.apply(edge.Points[i].X), Y: mapY.apply(edge.Points[i].Y)}
+			}
+		}
+	}
+
+	right, bottom := container.Position.X+container.Width, container.Position.Y+container.Height
+	dx, dy := mapX.total(), mapY.total()
+	container.Width += dx
+	container.Height += dy
+	pushAway(diagram, container, right, bottom, max(dx, 0), max(dy, 0))
+
+	// Edges leaving the container are redrawn straight between their ends
+	for _, edge := range diagram.Edges {
+		if inside(edge.Source) == inside(edge.Target) {
+			continue
+		}
+		src, dst := diagram.GetNode(edge.Source), diagram.GetNode(edge.Target)
+		if src == nil || dst == nil || src.Position == nil || dst.Position == nil {
+			continue
+		}
+		srcCenter, dstCenter := center(src), center(dst)
+		edge.Points = []ir.Point{
+			borderPoint(src, srcCenter, dstCenter),
+			borderPoint(dst, dstCenter, srcCenter),
+		}
+	}
+}
+
+// pushAway makes room for a container that grew by dx and dy from its old
+// right and bottom edges: nodes and edge points past those edges move
+// along, and the containers around it grow too.
+func pushAway(diagram *ir.Diagram, container *ir.Node, right, bottom, dx, dy float64) {
+	if dx == 0 && dy == 0 {
+		return
+	}
+	shift := func(v, edge, d float64) float64 {
+		if v >= edge {
+			return v + d
+		}
+		return v
+	}
+
+	for _, node := range diagram.Nodes {
+		switch {
+		case node == container || node.Position == nil || isDescendant(diagram, node.ID, container.ID):
+		case isDescendant(diagram, container.ID, node.ID):
+			node.Width += dx
+			node.Height += dy
+		default:
+			node.Position.X = shift(node.Position.X, right, dx)
+			node.Position.Y = shift(node.Position.Y, bottom, dy)
+		}
+	}
+	for _, edge := range diagram.Edges {
+		if isDescendant(diagram, edge.Source, container.ID) || isDescendant(diagram, edge.Target, container.ID) {
+			continue
+		}
+		for i := range edge.Points {
+			edge.Points[i].X = shift(edge.Points[i].X, right, dx)
+			edge.Points[i].Y = shift(edge.Points[i].Y, bottom, dy)
+		}
+	}
+}
+
+// isDescendant reports whether the node id is nested, at any depth, inside
+// the container ancestor.
+func isDescendant(diagram *ir.Diagram, id, ancestor string) bool {
+	for node := diagram.GetNode(id); node != nil; node = diagram.GetNode(node.Container) {
+		if node.Container == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// span is the extent of a node along one axis.
+type span struct {
+	start, end float64
+}
+
+// gapScale maps coordinates along one axis so that the gaps between a
+// container's children are scaled: children that overlap along the axis
+// form a band that moves as one, and the space between bands is multiplied
+// by the spacing.
+type gapScale struct {
+	bands   []span
+	shifts  []float64 // How far each band moves
+	spacing float64
+}
+
+// newGapScale returns the mapping that scales the gaps between spans.
+func newGapScale(spans []span, spacing float64) gapScale {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var bands []span
+	for _, s := range spans {
+		if n := len(bands); n > 0 && s.start <= bands[n-1].end {
+			bands[n-1].end = max(bands[n-1].end, s.end)
+			continue
+		}
+		bands = append(bands, s)
+	}
+
+	shifts := make([]float64, len(bands))
+	for i := 1; i < len(bands); i++ {
+		gap := bands[i].start - bands[i-1].end
+		shifts[i] = shifts[i-1] + gap*(spacing-1)
+	}
+	return gapScale{bands: bands, shifts: shifts, spacing: spacing}
+}
+
+// apply maps a coordinate. Coordinates inside a band move with it, those
+// between bands are scaled with the gap, and those after the last band
+// move with it.
+func (g gapScale) apply(v float64) float64 {
+	if len(g.bands) == 0 || v <= g.bands[0].start {
+		return v
+	}
+	for i, band := range g.bands {
+		if v <= band.end {
+			return v + g.shifts[i]
+		}
+		if i+1 < len(g.bands) && v < g.bands[i+1].start {
+			return band.end + g.shifts[i] + (v-band.end)*g.spacing
+		}
+	}
+	return v + g.total()
+}
+
+// total returns how much longer the children's extent becomes.
+func (g gapScale) total() float64 {
+	if len(g.shifts) == 0 {
+		return 0
+	}
+	return g.shifts[len(g.shifts)-1]
+}
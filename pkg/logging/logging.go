@@ -0,0 +1,87 @@
+// Package logging provides the leveled logger used for diagnostics by the
+// CLI, the editor server, and the renderer.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// levels maps the level names accepted by ParseLevel to slog levels.
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// logger is the package logger, replaced by Setup.
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(New(os.Stderr, slog.LevelInfo))
+}
+
+// New returns a logger that writes messages at level and above to w as
+// key=value lines, with the time of day as the timestamp.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(slog.TimeKey, a.Value.Time().Format("15:04:05"))
+			}
+			return a
+		},
+	}))
+}
+
+// ParseLevel returns the level with the given name: debug, info, warn, or
+// error.
+func ParseLevel(name string) (slog.Level, error) {
+	level, ok := levels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported log level: %s (use debug, info, warn, or error)", name)
+	}
+	return level, nil
+}
+
+// Setup makes the package logger write messages at the named level and above
+// to w.
+func Setup(w io.Writer, level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.Store(New(w, l))
+	return nil
+}
+
+// Logger returns the package logger.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// Debug logs a message for troubleshooting, such as render timings.
+func Debug(msg string, args ...any) {
+	Logger().Debug(msg, args...)
+}
+
+// Info logs a routine event.
+func Info(msg string, args ...any) {
+	Logger().Info(msg, args...)
+}
+
+// Warn logs a problem the tool recovered from.
+func Warn(msg string, args ...any) {
+	Logger().Warn(msg, args...)
+}
+
+// Error logs a failed operation.
+func Error(msg string, args ...any) {
+	Logger().Error(msg, args...)
+}
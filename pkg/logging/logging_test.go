@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSetup_Levels(t *testing.T) {
+	defer Setup(os.Stderr, "info")
+
+	logAll := func() {
+		Debug("render finished", "duration", "12ms")
+		Info("client connected", "remote", "127.0.0.1:5000")
+		Warn("failed to load font")
+		Error("file watcher error")
+	}
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, "debug"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	logAll()
+	out := buf.String()
+	for _, want := range []string{
+		`level=DEBUG msg="render finished" duration=12ms`,
+		`level=INFO msg="client connected" remote=127.0.0.1:5000`,
+		`level=WARN msg="failed to load font"`,
+		`level=ERROR msg="file watcher error"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q at debug level, got:\n%s", want, out)
+		}
+	}
+
+	if !regexp.MustCompile(`(?m)^time=\d\d:\d\d:\d\d level=`).MatchString(out) {
+		t.Errorf("Expected lines to start with an HH:MM:SS timestamp, got:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := Setup(&buf, "WARN"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	logAll()
+	out = buf.String()
+	if strings.Contains(out, "render finished") || strings.Contains(out, "client connected") {
+		t.Errorf("Expected debug and info messages to be suppressed at warn level, got:\n%s", out)
+	}
+	if !strings.Contains(out, "failed to load font") || !strings.Contains(out, "file watcher error") {
+		t.Errorf("Expected warn and error messages at warn level, got:\n%s", out)
+	}
+
+	if err := Setup(&buf, "verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
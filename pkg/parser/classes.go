@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"strconv"
+
+	"oss.terrastruct.com/d2/d2ast"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// convertClasses reads the class definitions in a board's classes block.
+// Only the shape and style of each class are kept; other attributes, such
+// as labels, are already applied to the nodes and edges using the class.
+func convertClasses(ast *d2ast.Map) ir.Classes {
+	if ast == nil {
+		return nil
+	}
+
+	classes := make(ir.Classes)
+	for _, n := range ast.Nodes {
+		if !isKey(n.MapKey, "classes") || n.MapKey.Value.Map == nil {
+			continue
+		}
+		for _, c := range n.MapKey.Value.Map.Nodes {
+			if c.MapKey == nil || c.MapKey.Key == nil || len(c.MapKey.Key.Path) != 1 {
+				continue
+			}
+			name := c.MapKey.Key.Path[0].Unbox().ScalarString()
+			class := classes[name]
+			if c.MapKey.Value.Map != nil {
+				walkScalars(c.MapKey.Value.Map, nil, func(path []string, value string) {
+					switch {
+					case len(path) == 1 && path[0] == "shape":
+						class.Shape = value
+					case len(path) == 2 && path[0] == "style":
+						setStyleProperty(&class.Style, path[1], value)
+					}
+				})
+			}
+			classes[name] = class
+		}
+	}
+
+	if len(classes) == 0 {
+		return nil
+	}
+	return classes
+}
+
+// walkScalars calls fn with the full key path and value of every scalar
+// field in m, descending into nested maps.
+func walkScalars(m *d2ast.Map, prefix []string, fn func(path []string, value string)) {
+	for _, n := range m.Nodes {
+		if n.MapKey == nil || n.MapKey.Key == nil || len(n.MapKey.Edges) > 0 {
+			continue
+		}
+		path := append(append([]string(nil), prefix...), n.MapKey.Key.StringIDA()...)
+		// Boards generated by the compiler hold values as the key's primary
+		if scalar := n.MapKey.Value.ScalarBox().Unbox(); scalar != nil {
+			fn(path, scalar.ScalarString())
+		} else if scalar := n.MapKey.Primary.Unbox(); scalar != nil {
+			fn(path, scalar.ScalarString())
+		}
+		if n.MapKey.Value.Map != nil {
+			walkScalars(n.MapKey.Value.Map, path, fn)
+		}
+	}
+}
+
+// setStyleProperty sets the style property with the given D2 keyword.
+// Unknown keywords and invalid values are ignored.
+func setStyleProperty(style *ir.Style, key, value string) {
+	atoi := func() int {
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	switch key {
+	case "fill":
+		style.Fill = value
+	case "stroke":
+		style.Stroke = value
+	case "stroke-width":
+		style.StrokeWidth = atoi()
+	case "stroke-dash":
+		style.StrokeDash, _ = ir.ParseStrokeDash(value)
+	case "border-radius":
+		style.BorderRadius = atoi()
+	case "opacity":
+		style.Opacity, _ = strconv.ParseFloat(value, 64)
+	case "shadow":
+		style.Shadow = value == "true"
+	case "3d":
+		style.ThreeD = value == "true"
+	case "multiple":
+		style.Multiple = value == "true"
+	case "double-border":
+		style.DoubleBorder = value == "true"
+	case "font":
+		style.Font = value
+	case "font-size":
+		style.FontSize = atoi()
+	case "font-color":
+		style.FontColor = value
+	case "bold":
+		style.Bold = value == "true"
+	case "italic":
+		style.Italic = value == "true"
+	case "underline":
+		style.Underline = value == "true"
+	case "text-transform":
+		style.TextTransform = value
+	case "animated":
+		style.Animated = value == "true"
+	}
+}
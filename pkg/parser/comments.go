@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// convertComments keeps the comments D2 drops when compiling: the comment
+// lines at the top of the file go into the diagram's "comment" metadata,
+// and a comment on the same line as a node's key goes into the node's
+// "comment" property.
+func convertComments(ast *d2ast.Map, diagram *ir.Diagram) {
+	if ast == nil {
+		return
+	}
+
+	var header []string
+	for _, n := range ast.Nodes {
+		if n.Comment == nil {
+			break
+		}
+		header = append(header, n.Comment.Value)
+	}
+	if len(header) > 0 {
+		diagram.Metadata["comment"] = strings.Join(header, "\n")
+	}
+
+	convertTrailingComments(ast, "", diagram)
+}
+
+// convertTrailingComments attaches the comments following node keys in m,
+// whose keys are relative to the node parentID, descending into nested maps.
+func convertTrailingComments(m *d2ast.Map, parentID string, diagram *ir.Diagram) {
+	for i, n := range m.Nodes {
+		key := n.MapKey
+		if key == nil || key.Key == nil || len(key.Edges) > 0 {
+			continue
+		}
+		id := strings.Join(key.Key.StringIDA(), ".")
+		if parentID != "" {
+			id = parentID + "." + id
+		}
+
+		// "a # note" leaves the comment after the key; "a: { # note"
+		// leaves it first inside the key's map
+		var comment *d2ast.Comment
+		if i+1 < len(m.Nodes) && m.Nodes[i+1].Comment != nil &&
+			m.Nodes[i+1].Comment.Range.Start.Line == key.Range.End.Line {
+			comment = m.Nodes[i+1].Comment
+		} else if value := key.Value.Map; value != nil && len(value.Nodes) > 0 &&
+			value.Nodes[0].Comment != nil && value.Nodes[0].Comment.Range.Start.Line == key.Range.Start.Line {
+			comment = value.Nodes[0].Comment
+		}
+		if node := diagram.GetNode(id); node != nil && comment != nil {
+			if node.Properties == nil {
+				node.Properties = make(map[string]interface{})
+			}
+			node.Properties["comment"] = strings.TrimSpace(comment.Value)
+		}
+
+		if key.Value.Map != nil {
+			convertTrailingComments(key.Value.Map, id, diagram)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// SyntaxError is a D2 syntax or compile error at a position in the source.
+type SyntaxError struct {
+	Line    int    // 1-based line number
+	Column  int    // 1-based column number
+	Message string // Error text without the position
+}
+
+// SyntaxErrors returns the positioned errors in an error returned by Parse,
+// ParseFile or CheckSyntax, in source order as reported by D2. It returns
+// nil if err carries no positions, e.g. for include errors.
+func SyntaxErrors(err error) []SyntaxError {
+	var parseErr *d2parser.ParseError
+	if !errors.As(err, &parseErr) {
+		return nil
+	}
+
+	result := make([]SyntaxError, 0, len(parseErr.Errors))
+	for _, e := range parseErr.Errors {
+		// D2 prefixes each message with its range, e.g. "file.d2:3:5: "
+		message := strings.TrimPrefix(e.Message, e.Range.String()+": ")
+		result = append(result, SyntaxError{
+			Line:    e.Range.Start.Line + 1,
+			Column:  e.Range.Start.Column + 1,
+			Message: message,
+		})
+	}
+	return result
+}
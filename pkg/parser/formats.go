@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// Input formats selectable with For.
+const (
+	FormatD2       = "d2"
+	FormatPlantUML = "plantuml"
+	FormatMermaid  = "mermaid"
+)
+
+// For returns the parser for an input format: d2, plantuml or mermaid.
+// PlantUML is recognized so its files are not mistaken for D2, but has no
+// parser yet.
+func For(format string) (Parser, error) {
+	switch strings.ToLower(format) {
+	case FormatD2:
+		return NewD2Parser(), nil
+	case FormatMermaid:
+		return NewMermaidParser(), nil
+	case FormatPlantUML:
+		return nil, fmt.Errorf("%s input is not supported yet", strings.ToLower(format))
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s (use d2, plantuml, or mermaid)", format)
+	}
+}
+
+// FormatFromPath returns the input format of a file from its extension:
+// plantuml for .puml, .plantuml and .pu, mermaid for .mmd and .mermaid, and
+// d2 otherwise.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".puml", ".plantuml", ".pu":
+		return FormatPlantUML
+	case ".mmd", ".mermaid":
+		return FormatMermaid
+	default:
+		return FormatD2
+	}
+}
+
+// ParseFile parses the source of the file at path with p, resolving
+// references such as D2 includes relative to the file when p supports it.
+func ParseFile(p Parser, source, path string) (*ir.Diagram, error) {
+	if fp, ok := p.(interface {
+		ParseFile(source, filename string) (*ir.Diagram, error)
+	}); ok {
+		return fp.ParseFile(source, path)
+	}
+	return p.Parse(source)
+}
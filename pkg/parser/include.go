@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// includeDirectiveRe matches a "# @include path" directive line. Being a D2
+// comment, the directive is ignored by tools that don't resolve includes.
+var includeDirectiveRe = regexp.MustCompile(`^\s*#\s*@include\s+(.+?)\s*$`)
+
+// ResolveIncludes expands "# @include path" directives in D2 source, replacing
+// each directive line with the contents of the named file. Paths are resolved
+// relative to the directory of the including file, and included files may
+// include others. An include cycle is reported as an error.
+func ResolveIncludes(source string, filename string) (string, error) {
+	inProgress := make(map[string]bool)
+	var chain []string
+	if filename != "" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			inProgress[abs] = true
+			chain = append(chain, filename)
+		}
+	}
+	return expandIncludes(source, filepath.Dir(filename), inProgress, chain, nil)
+}
+
+// IncludedFiles returns the absolute paths of the files that source includes,
+// directly or through other included files, in the order they are first
+// included. Paths are resolved as in ResolveIncludes. If an include can't be
+// resolved, the files found so far, including the missing one, are returned
+// with the error.
+func IncludedFiles(source string, filename string) ([]string, error) {
+	inProgress := make(map[string]bool)
+	if filename != "" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			inProgress[abs] = true
+		}
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	_, err := expandIncludes(source, filepath.Dir(filename), inProgress, []string{filename}, func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	})
+	return files, err
+}
+
+// expandIncludes expands the directives in source. inProgress holds the files
+// currently being expanded, and chain the same files in include order for
+// error messages. If visit is non-nil, it is called with the absolute path of
+// each included file.
+func expandIncludes(source string, dir string, inProgress map[string]bool, chain []string, visit func(path string)) (string, error) {
+	if !strings.Contains(source, "@include") {
+		return source, nil
+	}
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		match := includeDirectiveRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		path := strings.Trim(match[1], `"'`)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve include %s: %w", match[1], err)
+		}
+		if inProgress[abs] {
+			return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(chain, " -> "), path)
+		}
+
+		if visit != nil {
+			visit(abs)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read include %s: %w", match[1], err)
+		}
+
+		inProgress[abs] = true
+		expanded, err := expandIncludes(string(content), filepath.Dir(path), inProgress, append(chain, path), visit)
+		delete(inProgress, abs)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = strings.TrimRight(expanded, "\n")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
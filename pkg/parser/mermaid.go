@@ -0,0 +1,690 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// MermaidParser parses Mermaid flowcharts ("graph" or "flowchart") into IR:
+// the diagram's direction and front matter title, nodes with their labels
+// and the closest IR shape, subgraphs as containers, and links with their
+// labels, arrowheads and line styles. Styling and interaction statements
+// such as classDef, style and click are skipped, and other Mermaid diagram
+// types are rejected.
+//
+// Mermaid node IDs are flat, so a node in a subgraph gets the subgraph's ID
+// as prefix, as in D2: "b" in "subgraph one" becomes "one.b". As in
+// Mermaid, a node belongs to the first subgraph that mentions it, even if
+// it was used outside before.
+type MermaidParser struct{}
+
+// NewMermaidParser creates a new Mermaid flowchart parser.
+func NewMermaidParser() *MermaidParser {
+	return &MermaidParser{}
+}
+
+// mermaidHeader matches the first statement of a flowchart and its
+// direction.
+var mermaidHeader = regexp.MustCompile(`^(?i:graph|flowchart)(?:\s+([A-Za-z]{2}))?$`)
+
+// mermaidSkipped are the statements that only style or annotate a
+// flowchart.
+var mermaidSkipped = []string{"classDef", "class", "style", "linkStyle", "click", "accTitle", "accDescr"}
+
+// mermaidShapes are the node shape delimiters, longest openings first, and
+// the IR shape each stands for. Mermaid has more shapes than IR, so rounded
+// and subroutine boxes are rectangles and trapezoids parallelograms.
+var mermaidShapes = []struct {
+	open  string
+	close []string
+	shape ir.ShapeType
+}{
+	{"(((", []string{")))"}, ir.ShapeCircle},
+	{"((", []string{"))"}, ir.ShapeCircle},
+	{"([", []string{"])"}, ir.ShapeOval},
+	{"[[", []string{"]]"}, ir.ShapeRectangle},
+	{"[(", []string{")]"}, ir.ShapeCylinder},
+	{"{{", []string{"}}"}, ir.ShapeHexagon},
+	{"[/", []string{"/]", `\]`}, ir.ShapeParallelogram},
+	{`[\`, []string{`\]`, "/]"}, ir.ShapeParallelogram},
+	{"[", []string{"]"}, ir.ShapeRectangle},
+	{"(", []string{")"}, ir.ShapeRectangle},
+	{"{", []string{"}"}, ir.ShapeDiamond},
+	{">", []string{"]"}, ir.ShapeRectangle},
+}
+
+// mermaidNode is a node or subgraph read from a flowchart.
+type mermaidNode struct {
+	id        string
+	label     string
+	shape     ir.ShapeType
+	subgraph  *mermaidNode // Innermost subgraph it belongs to
+	placed    bool         // Whether subgraph is set from a mention
+	container bool
+	direction string
+}
+
+// mermaidLink is a link read from a flowchart.
+type mermaidLink struct {
+	label            string
+	start, end       byte // Arrowhead markers: 0, '<' or '>', 'o' or 'x'
+	dotted, thick    bool
+	sources, targets []*mermaidNode
+}
+
+// mermaidReader collects the nodes and links of a flowchart.
+type mermaidReader struct {
+	diagram *ir.Diagram
+	nodes   map[string]*mermaidNode
+	order   []*mermaidNode
+	edges   []*ir.Edge
+	open    []*mermaidNode // Subgraphs being read, innermost last
+}
+
+// Parse converts a Mermaid flowchart to internal representation.
+func (p *MermaidParser) Parse(source string) (*ir.Diagram, error) {
+	r := &mermaidReader{
+		diagram: &ir.Diagram{
+			ID:       "diagram",
+			Nodes:    make([]*ir.Node, 0),
+			Edges:    make([]*ir.Edge, 0),
+			Metadata: make(map[string]string),
+		},
+		nodes: make(map[string]*mermaidNode),
+	}
+
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	first := r.readFrontMatter(lines)
+	header := false
+	for i := first; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "%%") {
+			continue
+		}
+		for _, statement := range splitMermaidStatements(line) {
+			var err error
+			if !header {
+				err = r.readHeader(statement)
+				header = true
+			} else {
+				err = r.readStatement(statement)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("mermaid line %d: %w", i+1, err)
+			}
+		}
+	}
+	if !header {
+		return nil, fmt.Errorf("mermaid: no flowchart found")
+	}
+	if len(r.open) > 0 {
+		return nil, fmt.Errorf("mermaid: subgraph %s is missing its end", r.open[len(r.open)-1].id)
+	}
+
+	r.build()
+	return r.diagram, nil
+}
+
+// readFrontMatter reads the title from a "---" block at the start of the
+// lines and returns the index of the first line after it.
+func (r *mermaidReader) readFrontMatter(lines []string) int {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return 0
+	}
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "---" {
+			return i + 1
+		}
+		if title, ok := strings.CutPrefix(line, "title:"); ok {
+			title = strings.TrimSpace(title)
+			if len(title) >= 2 && (title[0] == '"' || title[0] == '\'') && title[len(title)-1] == title[0] {
+				title = title[1 : len(title)-1]
+			}
+			r.diagram.Metadata["title"] = title
+		}
+	}
+	return 0
+}
+
+// readHeader reads the "graph" or "flowchart" statement.
+func (r *mermaidReader) readHeader(statement string) error {
+	m := mermaidHeader.FindStringSubmatch(statement)
+	if m == nil {
+		return fmt.Errorf("only flowcharts are supported, got %q", statement)
+	}
+	if m[1] != "" {
+		direction, err := mermaidDirection(m[1])
+		if err != nil {
+			return err
+		}
+		r.diagram.Config.Direction = direction
+	}
+	return nil
+}
+
+// readStatement reads a statement of the flowchart's body.
+func (r *mermaidReader) readStatement(statement string) error {
+	keyword, rest, _ := strings.Cut(statement, " ")
+	rest = strings.TrimSpace(rest)
+	switch {
+	case keyword == "subgraph":
+		return r.openSubgraph(rest)
+	case statement == "end":
+		if len(r.open) == 0 {
+			return fmt.Errorf("end without a subgraph")
+		}
+		r.open = r.open[:len(r.open)-1]
+		return nil
+	case keyword == "direction":
+		direction, err := mermaidDirection(rest)
+		if err != nil {
+			return err
+		}
+		if len(r.open) > 0 {
+			r.open[len(r.open)-1].direction = direction
+		} else {
+			r.diagram.Config.Direction = direction
+		}
+		return nil
+	}
+	for _, skipped := range mermaidSkipped {
+		if keyword == skipped || strings.HasPrefix(keyword, skipped+":") {
+			return nil
+		}
+	}
+	return r.readChain(statement)
+}
+
+// openSubgraph starts a subgraph from its header: an ID with an optional
+// label in brackets, or a title, which is also its ID.
+func (r *mermaidReader) openSubgraph(header string) error {
+	if header == "" {
+		return fmt.Errorf("subgraph needs an ID")
+	}
+	sc := &mermaidScanner{s: header}
+	id, label, _, err := sc.nodeRef()
+	if sc.skipSpace(); err == nil && label == "" && sc.consume("[") {
+		// "subgraph one [Title]"
+		label, err = sc.label([]string{"]"})
+	}
+	if err != nil || !sc.done() {
+		// A title with spaces or punctuation
+		label = mermaidUnescape(strings.Trim(header, `"`))
+		id = strings.Map(func(c rune) rune {
+			if isMermaidIDRune(c) {
+				return c
+			}
+			return '_'
+		}, label)
+	}
+
+	node := r.node(id)
+	node.container = true
+	if label != "" {
+		node.label = label
+	}
+	r.open = append(r.open, node)
+	return nil
+}
+
+// readChain reads nodes and the links between them: "a", "a --> b --> c",
+// or groups joined with "&", such as "a & b --> c".
+func (r *mermaidReader) readChain(statement string) error {
+	sc := &mermaidScanner{s: statement}
+	sources, err := r.nodeGroup(sc)
+	if err != nil {
+		return err
+	}
+	for !sc.done() {
+		link, err := sc.link()
+		if err != nil {
+			return err
+		}
+		targets, err := r.nodeGroup(sc)
+		if err != nil {
+			return err
+		}
+		link.sources, link.targets = sources, targets
+		r.addLink(link)
+		sources = targets
+	}
+	return nil
+}
+
+// nodeGroup reads one or more node references joined with "&", declaring
+// the nodes and moving them into the innermost open subgraph.
+func (r *mermaidReader) nodeGroup(sc *mermaidScanner) ([]*mermaidNode, error) {
+	var group []*mermaidNode
+	for {
+		id, label, shape, err := sc.nodeRef()
+		if err != nil {
+			return nil, err
+		}
+		node := r.node(id)
+		if label != "" {
+			node.label = label
+		}
+		if shape != "" && !node.container {
+			node.shape = shape
+		}
+		group = append(group, node)
+
+		sc.skipSpace()
+		if !sc.consume("&") {
+			return group, nil
+		}
+		sc.skipSpace()
+	}
+}
+
+// node returns the node with the given ID, adding it if it doesn't exist,
+// and places it in the innermost open subgraph if it isn't in one yet.
+func (r *mermaidReader) node(id string) *mermaidNode {
+	node, ok := r.nodes[id]
+	if !ok {
+		node = &mermaidNode{id: id, shape: ir.ShapeRectangle}
+		r.nodes[id] = node
+		r.order = append(r.order, node)
+	}
+	if len(r.open) > 0 && !node.placed {
+		parent := r.open[len(r.open)-1]
+		// A subgraph can't be placed inside itself
+		for p := parent; p != nil; p = p.subgraph {
+			if p == node {
+				return node
+			}
+		}
+		node.subgraph, node.placed = parent, true
+	}
+	return node
+}
+
+// addLink adds an edge from each source to each target of a link.
+func (r *mermaidReader) addLink(link mermaidLink) {
+	for _, source := range link.sources {
+		for _, target := range link.targets {
+			edge := &ir.Edge{Source: source.id, Target: target.id, Label: link.label}
+			switch {
+			case link.start != 0 && link.end != 0:
+				edge.Direction = ir.DirectionBoth
+			case link.end != 0:
+				edge.Direction = ir.DirectionForward
+			case link.start != 0:
+				edge.Direction = ir.DirectionBackward
+			default:
+				edge.Direction = ir.DirectionNone
+			}
+			edge.SourceArrowhead = mermaidArrowhead(link.start)
+			edge.TargetArrowhead = mermaidArrowhead(link.end)
+			if link.dotted {
+				edge.Style.StrokeDash = ir.StrokeDashPresets["dotted"]
+			}
+			if link.thick {
+				edge.Style.StrokeWidth = 4
+			}
+			r.edges = append(r.edges, edge)
+		}
+	}
+}
+
+// build converts the nodes and links read to the diagram's nodes and
+// edges, with hierarchical IDs. Subgraphs come before the nodes in them.
+func (r *mermaidReader) build() {
+	ids := make(map[*mermaidNode]string)
+	var fullID func(node *mermaidNode) string
+	fullID = func(node *mermaidNode) string {
+		if id, ok := ids[node]; ok {
+			return id
+		}
+		id := node.id
+		if node.subgraph != nil {
+			id = fullID(node.subgraph) + "." + id
+		}
+		ids[node] = id
+		return id
+	}
+
+	added := make(map[*mermaidNode]bool)
+	var add func(node *mermaidNode)
+	add = func(node *mermaidNode) {
+		if added[node] {
+			return
+		}
+		added[node] = true
+		irNode := &ir.Node{
+			ID:        fullID(node),
+			Label:     node.label,
+			Shape:     node.shape,
+			Direction: node.direction,
+		}
+		if irNode.Label == "" {
+			irNode.Label = node.id
+		}
+		if node.container {
+			irNode.Shape = ir.ShapeContainer
+		}
+		if node.subgraph != nil {
+			add(node.subgraph)
+			irNode.Container = fullID(node.subgraph)
+		}
+		r.diagram.Nodes = append(r.diagram.Nodes, irNode)
+	}
+	for _, node := range r.order {
+		add(node)
+	}
+
+	for i, edge := range r.edges {
+		edge.Source = fullID(r.nodes[edge.Source])
+		edge.Target = fullID(r.nodes[edge.Target])
+		edge.ID = fmt.Sprintf("%s-%s-%d", edge.Source, edge.Target, i)
+		r.diagram.Edges = append(r.diagram.Edges, edge)
+	}
+}
+
+// mermaidScanner reads node references and links from a statement.
+type mermaidScanner struct {
+	s   string
+	pos int
+}
+
+// rest returns the unread part of the statement.
+func (sc *mermaidScanner) rest() string {
+	return sc.s[sc.pos:]
+}
+
+// done reports whether only spaces are left.
+func (sc *mermaidScanner) done() bool {
+	return strings.TrimSpace(sc.rest()) == ""
+}
+
+// skipSpace skips spaces and tabs.
+func (sc *mermaidScanner) skipSpace() {
+	for sc.pos < len(sc.s) && (sc.s[sc.pos] == ' ' || sc.s[sc.pos] == '\t') {
+		sc.pos++
+	}
+}
+
+// consume skips prefix if the unread part starts with it.
+func (sc *mermaidScanner) consume(prefix string) bool {
+	if strings.HasPrefix(sc.rest(), prefix) {
+		sc.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// nodeRef reads a node ID followed by an optional shape with its label,
+// as in "a", "a[Label]" or `a(("Label"))`, and an optional ":::class".
+func (sc *mermaidScanner) nodeRef() (id, label string, shape ir.ShapeType, err error) {
+	start := sc.pos
+	for sc.pos < len(sc.s) {
+		c, size := utf8.DecodeRuneInString(sc.rest())
+		// A hyphen belongs to the ID only between ID characters, so
+		// "a-b-->c" links "a-b" to "c"
+		if c == '-' && sc.pos > start {
+			if next, _ := utf8.DecodeRuneInString(sc.s[sc.pos+1:]); isMermaidIDRune(next) {
+				sc.pos++
+				continue
+			}
+		}
+		if !isMermaidIDRune(c) {
+			break
+		}
+		sc.pos += size
+	}
+	if sc.pos == start {
+		return "", "", "", fmt.Errorf("expected a node ID at %q", sc.rest())
+	}
+	id = sc.s[start:sc.pos]
+
+	for _, delim := range mermaidShapes {
+		if !sc.consume(delim.open) {
+			continue
+		}
+		label, err = sc.label(delim.close)
+		if err != nil {
+			return "", "", "", fmt.Errorf("node %s: %w", id, err)
+		}
+		shape = delim.shape
+		break
+	}
+
+	if sc.consume(":::") {
+		class := strings.IndexFunc(sc.rest(), func(c rune) bool { return !isMermaidIDRune(c) && c != '-' })
+		if class < 0 {
+			class = len(sc.rest())
+		}
+		sc.pos += class
+	}
+	return id, label, shape, nil
+}
+
+// label reads a node label up to the first of the closing delimiters,
+// unquoting and unescaping it.
+func (sc *mermaidScanner) label(closers []string) (string, error) {
+	rest := sc.rest()
+	if strings.HasPrefix(strings.TrimSpace(rest), `"`) {
+		open := strings.Index(rest, `"`)
+		end := strings.Index(rest[open+1:], `"`)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		text := rest[open+1 : open+1+end]
+		sc.pos += open + end + 2
+		sc.skipSpace()
+		for _, closer := range closers {
+			if sc.consume(closer) {
+				return mermaidUnescape(text), nil
+			}
+		}
+		return "", fmt.Errorf("expected %q after the label", closers[0])
+	}
+
+	end, size := -1, 0
+	for _, closer := range closers {
+		if i := strings.Index(rest, closer); i >= 0 && (end < 0 || i < end) {
+			end, size = i, len(closer)
+		}
+	}
+	if end < 0 {
+		return "", fmt.Errorf("missing %q", closers[0])
+	}
+	sc.pos += end + size
+	return mermaidUnescape(strings.TrimSpace(rest[:end])), nil
+}
+
+// link reads a link between node references: a line of "-", "=" or "-.",
+// with optional arrowheads at either end and an optional label, either
+// inside the line as in "-- yes -->" or after it as in "-->|yes|".
+func (sc *mermaidScanner) link() (mermaidLink, error) {
+	var link mermaidLink
+	sc.skipSpace()
+	rest := sc.rest()
+	if len(rest) > 1 && strings.ContainsRune("<ox", rune(rest[0])) && (rest[1] == '-' || rest[1] == '=') {
+		link.start = rest[0]
+		sc.pos++
+	}
+
+	// The line, and whether a label follows inside it
+	inline := false
+	rest = sc.rest()
+	switch {
+	case strings.HasPrefix(rest, "-."):
+		link.dotted = true
+		dots := strings.TrimLeft(rest[1:], ".")
+		sc.pos += len(rest) - len(dots)
+		if !sc.consume("-") {
+			inline = true
+		}
+	case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "=="):
+		link.thick = rest[0] == '='
+		line := strings.TrimLeft(rest, rest[:1])
+		sc.pos += len(rest) - len(line)
+		inline = len(rest)-len(line) == 2 && (line == "" || line[0] == ' ' || line[0] == '\t')
+	default:
+		return link, fmt.Errorf("expected a link at %q", rest)
+	}
+
+	if inline {
+		closer := mermaidLabelCloser(link)
+		m := closer.FindStringSubmatchIndex(sc.rest())
+		if m == nil {
+			return link, fmt.Errorf("link label %q has no end", strings.TrimSpace(sc.rest()))
+		}
+		link.label = mermaidLinkLabel(sc.rest()[:m[0]])
+		if m[3] > m[2] {
+			link.end = sc.rest()[m[2]]
+		}
+		sc.pos += m[1]
+	} else {
+		sc.readArrowhead(&link)
+	}
+
+	sc.skipSpace()
+	if sc.consume("|") {
+		end := strings.Index(sc.rest(), "|")
+		if end < 0 {
+			return link, fmt.Errorf("link label %q has no closing |", sc.rest())
+		}
+		link.label = mermaidLinkLabel(sc.rest()[:end])
+		sc.pos += end + 1
+	}
+	sc.skipSpace()
+	return link, nil
+}
+
+// readArrowhead reads the arrowhead at the end of a link's line: ">", or
+// "o" or "x" when not the start of the next node's ID.
+func (sc *mermaidScanner) readArrowhead(link *mermaidLink) {
+	rest := sc.rest()
+	if rest == "" {
+		return
+	}
+	switch rest[0] {
+	case '>':
+		link.end = '>'
+		sc.pos++
+	case 'o', 'x':
+		if next, _ := utf8.DecodeRuneInString(rest[1:]); !isMermaidIDRune(next) {
+			link.end = rest[0]
+			sc.pos++
+		}
+	}
+}
+
+// mermaidLabelCloser returns the pattern ending a label inside a link of
+// the given line style, capturing its arrowhead.
+func mermaidLabelCloser(link mermaidLink) *regexp.Regexp {
+	switch {
+	case link.dotted:
+		return regexp.MustCompile(`\.+-([>ox]?)`)
+	case link.thick:
+		return regexp.MustCompile(`={2,}([>ox]?)`)
+	default:
+		return regexp.MustCompile(`-{2,}([>ox]?)`)
+	}
+}
+
+// mermaidLinkLabel returns the text of a link label, which may be quoted.
+func mermaidLinkLabel(label string) string {
+	label = strings.TrimSpace(label)
+	if len(label) >= 2 && label[0] == '"' && label[len(label)-1] == '"' {
+		label = label[1 : len(label)-1]
+	}
+	return mermaidUnescape(label)
+}
+
+// mermaidArrowhead returns the IR arrowhead shape for a link end marker,
+// or "" for the default arrow or none.
+func mermaidArrowhead(marker byte) string {
+	switch marker {
+	case 'o':
+		return "circle"
+	case 'x':
+		return "cross"
+	default:
+		return ""
+	}
+}
+
+// mermaidDirection returns the IR direction for a Mermaid direction.
+func mermaidDirection(direction string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(direction)) {
+	case "TB", "TD":
+		return "down", nil
+	case "BT":
+		return "up", nil
+	case "LR":
+		return "right", nil
+	case "RL":
+		return "left", nil
+	default:
+		return "", fmt.Errorf("unknown direction %q (use TB, TD, BT, LR or RL)", direction)
+	}
+}
+
+// isMermaidIDRune reports whether c can be part of a node ID.
+func isMermaidIDRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// mermaidEntityStart matches the start of an entity code, which a
+// semicolon ends rather than a statement.
+var mermaidEntityStart = regexp.MustCompile(`#\w+$`)
+
+// splitMermaidStatements splits a line into its statements, separated by
+// semicolons outside quotes and entity codes.
+func splitMermaidStatements(line string) []string {
+	var statements []string
+	quoted, start := false, 0
+	for i := 0; i <= len(line); i++ {
+		if i < len(line) && line[i] == '"' {
+			quoted = !quoted
+		}
+		if i == len(line) || (line[i] == ';' && !quoted && !mermaidEntityStart.MatchString(line[start:i])) {
+			if statement := strings.TrimSpace(line[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+	}
+	return statements
+}
+
+// mermaidEntity matches the entity codes Mermaid labels use for characters
+// that would end them, such as "#quot;" or "#35;", and line breaks.
+var mermaidEntity = regexp.MustCompile(`#(\w+);|(?i)<br\s*/?>`)
+
+// mermaidEntities are the named entity codes mermaidUnescape knows.
+var mermaidEntities = map[string]string{
+	"quot": `"`,
+	"amp":  "&",
+	"lt":   "<",
+	"gt":   ">",
+	"apos": "'",
+}
+
+// mermaidUnescape replaces entity codes and <br> line breaks in a label
+// with the text they stand for.
+func mermaidUnescape(text string) string {
+	return mermaidEntity.ReplaceAllStringFunc(text, func(match string) string {
+		if !strings.HasPrefix(match, "#") {
+			return "\n"
+		}
+		name := match[1 : len(match)-1]
+		if code, err := strconv.Atoi(name); err == nil {
+			return string(rune(code))
+		}
+		if s, ok := mermaidEntities[name]; ok {
+			return s
+		}
+		return match
+	})
+}
@@ -5,9 +5,11 @@ package parser
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
+	"oss.terrastruct.com/d2/d2ast"
 	"oss.terrastruct.com/d2/d2compiler"
 	"oss.terrastruct.com/d2/d2graph"
 
@@ -49,28 +51,57 @@ func NewD2ParserWithOptions(opts D2ParserOptions) *D2Parser {
 
 // Parse converts D2 source code to internal representation.
 func (p *D2Parser) Parse(source string) (*ir.Diagram, error) {
-	// Compile D2 source to graph
-	graph, _, err := d2compiler.Compile("", strings.NewReader(source), &d2compiler.CompileOptions{
+	return p.parse(source, "")
+}
+
+// ParseFile reads and parses a D2 file (convenience wrapper).
+// "# @include path" directives are resolved relative to the file's directory.
+func (p *D2Parser) ParseFile(source string, filename string) (*ir.Diagram, error) {
+	source, err := ResolveIncludes(source, filename)
+	if err != nil {
+		return nil, err
+	}
+	return p.parse(source, filename)
+}
+
+// parse compiles D2 source and converts it to IR, with the tags the
+// compiler doesn't know about.
+func (p *D2Parser) parse(source, filename string) (*ir.Diagram, error) {
+	normalized, tags := stripTags(normalizeValues(source))
+	normalized, routes := stripRouting(normalized)
+	graph, _, err := d2compiler.Compile(filename, strings.NewReader(normalized), &d2compiler.CompileOptions{
 		UTF16Pos: p.Options.UTF16Pos,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("d2 compilation failed: %w", err)
 	}
 
-	// Convert D2 graph to IR
-	return convertGraph(graph)
+	diagram, err := convertGraph(graph)
+	if err != nil {
+		return nil, err
+	}
+	applyTags(diagram, tags, "")
+	applyRouting(diagram, graph, routes)
+	return diagram, nil
 }
 
-// ParseFile reads and parses a D2 file (convenience wrapper).
-func (p *D2Parser) ParseFile(source string, filename string) (*ir.Diagram, error) {
-	graph, _, err := d2compiler.Compile(filename, strings.NewReader(source), &d2compiler.CompileOptions{
+// CheckSyntax compiles D2 source without converting it to IR.
+// It is a fast path for syntax checks on large files; includes are
+// resolved relative to filename like in ParseFile.
+func (p *D2Parser) CheckSyntax(source string, filename string) error {
+	source, err := ResolveIncludes(source, filename)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = d2compiler.Compile(filename, strings.NewReader(Normalize(source)), &d2compiler.CompileOptions{
 		UTF16Pos: p.Options.UTF16Pos,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("d2 compilation failed: %w", err)
+		return fmt.Errorf("d2 compilation failed: %w", err)
 	}
 
-	return convertGraph(graph)
+	return nil
 }
 
 // convertGraph converts a D2 graph to our IR Diagram.
@@ -81,6 +112,13 @@ func convertGraph(g *d2graph.Graph) (*ir.Diagram, error) {
 		Edges:    make([]*ir.Edge, 0),
 		Metadata: make(map[string]string),
 	}
+	if title := Title(g); title != "" {
+		diagram.Metadata["title"] = title
+	}
+	if g.Root != nil {
+		diagram.Config.Direction = g.Root.Direction.Value
+	}
+	diagram.Classes = convertClasses(g.AST)
 
 	// Convert objects to nodes (recursive for nested objects)
 	if g.Root != nil {
@@ -93,9 +131,54 @@ func convertGraph(g *d2graph.Graph) (*ir.Diagram, error) {
 		diagram.Edges = append(diagram.Edges, irEdge)
 	}
 
+	// Comments, which the compiler drops
+	convertComments(g.AST, diagram)
+
+	// Convert nested boards (layers, scenarios, steps)
+	diagram.FolderOnly = g.IsFolderOnly
+	for _, boards := range [][]*d2graph.Graph{g.Layers, g.Scenarios, g.Steps} {
+		for _, board := range boards {
+			irBoard, err := convertGraph(board)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert board %q: %w", board.Name, err)
+			}
+			irBoard.ID = board.Name
+			diagram.Boards = append(diagram.Boards, irBoard)
+		}
+	}
+
 	return diagram, nil
 }
 
+// Title returns the title of a compiled D2 board: its root label if one is
+// set, otherwise the value of a "title" variable declared in its vars.
+func Title(g *d2graph.Graph) string {
+	if g.Root != nil && g.Root.Label.MapKey != nil {
+		return g.Root.Label.Value
+	}
+	if g.AST == nil {
+		return ""
+	}
+	for _, n := range g.AST.Nodes {
+		if !isKey(n.MapKey, "vars") || n.MapKey.Value.Map == nil {
+			continue
+		}
+		for _, v := range n.MapKey.Value.Map.Nodes {
+			if isKey(v.MapKey, "title") {
+				if scalar := v.MapKey.Value.ScalarBox().Unbox(); scalar != nil {
+					return scalar.ScalarString()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// isKey reports whether an AST map key is the single-part key name.
+func isKey(key *d2ast.Key, name string) bool {
+	return key != nil && key.Key != nil && slices.Equal(key.Key.StringIDA(), []string{name})
+}
+
 // convertObjects recursively converts D2 objects to IR nodes.
 func convertObjects(objects []*d2graph.Object, parentID string, diagram *ir.Diagram) {
 	for _, obj := range objects {
@@ -132,6 +215,25 @@ func convertObject(obj *d2graph.Object, parentID string) *ir.Node {
 		Shape:     shape,
 		Container: parentID,
 		Style:     convertObjectStyle(obj),
+		Classes:   slices.Clone(obj.Classes),
+	}
+
+	// Per-container layout direction
+	if obj.Direction.Value != "" {
+		node.Direction = obj.Direction.Value
+	}
+
+	// Placement near a canvas position or another object
+	if obj.NearKey != nil {
+		node.Near = strings.Join(d2graph.Key(obj.NearKey), ".")
+	}
+
+	// Size hints
+	if obj.WidthAttr != nil {
+		node.MinWidth, _ = strconv.ParseFloat(obj.WidthAttr.Value, 64)
+	}
+	if obj.HeightAttr != nil {
+		node.MinHeight, _ = strconv.ParseFloat(obj.HeightAttr.Value, 64)
 	}
 
 	// Copy position if available (from D2's layout)
@@ -195,6 +297,8 @@ func mapD2ShapeToIR(obj *d2graph.Object) ir.ShapeType {
 		return ir.ShapeCloud
 	case "cylinder", "storage":
 		return ir.ShapeCylinder
+	case "queue":
+		return ir.ShapeQueue
 	case "sql_table":
 		return ir.ShapeSQLTable
 	case "class":
@@ -224,7 +328,7 @@ func convertObjectStyle(obj *d2graph.Object) ir.Style {
 		}
 	}
 	if obj.Style.StrokeDash != nil && obj.Style.StrokeDash.Value != "" {
-		if d, err := strconv.Atoi(obj.Style.StrokeDash.Value); err == nil {
+		if d, ok := ir.ParseStrokeDash(obj.Style.StrokeDash.Value); ok {
 			style.StrokeDash = d
 		}
 	}
@@ -300,16 +404,21 @@ func convertEdge(edge *d2graph.Edge, index int) *ir.Edge {
 		Target:    dstID,
 		Direction: direction,
 		Style:     convertEdgeStyle(edge),
+		Classes:   slices.Clone(edge.Classes),
 	}
 
-	// Handle SQL table column connections
-	if edge.SrcTableColumnIndex != nil {
-		irEdge.SourcePort = fmt.Sprintf("col-%d", *edge.SrcTableColumnIndex)
+	// Copy explicit arrowhead shapes
+	if edge.SrcArrowhead != nil {
+		irEdge.SourceArrowhead = edge.SrcArrowhead.Shape.Value
 	}
-	if edge.DstTableColumnIndex != nil {
-		irEdge.TargetPort = fmt.Sprintf("col-%d", *edge.DstTableColumnIndex)
+	if edge.DstArrowhead != nil {
+		irEdge.TargetArrowhead = edge.DstArrowhead.Shape.Value
 	}
 
+	// Connections to SQL table columns use the column name as port
+	irEdge.SourcePort = tableColumnName(edge.Src, edge.SrcTableColumnIndex)
+	irEdge.TargetPort = tableColumnName(edge.Dst, edge.DstTableColumnIndex)
+
 	// Copy route points if available
 	if len(edge.Route) > 0 {
 		irEdge.Points = make([]ir.Point, len(edge.Route))
@@ -318,15 +427,24 @@ func convertEdge(edge *d2graph.Edge, index int) *ir.Edge {
 		}
 	}
 
+	// Curved by the layout engine, or with rounded bends in the source
+	irEdge.Curved = edge.IsCurve || irEdge.Style.BorderRadius > 0
+
 	// Store D2-specific properties
 	irEdge.Properties = make(map[string]interface{})
-	if edge.IsCurve {
-		irEdge.Properties["curved"] = true
-	}
 
 	return irEdge
 }
 
+// tableColumnName returns the name of the SQL table column at index, or ""
+// if the edge doesn't connect to a column.
+func tableColumnName(obj *d2graph.Object, index *int) string {
+	if index == nil || obj.SQLTable == nil || *index < 0 || *index >= len(obj.SQLTable.Columns) {
+		return ""
+	}
+	return obj.SQLTable.Columns[*index].Name.Label
+}
+
 // mapD2DirectionToIR maps D2 arrow configuration to IR direction.
 func mapD2DirectionToIR(srcArrow, dstArrow bool) ir.Direction {
 	switch {
@@ -354,10 +472,15 @@ func convertEdgeStyle(edge *d2graph.Edge) ir.Style {
 		}
 	}
 	if edge.Style.StrokeDash != nil && edge.Style.StrokeDash.Value != "" {
-		if d, err := strconv.Atoi(edge.Style.StrokeDash.Value); err == nil {
+		if d, ok := ir.ParseStrokeDash(edge.Style.StrokeDash.Value); ok {
 			style.StrokeDash = d
 		}
 	}
+	if edge.Style.BorderRadius != nil && edge.Style.BorderRadius.Value != "" {
+		if r, err := strconv.Atoi(edge.Style.BorderRadius.Value); err == nil {
+			style.BorderRadius = r
+		}
+	}
 	if edge.Style.Opacity != nil && edge.Style.Opacity.Value != "" {
 		if o, err := strconv.ParseFloat(edge.Style.Opacity.Value, 64); err == nil {
 			style.Opacity = o
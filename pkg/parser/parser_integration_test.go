@@ -1,12 +1,19 @@
 package parser
 
 import (
+	"flag"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
 )
 
+// updateGolden rewrites the golden files in testdata/golden with the
+// current output instead of comparing against them.
+var updateGolden = flag.Bool("update", false, "update golden files")
+
 // TestParse_ExampleFiles tests parsing all example D2 files
 func TestParse_ExampleFiles(t *testing.T) {
 	p := NewD2Parser()
@@ -217,3 +224,215 @@ services.auth -> data.cache: Session Cache
 		t.Error("Expected gateway bold to be true")
 	}
 }
+
+// TestParseFile_Include tests resolving "# @include" directives relative to the file
+func TestParseFile_Include(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(shared, "storage.d2"), []byte("db: Database {\n  shape: cylinder\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write fragment: %v", err)
+	}
+	base := filepath.Join(dir, "base.d2")
+	source := "# @include shared/storage.d2\napi: API\napi -> db\n"
+	if err := os.WriteFile(base, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write base file: %v", err)
+	}
+
+	p := NewD2Parser()
+	diagram, err := p.ParseFile(source, base)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+
+	db := diagram.GetNode("db")
+	if db == nil {
+		t.Fatal("Expected node 'db' from the included file")
+	}
+	if db.Shape != "cylinder" {
+		t.Errorf("Expected included node shape 'cylinder', got '%s'", db.Shape)
+	}
+	if diagram.GetNode("api") == nil {
+		t.Error("Expected node 'api' from the base file")
+	}
+	if len(diagram.Nodes) != 2 || len(diagram.Edges) != 1 {
+		t.Errorf("Expected 2 nodes and 1 edge, got %d and %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+}
+
+// TestParseFile_IncludeCycle tests that cyclic includes return an error
+func TestParseFile_IncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.d2")
+	b := filepath.Join(dir, "b.d2")
+	if err := os.WriteFile(a, []byte("# @include b.d2\na\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(b, []byte("# @include a.d2\nb\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	p := NewD2Parser()
+	_, err := p.ParseFile("# @include b.d2\na\n", a)
+	if err == nil {
+		t.Fatal("Expected error for cyclic include")
+	}
+	if !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expected include cycle error, got: %v", err)
+	}
+
+	// Including the same fragment twice without a cycle is fine
+	if err := os.WriteFile(b, []byte("b\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := p.ParseFile("# @include b.d2\n# @include b.d2\na\n", a); err != nil {
+		t.Errorf("Repeated include should not be a cycle: %v", err)
+	}
+}
+
+// TestIncludedFiles tests listing nested and missing includes
+func TestIncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.d2")
+	shared := filepath.Join(dir, "shared.d2")
+	nested := filepath.Join(dir, "nested.d2")
+	if err := os.WriteFile(shared, []byte("# @include nested.d2\nshared\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(nested, []byte("nested\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	files, err := IncludedFiles("# @include shared.d2\n# @include nested.d2\na\n", base)
+	if err != nil {
+		t.Fatalf("IncludedFiles failed: %v", err)
+	}
+	if strings.Join(files, ",") != shared+","+nested {
+		t.Errorf("Expected [%s %s], got %v", shared, nested, files)
+	}
+
+	// A missing include is still listed, so it can be watched for creation
+	missing := filepath.Join(dir, "missing.d2")
+	files, err = IncludedFiles("# @include missing.d2\n", base)
+	if err == nil {
+		t.Error("Expected error for missing include")
+	}
+	if len(files) != 1 || files[0] != missing {
+		t.Errorf("Expected [%s], got %v", missing, files)
+	}
+}
+
+// TestParseFile_FindNodes exercises label and shape search on the microservices example
+func TestParseFile_FindNodes(t *testing.T) {
+	file := "../../examples/07-microservices.d2"
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	diagram, err := NewD2Parser().ParseFile(string(content), file)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", file, err)
+	}
+
+	// Matches the five hexagon services plus the "Microservices" container
+	hexagons := 0
+	for _, node := range diagram.FindNodesByLabel("service") {
+		if node.Shape == ir.ShapeHexagon {
+			hexagons++
+		} else if node.ID != "services" {
+			t.Errorf("Unexpected match %s (%s)", node.ID, node.Label)
+		}
+	}
+	if hexagons != 5 {
+		t.Errorf("Expected 5 hexagon services, got %d", hexagons)
+	}
+
+	databases := diagram.FindNodesByShape(ir.ShapeCylinder)
+	if len(databases) != 2 {
+		t.Fatalf("Expected 2 cylinder nodes, got %d", len(databases))
+	}
+	for _, node := range databases {
+		if !strings.HasSuffix(node.Label, "DB") {
+			t.Errorf("Expected a database node, got %s (%s)", node.ID, node.Label)
+		}
+	}
+}
+
+// TestGenerate_MicroservicesGolden compares the Mermaid and PlantUML
+// generated from the microservices example with golden files. Run with
+// -update to rewrite them after an intended change.
+func TestGenerate_MicroservicesGolden(t *testing.T) {
+	file := "../../examples/07-microservices.d2"
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	diagram, err := NewD2Parser().ParseFile(string(content), file)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", file, err)
+	}
+
+	tests := []struct {
+		name     string
+		generate func(*ir.Diagram) string
+		golden   string
+		header   string
+		edges    []string
+	}{
+		{
+			name:     "mermaid",
+			generate: ir.ToMermaid,
+			golden:   "07-microservices.mmd",
+			header:   "graph TD\n",
+			edges: []string{
+				"web -->|HTTPS| gateway",
+				"gateway -->|Authenticate| services_auth",
+				"services_orders -->|Order Events| queue",
+				"services_users --> data_userdb",
+			},
+		},
+		{
+			name:     "plantuml",
+			generate: ir.ToPlantUML,
+			golden:   "07-microservices.puml",
+			header:   "@startuml\n",
+			edges: []string{
+				"web --> gateway : HTTPS",
+				"gateway --> services_auth : Authenticate",
+				"services_orders --> queue : Order Events",
+				"services_users --> data_userdb",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.generate(diagram)
+			if !strings.HasPrefix(got, tt.header) {
+				t.Errorf("Expected output to start with %q, got:\n%s", tt.header, got)
+			}
+			for _, edge := range tt.edges {
+				if !strings.Contains(got, edge+"\n") {
+					t.Errorf("Expected edge %q in output:\n%s", edge, got)
+				}
+			}
+
+			golden := filepath.Join("../../testdata/golden", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("Output differs from %s (run with -update to rewrite it):\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package parser
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/mark/dsl-diagram-tool/pkg/ir"
@@ -188,6 +193,100 @@ circle: Circle { shape: circle }
 	}
 }
 
+func TestParse_ShapeAliases(t *testing.T) {
+	p := NewD2Parser()
+	source := `
+db: Database { shape: db }
+actor.shape: Actor
+decision: { shape: decision }
+process: { shape: process }
+terminator: { shape: terminator }
+queue: { shape: queue }
+classes: { store: { shape: db } }
+cache: { class: store }
+a -> b: { target-arrowhead.shape: diamond }
+`
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		id    string
+		shape ir.ShapeType
+	}{
+		{"db", ir.ShapeCylinder},
+		{"actor", ir.ShapePerson},
+		{"decision", ir.ShapeDiamond},
+		{"process", ir.ShapeRectangle},
+		{"terminator", ir.ShapeOval},
+		{"queue", ir.ShapeQueue},
+		{"cache", ir.ShapeCylinder},
+	}
+	for _, tt := range tests {
+		n := diagram.GetNode(tt.id)
+		if n == nil {
+			t.Errorf("Expected node '%s'", tt.id)
+			continue
+		}
+		if n.Shape != tt.shape {
+			t.Errorf("Node '%s': expected shape %s, got %s", tt.id, tt.shape, n.Shape)
+		}
+	}
+}
+
+func TestNormalizeShapes(t *testing.T) {
+	source := "a: { shape: DB }\nb: \"shape: db\"\nc.shape: process\n"
+	expected := "a: { shape: cylinder }\nb: \"shape: db\"\nc.shape: rectangle\n"
+	if got := NormalizeShapes(source); got != expected {
+		t.Errorf("NormalizeShapes() = %q, expected %q", got, expected)
+	}
+
+	// Invalid source is left for the compiler to report
+	if got := NormalizeShapes("a: { shape: db"); got != "a: { shape: db" {
+		t.Errorf("Expected invalid source unchanged, got %q", got)
+	}
+}
+
+func TestParse_NamedStrokeDash(t *testing.T) {
+	source := `
+a -> b: { style.stroke-dash: dotted }
+b -> c
+(b -> c)[0].style.stroke-dash: Dashed
+c.style.stroke-dash: dashed
+d: { style: { stroke-dash: 3 } }
+classes: { async: { style.stroke-dash: dotted } }
+c -> d: { class: async }
+`
+	diagram, err := NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	dotted, dashed := ir.StrokeDashPresets["dotted"], ir.StrokeDashPresets["dashed"]
+	if dotted == 0 || dotted >= dashed {
+		t.Fatalf("Expected dotted to be a shorter dash than dashed, got %d and %d", dotted, dashed)
+	}
+	for _, tt := range []struct {
+		style ir.Style
+		name  string
+		want  int
+	}{
+		{diagram.Edges[0].Style, "edge a -> b", dotted},
+		{diagram.Edges[1].Style, "edge b -> c", dashed},
+		{diagram.Edges[2].Style, "edge c -> d", dotted},
+		{diagram.GetNode("c").Style, "node c", dashed},
+		{diagram.GetNode("d").Style, "node d", 3},
+	} {
+		if tt.style.StrokeDash != tt.want {
+			t.Errorf("%s: expected stroke dash %d, got %d", tt.name, tt.want, tt.style.StrokeDash)
+		}
+	}
+	if got := diagram.Classes["async"].Style.StrokeDash; got != dotted {
+		t.Errorf("Expected class async to have stroke dash %d, got %d", dotted, got)
+	}
+}
+
 func TestParse_Containers(t *testing.T) {
 	p := NewD2Parser()
 	source := `
@@ -343,6 +442,67 @@ a -> b: styled {
 	}
 }
 
+func TestParse_Classes(t *testing.T) {
+	p := NewD2Parser()
+	source := `
+classes: {
+  c4-system: {
+    style.fill: "#1168bd"
+    style.font-color: "#ffffff"
+  }
+  db: {
+    shape: cylinder
+  }
+  async: {
+    style.stroke-dash: 3
+  }
+}
+banking: Banking System {
+  class: c4-system
+}
+store: {
+  class: [c4-system; db]
+}
+banking -> store: {
+  class: async
+}
+`
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(diagram.Classes) != 3 {
+		t.Fatalf("Expected 3 classes, got %d", len(diagram.Classes))
+	}
+	if class := diagram.Classes["c4-system"]; class.Style.Fill != "#1168bd" || class.Style.FontColor != "#ffffff" {
+		t.Errorf("Expected c4-system fill and font color, got %+v", class.Style)
+	}
+	if shape := diagram.Classes["db"].Shape; shape != "cylinder" {
+		t.Errorf("Expected db class shape 'cylinder', got %q", shape)
+	}
+
+	banking := diagram.GetNode("banking")
+	if !slices.Equal(banking.Classes, []string{"c4-system"}) {
+		t.Errorf("Expected banking classes [c4-system], got %v", banking.Classes)
+	}
+	if banking.Style.Fill != "#1168bd" {
+		t.Errorf("Expected class fill on banking, got '%s'", banking.Style.Fill)
+	}
+
+	store := diagram.GetNode("store")
+	if !slices.Equal(store.Classes, []string{"c4-system", "db"}) {
+		t.Errorf("Expected store classes [c4-system db], got %v", store.Classes)
+	}
+	if store.Shape != ir.ShapeCylinder {
+		t.Errorf("Expected store shape cylinder, got %s", store.Shape)
+	}
+
+	if len(diagram.Edges) != 1 || !slices.Equal(diagram.Edges[0].Classes, []string{"async"}) {
+		t.Errorf("Expected edge classes [async], got %+v", diagram.Edges)
+	}
+}
+
 func TestParse_CrossContainerEdges(t *testing.T) {
 	p := NewD2Parser()
 	source := `
@@ -385,6 +545,28 @@ invalid syntax {{{{
 	}
 }
 
+func TestSyntaxErrors(t *testing.T) {
+	_, err := NewD2Parser().Parse("a -> b\nb: {\n  shape: hexagonal\n}")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid shape")
+	}
+
+	errs := SyntaxErrors(err)
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 syntax error, got %v", errs)
+	}
+	if errs[0].Line != 3 || errs[0].Column != 10 {
+		t.Errorf("Expected error at 3:10, got %d:%d", errs[0].Line, errs[0].Column)
+	}
+	if !strings.Contains(errs[0].Message, "hexagonal") || strings.HasPrefix(errs[0].Message, "3:10") {
+		t.Errorf("Expected message without position, got %q", errs[0].Message)
+	}
+
+	if errs := SyntaxErrors(errors.New("unrelated")); errs != nil {
+		t.Errorf("Expected no syntax errors for other errors, got %v", errs)
+	}
+}
+
 func TestParse_EmptySource(t *testing.T) {
 	p := NewD2Parser()
 	diagram, err := p.Parse("")
@@ -448,6 +630,47 @@ a -> b: third
 	}
 }
 
+func TestParse_DuplicateEdgesLint(t *testing.T) {
+	p := NewD2Parser()
+	diagram, err := p.Parse("a -> b: x\na -> b: x\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	warnings := diagram.Lint(ir.LintOptions{DuplicateEdges: true})
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "a-b-0, a-b-1") {
+		t.Errorf("Expected one warning naming both edges, got %v", warnings)
+	}
+
+	diagram, err = p.Parse("a -> b: x\na -> b: y\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if warnings := diagram.Lint(ir.LintOptions{DuplicateEdges: true}); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for differently labeled edges, got %v", warnings)
+	}
+}
+
+func TestParse_MergeParallelEdges(t *testing.T) {
+	p := NewD2Parser()
+	source := `
+a -> b: first
+a -> b: second
+a -> b: third
+`
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	merged := diagram.MergeParallelEdges(", ")
+	if len(merged.Edges) != 1 {
+		t.Fatalf("Expected 1 merged edge, got %d", len(merged.Edges))
+	}
+	if merged.Edges[0].Label != "first, second, third" {
+		t.Errorf("Expected label 'first, second, third', got '%s'", merged.Edges[0].Label)
+	}
+}
+
 func TestMapD2DirectionToIR(t *testing.T) {
 	tests := []struct {
 		srcArrow bool
@@ -520,6 +743,109 @@ func TestParseFile(t *testing.T) {
 	}
 }
 
+func TestParse_Boards(t *testing.T) {
+	p := NewD2Parser()
+	source := `
+layers: {
+  current: {
+    client -> api
+  }
+  future: {
+    client -> gateway -> api
+  }
+}
+`
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if !diagram.FolderOnly {
+		t.Error("Expected root board with only layers to be folder-only")
+	}
+	if len(diagram.Boards) != 2 {
+		t.Fatalf("Expected 2 boards, got %d", len(diagram.Boards))
+	}
+
+	current, future := diagram.Boards[0], diagram.Boards[1]
+	if current.ID != "current" || future.ID != "future" {
+		t.Errorf("Expected boards 'current' and 'future', got '%s' and '%s'", current.ID, future.ID)
+	}
+	if len(current.Nodes) != 2 || len(current.Edges) != 1 {
+		t.Errorf("Expected 2 nodes and 1 edge in 'current', got %d and %d", len(current.Nodes), len(current.Edges))
+	}
+	if len(future.Nodes) != 3 || len(future.Edges) != 2 {
+		t.Errorf("Expected 3 nodes and 2 edges in 'future', got %d and %d", len(future.Nodes), len(future.Edges))
+	}
+}
+
+func TestParse_Title(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		title  string
+	}{
+		{"root label", "label: System Overview\na -> b", "System Overview"},
+		{"vars map", "vars: {\n  title: From Vars\n}\na -> b", "From Vars"},
+		{"label wins over vars", "vars: {title: Var}\nlabel: Label\na", "Label"},
+		{"label from var", "vars: {name: Shop}\nlabel: ${name} Architecture\na", "Shop Architecture"},
+		{"no title", "a -> b", ""},
+	}
+
+	p := NewD2Parser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagram, err := p.Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			title, ok := diagram.Metadata["title"]
+			if title != tt.title || ok != (tt.title != "") {
+				t.Errorf("Expected title %q, got %q (set: %v)", tt.title, title, ok)
+			}
+		})
+	}
+}
+
+func TestParse_KeptComments(t *testing.T) {
+	source := `# Order service
+# Owned by the payments team
+
+api: API # public entry point
+aws: {
+  # not a trailing comment
+  db: Database { # primary store
+    shape: cylinder
+  }
+}
+api -> aws.db # edges keep no comments
+`
+	p := NewD2Parser()
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if got, want := diagram.Metadata["comment"], "Order service\nOwned by the payments team"; got != want {
+		t.Errorf("Expected file comment %q, got %q", want, got)
+	}
+
+	comments := map[string]interface{}{
+		"api":    "public entry point",
+		"aws":    nil,
+		"aws.db": "primary store",
+	}
+	for id, want := range comments {
+		node := diagram.GetNode(id)
+		if node == nil {
+			t.Fatalf("Node %s not found", id)
+		}
+		if got := node.Properties["comment"]; got != want {
+			t.Errorf("Node %s: expected comment %v, got %v", id, want, got)
+		}
+	}
+}
+
 // Benchmark tests
 func BenchmarkParse_Simple(b *testing.B) {
 	p := NewD2Parser()
@@ -537,9 +863,8 @@ cache -> server
 	}
 }
 
-func BenchmarkParse_Complex(b *testing.B) {
-	p := NewD2Parser()
-	source := `
+// complexBenchSource is the nested fixture shared by the complex benchmarks.
+const complexBenchSource = `
 aws: AWS Cloud {
   vpc: VPC {
     public: Public Subnet {
@@ -564,11 +889,365 @@ web2 -> app2
 app1 -> db
 app2 -> db
 `
+
+func BenchmarkParse_Complex(b *testing.B) {
+	p := NewD2Parser()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := p.Parse(source)
+		_, err := p.Parse(complexBenchSource)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkValidate_Full measures parsing plus structural validation,
+// as done by "diagtool validate".
+func BenchmarkValidate_Full(b *testing.B) {
+	p := NewD2Parser()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diagram, err := p.ParseFile(complexBenchSource, "complex.d2")
 		if err != nil {
 			b.Fatal(err)
 		}
+		diagram.Validate()
+	}
+}
+
+// BenchmarkValidate_SyntaxOnly measures "diagtool validate --syntax-only".
+func BenchmarkValidate_SyntaxOnly(b *testing.B) {
+	p := NewD2Parser()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.CheckSyntax(complexBenchSource, "complex.d2"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	p, err := For("D2")
+	if err != nil {
+		t.Fatalf("For(d2) failed: %v", err)
+	}
+	if _, ok := p.(*D2Parser); !ok {
+		t.Errorf("Expected a D2 parser, got %T", p)
+	}
+
+	if p, err := For("mermaid"); err != nil {
+		t.Errorf("For(mermaid) failed: %v", err)
+	} else if _, ok := p.(*MermaidParser); !ok {
+		t.Errorf("Expected a Mermaid parser, got %T", p)
+	}
+	if _, err := For(FormatPlantUML); err == nil || !strings.Contains(err.Error(), "plantuml input is not supported") {
+		t.Errorf("Expected plantuml to be recognized without a parser, got %v", err)
+	}
+	if _, err := For("yaml"); err == nil || !strings.Contains(err.Error(), "unsupported input format") {
+		t.Errorf("Expected an unsupported format error, got %v", err)
+	}
+
+	tests := map[string]string{
+		"diagram.d2":       FormatD2,
+		"sequence.puml":    FormatPlantUML,
+		"Sequence.PU":      FormatPlantUML,
+		"flow.mmd":         FormatMermaid,
+		"flow.mermaid":     FormatMermaid,
+		"notes.txt":        FormatD2,
+		"dir.puml/diagram": FormatD2,
+	}
+	for path, want := range tests {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseFile_Includes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "shared.d2"), []byte("db"), 0644); err != nil {
+		t.Fatalf("Failed to write include: %v", err)
+	}
+	p, err := For(FormatD2)
+	if err != nil {
+		t.Fatalf("For failed: %v", err)
+	}
+	diagram, err := ParseFile(p, "# @include shared.d2\napi -> db", filepath.Join(dir, "main.d2"))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if len(diagram.Nodes) != 2 {
+		t.Errorf("Expected 2 nodes, got %d", len(diagram.Nodes))
+	}
+}
+
+func TestParse_RoutingMode(t *testing.T) {
+	source := `a -> b: calls {routing: orthogonal}
+b -> c
+(b -> c)[0].routing: orthogonal
+c -> d: {
+  routing: direct
+  style.stroke: red
+}
+box: {x -> y: {routing: orthogonal}}
+d -> a`
+	diagram, err := NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(diagram.Nodes) != 7 {
+		t.Fatalf("Expected routing fields not to become nodes, got %d nodes", len(diagram.Nodes))
+	}
+
+	want := map[string]string{
+		"a->b":         ir.RoutingOrthogonal,
+		"b->c":         ir.RoutingOrthogonal,
+		"c->d":         ir.RoutingDirect,
+		"box.x->box.y": ir.RoutingOrthogonal,
+		"d->a":         "",
+	}
+	for _, edge := range diagram.Edges {
+		key := edge.Source + "->" + edge.Target
+		if edge.RoutingMode != want[key] {
+			t.Errorf("Edge %s: expected routing mode %q, got %q", key, want[key], edge.RoutingMode)
+		}
+	}
+	if edge := diagram.Edges[0]; edge.Label != "calls" {
+		t.Errorf("Expected the label to be kept, got %q", edge.Label)
+	}
+	if err := NewD2Parser().CheckSyntax(source, ""); err != nil {
+		t.Errorf("Routing fields should pass the syntax check: %v", err)
+	}
+}
+
+func TestParse_Tags(t *testing.T) {
+	source := `api: API {tags: [backend, public]}
+db.tags: [backend]
+db.tags: [storage; backend]
+web: {
+  tags: [frontend]
+  shape: circle
+}
+api -> db
+layers: {
+  v2: {cache: {tags: [backend]}}
+}`
+	diagram, err := NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(diagram.Nodes) != 3 {
+		t.Fatalf("Expected tags not to become nodes, got %d nodes", len(diagram.Nodes))
+	}
+	for id, want := range map[string][]string{
+		"api": {"backend", "public"},
+		"db":  {"backend", "storage"},
+		"web": {"frontend"},
+	} {
+		node := diagram.GetNode(id)
+		if node == nil {
+			t.Fatalf("Missing node %s", id)
+		}
+		if !slices.Equal(node.Tags, want) {
+			t.Errorf("Node %s: expected tags %v, got %v", id, want, node.Tags)
+		}
+		if node.Shape == ir.ShapeContainer {
+			t.Errorf("Node %s should not become a container", id)
+		}
+	}
+	if label := diagram.GetNode("api").Label; label != "API" {
+		t.Errorf("Expected label API, got %q", label)
+	}
+	if cache := diagram.Boards[0].GetNode("cache"); cache == nil || !slices.Equal(cache.Tags, []string{"backend"}) {
+		t.Errorf("Expected tags on the layer's node, got %+v", cache)
+	}
+
+	// Tags are blanked out, so lines stay where they were
+	normalized := Normalize(source)
+	if strings.Contains(normalized, "tags") || strings.Count(normalized, "\n") != strings.Count(source, "\n") {
+		t.Errorf("Expected tags removed in place, got:\n%s", normalized)
+	}
+}
+
+func TestMermaidParser_Flowchart(t *testing.T) {
+	source := `---
+title: Checkout "flow"
+---
+%% Orders from cart to shipping
+flowchart LR
+    start([Start]) --> cart[Cart #quot;v2#quot;]
+    cart -->|checkout| pay{Paid?}
+    pay -- yes --> db[(Orders DB)]
+    pay -. no .-> cart
+    pay == retry ==> pay
+    hub --> api
+    subgraph backend [Back end]
+      direction TB
+      api & worker --- hub((Hub))
+      subgraph queue
+        in --o out
+      end
+    end
+    subgraph "Web apps"
+      web[/"Web; app"/] <--> gw{{Gateway}};
+    end
+    classDef hot fill:#f00
+    web:::hot --> backend`
+	diagram, err := NewMermaidParser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if got := diagram.Metadata["title"]; got != `Checkout "flow"` {
+		t.Errorf("Expected the front matter title, got %q", got)
+	}
+	if diagram.Config.Direction != "right" {
+		t.Errorf("Expected direction right, got %q", diagram.Config.Direction)
+	}
+
+	nodes := []struct {
+		id, label, container string
+		shape                ir.ShapeType
+	}{
+		{"start", "Start", "", ir.ShapeOval},
+		{"cart", `Cart "v2"`, "", ir.ShapeRectangle},
+		{"pay", "Paid?", "", ir.ShapeDiamond},
+		{"db", "Orders DB", "", ir.ShapeCylinder},
+		{"backend", "Back end", "", ir.ShapeContainer},
+		// Used before the subgraph, but mentioned in it
+		{"backend.hub", "Hub", "backend", ir.ShapeCircle},
+		{"backend.api", "api", "backend", ir.ShapeRectangle},
+		{"backend.worker", "worker", "backend", ir.ShapeRectangle},
+		{"backend.queue", "queue", "backend", ir.ShapeContainer},
+		{"backend.queue.in", "in", "backend.queue", ir.ShapeRectangle},
+		{"Web_apps", "Web apps", "", ir.ShapeContainer},
+		{"Web_apps.web", "Web; app", "Web_apps", ir.ShapeParallelogram},
+		{"Web_apps.gw", "Gateway", "Web_apps", ir.ShapeHexagon},
+	}
+	for _, want := range nodes {
+		node := diagram.GetNode(want.id)
+		if node == nil {
+			t.Errorf("Missing node %s", want.id)
+			continue
+		}
+		if node.Label != want.label || node.Container != want.container || node.Shape != want.shape {
+			t.Errorf("Node %s: expected label %q, container %q, shape %s, got %q, %q, %s",
+				want.id, want.label, want.container, want.shape, node.Label, node.Container, node.Shape)
+		}
+	}
+	if len(diagram.Nodes) != 14 {
+		t.Errorf("Expected 14 nodes, got %d", len(diagram.Nodes))
+	}
+	if got := diagram.GetNode("backend").Direction; got != "down" {
+		t.Errorf("Expected the subgraph's direction down, got %q", got)
+	}
+
+	edges := []struct {
+		source, target, label string
+		direction             ir.Direction
+	}{
+		{"start", "cart", "", ir.DirectionForward},
+		{"cart", "pay", "checkout", ir.DirectionForward},
+		{"pay", "db", "yes", ir.DirectionForward},
+		{"pay", "cart", "no", ir.DirectionForward},
+		{"pay", "pay", "retry", ir.DirectionForward},
+		{"backend.hub", "backend.api", "", ir.DirectionForward},
+		{"backend.api", "backend.hub", "", ir.DirectionNone},
+		{"backend.worker", "backend.hub", "", ir.DirectionNone},
+		{"backend.queue.in", "backend.queue.out", "", ir.DirectionForward},
+		{"Web_apps.web", "Web_apps.gw", "", ir.DirectionBoth},
+		{"Web_apps.web", "backend", "", ir.DirectionForward},
+	}
+	if len(diagram.Edges) != len(edges) {
+		t.Fatalf("Expected %d edges, got %d", len(edges), len(diagram.Edges))
+	}
+	for i, want := range edges {
+		edge := diagram.Edges[i]
+		if edge.Source != want.source || edge.Target != want.target || edge.Label != want.label || edge.Direction != want.direction {
+			t.Errorf("Edge %d: expected %s -> %s %q (%s), got %s -> %s %q (%s)", i,
+				want.source, want.target, want.label, want.direction, edge.Source, edge.Target, edge.Label, edge.Direction)
+		}
+	}
+	if style := diagram.Edges[3].Style; style.StrokeDash == 0 {
+		t.Error("Expected the dotted link to be dashed")
+	}
+	if style := diagram.Edges[4].Style; style.StrokeWidth == 0 {
+		t.Error("Expected the thick link to be wider")
+	}
+	if head := diagram.Edges[8].TargetArrowhead; head != "circle" {
+		t.Errorf("Expected a circle arrowhead, got %q", head)
+	}
+	if err := diagram.Validate(); len(err) > 0 {
+		t.Errorf("Expected a valid diagram, got %v", err)
+	}
+}
+
+func TestMermaidParser_Errors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"sequenceDiagram\n  A->>B: hi", "line 1: only flowcharts are supported"},
+		{"graph XY\n  a --> b", "unknown direction"},
+		{"graph TD\n  a --> b[Label", `line 2: node b: missing "]"`},
+		{"graph TD\n  a -->", "expected a node ID"},
+		{"graph TD\n  subgraph one\n  a", "subgraph one is missing its end"},
+		{"graph TD\n  end", "end without a subgraph"},
+		{"%% only a comment", "no flowchart found"},
+	}
+	for _, tt := range tests {
+		if _, err := NewMermaidParser().Parse(tt.source); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q): expected an error containing %q, got %v", tt.source, tt.want, err)
+		}
+	}
+}
+
+func TestParse_TagsObject(t *testing.T) {
+	// A scalar "tags" field is a child object, such as a table of tags
+	source := `blog: {
+  posts
+  tags: Tag table
+}
+blog.posts -> blog.tags`
+	diagram, err := NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tags := diagram.GetNode("blog.tags")
+	if tags == nil {
+		t.Fatalf("Expected blog.tags to be a node, got %d nodes", len(diagram.Nodes))
+	}
+	if tags.Label != "Tag table" {
+		t.Errorf("Expected label %q, got %q", "Tag table", tags.Label)
+	}
+	if blog := diagram.GetNode("blog"); blog == nil || len(blog.Tags) != 0 {
+		t.Errorf("Expected blog to have no tags, got %+v", blog)
+	}
+	if len(diagram.Edges) != 1 || diagram.Edges[0].Target != "blog.tags" {
+		t.Errorf("Expected an edge to blog.tags, got %+v", diagram.Edges)
+	}
+	if normalized := Normalize(source); normalized != source {
+		t.Errorf("Expected the tags object to be kept, got:\n%s", normalized)
+	}
+}
+
+func TestParse_Direction(t *testing.T) {
+	p := NewD2Parser()
+	diagram, err := p.Parse("direction: right\na -> b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if diagram.Config.Direction != "right" {
+		t.Errorf("Expected direction right, got %q", diagram.Config.Direction)
+	}
+
+	diagram, err = p.Parse("a -> b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if diagram.Config.Direction != "" {
+		t.Errorf("Expected no direction when none is set, got %q", diagram.Config.Direction)
 	}
 }
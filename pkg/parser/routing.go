@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2parser"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// stripRouting removes "routing" fields of edges from D2 source, which the
+// D2 compiler doesn't know, and returns the routing modes by the byte offset
+// of the edge they are declared on:
+//
+//	a -> b: {routing: orthogonal}
+//	(a -> b)[0].routing: orthogonal
+//
+// Modes are also read from "# diagtool: routing" comments in an edge's
+// block, which is how GenerateD2 in package render writes them so its
+// output stays plain D2:
+//
+//	a -> b: {
+//	  # diagtool: routing orthogonal
+//	}
+//
+// As with tags, fields are blanked out so offsets, line and column numbers
+// are unchanged, and source that fails to parse is returned unchanged.
+func stripRouting(source string) (string, map[int]string) {
+	if !strings.Contains(source, "routing") {
+		return source, nil
+	}
+	ast, err := d2parser.Parse("", strings.NewReader(source), nil)
+	if err != nil {
+		return source, nil
+	}
+
+	routes := make(map[int]string)
+	var blank []d2ast.Range
+	add := func(key *d2ast.Key, value d2ast.ValueBox) bool {
+		scalar := value.ScalarBox().Unbox()
+		if scalar == nil {
+			return false
+		}
+		for _, edge := range key.Edges {
+			routes[edge.Range.Start.Byte] = strings.ToLower(scalar.ScalarString())
+		}
+		return true
+	}
+	var walk func(m *d2ast.Map)
+	walk = func(m *d2ast.Map) {
+		for _, n := range m.Nodes {
+			key := n.MapKey
+			if key == nil {
+				continue
+			}
+			if len(key.Edges) == 0 {
+				if key.Value.Map != nil {
+					walk(key.Value.Map)
+				}
+				continue
+			}
+
+			// "(a -> b)[0].routing: x" keeps the edge reference
+			if key.EdgeKey != nil && isRoutingKey(key.EdgeKey) {
+				if add(key, key.Value) {
+					r := key.Range
+					r.Start = key.EdgeKey.Range.Start
+					r.Start.Byte-- // the dot before the field
+					blank = append(blank, r)
+				}
+				continue
+			}
+			if key.EdgeKey != nil || key.Value.Map == nil {
+				continue
+			}
+			for _, field := range key.Value.Map.Nodes {
+				if field.Comment != nil {
+					if mode, ok := routingComment(field.Comment.Value); ok {
+						for _, edge := range key.Edges {
+							routes[edge.Range.Start.Byte] = mode
+						}
+					}
+					continue
+				}
+				if field.MapKey != nil && len(field.MapKey.Edges) == 0 && isRoutingKey(field.MapKey.Key) && add(key, field.MapKey.Value) {
+					blank = append(blank, field.MapKey.Range)
+				}
+			}
+		}
+	}
+	walk(ast)
+
+	b := []byte(source)
+	for _, r := range blank {
+		if r.Start.Byte < 0 || r.End.Byte > len(b) {
+			continue
+		}
+		for i := r.Start.Byte; i < r.End.Byte; i++ {
+			if b[i] != '\n' {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b), routes
+}
+
+// isRoutingKey reports whether a key path is the single field "routing".
+func isRoutingKey(path *d2ast.KeyPath) bool {
+	if path == nil {
+		return false
+	}
+	ida := path.StringIDA()
+	return len(ida) == 1 && ida[0] == "routing"
+}
+
+// routingComment returns the routing mode a "diagtool: routing" comment
+// declares.
+func routingComment(comment string) (string, bool) {
+	mode, ok := strings.CutPrefix(strings.TrimSpace(comment), "diagtool: routing ")
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	return mode, ok && mode != ""
+}
+
+// applyRouting sets the routing modes of the diagram's edges, and those of
+// its boards, from routes keyed by the byte offset of the edges' references
+// in the compiled graph. The diagram must have been converted from g.
+func applyRouting(diagram *ir.Diagram, g *d2graph.Graph, routes map[int]string) {
+	if len(routes) == 0 {
+		return
+	}
+	for i, edge := range g.Edges {
+		if i >= len(diagram.Edges) {
+			break
+		}
+		for _, ref := range edge.References {
+			if ref.Edge == nil {
+				continue
+			}
+			if mode, ok := routes[ref.Edge.Range.Start.Byte]; ok {
+				diagram.Edges[i].RoutingMode = mode
+			}
+		}
+	}
+
+	// Boards are converted in this order by convertGraph
+	var boards []*d2graph.Graph
+	for _, kind := range [][]*d2graph.Graph{g.Layers, g.Scenarios, g.Steps} {
+		boards = append(boards, kind...)
+	}
+	for i, board := range boards {
+		if i < len(diagram.Boards) {
+			applyRouting(diagram.Boards[i], board, routes)
+		}
+	}
+}
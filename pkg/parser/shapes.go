@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2parser"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// ShapeAliases maps shape names common in other diagram tools to the D2
+// shapes they stand for.
+var ShapeAliases = map[string]string{
+	"db":         "cylinder",
+	"actor":      "person",
+	"decision":   "diamond",
+	"process":    "rectangle",
+	"terminator": "oval",
+}
+
+// NormalizeShapes rewrites "shape" values in D2 source that are keys of
+// ShapeAliases to the D2 shapes they stand for, which the D2 compiler would
+// otherwise reject. Only the alias values change, so line numbers in compile
+// errors still match the original source. Source that fails to parse is
+// returned unchanged, for the compiler to report.
+func NormalizeShapes(source string) string {
+	if !strings.Contains(source, "shape") {
+		return source
+	}
+	return replaceValues(source, normalizeShape)
+}
+
+// normalizeShape returns the D2 shape for a shape alias set at path.
+func normalizeShape(path []string, value string) (string, bool) {
+	if !isShapeKey(path) {
+		return "", false
+	}
+	shape, ok := ShapeAliases[strings.ToLower(value)]
+	return shape, ok
+}
+
+// normalizeStrokeDash returns the dash length for a name in
+// ir.StrokeDashPresets set as a stroke-dash at path, such as "dotted".
+func normalizeStrokeDash(path []string, value string) (string, bool) {
+	if len(path) == 0 || !strings.EqualFold(path[len(path)-1], "stroke-dash") {
+		return "", false
+	}
+	dash, ok := ir.StrokeDashPresets[strings.ToLower(value)]
+	return strconv.Itoa(dash), ok
+}
+
+// normalizeValues rewrites shape aliases and named stroke dashes in D2
+// source to values the D2 compiler accepts.
+func normalizeValues(source string) string {
+	if !strings.Contains(source, "shape") && !strings.Contains(source, "stroke-dash") {
+		return source
+	}
+	return replaceValues(source, func(path []string, value string) (string, bool) {
+		if shape, ok := normalizeShape(path, value); ok {
+			return shape, true
+		}
+		return normalizeStrokeDash(path, value)
+	})
+}
+
+// replaceValues replaces the unquoted values of fields in D2 source for
+// which replace returns true, given the field's key path. Source that fails
+// to parse is returned unchanged.
+func replaceValues(source string, replace func(path []string, value string) (string, bool)) string {
+	ast, err := d2parser.Parse("", strings.NewReader(source), nil)
+	if err != nil {
+		return source
+	}
+
+	type replacement struct {
+		start, end int
+		value      string
+	}
+	var replacements []replacement
+	var walk func(m *d2ast.Map, prefix []string)
+	walk = func(m *d2ast.Map, prefix []string) {
+		for _, n := range m.Nodes {
+			key := n.MapKey
+			if key == nil {
+				continue
+			}
+			// Fields of an edge, as in "(a -> b)[0].style.stroke-dash", are
+			// relative to the edge
+			var path []string
+			switch {
+			case len(key.Edges) > 0 && key.EdgeKey != nil:
+				path = key.EdgeKey.StringIDA()
+			case len(key.Edges) > 0:
+			case key.Key != nil:
+				path = append(slices.Clone(prefix), key.Key.StringIDA()...)
+			}
+			if s, ok := key.Value.ScalarBox().Unbox().(*d2ast.UnquotedString); ok && len(path) > 0 {
+				r := s.GetRange()
+				if value, ok := replace(path, s.ScalarString()); ok && r.Start.Byte >= 0 {
+					replacements = append(replacements, replacement{r.Start.Byte, r.End.Byte, value})
+				}
+			}
+			if key.Value.Map != nil {
+				walk(key.Value.Map, path)
+			}
+		}
+	}
+	walk(ast, nil)
+
+	// Replace from the end so earlier offsets stay valid
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].start > replacements[j].start })
+	for _, r := range replacements {
+		source = source[:r.start] + r.value + source[r.end:]
+	}
+	return source
+}
+
+// isShapeKey reports whether a key path sets an object's shape, rather than
+// an arrowhead's.
+func isShapeKey(path []string) bool {
+	if len(path) == 0 || !strings.EqualFold(path[len(path)-1], "shape") {
+		return false
+	}
+	for _, p := range path {
+		if strings.HasSuffix(strings.ToLower(p), "arrowhead") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2parser"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// Normalize rewrites D2 source into what the D2 compiler accepts: shape
+// aliases become D2 shapes (see NormalizeShapes), named stroke dashes such
+// as "dotted" become dash lengths (see ir.StrokeDashPresets), and "tags"
+// and edge "routing" fields, which only the parser reads, are removed. Line
+// numbers in compile errors still match the original source.
+func Normalize(source string) string {
+	source, _ = stripTags(normalizeValues(source))
+	source, _ = stripRouting(source)
+	return source
+}
+
+// stripTags removes "tags" fields from D2 source, which the D2 compiler
+// would take for child objects, and returns the tags by node ID. A tags
+// field holds a list of tags, separated by commas or, as in other D2 lists,
+// semicolons:
+//
+//	api: {tags: [backend, public]}
+//	db.tags: [backend]
+//
+// Only lists are tags: a "tags" field with a scalar value or a map, as in
+// "blog: {tags: Tag table}", is an ordinary child object and is left alone.
+//
+// Fields are blanked out rather than deleted so line and column numbers are
+// unchanged. Tags in layers, scenarios, and steps are keyed by their board
+// path, such as "layers.v2.api". Source that fails to parse is returned
+// unchanged, for the compiler to report.
+func stripTags(source string) (string, map[string][]string) {
+	if !strings.Contains(source, "tags") {
+		return source, nil
+	}
+	ast, err := d2parser.Parse("", strings.NewReader(source), nil)
+	if err != nil {
+		return source, nil
+	}
+
+	tags := make(map[string][]string)
+	var blank []d2ast.Range
+	var walk func(m *d2ast.Map, prefix []string)
+	walk = func(m *d2ast.Map, prefix []string) {
+		for _, n := range m.Nodes {
+			key := n.MapKey
+			if key == nil || key.Key == nil || len(key.Edges) > 0 {
+				continue
+			}
+			path := key.Key.StringIDA()
+			if len(prefix) == 0 && (path[0] == "vars" || path[0] == "classes") {
+				continue
+			}
+			full := append(slices.Clone(prefix), path...)
+
+			if len(full) >= 2 && path[len(path)-1] == "tags" && key.Value.Array != nil {
+				values := tagValues(key.Value.Array)
+				if values == nil {
+					continue
+				}
+				owner := strings.Join(full[:len(full)-1], ".")
+				tags[owner] = append(tags[owner], values...)
+
+				// Keep the owner of "a.tags: x", which may declare it
+				r := key.Range
+				if len(path) > 1 {
+					r.Start = key.Key.Path[len(path)-2].Unbox().GetRange().End
+				}
+				blank = append(blank, r)
+				continue
+			}
+			if key.Value.Map != nil {
+				walk(key.Value.Map, full)
+			}
+		}
+	}
+	walk(ast, nil)
+
+	b := []byte(source)
+	for _, r := range blank {
+		if r.Start.Byte < 0 || r.End.Byte > len(b) {
+			continue
+		}
+		for i := r.Start.Byte; i < r.End.Byte; i++ {
+			if b[i] != '\n' {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b), tags
+}
+
+// tagValues returns the tags in a tags field's list, or nil if it holds
+// none.
+func tagValues(array *d2ast.Array) []string {
+	var values []string
+	for _, item := range array.Nodes {
+		scalar, ok := item.Unbox().(d2ast.Scalar)
+		if !ok {
+			continue
+		}
+		for _, tag := range strings.Split(scalar.ScalarString(), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				values = append(values, tag)
+			}
+		}
+	}
+	return values
+}
+
+// applyTags sets the tags of the diagram's nodes and those of its boards
+// from tags keyed by node ID, with prefix naming the board.
+func applyTags(diagram *ir.Diagram, tags map[string][]string, prefix string) {
+	if len(tags) == 0 {
+		return
+	}
+	for _, node := range diagram.Nodes {
+		for _, tag := range tags[prefix+node.ID] {
+			if !slices.Contains(node.Tags, tag) {
+				node.Tags = append(node.Tags, tag)
+			}
+		}
+	}
+	for _, board := range diagram.Boards {
+		for _, kind := range []string{"layers", "scenarios", "steps"} {
+			applyTags(board, tags, prefix+kind+"."+board.ID+".")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Package render provides diagram rendering to various formats.
+// This file adds screen reader support to rendered SVGs.
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/svg"
+)
+
+// defaultAccessibleTitle names diagrams that have no title of their own.
+const defaultAccessibleTitle = "Diagram"
+
+// makeAccessible adds ARIA attributes, a <title> and a <desc> to the root of
+// an SVG rendered from board, and a <title> with its label to each shape
+// that has no tooltip (D2 already renders tooltips as titles).
+func makeAccessible(svgBytes []byte, board *d2target.Diagram) []byte {
+	title := board.Root.Label
+	if title == "" {
+		title = defaultAccessibleTitle
+	}
+
+	for _, shape := range board.Shapes {
+		if shape.Label != "" && shape.Tooltip == "" {
+			svgBytes = addShapeTitle(svgBytes, shape.ID, shape.Label)
+		}
+	}
+
+	desc := plural(len(board.Shapes), "shape") + " and " + plural(len(board.Connections), "connection")
+	svgBytes = addSVGAttributes(svgBytes, `role="img" aria-label="`+html.EscapeString(title)+`"`)
+	return insertAfterSVGOpenTag(svgBytes,
+		"<title>"+html.EscapeString(title)+"</title><desc>"+html.EscapeString(desc)+"</desc>")
+}
+
+// addShapeTitle inserts a <title> as the first child of the group D2 renders
+// for the shape with the given ID. The SVG is returned unchanged if the
+// group is not found.
+func addShapeTitle(svgBytes []byte, id, label string) []byte {
+	// D2 identifies each shape's group by its base64-encoded ID as first class
+	open := []byte(`<g class="` + base64.URLEncoding.EncodeToString([]byte(svg.EscapeText(id))))
+	for offset := 0; ; {
+		i := bytes.Index(svgBytes[offset:], open)
+		if i < 0 {
+			return svgBytes
+		}
+		start := offset + i
+		offset = start + len(open)
+		if offset >= len(svgBytes) || (svgBytes[offset] != '"' && svgBytes[offset] != ' ') {
+			continue // Another shape whose ID encodes to a longer class
+		}
+		end := bytes.IndexByte(svgBytes[offset:], '>')
+		if end < 0 {
+			return svgBytes
+		}
+		return insertAt(svgBytes, offset+end+1, "<title>"+html.EscapeString(label)+"</title>")
+	}
+}
+
+// addSVGAttributes adds attrs to the opening tag of the outermost <svg>
+// element. The SVG is returned unchanged if no <svg> tag is found.
+func addSVGAttributes(svgBytes []byte, attrs string) []byte {
+	start := bytes.Index(svgBytes, []byte("<svg"))
+	if start < 0 {
+		return svgBytes
+	}
+	return insertAt(svgBytes, start+len("<svg"), " "+attrs)
+}
+
+// plural formats a count of things, e.g. "1 shape" or "2 shapes".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
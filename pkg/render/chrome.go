@@ -0,0 +1,66 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Headless Chrome sometimes fails to start on loaded machines, so starting it
+// is retried, waiting chromeStartBackoff and then twice as long each time.
+var (
+	chromeStartAttempts = 3
+	chromeStartBackoff  = 500 * time.Millisecond
+)
+
+// launchChrome starts a headless Chrome with the given allocator options and
+// returns a browser context and the function that shuts it down. It is a
+// variable so tests can simulate browsers that fail to start.
+var launchChrome = func(ctx context.Context, opts []chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc, error) {
+	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, opts...)
+	chromeCtx, chromeCancel := chromedp.NewContext(allocCtx)
+	cancel := func() {
+		chromeCancel()
+		allocCancel()
+	}
+
+	// Running no actions starts the browser
+	if err := chromedp.Run(chromeCtx); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return chromeCtx, cancel, nil
+}
+
+// startChrome starts a headless Chrome like launchChrome, retrying with
+// backoff if it fails. A missing Chrome executable is not retried.
+func startChrome(ctx context.Context, opts ...chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc, error) {
+	backoff := chromeStartBackoff
+	var err error
+	for attempt := 1; attempt <= chromeStartAttempts; attempt++ {
+		var chromeCtx context.Context
+		var cancel context.CancelFunc
+		chromeCtx, cancel, err = launchChrome(ctx, opts)
+		if err == nil {
+			return chromeCtx, cancel, nil
+		}
+		if errors.Is(err, exec.ErrNotFound) || attempt == chromeStartAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("failed to start Chrome: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil, err
+	}
+	return nil, nil, fmt.Errorf("failed to start Chrome after %d attempts: %w", chromeStartAttempts, err)
+}
@@ -0,0 +1,169 @@
+package render
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// Space left around the children of a container without a size of its
+// own; the top leaves room for the container's label.
+const (
+	fixedContainerPadding    = 30
+	fixedContainerPaddingTop = 60
+)
+
+// unpositionedNodes returns the IDs of the nodes without a position.
+func unpositionedNodes(diagram *ir.Diagram) []string {
+	var ids []string
+	for _, node := range diagram.Nodes {
+		if node.Position == nil {
+			ids = append(ids, node.ID)
+		}
+	}
+	return ids
+}
+
+// fixedLayout returns a layout engine that places objects at the positions
+// of the matching nodes in diagram instead of computing a layout. Nodes keep
+// their size when they have one. Containers without a size wrap their
+// children, ignoring their own position, and other shapes keep the size D2
+// measured. Edges follow their points, or run
+// straight between the centers of their ends, clipped to the shapes.
+func fixedLayout(diagram *ir.Diagram) d2graph.LayoutGraph {
+	return func(ctx context.Context, g *d2graph.Graph) error {
+		// Children first, so containers can wrap them
+		objects := append([]*d2graph.Object(nil), g.Objects...)
+		sort.SliceStable(objects, func(i, j int) bool { return objects[i].Level() > objects[j].Level() })
+		for _, obj := range objects {
+			placeObject(obj, diagram.GetNode(obj.AbsID()))
+		}
+		for _, obj := range g.Objects {
+			positionLabel(obj)
+		}
+
+		// Parallel edges between the same ends match up in order
+		seen := make(map[string]int)
+		for _, edge := range g.Edges {
+			src, dst := edge.Src.AbsID(), edge.Dst.AbsID()
+			key := src + "\x00" + dst
+			irEdge, reversed := findFixedEdge(diagram, src, dst, seen[key])
+			seen[key]++
+			routeEdge(edge, irEdge, reversed)
+		}
+		return nil
+	}
+}
+
+// placeObject sets the box of obj from node, which may be nil for objects
+// the diagram has no node for. Children must be placed first.
+func placeObject(obj *d2graph.Object, node *ir.Node) {
+	if node != nil && node.Width > 0 && node.Height > 0 {
+		obj.Width, obj.Height = node.Width, node.Height
+	} else if len(obj.ChildrenArray) > 0 {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, child := range obj.ChildrenArray {
+			if child.TopLeft == nil {
+				continue
+			}
+			minX, minY = math.Min(minX, child.TopLeft.X), math.Min(minY, child.TopLeft.Y)
+			maxX, maxY = math.Max(maxX, child.TopLeft.X+child.Width), math.Max(maxY, child.TopLeft.Y+child.Height)
+		}
+		if !math.IsInf(minX, 1) {
+			obj.TopLeft = geo.NewPoint(minX-fixedContainerPadding, minY-fixedContainerPaddingTop)
+			obj.Width = maxX - minX + 2*fixedContainerPadding
+			obj.Height = maxY - minY + fixedContainerPadding + fixedContainerPaddingTop
+			return
+		}
+	}
+
+	switch {
+	case node != nil && node.Position != nil:
+		obj.TopLeft = geo.NewPoint(node.Position.X, node.Position.Y)
+	case obj.TopLeft == nil && obj.Parent != nil && obj.Parent.TopLeft != nil:
+		obj.TopLeft = geo.NewPoint(obj.Parent.TopLeft.X, obj.Parent.TopLeft.Y)
+	case obj.TopLeft == nil:
+		obj.TopLeft = geo.NewPoint(0, 0)
+	}
+}
+
+// positionLabel places an object's label and icon like the dagre layout:
+// above containers and centered inside other shapes.
+func positionLabel(obj *d2graph.Object) {
+	container := len(obj.ChildrenArray) > 0
+	if obj.Icon != nil && obj.IconPosition == nil {
+		if container {
+			obj.IconPosition = labelAt(label.OutsideTopLeft)
+		} else {
+			obj.IconPosition = labelAt(label.InsideMiddleCenter)
+		}
+	}
+	if !obj.HasLabel() || obj.LabelPosition != nil {
+		return
+	}
+	switch {
+	case container:
+		obj.LabelPosition = labelAt(label.OutsideTopCenter)
+	case obj.HasOutsideBottomLabel(), float64(obj.LabelDimensions.Width) > obj.Width, float64(obj.LabelDimensions.Height) > obj.Height:
+		obj.LabelPosition = labelAt(label.OutsideBottomCenter)
+	case obj.Icon != nil:
+		obj.LabelPosition = labelAt(label.InsideTopCenter)
+	default:
+		obj.LabelPosition = labelAt(label.InsideMiddleCenter)
+	}
+}
+
+// findFixedEdge returns the index-th edge of diagram between the objects
+// src and dst, which may include a port, in either direction, and whether
+// it runs from dst to src.
+func findFixedEdge(diagram *ir.Diagram, src, dst string, index int) (*ir.Edge, bool) {
+	for _, edge := range diagram.Edges {
+		from, to := edgeEndpoint(edge.Source, edge.SourcePort), edgeEndpoint(edge.Target, edge.TargetPort)
+		if (from == src && to == dst) || (from == dst && to == src) {
+			if index == 0 {
+				return edge, from != src
+			}
+			index--
+		}
+	}
+	return nil, false
+}
+
+// routeEdge sets the route of edge from the points of irEdge, reversed if
+// the IR edge runs the other way, or draws it straight between its ends if
+// irEdge is nil or has no points.
+func routeEdge(edge *d2graph.Edge, irEdge *ir.Edge, reversed bool) {
+	if irEdge != nil && len(irEdge.Points) >= 2 {
+		points := irEdge.Points
+		if reversed {
+			points = slices.Clone(points)
+			slices.Reverse(points)
+		}
+		edge.Route = make([]*geo.Point, len(points))
+		for i, p := range points {
+			edge.Route[i] = geo.NewPoint(p.X, p.Y)
+		}
+	} else {
+		points := []*geo.Point{edge.Src.Center(), edge.Dst.Center()}
+		start, end := edge.TraceToShape(points, 0, 1)
+		edge.Route = points[start : end+1]
+	}
+	edge.IsCurve = false
+	if edge.Label.Value != "" {
+		edge.LabelPosition = labelAt(label.InsideMiddleCenter)
+	}
+}
+
+// labelAt returns a label or icon position as D2 stores it on objects.
+func labelAt(position label.Position) *string {
+	s := position.String()
+	return &s
+}
@@ -0,0 +1,138 @@
+// Package render provides diagram rendering to various formats.
+// This file loads custom font files for text measurement and rendering,
+// and embeds whole fonts in SVGs.
+package render
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
+)
+
+var (
+	// fontFamilies caches registered custom font families by their file paths.
+	// D2 keeps fonts in a global registry, so each set of files is added only once.
+	fontFamilies   = make(map[string]*d2fonts.FontFamily)
+	fontFamiliesMu sync.Mutex
+)
+
+// hasCustomFonts returns true if any custom font path is set.
+func (o Options) hasCustomFonts() bool {
+	return o.FontRegular != "" || o.FontBold != "" || o.FontItalic != ""
+}
+
+// loadFontFamily registers the custom fonts in opts with D2 and returns the
+// resulting font family, or nil to use D2's default fonts.
+// Styles whose file can't be read or parsed fall back to the default font,
+// with a warning on stderr.
+func loadFontFamily(opts Options) *d2fonts.FontFamily {
+	if !opts.hasCustomFonts() {
+		return nil
+	}
+
+	key := strings.Join([]string{opts.FontRegular, opts.FontBold, opts.FontItalic}, "\x00")
+
+	fontFamiliesMu.Lock()
+	defer fontFamiliesMu.Unlock()
+
+	if family, ok := fontFamilies[key]; ok {
+		return family
+	}
+
+	regular := readFont(opts.FontRegular)
+	bold := readFont(opts.FontBold)
+	italic := readFont(opts.FontItalic)
+	if regular == nil && bold == nil && italic == nil {
+		return nil
+	}
+
+	name := fmt.Sprintf("custom-%d", len(fontFamilies)+1)
+	family, err := d2fonts.AddFontFamily(name, regular, italic, bold, nil)
+	if err != nil {
+		logging.Warn("failed to load custom fonts, using defaults", "err", err)
+		return nil
+	}
+
+	fontFamilies[key] = family
+	return family
+}
+
+// readFont reads a TrueType font file.
+// Returns nil, with a logged warning, if the file is missing or invalid.
+func readFont(path string) []byte {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logging.Warn("failed to read font, using default", "err", err)
+		return nil
+	}
+
+	// Validate up front: D2's text ruler fails on any unparsable registered font
+	if _, err := truetype.Parse(data); err != nil {
+		logging.Warn("invalid font, using default", "path", path, "err", err)
+		return nil
+	}
+
+	return data
+}
+
+// newRuler creates a text ruler that measures with all registered fonts.
+// D2 appends to its font family list under FontFamiliesMu when fonts are
+// registered, but NewRuler reads the list without it, so concurrent renders
+// must hold the lock here.
+func newRuler() (*textmeasure.Ruler, error) {
+	d2fonts.FontFamiliesMu.Lock()
+	defer d2fonts.FontFamiliesMu.Unlock()
+	return textmeasure.NewRuler()
+}
+
+// embeddedFontFamily is the CSS font family of the fonts added by
+// withEmbeddedFonts.
+const embeddedFontFamily = "diagtool-font"
+
+// withEmbeddedFonts adds the whole regular, bold, and italic fonts of family,
+// or of D2's default family if nil, to the SVG as @font-face rules with
+// TrueType data URIs, and draws the watermark in them. D2 embeds only the
+// glyphs the diagram's text uses, so without them text added to the SVG
+// later, such as the watermark or hand-edited labels, falls back to the
+// viewer's system fonts.
+func withEmbeddedFonts(svg []byte, family *d2fonts.FontFamily) []byte {
+	if family == nil {
+		defaultFamily := d2fonts.SourceSansPro
+		family = &defaultFamily
+	}
+
+	var b strings.Builder
+	b.WriteString(`<style type="text/css"><![CDATA[`)
+	for _, face := range []struct {
+		style      d2fonts.FontStyle
+		descriptor string
+	}{
+		{d2fonts.FONT_STYLE_REGULAR, "font-weight: normal; font-style: normal;"},
+		{d2fonts.FONT_STYLE_BOLD, "font-weight: bold; font-style: normal;"},
+		{d2fonts.FONT_STYLE_ITALIC, "font-weight: normal; font-style: italic;"},
+	} {
+		d2fonts.FontFamiliesMu.Lock()
+		ttf := d2fonts.FontFaces.Get(family.Font(0, face.style))
+		d2fonts.FontFamiliesMu.Unlock()
+		if len(ttf) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `@font-face { font-family: "%s"; %s src: url("data:font/ttf;base64,%s") format("truetype"); }`,
+			embeddedFontFamily, face.descriptor, base64.StdEncoding.EncodeToString(ttf))
+	}
+	fmt.Fprintf(&b, `.diagtool-watermark { font-family: "%s", sans-serif; }`, embeddedFontFamily)
+	b.WriteString(`]]></style>`)
+	return insertAfterSVGOpenTag(svg, b.String())
+}
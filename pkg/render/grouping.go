@@ -0,0 +1,83 @@
+package render
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// tagGroupPrefix starts the IDs of the containers generated for tags.
+const tagGroupPrefix = "tag-"
+
+// invalidIDChars matches characters not used in generated container IDs.
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// groupByTag returns a copy of the diagram in which top-level nodes with
+// tags are moved into a generated container per tag, labeled with the tag.
+// A node with several tags goes into the container of its first one. Moved
+// nodes and their children get IDs inside the container, and edges follow
+// them. The input diagram is not modified.
+func groupByTag(diagram *ir.Diagram) *ir.Diagram {
+	result := diagram.Clone()
+
+	// Containers in the order their tags first appear
+	groups := make(map[string]*ir.Node)
+	var order []*ir.Node
+	moved := make(map[string]string) // Top-level node ID → container ID
+	for _, node := range result.Nodes {
+		if node.Container != "" || node.GetParentID() != "" || len(node.Tags) == 0 {
+			continue
+		}
+		tag := node.Tags[0]
+		group, ok := groups[tag]
+		if !ok {
+			group = &ir.Node{ID: tagGroupID(result, order, tag), Label: tag, Shape: ir.ShapeContainer}
+			groups[tag] = group
+			order = append(order, group)
+		}
+		moved[node.ID] = group.ID
+	}
+	if len(order) == 0 {
+		return result
+	}
+
+	// Moved nodes' children and edges go along with them
+	rename := func(id string) string {
+		root, _, _ := strings.Cut(id, ".")
+		if group, ok := moved[root]; ok {
+			return group + "." + id
+		}
+		return id
+	}
+	for _, node := range result.Nodes {
+		if group, ok := moved[node.ID]; ok {
+			node.Container = group
+		} else {
+			node.Container = rename(node.Container)
+		}
+		node.ID = rename(node.ID)
+		node.Near = rename(node.Near)
+	}
+	for _, edge := range result.Edges {
+		edge.Source = rename(edge.Source)
+		edge.Target = rename(edge.Target)
+	}
+
+	result.Nodes = append(order, result.Nodes...)
+	return result
+}
+
+// tagGroupID returns an ID for a tag's container that neither the diagram's
+// nodes nor the containers generated so far use.
+func tagGroupID(diagram *ir.Diagram, generated []*ir.Node, tag string) string {
+	id := tagGroupPrefix + strings.Trim(invalidIDChars.ReplaceAllString(tag, "-"), "-")
+	taken := func(id string) bool {
+		return diagram.GetNode(id) != nil || slices.ContainsFunc(generated, func(n *ir.Node) bool { return n.ID == id })
+	}
+	for taken(id) {
+		id += "-"
+	}
+	return id
+}
@@ -0,0 +1,110 @@
+// Package render provides diagram rendering to various formats.
+// This file embeds icons referenced by URL in rendered SVGs.
+package render
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
+)
+
+const (
+	// iconFetchTimeout bounds the download of each icon.
+	iconFetchTimeout = 10 * time.Second
+
+	// maxIconSize is the largest icon, in bytes, that is embedded.
+	maxIconSize = 1 << 20
+)
+
+// iconClient downloads icons for InlineIcons.
+var iconClient = &http.Client{Timeout: iconFetchTimeout}
+
+// imageHrefRe matches the URL of an <image> element that D2 renders for a
+// node's icon or an image shape, when it is an http or https URL.
+var imageHrefRe = regexp.MustCompile(`(<image\b[^>]*?\bhref=")(https?://[^"]+)(")`)
+
+// iconCache holds the data URIs of the icons fetched during one render, by
+// URL. Icons that could not be fetched map to "" so they are tried once.
+type iconCache map[string]string
+
+// inlineIcons replaces http and https image URLs in svg with data URIs of
+// the images they point to, so the SVG displays without network access.
+// Icons that can't be fetched, are larger than maxIconSize, or aren't
+// images keep their URL, with a warning on stderr.
+func inlineIcons(ctx context.Context, svg []byte, cache iconCache) []byte {
+	return imageHrefRe.ReplaceAllFunc(svg, func(match []byte) []byte {
+		parts := imageHrefRe.FindSubmatch(match)
+		iconURL := html.UnescapeString(string(parts[2]))
+
+		dataURI, ok := cache[iconURL]
+		if !ok {
+			var err error
+			dataURI, err = fetchIcon(ctx, iconURL)
+			if err != nil {
+				logging.Warn("failed to inline icon, keeping its URL", "url", iconURL, "err", err)
+			}
+			cache[iconURL] = dataURI
+		}
+		if dataURI == "" {
+			return match
+		}
+		return []byte(string(parts[1]) + dataURI + string(parts[3]))
+	})
+}
+
+// fetchIcon downloads an image and returns it as a base64 data URI. The
+// media type comes from the Content-Type header, the URL's extension, or
+// the image's content, in that order.
+func fetchIcon(ctx context.Context, iconURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := iconClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIconSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxIconSize {
+		return "", fmt.Errorf("icon is larger than %d bytes", maxIconSize)
+	}
+
+	mediaType := iconMediaType(resp.Header.Get("Content-Type"), resp.Request.URL, data)
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("not an image: %s", mediaType)
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// iconMediaType returns the first image media type of the Content-Type
+// header, the URL's extension, and the sniffed content, or the sniffed type
+// if none of them is an image.
+func iconMediaType(contentType string, u *url.URL, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(u.Path))); err == nil && strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
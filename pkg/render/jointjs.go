@@ -8,9 +8,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/chromedp/chromedp"
+	"oss.terrastruct.com/d2/d2compiler"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
 )
 
 //go:embed export.html
@@ -24,6 +29,12 @@ type Metadata struct {
 	RoutingMode map[string]string     `json:"routingMode,omitempty"`
 }
 
+// Routing modes for edges, matching the values stored in .d2meta files.
+const (
+	RoutingDirect     = ir.RoutingDirect
+	RoutingOrthogonal = ir.RoutingOrthogonal
+)
+
 // NodeOffset represents a position offset for a node.
 type NodeOffset struct {
 	DX float64 `json:"dx"`
@@ -36,6 +47,68 @@ type Vertex struct {
 	Y float64 `json:"y"`
 }
 
+// HasLayout returns true if the metadata contains any layout customizations
+// that require JointJS rendering.
+func (m *Metadata) HasLayout() bool {
+	return m != nil && (len(m.Positions) > 0 || len(m.Vertices) > 0 || len(m.RoutingMode) > 0)
+}
+
+// SetAllRoutingModes sets the routing mode for every edge in the D2 source.
+// Edge IDs use D2's absolute edge ID format (e.g. "(a -> b)[0]"), matching
+// the IDs the browser editor and export template use as metadata keys.
+// Setting "direct" removes any stored routing modes, since it is the default.
+func (m *Metadata) SetAllRoutingModes(source string, mode string) error {
+	graph, _, err := d2compiler.Compile("", strings.NewReader(parser.Normalize(source)), nil)
+	if err != nil {
+		return fmt.Errorf("d2 compilation failed: %w", err)
+	}
+
+	if m.RoutingMode == nil {
+		m.RoutingMode = make(map[string]string)
+	}
+	for _, edge := range graph.Edges {
+		if mode == "" || mode == RoutingDirect {
+			delete(m.RoutingMode, edge.AbsID())
+		} else {
+			m.RoutingMode[edge.AbsID()] = mode
+		}
+	}
+	return nil
+}
+
+// SetEdgeRoutingModes sets the routing modes declared on the diagram's edges,
+// keyed by D2 edge IDs like SetAllRoutingModes. source is the D2 source the
+// diagram was parsed from, or "" if it is rendered from the IR, in which
+// case the IDs are those of the D2 generated for it. Modes already set, such
+// as ones chosen in the editor, are kept.
+func (m *Metadata) SetEdgeRoutingModes(source string, diagram *ir.Diagram) error {
+	if source == "" {
+		source = irToD2Source(diagram)
+	}
+	graph, _, err := d2compiler.Compile("", strings.NewReader(parser.Normalize(source)), nil)
+	if err != nil {
+		return fmt.Errorf("d2 compilation failed: %w", err)
+	}
+
+	for i, edge := range graph.Edges {
+		if i >= len(diagram.Edges) {
+			break
+		}
+		mode := diagram.Edges[i].RoutingMode
+		if mode == "" || mode == RoutingDirect {
+			continue
+		}
+		if _, ok := m.RoutingMode[edge.AbsID()]; ok {
+			continue
+		}
+		if m.RoutingMode == nil {
+			m.RoutingMode = make(map[string]string)
+		}
+		m.RoutingMode[edge.AbsID()] = mode
+	}
+	return nil
+}
+
 // RenderResult contains the result of JointJS rendering.
 type RenderResult struct {
 	Success bool    `json:"success"`
@@ -90,10 +163,10 @@ func RenderWithJointJS(ctx context.Context, d2Svg []byte, metadata *Metadata) ([
 		chromedp.Flag("disable-web-security", true), // Allow loading external scripts
 	)
 
-	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, opts...)
-	defer allocCancel()
-
-	chromeCtx, chromeCancel := chromedp.NewContext(allocCtx)
+	chromeCtx, chromeCancel, err := startChrome(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render with JointJS: %w", err)
+	}
 	defer chromeCancel()
 
 	var resultJSON string
@@ -175,15 +248,15 @@ func RenderWithJointJSToPDF(ctx context.Context, d2Svg []byte, metadata *Metadat
 // It falls back to the original D2 SVG if metadata is nil or empty.
 func RenderWithMetadata(ctx context.Context, d2Svg []byte, metadata *Metadata, format Format, pixelDensity int) ([]byte, error) {
 	// Check if we have meaningful metadata
-	hasMetadata := metadata != nil && (len(metadata.Positions) > 0 || len(metadata.Vertices) > 0)
-
-	if !hasMetadata {
+	if !metadata.HasLayout() {
 		// No metadata, use original SVG
 		switch format {
 		case FormatSVG:
 			return d2Svg, nil
 		case FormatPNG:
 			return SVGToPNG(ctx, d2Svg, pixelDensity)
+		case FormatWebP:
+			return SVGToWebP(ctx, d2Svg, pixelDensity)
 		case FormatPDF:
 			return SVGToPDF(ctx, d2Svg)
 		default:
@@ -197,6 +270,12 @@ func RenderWithMetadata(ctx context.Context, d2Svg []byte, metadata *Metadata, f
 		return RenderWithJointJS(ctx, d2Svg, metadata)
 	case FormatPNG:
 		return RenderWithJointJSToPNG(ctx, d2Svg, metadata, pixelDensity)
+	case FormatWebP:
+		svgBytes, err := RenderWithJointJS(ctx, d2Svg, metadata)
+		if err != nil {
+			return nil, err
+		}
+		return SVGToWebP(ctx, svgBytes, pixelDensity)
 	case FormatPDF:
 		return RenderWithJointJSToPDF(ctx, d2Svg, metadata)
 	default:
@@ -0,0 +1,85 @@
+// Package render provides diagram rendering to various formats.
+// This file implements label truncation and wrapping for dense diagrams.
+package render
+
+import (
+	"strings"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// ellipsis marks a truncated label.
+const ellipsis = "…"
+
+// limitLabels returns a copy of the diagram in which node and edge labels
+// longer than maxLength characters are truncated with an ellipsis and
+// labels are wrapped at word boundaries near wrapWidth characters. Zero
+// disables either step. A truncated label's full text is kept in the
+// "tooltip" property unless one is already set.
+// The input diagram is not modified.
+func limitLabels(diagram *ir.Diagram, maxLength, wrapWidth int) *ir.Diagram {
+	result := diagram.Clone()
+	for _, node := range result.Nodes {
+		label, truncated := truncateLabel(node.Label, maxLength)
+		if truncated {
+			node.Properties = withTooltip(node.Properties, node.Label)
+		}
+		node.Label = wrapLabel(label, wrapWidth)
+	}
+	for _, edge := range result.Edges {
+		label, truncated := truncateLabel(edge.Label, maxLength)
+		if truncated {
+			edge.Properties = withTooltip(edge.Properties, edge.Label)
+		}
+		edge.Label = wrapLabel(label, wrapWidth)
+	}
+	return result
+}
+
+// truncateLabel shortens label to at most maxLength characters, ending in an
+// ellipsis, and reports whether it was shortened.
+func truncateLabel(label string, maxLength int) (string, bool) {
+	runes := []rune(label)
+	if maxLength <= 0 || len(runes) <= maxLength {
+		return label, false
+	}
+	return strings.TrimRight(string(runes[:maxLength-1]), " ") + ellipsis, true
+}
+
+// wrapLabel breaks label into lines of about width characters at word
+// boundaries. Words longer than width get a line of their own.
+func wrapLabel(label string, width int) string {
+	if width <= 0 || len([]rune(label)) <= width {
+		return label
+	}
+
+	var lines []string
+	for _, paragraph := range strings.Split(label, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withTooltip returns properties with "tooltip" set to text, unless a
+// tooltip is already present.
+func withTooltip(properties map[string]interface{}, text string) map[string]interface{} {
+	if _, ok := properties["tooltip"]; ok {
+		return properties
+	}
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	properties["tooltip"] = text
+	return properties
+}
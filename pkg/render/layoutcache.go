@@ -0,0 +1,153 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// Layout records the geometry computed while rendering a diagram, so that a
+// later render which only restyles it can skip the layout engine.
+type Layout struct {
+	passes   []*layoutPass
+	computed int // Number of passes run through the layout engine
+}
+
+// Reused reports whether every position in the layout was copied from the
+// previous layout instead of being computed.
+func (l *Layout) Reused() bool {
+	return len(l.passes) > 0 && l.computed == 0
+}
+
+// layoutPass is the result of one layout engine call. D2 calls the engine
+// once per board and per nested graph, always in the same order.
+type layoutPass struct {
+	inputs  map[string]string // Measured size and shape of each object and edge label before layout
+	objects map[string]objectGeometry
+	edges   map[string]edgeGeometry
+}
+
+type objectGeometry struct {
+	topLeft       geo.Point
+	width, height float64
+	labelPosition *string
+	iconPosition  *string
+}
+
+type edgeGeometry struct {
+	route         []geo.Point
+	isCurve       bool
+	labelPosition *string
+}
+
+// RenderWithLayout renders D2 source to SVG like RenderFromSource and returns
+// the layout it used. If prev is non-nil, geometry is copied from it for every
+// graph whose objects and measured sizes are unchanged; other graphs are laid
+// out as usual. Pass the returned layout as prev on the next render.
+func RenderWithLayout(ctx context.Context, source string, opts Options, prev *Layout) ([]byte, *Layout, error) {
+	layout := &Layout{}
+	targetDiagram, renderOpts, err := compileSource(ctx, source, opts, layout.layoutFunc(prev))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Render
+	svg, err := renderSVG(targetDiagram, renderOpts, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SVG rendering failed: %w", err)
+	}
+	return postProcess(ctx, svg, opts), layout, nil
+}
+
+// layoutFunc returns a layout engine that replays passes from prev when their
+// inputs match, and runs dagre otherwise, recording every pass into l.
+func (l *Layout) layoutFunc(prev *Layout) d2graph.LayoutGraph {
+	return func(ctx context.Context, g *d2graph.Graph) error {
+		inputs := layoutInputs(g)
+		i := len(l.passes)
+		if prev != nil && i < len(prev.passes) && maps.Equal(prev.passes[i].inputs, inputs) {
+			prev.passes[i].apply(g)
+			l.passes = append(l.passes, prev.passes[i])
+			return nil
+		}
+
+		if err := dagreLayout(ctx, g); err != nil {
+			return err
+		}
+		l.computed++
+		l.passes = append(l.passes, capturePass(g, inputs))
+		return nil
+	}
+}
+
+// layoutInputs describes everything about g that the layout engine depends
+// on, keyed by object and edge ID.
+func layoutInputs(g *d2graph.Graph) map[string]string {
+	inputs := make(map[string]string, len(g.Objects)+len(g.Edges)+1)
+	inputs[""] = g.Root.Direction.Value
+	for _, obj := range g.Objects {
+		inputs["obj:"+obj.AbsID()] = fmt.Sprintf("%g,%g,%s,%s", obj.Width, obj.Height, obj.Shape.Value, obj.Direction.Value)
+	}
+	for _, edge := range g.Edges {
+		inputs["edge:"+edge.AbsID()] = fmt.Sprintf("%d,%d", edge.LabelDimensions.Width, edge.LabelDimensions.Height)
+	}
+	return inputs
+}
+
+// capturePass records the geometry of a laid-out graph.
+func capturePass(g *d2graph.Graph, inputs map[string]string) *layoutPass {
+	pass := &layoutPass{
+		inputs:  inputs,
+		objects: make(map[string]objectGeometry, len(g.Objects)),
+		edges:   make(map[string]edgeGeometry, len(g.Edges)),
+	}
+	for _, obj := range g.Objects {
+		geometry := objectGeometry{
+			width:         obj.Width,
+			height:        obj.Height,
+			labelPosition: obj.LabelPosition,
+			iconPosition:  obj.IconPosition,
+		}
+		if obj.TopLeft != nil {
+			geometry.topLeft = *obj.TopLeft
+		}
+		pass.objects[obj.AbsID()] = geometry
+	}
+	for _, edge := range g.Edges {
+		route := make([]geo.Point, len(edge.Route))
+		for i, p := range edge.Route {
+			route[i] = *p
+		}
+		pass.edges[edge.AbsID()] = edgeGeometry{
+			route:         route,
+			isCurve:       edge.IsCurve,
+			labelPosition: edge.LabelPosition,
+		}
+	}
+	return pass
+}
+
+// apply copies the recorded geometry onto g, which must have the same
+// inputs as the graph the pass was captured from.
+func (p *layoutPass) apply(g *d2graph.Graph) {
+	for _, obj := range g.Objects {
+		geometry := p.objects[obj.AbsID()]
+		obj.TopLeft = geo.NewPoint(geometry.topLeft.X, geometry.topLeft.Y)
+		obj.Width = geometry.width
+		obj.Height = geometry.height
+		obj.LabelPosition = geometry.labelPosition
+		obj.IconPosition = geometry.iconPosition
+	}
+	for _, edge := range g.Edges {
+		geometry := p.edges[edge.AbsID()]
+		edge.Route = make([]*geo.Point, len(geometry.route))
+		for i, pt := range geometry.route {
+			edge.Route[i] = geo.NewPoint(pt.X, pt.Y)
+		}
+		edge.IsCurve = geometry.isCurve
+		edge.LabelPosition = geometry.labelPosition
+	}
+}
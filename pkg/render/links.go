@@ -0,0 +1,38 @@
+// Package render provides diagram rendering to various formats.
+// This file controls how node links appear in rendered SVGs.
+package render
+
+import (
+	"bytes"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+)
+
+// linkAnchor is how D2 opens the anchor it wraps around linked shapes.
+var linkAnchor = []byte(`<a href="`)
+
+// withoutLinks returns the diagram with node "link" properties removed, so
+// they render as static shapes. The input diagram is not modified.
+func withoutLinks(diagram *ir.Diagram) *ir.Diagram {
+	hasLinks := false
+	for _, node := range diagram.Nodes {
+		if _, ok := node.Properties["link"]; ok {
+			hasLinks = true
+			break
+		}
+	}
+	if !hasLinks {
+		return diagram
+	}
+
+	result := diagram.Clone()
+	for _, node := range result.Nodes {
+		delete(node.Properties, "link")
+	}
+	return result
+}
+
+// openLinksInNewTab makes every link in the SVG open in a new browser tab.
+func openLinksInNewTab(svg []byte) []byte {
+	return bytes.ReplaceAll(svg, linkAnchor, []byte(`<a target="_blank" rel="noopener" href="`))
+}
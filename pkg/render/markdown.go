@@ -0,0 +1,33 @@
+// Package render provides diagram rendering to various formats.
+// This file wraps rendered SVG in Markdown for documentation workflows.
+package render
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SVGToMarkdown returns a Markdown document that shows svg as an inline
+// data-URI image. A non-empty title becomes a level-one heading and the
+// image's alt text.
+func SVGToMarkdown(svg []byte, title string) []byte {
+	title = strings.TrimSpace(title)
+	alt := title
+	if alt == "" {
+		alt = "diagram"
+	}
+
+	var b strings.Builder
+	if title != "" {
+		fmt.Fprintf(&b, "# %s\n\n", title)
+	}
+	fmt.Fprintf(&b, "![%s](data:image/svg+xml;base64,%s)\n",
+		escapeMarkdownAlt(alt), base64.StdEncoding.EncodeToString(svg))
+	return []byte(b.String())
+}
+
+// escapeMarkdownAlt escapes characters that would end an image's alt text.
+func escapeMarkdownAlt(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "\n", " ").Replace(s)
+}
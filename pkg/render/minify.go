@@ -0,0 +1,188 @@
+// Package render provides diagram rendering to various formats.
+// This file implements a lightweight, whitespace-only SVG minifier.
+package render
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// verbatimTags are elements whose content is copied unchanged because
+// whitespace in it is significant.
+var verbatimTags = map[string]bool{
+	"pre":      true,
+	"code":     true,
+	"textarea": true,
+	"script":   true,
+}
+
+// tagNameRe matches the element name at the start of a tag.
+var tagNameRe = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9:_-]*)`)
+
+// MinifySVG removes comments and redundant whitespace from SVG markup.
+// Only cosmetic changes are made: indentation between tags, whitespace
+// inside tags, and whitespace and comments in <style> CSS. Attribute values,
+// CSS strings, text, and the content of <pre>, <code>, <textarea>, and
+// <script> elements are left unchanged.
+func MinifySVG(svg []byte) []byte {
+	out := make([]byte, 0, len(svg))
+	for i := 0; i < len(svg); {
+		switch {
+		case bytes.HasPrefix(svg[i:], []byte("<!--")):
+			end := bytes.Index(svg[i+4:], []byte("-->"))
+			if end < 0 {
+				return append(out, svg[i:]...)
+			}
+			i += 4 + end + 3
+
+		case bytes.HasPrefix(svg[i:], []byte("<![CDATA[")):
+			end := bytes.Index(svg[i:], []byte("]]>"))
+			if end < 0 {
+				return append(out, svg[i:]...)
+			}
+			out = append(out, svg[i:i+end+3]...)
+			i += end + 3
+
+		case svg[i] == '<':
+			end := tagEnd(svg, i)
+			tag := svg[i:end]
+			out = append(out, minifyTag(tag)...)
+			i = end
+
+			// Copy or minify element content up to its closing tag
+			m := tagNameRe.FindSubmatch(tag)
+			if m == nil || tag[1] == '/' || bytes.HasSuffix(tag, []byte("/>")) {
+				continue
+			}
+			name := string(bytes.ToLower(m[1]))
+			if name != "style" && !verbatimTags[name] {
+				continue
+			}
+			closeTag := []byte("</" + name)
+			contentEnd := bytes.Index(svg[i:], closeTag)
+			if contentEnd < 0 {
+				return append(out, svg[i:]...)
+			}
+			content := svg[i : i+contentEnd]
+			if name == "style" {
+				content = minifyCSS(content)
+			}
+			out = append(out, content...)
+			i += contentEnd
+
+		default:
+			// Text up to the next tag; drop it if it's only indentation
+			end := bytes.IndexByte(svg[i:], '<')
+			if end < 0 {
+				end = len(svg) - i
+			}
+			text := svg[i : i+end]
+			if len(bytes.TrimSpace(text)) > 0 || !bytes.ContainsAny(text, "\r\n") {
+				out = append(out, text...)
+			}
+			i += end
+		}
+	}
+	return out
+}
+
+// tagEnd returns the index just past the tag starting at start,
+// skipping '>' characters inside quoted attribute values.
+func tagEnd(svg []byte, start int) int {
+	var quote byte
+	for i := start + 1; i < len(svg); i++ {
+		c := svg[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(svg)
+}
+
+// minifyTag collapses whitespace runs outside quoted values in a tag and
+// removes whitespace before the closing "/>" or ">".
+func minifyTag(tag []byte) []byte {
+	out := make([]byte, 0, len(tag))
+	var quote byte
+	space := false
+	for _, c := range tag {
+		if quote == 0 && isSpace(c) {
+			space = true
+			continue
+		}
+		if space && c != '>' && c != '/' {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, c)
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		}
+	}
+	return out
+}
+
+// minifyCSS removes comments and collapses whitespace runs in CSS,
+// leaving quoted strings unchanged. Whitespace around braces,
+// semicolons, and commas is removed.
+func minifyCSS(css []byte) []byte {
+	out := make([]byte, 0, len(css))
+	var quote byte
+	space := false
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		if quote != 0 {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(css) {
+				i++
+				out = append(out, css[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '/' && i+1 < len(css) && css[i+1] == '*' {
+			end := bytes.Index(css[i+2:], []byte("*/"))
+			if end < 0 {
+				break
+			}
+			i += 2 + end + 1
+			space = true
+			continue
+		}
+		if isSpace(c) {
+			space = true
+			continue
+		}
+		// Whitespace next to punctuation is never significant
+		if space && len(out) > 0 && !isCSSPunct(c) && !isCSSPunct(out[len(out)-1]) {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, c)
+		if c == '"' || c == '\'' {
+			quote = c
+		}
+	}
+	return out
+}
+
+// isSpace reports whether c is XML whitespace.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isCSSPunct reports whether whitespace around c can be removed in CSS.
+func isCSSPunct(c byte) bool {
+	return c == '{' || c == '}' || c == ';' || c == ','
+}
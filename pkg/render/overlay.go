@@ -0,0 +1,101 @@
+// Package render provides diagram rendering to various formats.
+// This file draws review overlays, a coordinate grid and a watermark, on rendered SVGs.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Grid line spacing in diagram pixels; every gridMajorEvery-th line is darker.
+const (
+	gridSpacing    = 10
+	gridMajorEvery = 10
+)
+
+// Watermark text size and the distance between repeats, in pixels.
+const (
+	watermarkFontSize = 40
+	watermarkSpacingX = 320
+	watermarkSpacingY = 200
+)
+
+// diagramBackgroundRe matches the <svg> element D2 draws the diagram in,
+// capturing its viewBox, followed by the diagram's background rectangle.
+var diagramBackgroundRe = regexp.MustCompile(`(?s)<svg\b[^>]*\bclass="[^"]*\bd2-svg\b[^"]*"[^>]*\bviewBox="(-?[0-9.]+) (-?[0-9.]+) ([0-9.]+) ([0-9.]+)"[^>]*>\s*<rect\b[^>]*>`)
+
+// outerViewBoxRe matches the viewBox of the outermost <svg> element.
+var outerViewBoxRe = regexp.MustCompile(`^(?s)(?:<\?xml[^>]*\?>)?\s*<svg\b[^>]*?\bviewBox="0 0 ([0-9.]+) ([0-9.]+)"`)
+
+// withGrid draws a faint grid over the diagram's background, behind its
+// shapes, with lines at multiples of gridSpacing in diagram coordinates.
+// SVGs not rendered by D2 are returned unchanged.
+func withGrid(svg []byte) []byte {
+	m := diagramBackgroundRe.FindSubmatchIndex(svg)
+	if m == nil {
+		return svg
+	}
+	var box [4]float64
+	for i := range box {
+		box[i], _ = strconv.ParseFloat(string(svg[m[2+2*i]:m[3+2*i]]), 64)
+	}
+	x, y, width, height := box[0], box[1], box[2], box[3]
+
+	var minor, major strings.Builder
+	lines := func(from, to float64, line func(path *strings.Builder, at float64)) {
+		for at := math.Ceil(from/gridSpacing) * gridSpacing; at <= to; at += gridSpacing {
+			if math.Mod(at, gridSpacing*gridMajorEvery) == 0 {
+				line(&major, at)
+			} else {
+				line(&minor, at)
+			}
+		}
+	}
+	lines(x, x+width, func(path *strings.Builder, at float64) {
+		fmt.Fprintf(path, "M%g %gV%g", at, y, y+height)
+	})
+	lines(y, y+height, func(path *strings.Builder, at float64) {
+		fmt.Fprintf(path, "M%g %gH%g", x, at, x+width)
+	})
+
+	grid := `<g class="diagtool-grid" fill="none" stroke="#888888" pointer-events="none">`
+	if minor.Len() > 0 {
+		grid += fmt.Sprintf(`<path d="%s" stroke-opacity="0.15" stroke-width="0.5"/>`, minor.String())
+	}
+	if major.Len() > 0 {
+		grid += fmt.Sprintf(`<path d="%s" stroke-opacity="0.3" stroke-width="1"/>`, major.String())
+	}
+	grid += `</g>`
+	return insertAt(svg, m[1], grid)
+}
+
+// withWatermark repeats text diagonally across the whole SVG at low opacity,
+// on top of the diagram. An empty text, or an SVG not rendered by D2, is
+// returned unchanged.
+func withWatermark(svg []byte, text string) []byte {
+	m := outerViewBoxRe.FindSubmatch(svg)
+	end := bytes.LastIndex(svg, []byte("</svg>"))
+	if text == "" || m == nil || end < 0 {
+		return svg
+	}
+	width, _ := strconv.ParseFloat(string(m[1]), 64)
+	height, _ := strconv.ParseFloat(string(m[2]), 64)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<g class="diagtool-watermark" fill="#888888" fill-opacity="0.2" font-family="sans-serif" font-size="%d" font-weight="bold" text-anchor="middle" pointer-events="none">`, watermarkFontSize)
+	escaped := html.EscapeString(text)
+	for row := 0; float64(row*watermarkSpacingY) < height+watermarkSpacingY; row++ {
+		ty := row*watermarkSpacingY + watermarkSpacingY/2
+		// Offset every other row so the repeats form a diagonal pattern
+		for tx := (row % 2) * watermarkSpacingX / 2; float64(tx) < width+watermarkSpacingX; tx += watermarkSpacingX {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" transform="rotate(-30 %d %d)">%s</text>`, tx, ty, tx, ty, escaped)
+		}
+	}
+	b.WriteString(`</g>`)
+	return insertAt(svg, end, b.String())
+}
@@ -0,0 +1,171 @@
+// Package render provides diagram rendering to various formats.
+// This file contains PDF page sizing for printable output.
+package render
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// PageSize is a standard paper size for PDF output.
+type PageSize string
+
+// Supported PDF page sizes. An empty PageSize sizes the page to the diagram.
+const (
+	PageSizeA3      PageSize = "a3"
+	PageSizeA4      PageSize = "a4"
+	PageSizeA5      PageSize = "a5"
+	PageSizeLetter  PageSize = "letter"
+	PageSizeLegal   PageSize = "legal"
+	PageSizeTabloid PageSize = "tabloid"
+)
+
+// pageDimensions holds portrait paper dimensions in inches.
+var pageDimensions = map[PageSize][2]float64{
+	PageSizeA3:      {11.69, 16.54},
+	PageSizeA4:      {8.27, 11.69},
+	PageSizeA5:      {5.83, 8.27},
+	PageSizeLetter:  {8.5, 11},
+	PageSizeLegal:   {8.5, 14},
+	PageSizeTabloid: {11, 17},
+}
+
+// pdfMargin is the page margin in inches.
+const pdfMargin = 0.4
+
+// ParsePageSize converts a page size name (case-insensitive) to a PageSize.
+func ParsePageSize(name string) (PageSize, error) {
+	size := PageSize(strings.ToLower(strings.TrimSpace(name)))
+	if size == "" {
+		return "", nil
+	}
+	if _, ok := pageDimensions[size]; !ok {
+		return "", fmt.Errorf("unsupported page size: %s (use a3, a4, a5, letter, legal, or tabloid)", name)
+	}
+	return size, nil
+}
+
+// Dimensions returns the page width and height in inches, swapped for
+// landscape orientation. Returns false for unknown or empty page sizes.
+func (p PageSize) Dimensions(landscape bool) (width, height float64, ok bool) {
+	dims, ok := pageDimensions[p]
+	if !ok {
+		return 0, 0, false
+	}
+	if landscape {
+		return dims[1], dims[0], true
+	}
+	return dims[0], dims[1], true
+}
+
+// SVGToPDFWithOptions converts SVG bytes to PDF using headless Chrome, honoring
+// the PDF page options. When opts.PDFPageSize is set, the diagram is scaled to
+// fit and centered on a single page of that size; otherwise it behaves like
+// SVGToPDF.
+func SVGToPDFWithOptions(ctx context.Context, svgBytes []byte, opts Options) ([]byte, error) {
+	if _, _, ok := opts.PDFPageSize.Dimensions(opts.PDFLandscape); !ok {
+		return SVGToPDF(ctx, svgBytes)
+	}
+	return printSVGPages(ctx, [][]byte{svgBytes}, opts.PDFPageSize, opts.PDFLandscape)
+}
+
+// SVGPagesToPDF converts several SVGs (e.g. the boards of a diagram) into a
+// single PDF with one SVG per page. Pages use opts.PDFPageSize, or A4 when no
+// page size is set. A single page is converted like SVGToPDFWithOptions.
+func SVGPagesToPDF(ctx context.Context, pages [][]byte, opts Options) ([]byte, error) {
+	switch len(pages) {
+	case 0:
+		return nil, fmt.Errorf("no pages to render")
+	case 1:
+		return SVGToPDFWithOptions(ctx, pages[0], opts)
+	}
+
+	size := opts.PDFPageSize
+	if size == "" {
+		size = PageSizeA4
+	}
+	return printSVGPages(ctx, pages, size, opts.PDFLandscape)
+}
+
+// printSVGPages prints each SVG scaled to fit and centered on its own page.
+func printSVGPages(ctx context.Context, pages [][]byte, size PageSize, landscape bool) ([]byte, error) {
+	width, height, ok := size.Dimensions(landscape)
+	if !ok {
+		return nil, fmt.Errorf("unsupported page size: %s", size)
+	}
+
+	// Wrap each SVG in a page-sized box that scales it to the printable area
+	var body strings.Builder
+	for _, svgBytes := range pages {
+		svgURI := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svgBytes)
+		body.WriteString(`<div class="page"><img src="` + svgURI + `"></div>`)
+	}
+	// Chrome uses the document title as the PDF title
+	doc := fmt.Sprintf(`<!DOCTYPE html>
+<html><head><title>%s</title><style>
+@page { size: %.2fin %.2fin; margin: %.2fin; }
+html, body { margin: 0; }
+.page { display: flex; align-items: center; justify-content: center; width: %.2fin; height: %.2fin; break-after: page; }
+.page:last-child { break-after: auto; }
+img { max-width: 100%%; max-height: 100%%; width: 100%%; height: 100%%; object-fit: contain; }
+</style></head>
+<body>%s</body></html>`,
+		html.EscapeString(SVGTitle(pages[0])),
+		width, height, pdfMargin,
+		width-2*pdfMargin, height-2*pdfMargin-0.01, // Avoid rounding onto an extra page
+		body.String())
+	dataURI := "data:text/html;base64," + base64.StdEncoding.EncodeToString([]byte(doc))
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// Create headless Chrome options
+	chromeOpts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", true),
+		chromedp.Flag("disable-gpu", true),
+		chromedp.Flag("no-sandbox", true),
+		chromedp.Flag("disable-dev-shm-usage", true),
+	)
+
+	chromeCtx, chromeCancel, err := startChrome(ctx, chromeOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render PDF with Chrome: %w", err)
+	}
+	defer chromeCancel()
+
+	var pdfBytes []byte
+
+	err = chromedp.Run(chromeCtx,
+		chromedp.Navigate(dataURI),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			buf, _, err := page.PrintToPDF().
+				WithPrintBackground(true).
+				WithPaperWidth(width).
+				WithPaperHeight(height).
+				WithMarginTop(pdfMargin).
+				WithMarginBottom(pdfMargin).
+				WithMarginLeft(pdfMargin).
+				WithMarginRight(pdfMargin).
+				WithPreferCSSPageSize(true).
+				Do(ctx)
+			if err != nil {
+				return err
+			}
+			pdfBytes = buf
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render PDF with Chrome: %w", err)
+	}
+
+	return pdfBytes, nil
+}
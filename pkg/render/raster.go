@@ -0,0 +1,169 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// Rasterizer selects how SVGs are converted to PNG.
+type Rasterizer string
+
+// Supported rasterizers. An empty Rasterizer uses the browser.
+const (
+	// RasterizerBrowser draws the SVG in headless Chrome, matching what
+	// browsers show.
+	RasterizerBrowser Rasterizer = "browser"
+
+	// RasterizerNative draws the SVG in Go, without a browser. It draws
+	// shapes, connections, arrowheads, and text in D2's fonts, but not
+	// Markdown, images, or sketch mode.
+	RasterizerNative Rasterizer = "native"
+)
+
+// ErrUnsupportedSVG is returned by the native rasterizer for SVGs using
+// features it cannot draw, such as Markdown labels, images, or sketch mode.
+var ErrUnsupportedSVG = errors.New("SVG uses features the native rasterizer does not support")
+
+// ParseRasterizer converts a rasterizer name (case-insensitive) to a
+// Rasterizer.
+func ParseRasterizer(name string) (Rasterizer, error) {
+	rasterizer := Rasterizer(strings.ToLower(strings.TrimSpace(name)))
+	switch rasterizer {
+	case "", RasterizerBrowser, RasterizerNative:
+		return rasterizer, nil
+	default:
+		return "", fmt.Errorf("unsupported rasterizer: %s (use browser or native)", name)
+	}
+}
+
+// RasterizeSVG converts SVG bytes to PNG with the given rasterizer.
+func RasterizeSVG(ctx context.Context, svgBytes []byte, pixelDensity int, rasterizer Rasterizer) ([]byte, error) {
+	if rasterizer == RasterizerNative {
+		return SVGToPNGNative(svgBytes, pixelDensity)
+	}
+	return SVGToPNG(ctx, svgBytes, pixelDensity)
+}
+
+// nonDrawnElementRe matches the elements of D2's SVGs that oksvg leaves to
+// the native rasterizer or that are not drawn: stylesheets (D2 also sets
+// colors as attributes), text, arrowhead markers, masks, and accessibility
+// titles. oksvg would otherwise draw a mask's contents over the diagram.
+var nonDrawnElementRe = regexp.MustCompile(`(?s)<(style|text|marker|mask|title|desc)\b[^>]*?(/>|>.*?</(style|text|marker|mask|title|desc)>)`)
+
+// SVGToPNGNative converts SVG bytes to PNG in Go, without a browser. oksvg
+// draws the shapes and connections, with each arrowhead marker copied in
+// after its connection, and text is drawn over them with D2's fonts. The
+// result is approximate: custom fonts are not used, and edge labels are not
+// cut out of their connections. SVGs with unsupported elements return an
+// error wrapping ErrUnsupportedSVG.
+func SVGToPNGNative(svgBytes []byte, pixelDensity int) ([]byte, error) {
+	// Ensure minimum pixel density of 1
+	if pixelDensity < 1 {
+		pixelDensity = 1
+	}
+
+	scan, err := scanSVG(svgBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedSVG, err)
+	}
+	svg := nonDrawnElementRe.ReplaceAll(scan.withMarkers(svgBytes), nil)
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svg), oksvg.StrictErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedSVG, err)
+	}
+
+	// oksvg reads the viewBox of the diagram's own <svg>, ignoring the outer
+	// one, so the placement in a fit box is applied here
+	boxWidth, boxHeight := icon.ViewBox.W, icon.ViewBox.H
+	x, y, w, h := 0.0, 0.0, boxWidth, boxHeight
+	if bw, bh, fx, fy, fw, fh, ok := fittedPlacement(svgBytes); ok {
+		boxWidth, boxHeight = bw, bh
+		x, y, w, h = fx, fy, fw, fh
+	}
+
+	density := float64(pixelDensity)
+	if boxWidth <= 0 || boxHeight <= 0 {
+		return nil, fmt.Errorf("%w: missing viewBox", ErrUnsupportedSVG)
+	}
+	width, height := rasterPixels(boxWidth, density), rasterPixels(boxHeight, density)
+
+	// Unlike icon.SetTarget, scale the viewBox origin along with the drawing
+	icon.Transform = rasterx.Identity.
+		Translate(x*density, y*density).
+		Scale(w*density/icon.ViewBox.W, h*density/icon.ViewBox.H).
+		Translate(-icon.ViewBox.X, -icon.ViewBox.Y)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	outer := rasterx.Identity.Scale(float64(width)/boxWidth, float64(height)/boxHeight)
+	if err := scan.drawTexts(img, icon.Transform, outer); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// rasterPixels returns the number of pixels a length of size SVG units
+// takes up when scaled by factor, rounded to the nearest pixel and at least
+// one. Both rasterizers round widths and heights the same way, so images
+// keep the SVG's aspect ratio to within a pixel.
+func rasterPixels(size, factor float64) int {
+	return max(int(math.Round(size*factor)), 1)
+}
+
+// rasterSize returns the pixel size of a raster image of svg at
+// pixelDensity and scale: the size of the outer viewBox times both. ok is
+// false if the outer <svg> element has no "0 0 width height" viewBox.
+func rasterSize(svg []byte, pixelDensity int, scale float64) (width, height int, ok bool) {
+	m := outerViewBoxRe.FindSubmatch(svg)
+	if m == nil {
+		return 0, 0, false
+	}
+	w, errW := strconv.ParseFloat(string(m[1]), 64)
+	h, errH := strconv.ParseFloat(string(m[2]), 64)
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	factor := float64(max(pixelDensity, 1)) * scale
+	return rasterPixels(w, factor), rasterPixels(h, factor), true
+}
+
+// outerSVGTagRe matches the attributes of the outermost <svg> element.
+var outerSVGTagRe = regexp.MustCompile(`^(?s)((?:<\?xml[^>]*\?>)?\s*<svg\b)([^>]*)>`)
+
+// sizeAttrRe matches a width or height attribute.
+var sizeAttrRe = regexp.MustCompile(`\s(?:width|height)="[^"]*"`)
+
+// withPixelSize sets the width and height of the outermost <svg> element,
+// so browsers draw its viewBox at exactly that size.
+func withPixelSize(svg []byte, width, height int) []byte {
+	m := outerSVGTagRe.FindSubmatchIndex(svg)
+	if m == nil {
+		return svg
+	}
+	attrs := sizeAttrRe.ReplaceAll(svg[m[4]:m[5]], nil)
+	var result bytes.Buffer
+	result.Write(svg[m[2]:m[3]])
+	result.Write(attrs)
+	fmt.Fprintf(&result, ` width="%d" height="%d">`, width, height)
+	result.Write(svg[m[1]:])
+	return result.Bytes()
+}
@@ -0,0 +1,737 @@
+// Package render provides diagram rendering to various formats.
+// This file draws what oksvg cannot for the native rasterizer: text, with
+// D2's own fonts, and arrowhead markers.
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+)
+
+// rasterScan holds the text and arrowheads found in an SVG.
+type rasterScan struct {
+	texts   []textRun
+	markers []markerUse
+	fills   map[string]string // Fill color by CSS class, from the stylesheets
+}
+
+// markedPath is a path with markers, which end in the SVG at offset.
+type markedPath struct {
+	el     *rasterElement
+	offset int
+}
+
+// textRun is a line of text to draw.
+type textRun struct {
+	text          string
+	x, y          float64
+	anchor        string // start, middle or end
+	size          float64
+	letterSpacing float64
+	mono          bool
+	bold, italic  bool
+	fill          string // "" for black
+	opacity       float64
+	transform     rasterx.Matrix2D // From the text's user space to its <svg>'s
+	outer         bool             // In the outermost <svg> rather than D2's own
+}
+
+// markerUse is an arrowhead to draw at the end of a path: the marker's
+// contents, placed and rotated as a group inserted after the path.
+type markerUse struct {
+	offset int // Where in the SVG to insert the group
+	group  string
+}
+
+// markerDef is a <marker> element.
+type markerDef struct {
+	refX, refY    float64
+	width, height float64 // markerWidth and markerHeight
+	viewBox       []float64
+	strokeUnits   bool // Scaled by the stroke width, as markerUnits="strokeWidth" is
+	content       string
+}
+
+// rasterElement is the state an element passes on to its children.
+type rasterElement struct {
+	name          string
+	transform     rasterx.Matrix2D
+	fill          string
+	opacity       float64
+	size          float64
+	weight, style string
+	anchor        string
+	letterSpacing float64
+	classes       []string
+	hidden        bool // Inside an element whose content is not drawn
+	svgDepth      int
+
+	text    *textRun // The line being collected, in <text> and <tspan>
+	markers []string // marker-start and marker-end of a path
+	points  []rasterPoint
+	stroke  float64
+}
+
+type rasterPoint struct{ x, y float64 }
+
+// hiddenElements are elements whose content is not drawn where it appears.
+var hiddenElements = map[string]bool{
+	"defs": true, "marker": true, "mask": true, "clipPath": true, "pattern": true,
+	"symbol": true, "title": true, "desc": true, "style": true, "metadata": true,
+	"foreignObject": true, "script": true,
+}
+
+// cssFillRe matches a CSS rule setting only a class's fill, as D2 writes
+// them for its theme colors.
+var cssFillRe = regexp.MustCompile(`\.([\w-]+)\s*\{\s*fill\s*:\s*([^;}]+);?\s*\}`)
+
+// scanSVG finds the text and the arrowheads of an SVG.
+func scanSVG(svg []byte) (*rasterScan, error) {
+	scan := &rasterScan{fills: make(map[string]string)}
+	markers := make(map[string]*markerDef)
+	var paths []markedPath // Placed once every marker is defined
+
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	stack := []*rasterElement{{transform: rasterx.Identity, opacity: 1, size: 16, anchor: "start"}}
+	var marker *markerDef
+	markerStart := 0
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := parent.child(t, scan.fills)
+			stack = append(stack, el)
+			switch t.Name.Local {
+			case "marker":
+				marker = newMarkerDef(t)
+				markers[attr(t, "id")] = marker
+				markerStart = int(d.InputOffset())
+			case "text", "tspan":
+				if !el.hidden {
+					el.startText(t, parent.text)
+				}
+			case "path", "line":
+				if !el.hidden {
+					el.startPath(t)
+				}
+			}
+
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected end element </%s>", t.Name.Local)
+			}
+			el := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			parent = stack[len(stack)-1]
+			switch {
+			case t.Name.Local == "marker" && marker != nil:
+				marker.content = string(svg[markerStart:offset])
+				marker = nil
+			case el.text != nil && el.text != parent.text:
+				// A <tspan> moves the rest of its <text> along
+				if parent.text != nil {
+					parent.text.x, parent.text.y = el.text.x+textWidth(el.text), el.text.y
+				}
+				if el.text.text != "" {
+					scan.texts = append(scan.texts, *el.text)
+				}
+			case len(el.points) > 0:
+				paths = append(paths, markedPath{el, int(d.InputOffset())})
+			}
+
+		case xml.CharData:
+			if parent.text != nil {
+				parent.text.text = collapseSpace(parent.text.text + " " + string(t))
+			}
+			if parent.name == "style" {
+				for _, m := range cssFillRe.FindAllStringSubmatch(string(t), -1) {
+					scan.fills[m[1]] = strings.TrimSpace(m[2])
+				}
+			}
+		}
+	}
+
+	for _, path := range paths {
+		scan.markers = append(scan.markers, path.el.markerUses(path.offset, markers)...)
+	}
+	return scan, nil
+}
+
+// child returns the state of an element inside el. fills holds the fill
+// colors of the CSS classes defined so far.
+func (el *rasterElement) child(t xml.StartElement, fills map[string]string) *rasterElement {
+	c := *el
+	c.name = t.Name.Local
+	c.text, c.markers, c.points = el.text, nil, nil
+	c.hidden = el.hidden || hiddenElements[c.name]
+	if c.name == "svg" {
+		c.svgDepth++
+	}
+	classes := strings.Fields(attr(t, "class"))
+	if len(classes) > 0 {
+		c.classes = classes
+	}
+
+	props := make(map[string]string)
+	for _, a := range t.Attr {
+		props[a.Name.Local] = a.Value
+	}
+	for _, decl := range strings.Split(props["style"], ";") {
+		if name, value, ok := strings.Cut(decl, ":"); ok {
+			props[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if v, ok := props["transform"]; ok && c.name != "svg" {
+		c.transform = parseTransform(c.transform, v)
+	}
+	for _, class := range classes {
+		if fill, ok := fills[class]; ok {
+			c.fill = fill
+		}
+	}
+	if v := props["fill"]; v != "" {
+		c.fill = v
+	}
+	for _, name := range []string{"opacity", "fill-opacity"} {
+		if v, err := strconv.ParseFloat(props[name], 64); err == nil {
+			c.opacity *= v
+		}
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSuffix(props["font-size"], "px"), 64); err == nil {
+		c.size = v
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSuffix(props["letter-spacing"], "px"), 64); err == nil {
+		c.letterSpacing = v
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSuffix(props["stroke-width"], "px"), 64); err == nil {
+		c.stroke = v
+	}
+	if v := props["font-weight"]; v != "" {
+		c.weight = v
+	}
+	if v := props["font-style"]; v != "" {
+		c.style = v
+	}
+	if v := props["text-anchor"]; v != "" {
+		c.anchor = v
+	}
+	for _, name := range []string{"marker-start", "marker-end"} {
+		c.markers = append(c.markers, markerID(props[name]))
+	}
+	if c.markers[0] == "" && c.markers[1] == "" {
+		c.markers = nil
+	}
+	return &c
+}
+
+// startText starts a line of text at a <text> or <tspan> element. A
+// <tspan> continues where the text before it ended unless it is moved.
+func (el *rasterElement) startText(t xml.StartElement, outer *textRun) {
+	run := &textRun{
+		anchor:        el.anchor,
+		size:          el.size,
+		letterSpacing: el.letterSpacing,
+		fill:          el.fill,
+		opacity:       el.opacity,
+		transform:     el.transform,
+		outer:         el.svgDepth <= 1,
+	}
+	if outer != nil {
+		run.x, run.y = outer.x, outer.y
+	}
+	for _, class := range el.classes {
+		if strings.HasPrefix(class, "text") {
+			run.mono = run.mono || strings.Contains(class, "mono")
+			run.bold = run.bold || strings.Contains(class, "bold")
+			run.italic = run.italic || strings.Contains(class, "italic")
+		}
+	}
+	run.bold = run.bold || el.weight == "bold" || el.weight == "600" || el.weight == "700"
+	run.italic = run.italic || el.style == "italic"
+
+	for name, p := range map[string]*float64{"x": &run.x, "y": &run.y} {
+		if v, err := strconv.ParseFloat(firstNumber(attr(t, name)), 64); err == nil {
+			*p = v
+		}
+	}
+	for name, p := range map[string]*float64{"dx": &run.x, "dy": &run.y} {
+		if v, err := strconv.ParseFloat(firstNumber(attr(t, name)), 64); err == nil {
+			*p += v
+		}
+	}
+	el.text = run
+}
+
+// startPath records the points of a path or line with markers.
+func (el *rasterElement) startPath(t xml.StartElement) {
+	if el.markers == nil {
+		return
+	}
+	if el.name == "line" {
+		for _, names := range [][2]string{{"x1", "y1"}, {"x2", "y2"}} {
+			x, _ := strconv.ParseFloat(attr(t, names[0]), 64)
+			y, _ := strconv.ParseFloat(attr(t, names[1]), 64)
+			el.points = append(el.points, rasterPoint{x, y})
+		}
+		return
+	}
+	el.points = pathPoints(attr(t, "d"))
+}
+
+// markerUses places the path's markers at its ends, inserted at offset,
+// pointing the way the path runs there, as for orient="auto".
+func (el *rasterElement) markerUses(offset int, markers map[string]*markerDef) []markerUse {
+	var uses []markerUse
+	for i, id := range el.markers {
+		def := markers[id]
+		if def == nil {
+			continue
+		}
+		at, dir, ok := pathEnd(el.points, i == 1)
+		if !ok {
+			continue
+		}
+
+		scale := 1.0
+		if len(def.viewBox) == 4 && def.viewBox[2] > 0 && def.viewBox[3] > 0 {
+			scale = math.Min(def.width/def.viewBox[2], def.height/def.viewBox[3])
+		}
+		if def.strokeUnits && el.stroke > 0 {
+			scale *= el.stroke
+		}
+		uses = append(uses, markerUse{
+			offset: offset,
+			group: fmt.Sprintf(`<g transform="translate(%g %g) rotate(%g) scale(%g %g) translate(%g %g)">%s</g>`,
+				at.x, at.y, dir, scale, scale, -def.refX, -def.refY, def.content),
+		})
+	}
+	return uses
+}
+
+// pathEnd returns the start or end point of a path and the direction in
+// degrees the path runs there, from the first or last two distinct points.
+func pathEnd(points []rasterPoint, end bool) (at rasterPoint, dir float64, ok bool) {
+	if len(points) < 2 {
+		return at, 0, false
+	}
+	if end {
+		points = slices.Clone(points)
+		slices.Reverse(points)
+	}
+	at = points[0]
+	for _, p := range points[1:] {
+		if p == at {
+			continue
+		}
+		if end {
+			dir = math.Atan2(at.y-p.y, at.x-p.x)
+		} else {
+			dir = math.Atan2(p.y-at.y, p.x-at.x)
+		}
+		return at, dir * 180 / math.Pi, true
+	}
+	return at, 0, false
+}
+
+func newMarkerDef(t xml.StartElement) *markerDef {
+	number := func(name string, def float64) float64 {
+		if v, err := strconv.ParseFloat(attr(t, name), 64); err == nil {
+			return v
+		}
+		return def
+	}
+	def := &markerDef{
+		refX:        number("refX", 0),
+		refY:        number("refY", 0),
+		width:       number("markerWidth", 3),
+		height:      number("markerHeight", 3),
+		strokeUnits: attr(t, "markerUnits") != "userSpaceOnUse",
+	}
+	for _, field := range strings.FieldsFunc(attr(t, "viewBox"), isListSeparator) {
+		v, _ := strconv.ParseFloat(field, 64)
+		def.viewBox = append(def.viewBox, v)
+	}
+	return def
+}
+
+// withMarkers returns svg with the arrowheads inserted after their paths.
+func (s *rasterScan) withMarkers(svg []byte) []byte {
+	if len(s.markers) == 0 {
+		return svg
+	}
+	var result bytes.Buffer
+	last := 0
+	for _, use := range s.markers {
+		result.Write(svg[last:use.offset])
+		result.WriteString(use.group)
+		last = use.offset
+	}
+	result.Write(svg[last:])
+	return result.Bytes()
+}
+
+// drawTexts draws the text runs on img. inner maps D2's own <svg> to
+// pixels, outer the outermost one.
+func (s *rasterScan) drawTexts(img *image.RGBA, inner, outer rasterx.Matrix2D) error {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	filler := rasterx.NewFiller(width, height, scanner)
+
+	var glyphs truetype.GlyphBuf
+	for _, run := range s.texts {
+		clr := s.textColor(run)
+		if clr == nil {
+			continue
+		}
+		f, err := rasterFont(run.mono, run.bold, run.italic)
+		if err != nil {
+			return err
+		}
+
+		m := inner
+		if run.outer {
+			m = outer
+		}
+		adder := &rasterx.MatrixAdder{Adder: filler, M: m.Mult(run.transform)}
+		scale := fixed.Int26_6(run.size * 64)
+		x := run.x - anchorOffset(run, f)
+		prev, hasPrev := truetype.Index(0), false
+		for _, r := range run.text {
+			index := f.Index(r)
+			if hasPrev {
+				x += float64(f.Kern(scale, prev, index)) / 64
+			}
+			if err := glyphs.Load(f, scale, index, font.HintingNone); err == nil {
+				addGlyph(adder, &glyphs, x, run.y)
+			}
+			x += float64(f.HMetric(scale, index).AdvanceWidth)/64 + run.letterSpacing
+			prev, hasPrev = index, true
+		}
+		filler.SetColor(clr)
+		filler.Draw()
+		filler.Clear()
+	}
+	return nil
+}
+
+// textColor returns the color of a text run, or nil if it is not filled.
+func (s *rasterScan) textColor(run textRun) color.Color {
+	fill := run.fill
+	if fill == "" {
+		fill = "black"
+	}
+	if fill == "none" || run.opacity <= 0 {
+		return nil
+	}
+	c, err := oksvg.ParseSVGColor(fill)
+	if err != nil || c == nil {
+		return nil
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(float64(n.A) * math.Min(run.opacity, 1)))
+	return n
+}
+
+// anchorOffset returns how far left of its x a run starts.
+func anchorOffset(run textRun, f *truetype.Font) float64 {
+	switch run.anchor {
+	case "middle":
+		return textAdvance(run, f) / 2
+	case "end":
+		return textAdvance(run, f)
+	}
+	return 0
+}
+
+// textAdvance returns the width of a run in f.
+func textAdvance(run textRun, f *truetype.Font) float64 {
+	scale := fixed.Int26_6(run.size * 64)
+	width := 0.0
+	prev, hasPrev := truetype.Index(0), false
+	for _, r := range run.text {
+		index := f.Index(r)
+		if hasPrev {
+			width += float64(f.Kern(scale, prev, index)) / 64
+		}
+		width += float64(f.HMetric(scale, index).AdvanceWidth)/64 + run.letterSpacing
+		prev, hasPrev = index, true
+	}
+	return width
+}
+
+// textWidth returns the width of a run in its font, or 0 if the font
+// can't be loaded.
+func textWidth(run *textRun) float64 {
+	f, err := rasterFont(run.mono, run.bold, run.italic)
+	if err != nil {
+		return 0
+	}
+	return textAdvance(*run, f)
+}
+
+// addGlyph adds the outline of a loaded glyph with its origin at x, y.
+// TrueType outlines are quadratic curves whose consecutive off-curve points
+// have an implied on-curve point halfway between them.
+func addGlyph(adder rasterx.Adder, glyphs *truetype.GlyphBuf, x, y float64) {
+	point := func(p truetype.Point) fixed.Point26_6 {
+		return fixed.Point26_6{
+			X: fixed.Int26_6(x*64) + p.X,
+			Y: fixed.Int26_6(y*64) - p.Y,
+		}
+	}
+	mid := func(a, b fixed.Point26_6) fixed.Point26_6 {
+		return fixed.Point26_6{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2}
+	}
+	onCurve := func(p truetype.Point) bool { return p.Flags&1 != 0 }
+
+	start := 0
+	for _, end := range glyphs.Ends {
+		contour := glyphs.Points[start:end]
+		start = end
+		if len(contour) == 0 {
+			continue
+		}
+
+		// Start on an on-curve point, or halfway between two off-curve ones
+		first, rest := point(contour[0]), contour[1:]
+		if !onCurve(contour[0]) {
+			last := contour[len(contour)-1]
+			if onCurve(last) {
+				first, rest = point(last), contour[:len(contour)-1]
+			} else {
+				first, rest = mid(point(last), point(contour[0])), contour
+			}
+		}
+
+		adder.Start(first)
+		control, pendingControl := first, false
+		for _, p := range rest {
+			q := point(p)
+			switch {
+			case onCurve(p) && pendingControl:
+				adder.QuadBezier(control, q)
+				pendingControl = false
+			case onCurve(p):
+				adder.Line(q)
+			case pendingControl:
+				adder.QuadBezier(control, mid(control, q))
+				control = q
+			default:
+				control, pendingControl = q, true
+			}
+		}
+		if pendingControl {
+			adder.QuadBezier(control, first)
+		} else {
+			adder.Line(first)
+		}
+		adder.Stop(true)
+	}
+}
+
+var (
+	rasterFonts   = make(map[[3]bool]*truetype.Font)
+	rasterFontsMu sync.Mutex
+)
+
+// rasterFont returns D2's font for the given style: Source Sans Pro, or
+// Source Code Pro for monospace text. D2 has no bold italic, so bold text
+// is never italic.
+func rasterFont(mono, bold, italic bool) (*truetype.Font, error) {
+	key := [3]bool{mono, bold, italic}
+	rasterFontsMu.Lock()
+	defer rasterFontsMu.Unlock()
+	if f, ok := rasterFonts[key]; ok {
+		return f, nil
+	}
+
+	family := d2fonts.SourceSansPro
+	if mono {
+		family = d2fonts.SourceCodePro
+	}
+	style := d2fonts.FONT_STYLE_REGULAR
+	if bold {
+		style = d2fonts.FONT_STYLE_BOLD
+	} else if italic {
+		style = d2fonts.FONT_STYLE_ITALIC
+	}
+	f, err := truetype.Parse(d2fonts.FontFaces.Get(family.Font(0, style)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font %s %s: %w", family, style, err)
+	}
+	rasterFonts[key] = f
+	return f, nil
+}
+
+// pathCommandRe matches the commands and numbers of SVG path data.
+var pathCommandRe = regexp.MustCompile(`[MmLlHhVvCcSsQqTtAaZz]|[-+]?(?:\d*\.\d+|\d+\.?)(?:[eE][-+]?\d+)?`)
+
+// pathPoints returns the points of SVG path data in order, control points
+// included, so that the first two and last two distinct points give the
+// direction of the path at its ends.
+func pathPoints(d string) []rasterPoint {
+	var points []rasterPoint
+	var cur, start rasterPoint
+	cmd := byte('M')
+	var args []float64
+	params := map[byte]int{'M': 2, 'L': 2, 'T': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'A': 7, 'Z': 0}
+
+	flush := func() {
+		upper := cmd &^ 0x20
+		relative := cmd != upper
+		for len(args) >= params[upper] && params[upper] > 0 {
+			a := args[:params[upper]]
+			args = args[params[upper]:]
+			base := rasterPoint{}
+			if relative {
+				base = cur
+			}
+			pt := func(i int) rasterPoint { return rasterPoint{base.x + a[i], base.y + a[i+1]} }
+			switch upper {
+			case 'M':
+				cur = pt(0)
+				start = cur
+				points = append(points, cur)
+				if relative {
+					cmd = 'l'
+				} else {
+					cmd = 'L'
+				}
+			case 'L', 'T':
+				cur = pt(0)
+				points = append(points, cur)
+			case 'H':
+				cur = rasterPoint{base.x + a[0], cur.y}
+				points = append(points, cur)
+			case 'V':
+				cur = rasterPoint{cur.x, base.y + a[0]}
+				points = append(points, cur)
+			case 'C':
+				points = append(points, pt(0), pt(2), pt(4))
+				cur = pt(4)
+			case 'S', 'Q':
+				points = append(points, pt(0), pt(2))
+				cur = pt(2)
+			case 'A':
+				cur = pt(5)
+				points = append(points, cur)
+			}
+		}
+	}
+	for _, token := range pathCommandRe.FindAllString(d, -1) {
+		if c := token[0]; strings.ContainsRune("MmLlHhVvCcSsQqTtAaZz", rune(c)) {
+			flush()
+			cmd, args = c, nil
+			if cmd == 'Z' || cmd == 'z' {
+				cur = start
+				points = append(points, cur)
+			}
+			continue
+		}
+		v, _ := strconv.ParseFloat(token, 64)
+		args = append(args, v)
+		flush()
+	}
+	return points
+}
+
+// transformRe matches one function of a transform attribute.
+var transformRe = regexp.MustCompile(`(\w+)\s*\(([^)]*)\)`)
+
+// parseTransform applies an SVG transform attribute to m.
+func parseTransform(m rasterx.Matrix2D, value string) rasterx.Matrix2D {
+	for _, match := range transformRe.FindAllStringSubmatch(value, -1) {
+		var args []float64
+		for _, field := range strings.FieldsFunc(match[2], isListSeparator) {
+			v, _ := strconv.ParseFloat(field, 64)
+			args = append(args, v)
+		}
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+		switch match[1] {
+		case "matrix":
+			if len(args) == 6 {
+				m = m.Mult(rasterx.Matrix2D{A: args[0], B: args[1], C: args[2], D: args[3], E: args[4], F: args[5]})
+			}
+		case "translate":
+			m = m.Translate(arg(0, 0), arg(1, 0))
+		case "scale":
+			m = m.Scale(arg(0, 1), arg(1, arg(0, 1)))
+		case "rotate":
+			cx, cy := arg(1, 0), arg(2, 0)
+			m = m.Translate(cx, cy).Rotate(arg(0, 0)*math.Pi/180).Translate(-cx, -cy)
+		}
+	}
+	return m
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// firstNumber returns the first of a list of numbers.
+func firstNumber(list string) string {
+	fields := strings.FieldsFunc(list, isListSeparator)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// markerID returns the ID in a url(#id) reference.
+func markerID(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if !strings.HasPrefix(ref, "url(#") || !strings.HasSuffix(ref, ")") {
+		return ""
+	}
+	return ref[len("url(#") : len(ref)-1]
+}
+
+// collapseSpace trims text and collapses runs of whitespace, as SVG does.
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// attr returns the value of an element's attribute, or "".
+func attr(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -6,21 +6,31 @@ package render
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/chromedp/cdproto/emulation"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"oss.terrastruct.com/d2/d2ast"
+	"oss.terrastruct.com/d2/d2format"
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
 	"oss.terrastruct.com/d2/d2lib"
 	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/log"
-	"oss.terrastruct.com/d2/lib/textmeasure"
 
 	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/layout"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
 )
 
 // Format represents the output format for rendering.
@@ -28,9 +38,11 @@ type Format string
 
 // Supported output formats.
 const (
-	FormatSVG Format = "svg"
-	FormatPNG Format = "png"
-	FormatPDF Format = "pdf"
+	FormatSVG  Format = "svg"
+	FormatPNG  Format = "png"
+	FormatPDF  Format = "pdf"
+	FormatMD   Format = "md"   // SVG embedded in a Markdown document
+	FormatWebP Format = "webp" // Raster image, smaller than PNG for web pages
 )
 
 // Options configures the rendering behavior.
@@ -40,11 +52,17 @@ type Options struct {
 
 	// Theme ID (default: 0, which is the default D2 theme)
 	// Other themes: 1-8 are built-in D2 themes
+	// When 0, SVGRenderer uses the diagram's Config.Theme if it names a known
+	// theme, and source renders the theme-id set in the source's d2-config vars
 	ThemeID int64
 
 	// Dark mode (default: false)
 	DarkMode bool
 
+	// Dark theme ID used in dark mode (default: 0, the dark counterpart of ThemeID)
+	// Must be one of D2's dark themes (200-201)
+	DarkThemeID int64
+
 	// Sketch mode - hand-drawn appearance (default: false)
 	Sketch bool
 
@@ -58,10 +76,105 @@ type Options struct {
 	// Values > 1 produce larger output, < 1 produce smaller
 	Scale float64
 
-	// For PNG: pixel density / device scale factor (default: 3)
-	// Higher values produce sharper PNGs at larger file sizes
+	// For PNG and WebP: pixel density / device scale factor (default: 3)
+	// Higher values produce sharper images at larger file sizes
 	// Common values: 1 (standard), 2 (retina), 3-4 (high DPI)
 	PixelDensity int
+
+	// For PNG: how the SVG is rasterized (default: browser, using headless Chrome)
+	// RasterizerNative needs no browser but draws shapes and connections only
+	Rasterizer Rasterizer
+
+	// For PDF: standard page size to fit the diagram on (default: none)
+	// When empty, the page is sized to the diagram
+	PDFPageSize PageSize
+
+	// For PDF: use landscape orientation (default: false)
+	// Only applies when PDFPageSize is set
+	PDFLandscape bool
+
+	// Custom font files (TTF) for regular, bold, and italic text (default: D2's fonts)
+	// Styles that are unset or fail to load use the default font
+	FontRegular string
+	FontBold    string
+	FontItalic  string
+
+	// Embed the whole regular, bold, and italic fonts in the SVG (default: false)
+	// D2 embeds only the glyphs the diagram uses; whole fonts also cover the
+	// watermark and text edited into the SVG later, at a larger file size
+	EmbedFonts bool
+
+	// Embed icons and images referenced by http(s) URL in the SVG as data URIs (default: false)
+	// Makes the SVG display offline; icons that can't be fetched keep their URL
+	InlineIcons bool
+
+	// Maximum time for layout and rendering (default: 0, no limit)
+	// Exceeding it returns ErrTimeout
+	Timeout time.Duration
+
+	// Strip comments and redundant whitespace from the SVG (default: false)
+	// Only cosmetic changes are made; see MinifySVG
+	Minify bool
+
+	// Node ID to draw the neighborhood of (default: none, the whole diagram)
+	// Only nodes within FocusDepth edges of it, followed either way, are
+	// drawn, with the focus node highlighted; see ir.Diagram.Neighborhood
+	FocusNode string
+
+	// Number of edges from FocusNode to include (default: 0, the node alone)
+	FocusDepth int
+
+	// Node IDs to highlight (default: none)
+	// When set, all other nodes and the edges between them are dimmed
+	Highlight []string
+
+	// Wrap top-level nodes with tags in a generated container per tag (default: false)
+	// Only applies to diagrams rendered from the IR; see Node.Tags
+	GroupByTag bool
+
+	// Maximum label length in characters (default: 0, no limit)
+	// Longer node and edge labels end in "…", with the full text as a tooltip
+	MaxLabelLength int
+
+	// Wrap labels at word boundaries near this many characters (default: 0, no wrapping)
+	WrapLabels int
+
+	// Make node links clickable in SVGs rendered from the IR (default: false)
+	// Links in D2 source are always clickable; SVGRenderer drops them unless set
+	InteractiveLinks bool
+
+	// Open clicked links in a new browser tab (default: false)
+	OpenLinksInNewTab bool
+
+	// Sort nodes and edges by ID before generating D2 from the IR (default: false)
+	// Makes SVGRenderer output independent of declaration order; see ir.Diagram.SortStable
+	SortStable bool
+
+	// Add screen reader support to the SVG (default: false)
+	// The root <svg> gets role="img", an aria-label and a <title> and <desc>,
+	// and each shape gets a <title> with its label
+	Accessible bool
+
+	// Name of a layer, scenario, or step to render instead of the root board (default: root)
+	// Nested boards are searched depth-first; an unknown name is an error
+	Board string
+
+	// Box in pixels to scale the SVG into, preserving aspect ratio (default: 0, no limit)
+	// With both set, the SVG has the box's size with the diagram centered in it;
+	// Padding is part of the diagram being fitted
+	FitWidth  int
+	FitHeight int
+
+	// Text repeated diagonally across the SVG at low opacity, e.g. "DRAFT" (default: none)
+	Watermark string
+
+	// Draw a faint coordinate grid behind the diagram (default: false)
+	ShowGrid bool
+
+	// Place nodes at their positions in the IR instead of laying out the diagram (default: false)
+	// Every node needs a position; edges follow their points or run straight.
+	// Only applies to SVGRenderer
+	NoLayout bool
 }
 
 // DefaultOptions returns sensible default rendering options.
@@ -88,6 +201,8 @@ type Renderer interface {
 }
 
 // SVGRenderer renders diagrams to SVG format using D2's rendering engine.
+// It is safe for concurrent use: each render measures text and lays out the
+// diagram with its own state, and the renderer's Options are only read.
 type SVGRenderer struct {
 	Options Options
 }
@@ -107,7 +222,10 @@ func NewSVGRendererWithOptions(opts Options) *SVGRenderer {
 
 // PNGRenderer renders diagrams to PNG format using chromedp (headless Chrome).
 // This provides high-quality PNG output with proper font rendering.
-// Requires Chrome/Chromium to be installed on the system.
+// Requires Chrome/Chromium to be installed on the system, unless
+// Options.Rasterizer is RasterizerNative.
+// It is safe for concurrent use: each render starts its own headless Chrome,
+// so no browser or page is shared between calls and no page pool is needed.
 type PNGRenderer struct {
 	Options Options
 }
@@ -167,15 +285,48 @@ func (r *PDFRenderer) Render(ctx context.Context, diagram *ir.Diagram, w io.Writ
 
 // RenderToBytes renders the diagram and returns PDF as bytes.
 func (r *PDFRenderer) RenderToBytes(ctx context.Context, diagram *ir.Diagram) ([]byte, error) {
-	// First render to SVG
-	svgRenderer := NewSVGRendererWithOptions(r.Options)
-	svgBytes, err := svgRenderer.RenderToBytes(ctx, diagram)
+	// Start from the selected board
+	diagram, err := SelectBoard(diagram, r.Options.Board)
 	if err != nil {
-		return nil, fmt.Errorf("failed to render SVG for PDF conversion: %w", err)
+		return nil, err
+	}
+	svgOpts := r.Options
+	svgOpts.Board = ""
+
+	// First render each board to SVG
+	svgRenderer := NewSVGRendererWithOptions(svgOpts)
+	var pages [][]byte
+	for _, board := range pdfBoards(diagram) {
+		svgBytes, err := svgRenderer.RenderToBytes(ctx, board)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render SVG for PDF conversion: %w", err)
+		}
+		pages = append(pages, svgBytes)
 	}
 
-	// Convert SVG to PDF using headless Chrome
-	return SVGToPDF(ctx, svgBytes)
+	// Convert SVGs to PDF using headless Chrome, one board per page
+	return SVGPagesToPDF(ctx, pages, r.Options)
+}
+
+// pdfBoards returns the boards to print as PDF pages: the diagram followed by
+// its nested boards depth-first, skipping boards that only group other boards.
+func pdfBoards(diagram *ir.Diagram) []*ir.Diagram {
+	var boards []*ir.Diagram
+	var collect func(d *ir.Diagram)
+	collect = func(d *ir.Diagram) {
+		if !d.FolderOnly {
+			boards = append(boards, d)
+		}
+		for _, board := range d.Boards {
+			collect(board)
+		}
+	}
+	collect(diagram)
+
+	if len(boards) == 0 {
+		boards = append(boards, diagram)
+	}
+	return boards
 }
 
 // Render renders the diagram to PNG format.
@@ -197,19 +348,52 @@ func (r *PNGRenderer) RenderToBytes(ctx context.Context, diagram *ir.Diagram) ([
 		return nil, fmt.Errorf("failed to render SVG for PNG conversion: %w", err)
 	}
 
-	// Convert SVG to PNG using headless Chrome with specified pixel density
-	return SVGToPNG(ctx, svgBytes, r.Options.PixelDensity)
+	// Convert SVG to PNG with specified pixel density
+	if r.Options.Rasterizer == RasterizerNative {
+		return SVGToPNGNative(svgBytes, r.Options.PixelDensity)
+	}
+	return SVGToPNGScaled(ctx, svgBytes, r.Options.PixelDensity, r.Options.Scale)
 }
 
 // SVGToPNG converts SVG bytes to PNG using headless Chrome via chromedp.
 // This ensures proper font rendering since Chrome handles all fonts natively.
 // The pixelDensity parameter controls the device scale factor (2 = retina, 3 = higher DPI).
 func SVGToPNG(ctx context.Context, svgBytes []byte, pixelDensity int) ([]byte, error) {
+	return screenshotSVG(ctx, svgBytes, pixelDensity, 1, page.CaptureScreenshotFormatPng)
+}
+
+// SVGToPNGScaled converts SVG bytes to PNG like SVGToPNG, additionally
+// scaled by scale (1 for none). The PNG is the size of the SVG's viewBox
+// times pixelDensity and scale, rounded to whole pixels.
+func SVGToPNGScaled(ctx context.Context, svgBytes []byte, pixelDensity int, scale float64) ([]byte, error) {
+	return screenshotSVG(ctx, svgBytes, pixelDensity, scale, page.CaptureScreenshotFormatPng)
+}
+
+// SVGToWebP converts SVG bytes to WebP using headless Chrome, like SVGToPNG.
+// WebP files are usually much smaller than PNGs of the same diagram.
+func SVGToWebP(ctx context.Context, svgBytes []byte, pixelDensity int) ([]byte, error) {
+	return screenshotSVG(ctx, svgBytes, pixelDensity, 1, page.CaptureScreenshotFormatWebp)
+}
+
+// screenshotSVG draws SVG bytes in headless Chrome and captures them as an
+// image in the given format, at the highest quality. SVGs with a viewBox
+// are drawn at exactly their raster size (see rasterSize) at a device
+// scale factor of 1, so the image keeps their aspect ratio whatever the
+// density and scale; others are captured as a whole page at pixelDensity,
+// unscaled.
+func screenshotSVG(ctx context.Context, svgBytes []byte, pixelDensity int, scale float64, format page.CaptureScreenshotFormat) ([]byte, error) {
 	// Ensure minimum pixel density of 1
 	if pixelDensity < 1 {
 		pixelDensity = 1
 	}
 
+	width, height, sized := rasterSize(svgBytes, pixelDensity, scale)
+	deviceScale := pixelDensity
+	if sized {
+		svgBytes = withPixelSize(svgBytes, width, height)
+		deviceScale = 1
+	}
+
 	// Create a data URI for the SVG
 	dataURI := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svgBytes)
 
@@ -223,29 +407,49 @@ func SVGToPNG(ctx context.Context, svgBytes []byte, pixelDensity int) ([]byte, e
 		chromedp.Flag("disable-gpu", true),
 		chromedp.Flag("no-sandbox", true),
 		chromedp.Flag("disable-dev-shm-usage", true),
-		chromedp.Flag("force-device-scale-factor", fmt.Sprintf("%d", pixelDensity)),
+		chromedp.Flag("force-device-scale-factor", fmt.Sprintf("%d", deviceScale)),
 	)
 
-	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, opts...)
-	defer allocCancel()
-
-	// Create Chrome context
-	chromeCtx, chromeCancel := chromedp.NewContext(allocCtx)
+	name := "PNG"
+	if format == page.CaptureScreenshotFormatWebp {
+		name = "WebP"
+	}
+	chromeCtx, chromeCancel, err := startChrome(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s with Chrome: %w", name, err)
+	}
 	defer chromeCancel()
 
-	var pngBytes []byte
+	var imageBytes []byte
 
-	// Navigate to SVG data URI and capture screenshot
-	// Quality of 100 means PNG format (0-99 would be JPEG)
-	err := chromedp.Run(chromeCtx,
+	// Navigate to SVG data URI and capture the full page, like
+	// chromedp.FullScreenshot but in any format, or just the sized SVG
+	var actions []chromedp.Action
+	if sized {
+		actions = append(actions, emulation.SetDeviceMetricsOverride(int64(width), int64(height), 1, false))
+	}
+	actions = append(actions,
 		chromedp.Navigate(dataURI),
-		chromedp.FullScreenshot(&pngBytes, 100),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			capture := page.CaptureScreenshot().
+				WithCaptureBeyondViewport(true).
+				WithFromSurface(true).
+				WithFormat(format).
+				WithQuality(100)
+			if sized {
+				capture = capture.WithClip(&page.Viewport{Width: float64(width), Height: float64(height), Scale: 1})
+			}
+			var err error
+			imageBytes, err = capture.Do(ctx)
+			return err
+		}),
 	)
+	err = chromedp.Run(chromeCtx, actions...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to render PNG with Chrome: %w", err)
+		return nil, fmt.Errorf("failed to render %s with Chrome: %w", name, err)
 	}
 
-	return pngBytes, nil
+	return imageBytes, nil
 }
 
 // SVGToPDF converts SVG bytes to PDF using headless Chrome via chromedp.
@@ -267,17 +471,16 @@ func SVGToPDF(ctx context.Context, svgBytes []byte) ([]byte, error) {
 		chromedp.Flag("disable-dev-shm-usage", true),
 	)
 
-	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, opts...)
-	defer allocCancel()
-
-	// Create Chrome context
-	chromeCtx, chromeCancel := chromedp.NewContext(allocCtx)
+	chromeCtx, chromeCancel, err := startChrome(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render PDF with Chrome: %w", err)
+	}
 	defer chromeCancel()
 
 	var pdfBytes []byte
 
 	// Navigate to SVG data URI and print to PDF
-	err := chromedp.Run(chromeCtx,
+	err = chromedp.Run(chromeCtx,
 		chromedp.Navigate(dataURI),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			buf, _, err := page.PrintToPDF().
@@ -319,121 +522,485 @@ func (r *SVGRenderer) RenderToBytes(ctx context.Context, diagram *ir.Diagram) ([
 	discardLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	ctx = log.With(ctx, discardLogger)
 
+	// Render the selected board instead of the root
+	diagram, err := SelectBoard(diagram, r.Options.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	// Draw only the neighborhood of the focus node
+	if r.Options.FocusNode != "" {
+		diagram, err = focusDiagram(diagram, r.Options.FocusNode, r.Options.FocusDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Dim everything outside the highlighted nodes
+	if len(r.Options.Highlight) > 0 {
+		diagram = highlightDiagram(diagram, r.Options.Highlight)
+	}
+
+	// Cluster nodes by their first tag
+	if r.Options.GroupByTag {
+		diagram = groupByTag(diagram)
+	}
+
+	// Keep long labels from stretching the layout
+	if r.Options.MaxLabelLength > 0 || r.Options.WrapLabels > 0 {
+		diagram = limitLabels(diagram, r.Options.MaxLabelLength, r.Options.WrapLabels)
+	}
+
+	// Links only become anchors when asked for
+	if !r.Options.InteractiveLinks {
+		diagram = withoutLinks(diagram)
+	}
+
+	// Generate the same D2 however the diagram's elements were ordered
+	if r.Options.SortStable {
+		diagram = diagram.Clone()
+		diagram.SortStable()
+	}
+
 	// Convert IR to D2 source
 	d2Source := irToD2Source(diagram)
 
+	// Register custom fonts before creating the ruler so it can measure them
+	fontFamily := loadFontFamily(r.Options)
+
 	// Create text ruler for measurement
-	ruler, err := textmeasure.NewRuler()
+	ruler, err := newRuler()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create text ruler: %w", err)
 	}
 
-	// Create layout resolver
-	layoutResolver := func(engine string) (d2graph.LayoutGraph, error) {
-		return func(ctx context.Context, g *d2graph.Graph) error {
-			return d2dagrelayout.Layout(ctx, g, nil)
-		}, nil
+	// Create layout resolver, keeping the diagram's own positions if asked
+	engine := dagreLayout
+	if r.Options.NoLayout {
+		if missing := unpositionedNodes(diagram); len(missing) > 0 {
+			return nil, fmt.Errorf("rendering without layout needs a position for every node; missing: %s", strings.Join(missing, ", "))
+		}
+		engine = fixedLayout(diagram)
+	}
+	engine = layout.WithMinSizes(diagram, engine)
+	layoutResolver := func(string) (d2graph.LayoutGraph, error) {
+		return engine, nil
 	}
 
 	// Compile options
 	compileOpts := &d2lib.CompileOptions{
 		Ruler:          ruler,
 		LayoutResolver: layoutResolver,
+		FontFamily:     fontFamily,
+	}
+
+	// Use the diagram's preferred theme unless the caller chose one explicitly
+	themeID := r.Options.ThemeID
+	if themeID == 0 && diagram.Config.Theme != "" {
+		if id, ok := ThemeIDByName(diagram.Config.Theme); ok {
+			themeID = id
+		}
 	}
 
 	// Render options
 	renderOpts := &d2svg.RenderOpts{
-		ThemeID: &r.Options.ThemeID,
+		ThemeID: &themeID,
 		Pad:     &r.Options.Padding,
 		Sketch:  &r.Options.Sketch,
 		Center:  &r.Options.Center,
 	}
 
 	if r.Options.DarkMode {
-		darkThemeID := r.Options.ThemeID + 100 // D2 dark themes are offset by 100
+		darkThemeID, err := resolveDarkThemeID(themeID, r.Options.DarkThemeID)
+		if err != nil {
+			return nil, err
+		}
 		renderOpts.ThemeID = &darkThemeID
 	}
 
-	// Compile the diagram
-	targetDiagram, _, err := d2lib.Compile(ctx, d2Source, compileOpts, renderOpts)
+	// Compile the diagram; edge routing fields are only for metadata renders
+	targetDiagram, err := compileWithTimeout(ctx, r.Options.Timeout, parser.Normalize(d2Source), compileOpts, renderOpts)
 	if err != nil {
-		return nil, fmt.Errorf("compilation failed: %w", err)
+		return nil, err
 	}
+	removeWeightEdges(targetDiagram)
 
 	// Render to SVG
-	svg, err := d2svg.Render(targetDiagram, renderOpts)
+	svg, err := renderSVG(targetDiagram, renderOpts, r.Options)
 	if err != nil {
 		return nil, fmt.Errorf("SVG rendering failed: %w", err)
 	}
-
-	return svg, nil
+	return postProcess(ctx, svg, r.Options), nil
 }
 
 // RenderFromSource renders D2 source directly to SVG.
-// This is more efficient when you have the original D2 source.
+// This is more efficient when you have the original D2 source. Unless
+// opts.ThemeID is set, the theme-id and dark-theme-id in the source's
+// d2-config vars are honored.
 func RenderFromSource(ctx context.Context, source string, opts Options) ([]byte, error) {
+	targetDiagram, renderOpts, err := compileSource(ctx, source, opts, dagreLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render
+	svg, err := renderSVG(targetDiagram, renderOpts, opts)
+	if err != nil {
+		return nil, fmt.Errorf("SVG rendering failed: %w", err)
+	}
+	return postProcess(ctx, svg, opts), nil
+}
+
+// RenderFromIR renders an IR diagram directly to SVG.
+// This is useful for tools that build diagrams programmatically.
+func RenderFromIR(ctx context.Context, diagram *ir.Diagram, opts Options) ([]byte, error) {
+	return NewSVGRendererWithOptions(opts).RenderToBytes(ctx, diagram)
+}
+
+// RenderBoardsFromSource renders each board in the D2 source to its own SVG:
+// the root board first, then its layers, scenarios, and steps depth-first.
+// Boards that only group other boards are skipped.
+func RenderBoardsFromSource(ctx context.Context, source string, opts Options) ([][]byte, error) {
+	targetDiagram, renderOpts, err := compileSource(ctx, source, opts, dagreLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages [][]byte
+	icons := make(iconCache) // Boards share icons
+	var renderBoard func(board *d2target.Diagram) error
+	renderBoard = func(board *d2target.Diagram) error {
+		if !board.IsFolderOnly {
+			svg, err := renderSVG(board, renderOpts, opts)
+			if err != nil {
+				return fmt.Errorf("SVG rendering failed for board %q: %w", board.Name, err)
+			}
+			if opts.InlineIcons {
+				svg = inlineIcons(ctx, svg, icons)
+			}
+			pages = append(pages, svg)
+		}
+		for _, boards := range [][]*d2target.Diagram{board.Layers, board.Scenarios, board.Steps} {
+			for _, child := range boards {
+				if err := renderBoard(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := renderBoard(targetDiagram); err != nil {
+		return nil, err
+	}
+
+	// Always produce at least one page, even for an empty diagram
+	if len(pages) == 0 {
+		svg, err := renderSVG(targetDiagram, renderOpts, opts)
+		if err != nil {
+			return nil, fmt.Errorf("SVG rendering failed: %w", err)
+		}
+		pages = append(pages, svg)
+	}
+	return pages, nil
+}
+
+// renderSVG renders a compiled board to SVG, with its label as the SVG title.
+func renderSVG(board *d2target.Diagram, renderOpts *d2svg.RenderOpts, opts Options) ([]byte, error) {
+	svg, err := d2svg.Render(board, renderOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FitWidth > 0 || opts.FitHeight > 0 {
+		svg = fitSVG(svg, opts.FitWidth, opts.FitHeight)
+	}
+	if opts.ShowGrid {
+		svg = withGrid(svg)
+	}
+	if opts.Watermark != "" {
+		svg = withWatermark(svg, opts.Watermark)
+	}
+	if opts.EmbedFonts {
+		svg = withEmbeddedFonts(svg, loadFontFamily(opts))
+	}
+	if opts.Accessible {
+		return makeAccessible(svg, board), nil
+	}
+	return withTitle(svg, board.Root.Label), nil
+}
+
+// postProcess applies the options that rewrite a rendered diagram's SVG:
+// inlining icons, opening links in a new tab, and minifying. Every render of
+// a single SVG goes through it; PDF pages only get their icons inlined.
+func postProcess(ctx context.Context, svg []byte, opts Options) []byte {
+	if opts.InlineIcons {
+		svg = inlineIcons(ctx, svg, make(iconCache))
+	}
+	if opts.OpenLinksInNewTab {
+		svg = openLinksInNewTab(svg)
+	}
+	if opts.Minify {
+		svg = MinifySVG(svg)
+	}
+	return svg
+}
+
+// dagreLayout lays out a graph with the dagre engine.
+func dagreLayout(ctx context.Context, g *d2graph.Graph) error {
+	return d2dagrelayout.Layout(ctx, g, nil)
+}
+
+// compileSource compiles D2 source and lays it out with layout, returning the
+// target diagram and the SVG render options derived from opts.
+func compileSource(ctx context.Context, source string, opts Options, layout d2graph.LayoutGraph) (*d2target.Diagram, *d2svg.RenderOpts, error) {
 	// Add a discarding logger to context to suppress D2 warnings
 	discardLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	ctx = log.With(ctx, discardLogger)
 
+	// Register custom fonts before creating the ruler so it can measure them
+	fontFamily := loadFontFamily(opts)
+
 	// Create text ruler for measurement
-	ruler, err := textmeasure.NewRuler()
+	ruler, err := newRuler()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create text ruler: %w", err)
+		return nil, nil, fmt.Errorf("failed to create text ruler: %w", err)
 	}
 
 	// Create layout resolver
 	layoutResolver := func(engine string) (d2graph.LayoutGraph, error) {
-		return func(ctx context.Context, g *d2graph.Graph) error {
-			return d2dagrelayout.Layout(ctx, g, nil)
-		}, nil
+		return layout, nil
 	}
 
 	// Compile options
 	compileOpts := &d2lib.CompileOptions{
 		Ruler:          ruler,
 		LayoutResolver: layoutResolver,
+		FontFamily:     fontFamily,
 	}
 
-	// Render options
+	// Render options. Without a theme of its own, D2 takes the theme from
+	// the source's d2-config vars, or its default theme.
 	renderOpts := &d2svg.RenderOpts{
-		ThemeID: &opts.ThemeID,
-		Pad:     &opts.Padding,
-		Sketch:  &opts.Sketch,
-		Center:  &opts.Center,
+		Pad:    &opts.Padding,
+		Sketch: &opts.Sketch,
+		Center: &opts.Center,
+	}
+	if opts.ThemeID != 0 {
+		renderOpts.ThemeID = &opts.ThemeID
 	}
 
-	if opts.DarkMode {
-		darkThemeID := opts.ThemeID + 100
+	// In dark mode, a theme chosen in opts picks the dark theme before
+	// compiling; otherwise the source's themes pick it once they are read
+	darkFromSource := opts.DarkMode && opts.ThemeID == 0 && opts.DarkThemeID == 0
+	if opts.DarkMode && !darkFromSource {
+		darkThemeID, err := resolveDarkThemeID(opts.ThemeID, opts.DarkThemeID)
+		if err != nil {
+			return nil, nil, err
+		}
 		renderOpts.ThemeID = &darkThemeID
 	}
 
-	// Compile
-	targetDiagram, _, err := d2lib.Compile(ctx, source, compileOpts, renderOpts)
+	// Compile, accepting shape aliases and tags like the parser
+	targetDiagram, err := compileWithTimeout(ctx, opts.Timeout, parser.Normalize(source), compileOpts, renderOpts)
 	if err != nil {
-		return nil, fmt.Errorf("compilation failed: %w", err)
+		return nil, nil, err
 	}
 
-	// Render
-	svg, err := d2svg.Render(targetDiagram, renderOpts)
+	if darkFromSource {
+		var darkThemeID int64
+		if renderOpts.DarkThemeID != nil {
+			darkThemeID = *renderOpts.DarkThemeID
+		}
+		darkThemeID, err = resolveDarkThemeID(*renderOpts.ThemeID, darkThemeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		renderOpts.ThemeID = &darkThemeID
+	}
+
+	// Render the selected board instead of the root
+	if opts.Board != "" {
+		board := findBoard(targetDiagram, opts.Board)
+		if board == nil {
+			return nil, nil, unknownBoardError(opts.Board, boardNames(targetDiagram))
+		}
+		targetDiagram = board
+	}
+
+	return targetDiagram, renderOpts, nil
+}
+
+// SelectBoard returns the nested board of diagram with the given name, or
+// diagram itself if name is empty. Unknown names are an error listing the
+// available boards.
+func SelectBoard(diagram *ir.Diagram, name string) (*ir.Diagram, error) {
+	if name == "" {
+		return diagram, nil
+	}
+	board := diagram.Board(name)
+	if board == nil {
+		return nil, unknownBoardError(name, diagram.BoardNames())
+	}
+	return board, nil
+}
+
+// findBoard returns the nested board of a compiled diagram with the given
+// name, searching depth-first, or nil if there is none.
+func findBoard(diagram *d2target.Diagram, name string) *d2target.Diagram {
+	for _, boards := range [][]*d2target.Diagram{diagram.Layers, diagram.Scenarios, diagram.Steps} {
+		for _, board := range boards {
+			if board.Name == name {
+				return board
+			}
+			if found := findBoard(board, name); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// boardNames returns the names of all nested boards of a compiled diagram,
+// depth-first.
+func boardNames(diagram *d2target.Diagram) []string {
+	var names []string
+	for _, boards := range [][]*d2target.Diagram{diagram.Layers, diagram.Scenarios, diagram.Steps} {
+		for _, board := range boards {
+			names = append(names, board.Name)
+			names = append(names, boardNames(board)...)
+		}
+	}
+	return names
+}
+
+// unknownBoardError reports a board name that is not in the diagram.
+func unknownBoardError(name string, available []string) error {
+	if len(available) == 0 {
+		return fmt.Errorf("unknown board %q: the diagram has no layers, scenarios, or steps", name)
+	}
+	return fmt.Errorf("unknown board %q (available: %s)", name, strings.Join(available, ", "))
+}
+
+// ErrTimeout is returned when layout and rendering exceed Options.Timeout
+// or the context deadline.
+var ErrTimeout = errors.New("rendering timed out")
+
+// compileWithTimeout compiles and lays out D2 source, giving up after timeout
+// (no limit if zero) or when ctx is done.
+func compileWithTimeout(ctx context.Context, timeout time.Duration, source string, compileOpts *d2lib.CompileOptions, renderOpts *d2svg.RenderOpts) (*d2target.Diagram, error) {
+	var targetDiagram *d2target.Diagram
+	err := withTimeout(ctx, timeout, func(ctx context.Context) error {
+		var err error
+		var g *d2graph.Graph
+		targetDiagram, g, err = d2lib.Compile(ctx, source, compileOpts, renderOpts)
+		if err != nil {
+			return fmt.Errorf("compilation failed: %w", err)
+		}
+		// Boards other than the root are already labeled with their name
+		targetDiagram.Root.Label = parser.Title(g)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("SVG rendering failed: %w", err)
+		return nil, err
+	}
+	return targetDiagram, nil
+}
+
+// withTimeout runs fn with a context bounded by timeout (no limit if zero).
+// fn runs in its own goroutine, so work that doesn't check ctx, such as a
+// stuck layout, can't block the caller past the deadline.
+func withTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return ctx.Err()
 	}
+}
 
-	return svg, nil
+// GenerateD2 converts an IR diagram, such as one loaded from JSON, to D2
+// source in the diagram's configured direction or down. Unlike the source
+// generated for rendering, it has no additions for layout, and labels and
+// tooltips are quoted where D2 needs it, so parsing it gives back the
+// diagram's nodes, edges, labels, classes and styles. Nested boards
+// (layers, scenarios and steps) are not written.
+func GenerateD2(diagram *ir.Diagram) string {
+	return writeDiagram(diagram, diagramDirection(diagram))
 }
 
-// irToD2Source converts an IR diagram to D2 source code for rendering.
+// irToD2Source converts an IR diagram to D2 source code for rendering, in
+// the diagram's configured direction or down.
 func irToD2Source(diagram *ir.Diagram) string {
-	return irToD2SourceWithDirection(diagram, "down")
+	return irToD2SourceWithDirection(diagram, diagramDirection(diagram))
 }
 
-// irToD2SourceWithDirection converts IR to D2 with a specified direction.
+// irToD2SourceWithDirection converts IR to D2 for rendering with a
+// specified direction. Minimum node sizes are left out, since D2 would take
+// them as exact sizes; layout.WithMinSizes applies them instead.
 func irToD2SourceWithDirection(diagram *ir.Diagram, direction string) string {
+	return writeDiagram(withoutMinSizes(diagram), direction) + writeWeightEdges(diagram)
+}
+
+// withoutMinSizes returns the diagram without minimum node sizes, cloning
+// it if any are set.
+func withoutMinSizes(diagram *ir.Diagram) *ir.Diagram {
+	if !slices.ContainsFunc(diagram.Nodes, func(n *ir.Node) bool { return n.MinWidth != 0 || n.MinHeight != 0 }) {
+		return diagram
+	}
+	result := diagram.Clone()
+	for _, node := range result.Nodes {
+		node.MinWidth, node.MinHeight = 0, 0
+	}
+	return result
+}
+
+// diagramDirection returns the diagram's configured direction, or down.
+func diagramDirection(diagram *ir.Diagram) string {
+	if d, ok := layout.ParseDirection(diagram.Config.Direction); ok {
+		return string(d)
+	}
+	return string(layout.DirectionDown)
+}
+
+// writeDiagram writes the D2 source for a diagram's nodes, edges, and
+// classes with the given direction.
+func writeDiagram(diagram *ir.Diagram, direction string) string {
 	var result string
 
+	// Comment lines from the top of the source file
+	if comment, ok := diagram.Metadata["comment"]; ok {
+		for _, line := range strings.Split(comment, "\n") {
+			result += writeComment(line) + "\n"
+		}
+		result += "\n"
+	}
+
 	// Add direction directive
-	result += fmt.Sprintf("direction: %s\n\n", direction)
+	result += fmt.Sprintf("direction: %s\n", direction)
+	if title := diagram.Metadata["title"]; title != "" {
+		result += fmt.Sprintf("label: %s\n", escapeText(title))
+	}
+	result += "\n"
+
+	// Class definitions, so nodes and edges can refer to them by name
+	result += writeClasses(diagram.Classes)
 
 	// Track containers
 	containers := make(map[string]bool)
@@ -444,9 +1011,10 @@ func irToD2SourceWithDirection(diagram *ir.Diagram, direction string) string {
 	}
 
 	// Write root-level nodes
+	written := make(map[*ir.Node]bool)
 	for _, node := range diagram.Nodes {
 		if node.Container == "" && node.GetParentID() == "" {
-			result += writeNode(node, diagram, containers, 0)
+			result += writeNode(node, diagram, containers, written, 0)
 		}
 	}
 
@@ -454,14 +1022,47 @@ func irToD2SourceWithDirection(diagram *ir.Diagram, direction string) string {
 
 	// Write edges
 	for _, edge := range diagram.Edges {
-		result += writeEdge(edge)
+		result += writeEdge(edge, diagram.Classes)
 	}
 
 	return result
 }
 
+// writeWeightEdges writes copies of weighted edges for layout. Dagre adds up
+// the weights of parallel edges, so the copies keep a weighted edge's
+// endpoints close. They come after the real edges so those keep their
+// indexes, and are removed after layout.
+func writeWeightEdges(diagram *ir.Diagram) string {
+	var result string
+	for _, edge := range diagram.Edges {
+		for i := 1; i < edge.LayoutWeight(); i++ {
+			result += fmt.Sprintf("%s %s %s: {class: %s}\n", edgeEndpoint(edge.Source, edge.SourcePort), edgeArrow(edge.Direction), edgeEndpoint(edge.Target, edge.TargetPort), weightClass)
+		}
+	}
+
+	return result
+}
+
+// weightClass marks the copies of weighted edges written for layout.
+const weightClass = "diagtool-weight"
+
+// removeWeightEdges removes the copies of weighted edges from a laid out
+// diagram, so that only the real edges are drawn.
+func removeWeightEdges(diagram *d2target.Diagram) {
+	diagram.Connections = slices.DeleteFunc(diagram.Connections, func(c d2target.Connection) bool {
+		return slices.Contains(c.Classes, weightClass)
+	})
+}
+
 // writeNode writes a node and its children to D2 format.
-func writeNode(node *ir.Node, diagram *ir.Diagram, containers map[string]bool, indent int) string {
+func writeNode(node *ir.Node, diagram *ir.Diagram, containers map[string]bool, written map[*ir.Node]bool, indent int) string {
+	// Each node is written once, even if malformed IR nests containers in
+	// a cycle
+	if written[node] {
+		return ""
+	}
+	written[node] = true
+
 	var result string
 	prefix := ""
 	for i := 0; i < indent; i++ {
@@ -479,61 +1080,232 @@ func writeNode(node *ir.Node, diagram *ir.Diagram, containers map[string]bool, i
 
 	// Node declaration
 	if node.Label != "" && node.Label != localID {
-		result += fmt.Sprintf("%s%s: %s", prefix, localID, node.Label)
+		result += fmt.Sprintf("%s%s: %s", prefix, localID, escapeText(node.Label))
 	} else {
 		result += fmt.Sprintf("%s%s", prefix, localID)
 	}
 
+	// Shape and styles the node's classes provide are not repeated
+	classRef := writeClassRef(node.Classes, diagram.Classes)
+	applied := diagram.Classes.Applied(node.Classes)
+	style := node.Style.Without(applied.Style)
+
 	// Check if container or has styling
 	isContainer := containers[node.ID]
-	hasShape := node.Shape != ir.ShapeRectangle && node.Shape != ir.ShapeContainer
-	hasStyle := hasNonDefaultStyle(node.Style)
+	hasShape := node.Shape != ir.ShapeRectangle && node.Shape != ir.ShapeContainer &&
+		shapeToD2(node.Shape) != applied.Shape
+	hasStyle := hasNonDefaultStyle(style)
+	tooltip, hasTooltip := node.Properties["tooltip"].(string)
+	link, hasLink := node.Properties["link"].(string)
+	minWidth, minHeight := node.MinSize()
+	hasSize := minWidth > 0 || minHeight > 0
+
+	// Comment on the node's line, after the opening brace if there is one
+	comment := ""
+	if text, ok := node.Properties["comment"].(string); ok {
+		comment = " " + writeComment(text)
+	}
 
-	if isContainer || hasShape || hasStyle {
-		result += " {\n"
+	if isContainer || classRef != "" || hasShape || hasStyle || hasTooltip || hasLink || hasSize || node.Near != "" {
+		result += " {" + comment + "\n"
+
+		// Classes
+		if classRef != "" {
+			result += fmt.Sprintf("%s  %s\n", prefix, classRef)
+		}
 
 		// Shape
 		if hasShape {
 			result += fmt.Sprintf("%s  shape: %s\n", prefix, shapeToD2(node.Shape))
 		}
 
+		// Minimum size
+		if minWidth > 0 {
+			result += fmt.Sprintf("%s  width: %d\n", prefix, minWidth)
+		}
+		if minHeight > 0 {
+			result += fmt.Sprintf("%s  height: %d\n", prefix, minHeight)
+		}
+
+		// Placement
+		if node.Near != "" {
+			result += fmt.Sprintf("%s  near: %s\n", prefix, node.Near)
+		}
+
+		// Tooltip and link
+		if hasTooltip {
+			result += fmt.Sprintf("%s  tooltip: %s\n", prefix, escapeText(tooltip))
+		}
+		if hasLink {
+			result += fmt.Sprintf("%s  link: %s\n", prefix, escapeText(link))
+		}
+
+		// Layout direction of the container's children
+		if isContainer && node.Direction != "" {
+			result += fmt.Sprintf("%s  direction: %s\n", prefix, node.Direction)
+		}
+
 		// Styling
 		if hasStyle {
-			result += writeStyle(node.Style, prefix+"  ")
+			result += writeStyle(style, prefix+"  ")
 		}
 
 		// Children
 		if isContainer {
 			children := diagram.GetNodesByContainer(node.ID)
 			for _, child := range children {
-				result += writeNode(child, diagram, containers, indent+1)
+				result += writeNode(child, diagram, containers, written, indent+1)
 			}
 		}
 
 		result += fmt.Sprintf("%s}\n", prefix)
 	} else {
-		result += "\n"
+		result += comment + "\n"
 	}
 
 	return result
 }
 
-// writeEdge writes an edge in D2 format.
-func writeEdge(edge *ir.Edge) string {
-	arrow := "->"
-	switch edge.Direction {
+// writeComment returns a single-line D2 comment with the given text.
+func writeComment(text string) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	if text == "" {
+		return "#"
+	}
+	return "# " + text
+}
+
+// edgeEndpoint returns the D2 key an edge connects to: the node, or with a
+// port, the port inside it (such as a SQL table column).
+func edgeEndpoint(id, port string) string {
+	if port == "" {
+		return id
+	}
+	return id + "." + port
+}
+
+// edgeArrow returns the D2 connection operator for an edge direction.
+func edgeArrow(direction ir.Direction) string {
+	switch direction {
 	case ir.DirectionBackward:
-		arrow = "<-"
+		return "<-"
 	case ir.DirectionBoth:
-		arrow = "<->"
+		return "<->"
 	case ir.DirectionNone:
-		arrow = "--"
+		return "--"
+	default:
+		return "->"
 	}
+}
+
+// curvedEdgeRadius is the bend rounding written for curved edges.
+const curvedEdgeRadius = 8
 
+// writeEdge writes an edge in D2 format. Styles provided by the edge's
+// classes are left to the class definitions.
+func writeEdge(edge *ir.Edge, classes ir.Classes) string {
+	result := fmt.Sprintf("%s %s %s", edgeEndpoint(edge.Source, edge.SourcePort), edgeArrow(edge.Direction), edgeEndpoint(edge.Target, edge.TargetPort))
 	if edge.Label != "" {
-		return fmt.Sprintf("%s %s %s: %s\n", edge.Source, arrow, edge.Target, edge.Label)
+		result += ": " + escapeText(edge.Label)
+	}
+
+	// D2 has no per-edge curve setting; rounded bends are the closest hint
+	classRef := writeClassRef(edge.Classes, classes)
+	style := edge.Style.Without(classes.Applied(edge.Classes).Style)
+	if edge.Curved && style.BorderRadius == 0 {
+		style.BorderRadius = curvedEdgeRadius
+	}
+
+	// Arrowhead shapes, tooltips, routing and styling need a block
+	hasStyle := hasNonDefaultStyle(style)
+	tooltip, hasTooltip := edge.Properties["tooltip"].(string)
+	hasRouting := edge.RoutingMode != "" && edge.RoutingMode != ir.RoutingDirect
+	if classRef == "" && edge.SourceArrowhead == "" && edge.TargetArrowhead == "" && !hasStyle && !hasTooltip && !hasRouting {
+		return result + "\n"
+	}
+	result += " {\n"
+	if classRef != "" {
+		result += "  " + classRef + "\n"
+	}
+	if hasRouting {
+		// D2 has no routing field; the parser reads the mode from the comment
+		result += fmt.Sprintf("  # diagtool: routing %s\n", edge.RoutingMode)
+	}
+	if hasTooltip {
+		result += fmt.Sprintf("  tooltip: %s\n", escapeText(tooltip))
+	}
+	if edge.SourceArrowhead != "" {
+		result += fmt.Sprintf("  source-arrowhead: {\n    shape: %s\n  }\n", edge.SourceArrowhead)
+	}
+	if edge.TargetArrowhead != "" {
+		result += fmt.Sprintf("  target-arrowhead: {\n    shape: %s\n  }\n", edge.TargetArrowhead)
+	}
+	if hasStyle {
+		result += writeStyle(style, "  ")
+	}
+	return result + "}\n"
+}
+
+// writeClasses writes a top-level classes block with the given class
+// definitions, sorted by name.
+func writeClasses(classes ir.Classes) string {
+	if len(classes) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := "classes: {\n"
+	for _, name := range names {
+		class := classes[name]
+		result += fmt.Sprintf("  %s: {\n", name)
+		if class.Shape != "" {
+			result += fmt.Sprintf("    shape: %s\n", class.Shape)
+		}
+		if hasNonDefaultStyle(class.Style) {
+			result += writeStyle(class.Style, "    ")
+		}
+		result += "  }\n"
+	}
+	return result + "}\n\n"
+}
+
+// writeClassRef returns the class line for an element using the named
+// classes, or "" if it uses none. Classes that are not defined have no
+// effect and are skipped.
+func writeClassRef(names []string, classes ir.Classes) string {
+	var known []string
+	for _, name := range names {
+		if _, ok := classes[name]; ok {
+			known = append(known, name)
+		}
 	}
-	return fmt.Sprintf("%s %s %s\n", edge.Source, arrow, edge.Target)
+	switch len(known) {
+	case 0:
+		return ""
+	case 1:
+		return "class: " + known[0]
+	default:
+		return "class: [" + strings.Join(known, "; ") + "]"
+	}
+}
+
+// escapeText returns a label or tooltip as a D2 string value: unquoted if
+// it reads back unchanged, otherwise quoted with D2's escapes. Text with
+// D2 metacharacters such as ";" or "#", line breaks or surrounding spaces
+// is quoted, so regenerated source parses back to the same text.
+func escapeText(text string) string {
+	s := d2ast.RawString(text, false)
+	// D2 reads an unquoted "a: b" value back as is, but quoting it keeps the
+	// source unambiguous to readers
+	if _, unquoted := s.(*d2ast.UnquotedString); unquoted && strings.Contains(text, ":") {
+		s = d2ast.FlatDoubleQuotedString(text)
+	}
+	return d2format.Format(s)
 }
 
 // shapeToD2 converts IR shape type to D2 shape string.
@@ -545,6 +1317,8 @@ func shapeToD2(shape ir.ShapeType) string {
 		return "cloud"
 	case ir.ShapeCylinder:
 		return "cylinder"
+	case ir.ShapeQueue:
+		return "queue"
 	case ir.ShapeCircle:
 		return "circle"
 	case ir.ShapeOval:
@@ -582,16 +1356,16 @@ func writeStyle(s ir.Style, prefix string) string {
 	result += prefix + "style: {\n"
 
 	if s.Fill != "" {
-		result += fmt.Sprintf("%s  fill: \"%s\"\n", prefix, s.Fill)
+		result += fmt.Sprintf("%s  fill: %s\n", prefix, formatColor(s.Fill))
 	}
 	if s.Stroke != "" {
-		result += fmt.Sprintf("%s  stroke: \"%s\"\n", prefix, s.Stroke)
+		result += fmt.Sprintf("%s  stroke: %s\n", prefix, formatColor(s.Stroke))
 	}
 	if s.StrokeWidth != 0 {
 		result += fmt.Sprintf("%s  stroke-width: %d\n", prefix, s.StrokeWidth)
 	}
 	if s.StrokeDash != 0 {
-		result += fmt.Sprintf("%s  stroke-dash: %d\n", prefix, s.StrokeDash)
+		result += fmt.Sprintf("%s  stroke-dash: %d\n", prefix, s.D2StrokeDash())
 	}
 	if s.BorderRadius != 0 {
 		result += fmt.Sprintf("%s  border-radius: %d\n", prefix, s.BorderRadius)
@@ -618,7 +1392,7 @@ func writeStyle(s ir.Style, prefix string) string {
 		result += fmt.Sprintf("%s  font-size: %d\n", prefix, s.FontSize)
 	}
 	if s.FontColor != "" {
-		result += fmt.Sprintf("%s  font-color: \"%s\"\n", prefix, s.FontColor)
+		result += fmt.Sprintf("%s  font-color: %s\n", prefix, formatColor(s.FontColor))
 	}
 	if s.Bold {
 		result += fmt.Sprintf("%s  bold: true\n", prefix)
@@ -636,3 +1410,18 @@ func writeStyle(s ir.Style, prefix string) string {
 	result += prefix + "}\n"
 	return result
 }
+
+// colorKeywordRe matches bare color keywords such as "red" or "transparent".
+var colorKeywordRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z-]*$`)
+
+// formatColor formats a color value for D2 source.
+// Color keywords are written bare. Everything else is quoted: hex colors
+// must be, since '#' starts a D2 comment, and gradients such as
+// "linear-gradient(#f69d3c, #3f87a6)" contain characters D2 only accepts
+// inside a string.
+func formatColor(value string) string {
+	if colorKeywordRe.MatchString(value) {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
@@ -3,12 +3,37 @@ package render
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/lib/textmeasure"
 
 	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/layout"
 	"github.com/mark/dsl-diagram-tool/pkg/parser"
 )
 
@@ -114,6 +139,44 @@ func TestNewPDFRendererWithOptions(t *testing.T) {
 	}
 }
 
+func TestSVGRenderer_NoLayout(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "vpc", Label: "VPC", Shape: ir.ShapeContainer, Position: &ir.Position{}},
+			{ID: "vpc.app", Label: "App", Shape: ir.ShapeRectangle, Container: "vpc", Width: 100, Height: 50,
+				Position: &ir.Position{X: 200, Y: 100}},
+			{ID: "user", Label: "User", Shape: ir.ShapeRectangle, Width: 80, Height: 40,
+				Position: &ir.Position{X: -100, Y: 400}},
+		},
+		Edges: []*ir.Edge{
+			{ID: "e1", Source: "user", Target: "vpc.app", Direction: ir.DirectionForward},
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.NoLayout = true
+	svg, err := RenderFromIR(context.Background(), diagram, opts)
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	for _, rect := range []string{
+		`<rect x="200.000000" y="100.000000" width="100.000000" height="50.000000"`,
+		`<rect x="-100.000000" y="400.000000" width="80.000000" height="40.000000"`,
+		// The container wraps its child
+		`<rect x="170.000000" y="40.000000" width="160.000000" height="140.000000"`,
+	} {
+		if !bytes.Contains(svg, []byte(rect)) {
+			t.Errorf("Expected %s in SVG", rect)
+		}
+	}
+
+	diagram.Nodes[2].Position = nil
+	if _, err := RenderFromIR(context.Background(), diagram, opts); err == nil || !strings.Contains(err.Error(), "missing: user") {
+		t.Errorf("Expected an error for the node without a position, got %v", err)
+	}
+}
+
 func TestSVGRenderer_RenderToBytes_Simple(t *testing.T) {
 	// Create a simple diagram
 	diagram := &ir.Diagram{
@@ -176,6 +239,279 @@ func TestSVGRenderer_Render_Writer(t *testing.T) {
 	}
 }
 
+func TestSVGRenderer_Concurrent(t *testing.T) {
+	const font = "testdata/fonts/SourceCodePro-Regular.ttf"
+	r := NewSVGRenderer()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			diagram := &ir.Diagram{
+				ID: "test",
+				Nodes: []*ir.Node{
+					{ID: "server", Label: fmt.Sprintf("Server %d", i), Shape: ir.ShapeRectangle},
+					{ID: "database", Label: "Database", Shape: ir.ShapeCylinder},
+				},
+				Edges: []*ir.Edge{
+					{ID: "e1", Source: "server", Target: "database", Direction: ir.DirectionForward},
+				},
+			}
+
+			// Some renders register custom fonts while others measure text
+			renderer := r
+			if i%4 == 0 {
+				opts := r.Options
+				opts.FontRegular = font
+				if i%8 == 0 {
+					opts.FontBold = font
+				}
+				renderer = NewSVGRendererWithOptions(opts)
+			}
+
+			svg, err := renderer.RenderToBytes(ctx, diagram)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Contains(svg, []byte(fmt.Sprintf("Server %d", i))) {
+				errs <- fmt.Errorf("render %d is missing its label", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPNGRenderer_Concurrent(t *testing.T) {
+	r, err := NewPNGRenderer()
+	if err != nil {
+		t.Fatalf("NewPNGRenderer failed: %v", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			diagram := &ir.Diagram{
+				ID:    "test",
+				Nodes: []*ir.Node{{ID: fmt.Sprintf("node%d", i), Shape: ir.ShapeRectangle}},
+			}
+			png, err := r.RenderToBytes(ctx, diagram)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.HasPrefix(png, []byte("\x89PNG")) {
+				errs <- fmt.Errorf("render %d is not a PNG", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if errors.Is(err, exec.ErrNotFound) {
+			t.Skipf("Chrome is not available: %v", err)
+		}
+		t.Error(err)
+	}
+}
+
+func TestSVGToWebP(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "server -> database", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+
+	data, err := SVGToWebP(context.Background(), svg, 1)
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("SVGToWebP failed: %v", err)
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Errorf("Expected WebP output, got %q", data[:min(len(data), 12)])
+	}
+}
+
+func TestRasterSize(t *testing.T) {
+	svg := []byte(`<?xml version="1.0" encoding="utf-8"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 255 434"><svg width="255" height="434"></svg></svg>`)
+	tests := []struct {
+		density       int
+		scale         float64
+		width, height int
+	}{
+		{1, 1, 255, 434},
+		{2, 1, 510, 868},
+		{3, 1, 765, 1302},
+		{3, 1.5, 1148, 1953}, // 1147.5 rounds up like 1953
+		{2, 0, 510, 868},     // Unset scale
+		{0, 1, 255, 434},     // Density below 1
+	}
+	for _, tt := range tests {
+		width, height, ok := rasterSize(svg, tt.density, tt.scale)
+		if !ok || width != tt.width || height != tt.height {
+			t.Errorf("rasterSize(density %d, scale %g) = %dx%d, %v, want %dx%d", tt.density, tt.scale, width, height, ok, tt.width, tt.height)
+		}
+	}
+
+	if _, _, ok := rasterSize([]byte(`<svg width="10" height="10"></svg>`), 2, 1); ok {
+		t.Error("Expected no raster size for an SVG without a viewBox")
+	}
+
+	sized := string(withPixelSize(svg, 510, 868))
+	if !strings.Contains(sized, `viewBox="0 0 255 434" width="510" height="868">`) {
+		t.Errorf("Expected the outer <svg> to be sized, got %s", sized)
+	}
+	if !strings.Contains(sized, `<svg width="255" height="434">`) {
+		t.Errorf("Expected the inner <svg> to keep its size, got %s", sized)
+	}
+}
+
+func TestSVGToPNG_ExactDimensions(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "server -> database", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	m := outerViewBoxRe.FindSubmatch(svg)
+	if m == nil {
+		t.Fatal("Expected a viewBox on the rendered SVG")
+	}
+	width, _ := strconv.Atoi(string(m[1]))
+	height, _ := strconv.Atoi(string(m[2]))
+
+	sizeOf := func(data []byte) (int, int) {
+		t.Helper()
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to decode PNG header: %v", err)
+		}
+		return config.Width, config.Height
+	}
+
+	for _, density := range []int{2, 3} {
+		data, err := SVGToPNGNative(svg, density)
+		if err != nil {
+			t.Fatalf("SVGToPNGNative failed: %v", err)
+		}
+		if w, h := sizeOf(data); w != width*density || h != height*density {
+			t.Errorf("Native PNG at density %d is %dx%d, want %dx%d", density, w, h, width*density, height*density)
+		}
+
+		data, err = SVGToPNG(context.Background(), svg, density)
+		if errors.Is(err, exec.ErrNotFound) {
+			t.Skipf("Chrome is not available: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("SVGToPNG failed: %v", err)
+		}
+		if w, h := sizeOf(data); w != width*density || h != height*density {
+			t.Errorf("PNG at density %d is %dx%d, want %dx%d", density, w, h, width*density, height*density)
+		}
+	}
+}
+
+func TestSVGToPNGNative_DrawsLabelsAndArrowheads(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "server: Web Server\nserver -> database", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	draw := func(svg []byte) image.Image {
+		t.Helper()
+		data, err := SVGToPNGNative(svg, 1)
+		if err != nil {
+			t.Fatalf("SVGToPNGNative failed: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to decode PNG: %v", err)
+		}
+		return img
+	}
+	// changed counts the pixels that differ between two drawings
+	changed := func(a, b image.Image) int {
+		n := 0
+		for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+			for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+				if a.At(x, y) != b.At(x, y) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	full := draw(svg)
+	withoutText := draw(regexp.MustCompile(`(?s)<text\b.*?</text>`).ReplaceAll(svg, nil))
+	if n := changed(full, withoutText); n < 100 {
+		t.Errorf("Expected the labels to draw text pixels, %d pixels differ", n)
+	}
+	withoutMarkers := draw(regexp.MustCompile(`marker-end="[^"]*"`).ReplaceAll(svg, nil))
+	if n := changed(full, withoutMarkers); n < 20 {
+		t.Errorf("Expected the connection to draw an arrowhead, %d pixels differ", n)
+	}
+}
+
+func TestPNGRenderer_Native(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Rasterizer = RasterizerNative
+	opts.PixelDensity = 2
+	r, err := NewPNGRendererWithOptions(opts)
+	if err != nil {
+		t.Fatalf("NewPNGRendererWithOptions failed: %v", err)
+	}
+
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "server", Label: "Server", Shape: ir.ShapeRectangle},
+			{ID: "db", Label: "Database", Shape: ir.ShapeCylinder},
+		},
+		Edges: []*ir.Edge{{ID: "e1", Source: "server", Target: "db", Direction: ir.DirectionForward}},
+	}
+	// Plain shapes and connections, without the Markdown, images or sketch
+	// mode ErrUnsupportedSVG is for, so any error is a failure
+	data, err := r.RenderToBytes(context.Background(), diagram)
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		t.Fatal("Expected PNG magic bytes")
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode PNG: %v", err)
+	}
+	if img.Bounds().Dx() < 200 || img.Bounds().Dy() < 200 {
+		t.Errorf("Expected a 2x raster of the diagram, got %v", img.Bounds())
+	}
+}
+
+func TestParseRasterizer(t *testing.T) {
+	for input, expected := range map[string]Rasterizer{"": "", "browser": RasterizerBrowser, "Native": RasterizerNative} {
+		if got, err := ParseRasterizer(input); err != nil || got != expected {
+			t.Errorf("ParseRasterizer(%q) = %q, %v, expected %q", input, got, err, expected)
+		}
+	}
+	if _, err := ParseRasterizer("cairo"); err == nil {
+		t.Error("Expected an unknown rasterizer to be rejected")
+	}
+}
+
 func TestRenderFromSource_Simple(t *testing.T) {
 	source := `
 server: Web Server
@@ -198,6 +534,40 @@ server -> database: SQL
 	}
 }
 
+func TestRenderFromIR_JSONRoundtrip(t *testing.T) {
+	original := &ir.Diagram{
+		ID: "roundtrip",
+		Nodes: []*ir.Node{
+			{ID: "web", Label: "Web Server", Shape: ir.ShapeRectangle},
+			{ID: "db", Label: "Database", Shape: ir.ShapeCylinder},
+		},
+		Edges: []*ir.Edge{
+			{ID: "web->db", Source: "web", Target: "db", Label: "SQL", Direction: ir.DirectionForward},
+		},
+	}
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var reloaded ir.Diagram
+	if err := json.Unmarshal(data, &reloaded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	svg, err := RenderFromIR(context.Background(), &reloaded, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
+	}
+	if !bytes.Contains(svg, []byte("Web Server")) {
+		t.Error("SVG doesn't contain 'Web Server'")
+	}
+}
+
 func TestRenderFromSource_WithContainers(t *testing.T) {
 	source := `
 aws: AWS Cloud {
@@ -279,413 +649,2464 @@ func TestRenderFromSource_DarkMode(t *testing.T) {
 	}
 }
 
-func TestRenderFromSource_CustomPadding(t *testing.T) {
-	source := `a -> b`
+func TestRenderFromSource_DarkTheme(t *testing.T) {
 	ctx := context.Background()
 	opts := DefaultOptions()
-	opts.Padding = 200
+	opts.DarkMode = true
+	opts.DarkThemeID = 201 // Dark Flagship Terrastruct
 
-	svg, err := RenderFromSource(ctx, source, opts)
+	explicit, err := RenderFromSource(ctx, `a -> b`, opts)
 	if err != nil {
-		t.Fatalf("RenderFromSource with custom padding failed: %v", err)
+		t.Fatalf("RenderFromSource with dark theme failed: %v", err)
 	}
 
-	if !bytes.Contains(svg, []byte("<svg")) {
-		t.Error("Output doesn't contain <svg tag")
+	opts.DarkThemeID = 0
+	fallback, err := RenderFromSource(ctx, `a -> b`, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource with dark mode failed: %v", err)
 	}
-}
 
-func TestIrToD2Source_Simple(t *testing.T) {
-	diagram := &ir.Diagram{
-		ID: "test",
-		Nodes: []*ir.Node{
-			{ID: "server", Label: "Web Server", Shape: ir.ShapeRectangle},
-			{ID: "database", Label: "Database", Shape: ir.ShapeCylinder},
-		},
-		Edges: []*ir.Edge{
-			{ID: "e1", Source: "server", Target: "database", Direction: ir.DirectionForward, Label: "SQL"},
-		},
+	if bytes.Equal(explicit, fallback) {
+		t.Error("Explicit dark theme should differ from the default dark theme")
 	}
 
-	source := irToD2Source(diagram)
-
-	if source == "" {
-		t.Error("Generated D2 source is empty")
-	}
-	if !strings.Contains(source, "direction: down") {
-		t.Error("Missing direction directive")
-	}
-	if !strings.Contains(source, "server") {
-		t.Error("Missing server node")
-	}
-	if !strings.Contains(source, "database") {
-		t.Error("Missing database node")
-	}
-	if !strings.Contains(source, "->") {
-		t.Error("Missing edge arrow")
+	// A light theme is rejected as a dark theme
+	opts.DarkThemeID = 3
+	if _, err := RenderFromSource(ctx, `a -> b`, opts); err == nil {
+		t.Error("Expected error for a non-dark DarkThemeID")
 	}
 }
 
-func TestIrToD2Source_WithContainers(t *testing.T) {
-	diagram := &ir.Diagram{
-		ID: "test",
-		Nodes: []*ir.Node{
-			{ID: "aws", Label: "AWS", Shape: ir.ShapeContainer},
-			{ID: "aws.server", Label: "Server", Shape: ir.ShapeRectangle, Container: "aws"},
-		},
-		Edges: []*ir.Edge{},
+func TestRenderFromSource_ConfigTheme(t *testing.T) {
+	ctx := context.Background()
+	fillRe := regexp.MustCompile(`\.fill-B1\{fill:(#[0-9A-Fa-f]+);\}`)
+	themeColor := func(source string, opts Options) string {
+		t.Helper()
+		svg, err := RenderFromSource(ctx, source, opts)
+		if err != nil {
+			t.Fatalf("RenderFromSource failed: %v", err)
+		}
+		m := fillRe.FindSubmatch(svg)
+		if m == nil {
+			t.Fatal("Expected a .fill-B1 rule in the SVG")
+		}
+		return string(m[1])
 	}
+	const declared = "vars: {d2-config: {theme-id: 6}}\na -> b"
 
-	source := irToD2Source(diagram)
-
-	if !strings.Contains(source, "aws") {
-		t.Error("Missing aws container")
+	opts := DefaultOptions()
+	opts.ThemeID = 6 // Grape Soda
+	grape := themeColor("a -> b", opts)
+	if got := themeColor(declared, DefaultOptions()); got != grape {
+		t.Errorf("Expected the theme declared in d2-config to be used, got %s, want %s", got, grape)
 	}
-	if !strings.Contains(source, "server") {
-		t.Error("Missing server node")
+	if themeColor("a -> b", DefaultOptions()) == grape {
+		t.Error("Expected the default theme to differ from the declared one")
 	}
-}
 
-func TestIrToD2Source_WithStyles(t *testing.T) {
-	diagram := &ir.Diagram{
-		ID: "test",
-		Nodes: []*ir.Node{
-			{
-				ID:    "styled",
-				Label: "Styled Node",
-				Shape: ir.ShapeRectangle,
-				Style: ir.Style{
-					Fill:      "#4CAF50",
-					Stroke:    "#2E7D32",
-					FontColor: "white",
-					Bold:      true,
-				},
-			},
-		},
-		Edges: []*ir.Edge{},
+	// A theme chosen in the options takes precedence
+	opts.ThemeID = 1
+	if themeColor(declared, opts) == grape {
+		t.Error("Expected ThemeID to override the declared theme")
 	}
 
-	source := irToD2Source(diagram)
-
-	if !strings.Contains(source, "style:") {
-		t.Error("Missing style block")
-	}
-	if !strings.Contains(source, "#4CAF50") {
-		t.Error("Missing fill color")
-	}
-	if !strings.Contains(source, "#2E7D32") {
-		t.Error("Missing stroke color")
-	}
-	if !strings.Contains(source, "bold: true") {
-		t.Error("Missing bold style")
+	// Dark mode uses the dark variant of the declared theme, if there is one
+	opts = DefaultOptions()
+	opts.DarkMode = true
+	const declaredDark = "vars: {d2-config: {theme-id: 101}}\na -> b"
+	opts.ThemeID = 101 // Orange Creamsicle, with Dark Flagship Terrastruct as its dark variant
+	want := themeColor("a -> b", opts)
+	opts.ThemeID = 0
+	if got := themeColor(declaredDark, opts); got != want {
+		t.Errorf("Expected the dark variant of the declared theme, got %s, want %s", got, want)
 	}
 }
 
-func TestIrToD2SourceWithDirection(t *testing.T) {
-	diagram := &ir.Diagram{
-		ID:    "test",
-		Nodes: []*ir.Node{{ID: "a", Shape: ir.ShapeRectangle}},
-	}
-
+func TestResolveDarkThemeID(t *testing.T) {
 	tests := []struct {
-		direction string
-		expected  string
+		name        string
+		themeID     int64
+		darkThemeID int64
+		want        int64
+		wantErr     bool
 	}{
-		{"down", "direction: down"},
-		{"up", "direction: up"},
-		{"right", "direction: right"},
-		{"left", "direction: left"},
+		{"explicit dark theme", 0, 201, 201, false},
+		{"explicit overrides counterpart", 100, 201, 201, false},
+		{"counterpart", 100, 0, 200, false},
+		{"no counterpart", 3, 0, DefaultDarkThemeID, false},
+		{"default theme", 0, 0, DefaultDarkThemeID, false},
+		{"light theme as dark", 0, 4, 0, true},
+		{"unknown theme", 0, 999, 0, true},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.direction, func(t *testing.T) {
-			source := irToD2SourceWithDirection(diagram, tt.direction)
-			if !strings.Contains(source, tt.expected) {
-				t.Errorf("Expected %q in source, got: %s", tt.expected, source)
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDarkThemeID(tt.themeID, tt.darkThemeID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveDarkThemeID(%d, %d) error = %v, wantErr %v", tt.themeID, tt.darkThemeID, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveDarkThemeID(%d, %d) = %d, expected %d", tt.themeID, tt.darkThemeID, got, tt.want)
 			}
 		})
 	}
 }
 
-func TestShapeToD2(t *testing.T) {
-	tests := []struct {
-		shape    ir.ShapeType
-		expected string
-	}{
-		{ir.ShapeRectangle, "rectangle"},
-		{ir.ShapePerson, "person"},
-		{ir.ShapeCloud, "cloud"},
-		{ir.ShapeCylinder, "cylinder"},
-		{ir.ShapeCircle, "circle"},
-		{ir.ShapeDiamond, "diamond"},
-		{ir.ShapeHexagon, "hexagon"},
-		{ir.ShapeSquare, "square"},
-		{ir.ShapeParallelogram, "parallelogram"},
-		{ir.ShapeSQLTable, "sql_table"},
-		{ir.ShapeClass, "class"},
+func TestWithTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	// A stub that waits for cancellation
+	err := withTimeout(ctx, 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout from a cancellation-aware stub, got: %v", err)
 	}
 
-	for _, tt := range tests {
-		t.Run(string(tt.shape), func(t *testing.T) {
-			result := shapeToD2(tt.shape)
-			if result != tt.expected {
-				t.Errorf("shapeToD2(%s) = %s, expected %s", tt.shape, result, tt.expected)
-			}
-		})
+	// A stub that ignores the context is abandoned at the deadline
+	block := make(chan struct{})
+	defer close(block)
+	err = withTimeout(ctx, 10*time.Millisecond, func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout from a stub ignoring the context, got: %v", err)
+	}
+
+	// Errors from work finishing in time are returned as is
+	want := errors.New("boom")
+	if err := withTimeout(ctx, time.Second, func(ctx context.Context) error { return want }); err != want {
+		t.Errorf("Expected stub error, got: %v", err)
 	}
 }
 
-func TestHasNonDefaultStyle(t *testing.T) {
-	tests := []struct {
-		name     string
-		style    ir.Style
-		expected bool
-	}{
-		{"empty style", ir.Style{}, false},
-		{"with fill", ir.Style{Fill: "#fff"}, true},
-		{"with stroke", ir.Style{Stroke: "#000"}, true},
-		{"with bold", ir.Style{Bold: true}, true},
-		{"with shadow", ir.Style{Shadow: true}, true},
-		{"with animated", ir.Style{Animated: true}, true},
+func TestRenderFromSource_Timeout(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Timeout = time.Nanosecond
+
+	_, err := RenderFromSource(context.Background(), "a -> b -> c", opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "rendering timed out") {
+		t.Errorf("Expected a clear timeout message, got: %v", err)
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := hasNonDefaultStyle(tt.style)
-			if result != tt.expected {
-				t.Errorf("hasNonDefaultStyle() = %v, expected %v", result, tt.expected)
-			}
-		})
+func TestRenderFromSource_Minify(t *testing.T) {
+	source := "server: Web Server\ndatabase: Database {shape: cylinder}\nserver -> database: SQL\nnotes: |md\n  # Notes\n  some `code`\n|"
+	ctx := context.Background()
+	opts := DefaultOptions()
+
+	full, err := RenderFromSource(ctx, source, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	opts.Minify = true
+	minified, err := RenderFromSource(ctx, source, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource with minify failed: %v", err)
+	}
+
+	if len(minified) >= len(full) {
+		t.Errorf("Minified SVG should be smaller: %d >= %d bytes", len(minified), len(full))
+	}
+	for _, want := range []string{"<svg", "Web Server", "Database", "SQL", "Notes"} {
+		if !bytes.Contains(minified, []byte(want)) {
+			t.Errorf("Minified SVG doesn't contain %q", want)
+		}
+	}
+
+	// Still well-formed XML
+	decoder := xml.NewDecoder(bytes.NewReader(minified))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Minified SVG is not valid XML: %v", err)
+		}
 	}
 }
 
-func TestWriteEdge(t *testing.T) {
+func TestMinifySVG(t *testing.T) {
 	tests := []struct {
 		name     string
-		edge     *ir.Edge
+		input    string
 		expected string
 	}{
-		{
-			"forward arrow",
-			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward},
-			"a -> b\n",
-		},
-		{
-			"backward arrow",
-			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionBackward},
-			"a <- b\n",
-		},
-		{
-			"bidirectional",
-			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionBoth},
-			"a <-> b\n",
-		},
-		{
-			"no arrow",
-			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionNone},
-			"a -- b\n",
-		},
-		{
-			"with label",
-			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, Label: "connects to"},
-			"a -> b: connects to\n",
-		},
+		{"indentation", "<svg>\n  <g>\n    <rect />\n  </g>\n</svg>", "<svg><g><rect/></g></svg>"},
+		{"comments", "<svg><!-- note --><g/></svg>", "<svg><g/></svg>"},
+		{"tag whitespace", "<rect  x=\"1\"\n   y=\"2\" />", "<rect x=\"1\" y=\"2\"/>"},
+		{"attribute values kept", "<text title=\"a   b\">x</text>", "<text title=\"a   b\">x</text>"},
+		{"text kept", "<text>a  b</text> <text>c</text>", "<text>a  b</text> <text>c</text>"},
+		{"css", "<style>\n.a {\n  fill: red; /* c */\n}\n.b, .c { content: \"  x  \"; }\n</style>", "<style>.a{fill: red;}.b,.c{content: \"  x  \";}</style>"},
+		{"pre kept", "<pre>line 1\n  line 2</pre>", "<pre>line 1\n  line 2</pre>"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := writeEdge(tt.edge)
-			if result != tt.expected {
-				t.Errorf("writeEdge() = %q, expected %q", result, tt.expected)
+			if got := string(MinifySVG([]byte(tt.input))); got != tt.expected {
+				t.Errorf("MinifySVG() = %q, expected %q", got, tt.expected)
 			}
 		})
 	}
 }
 
-// Integration test: Parse -> Render roundtrip
-func TestParseAndRender_Roundtrip(t *testing.T) {
-	source := `
-server: Web Server
-database: Database { shape: cylinder }
-cache: Cache { shape: circle }
+func TestRenderWithLayout(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultOptions()
+	source := "a: Alpha\nb: Beta\ngroup: {\n  c: Gamma\n}\na -> b: calls\nb -> group.c"
 
-server -> database: queries
-server -> cache: reads
-`
-	// Parse
-	p := parser.NewD2Parser()
-	diagram, err := p.Parse(source)
+	_, first, err := RenderWithLayout(ctx, source, opts, nil)
 	if err != nil {
-		t.Fatalf("Parse failed: %v", err)
+		t.Fatalf("RenderWithLayout failed: %v", err)
+	}
+	if first.Reused() {
+		t.Error("First render should run the layout engine")
 	}
 
-	// Render
-	r := NewSVGRenderer()
-	ctx := context.Background()
-	svg, err := r.RenderToBytes(ctx, diagram)
+	// A restyle reuses the layout and matches a full render
+	restyled := source + "\na.style.fill: \"#ff0000\"\n(a -> b)[0].style.stroke: \"#00ff00\""
+	svg, second, err := RenderWithLayout(ctx, restyled, opts, first)
 	if err != nil {
-		t.Fatalf("Render failed: %v", err)
+		t.Fatalf("RenderWithLayout failed: %v", err)
+	}
+	if !second.Reused() {
+		t.Error("Style-only change should reuse the layout")
+	}
+	full, err := RenderFromSource(ctx, restyled, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if !bytes.Equal(svg, full) {
+		t.Error("Reused layout should render the same SVG as a full layout")
 	}
 
-	// Verify SVG output
-	if !bytes.Contains(svg, []byte("<svg")) {
-		t.Error("Output doesn't contain <svg tag")
+	// A label that changes a shape's size is laid out again
+	relabeled := strings.Replace(source, "a: Alpha", "a: A much longer label", 1)
+	svg, third, err := RenderWithLayout(ctx, relabeled, opts, second)
+	if err != nil {
+		t.Fatalf("RenderWithLayout failed: %v", err)
 	}
-	if !bytes.Contains(svg, []byte("Web Server")) {
-		t.Error("SVG doesn't contain 'Web Server'")
+	if third.Reused() {
+		t.Error("Resized shape should run the layout engine")
 	}
-	if !bytes.Contains(svg, []byte("Database")) {
-		t.Error("SVG doesn't contain 'Database'")
+	full, err = RenderFromSource(ctx, relabeled, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if !bytes.Equal(svg, full) {
+		t.Error("Relaid-out diagram should match a full render")
 	}
 }
 
-// Test rendering example files
-func TestRender_ExampleFiles(t *testing.T) {
-	examplesDir := "../../examples"
+func TestRenderFromSource_CustomPadding(t *testing.T) {
+	source := `a -> b`
+	ctx := context.Background()
+	opts := DefaultOptions()
+	opts.Padding = 200
 
-	files, err := filepath.Glob(filepath.Join(examplesDir, "*.d2"))
+	svg, err := RenderFromSource(ctx, source, opts)
 	if err != nil {
-		t.Fatalf("Failed to glob examples: %v", err)
+		t.Fatalf("RenderFromSource with custom padding failed: %v", err)
 	}
 
-	if len(files) == 0 {
-		t.Skip("No example files found")
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
 	}
+}
 
+func TestRenderFromSource_Fit(t *testing.T) {
+	source := "a -> b -> c -> d"
 	ctx := context.Background()
-	opts := DefaultOptions()
 
-	for _, file := range files {
-		// Skip macOS metadata files
-		if strings.HasPrefix(filepath.Base(file), "._") {
-			continue
+	// svgSize returns the outer viewBox size and the inner <svg> size
+	sizeRe := regexp.MustCompile(`viewBox="0 0 ([0-9.]+) ([0-9.]+)".*?<svg[^>]* width="([0-9.]+)" height="([0-9.]+)"`)
+	svgSize := func(svg []byte) (boxW, boxH, w, h float64) {
+		m := sizeRe.FindSubmatch(svg)
+		if m == nil {
+			t.Fatalf("SVG size not found in:\n%.300s", svg)
 		}
-		t.Run(filepath.Base(file), func(t *testing.T) {
-			content, err := os.ReadFile(file)
-			if err != nil {
-				t.Fatalf("Failed to read file: %v", err)
-			}
+		var v [4]float64
+		for i := range v {
+			v[i], _ = strconv.ParseFloat(string(m[i+1]), 64)
+		}
+		return v[0], v[1], v[2], v[3]
+	}
 
-			svg, err := RenderFromSource(ctx, string(content), opts)
-			if err != nil {
-				t.Fatalf("Failed to render %s: %v", file, err)
-			}
+	original, err := RenderFromSource(ctx, source, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	_, _, origW, origH := svgSize(original)
 
-			if !bytes.Contains(svg, []byte("<svg")) {
-				t.Error("Output doesn't contain <svg tag")
-			}
+	opts := DefaultOptions()
+	opts.FitWidth, opts.FitHeight = 400, 400
+	svg, err := RenderFromSource(ctx, source, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource with fit box failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte(`width="400" height="400"`)) {
+		t.Errorf("Expected the SVG to be sized to the fit box:\n%.300s", svg)
+	}
 
-			t.Logf("Rendered %s: %d bytes SVG", filepath.Base(file), len(svg))
-		})
+	boxW, boxH, w, h := svgSize(svg)
+	if boxW != 400 || boxH != 400 || w > 400 || h > 400 {
+		t.Errorf("Expected the diagram to fit 400x400, got %vx%v in a %vx%v box", w, h, boxW, boxH)
+	}
+	if w != 400 && h != 400 {
+		t.Errorf("Expected the diagram to fill the box in one dimension, got %vx%v", w, h)
+	}
+	if ratio, origRatio := w/h, origW/origH; math.Abs(ratio-origRatio) > 0.01 {
+		t.Errorf("Expected aspect ratio %.3f to be preserved, got %.3f", origRatio, ratio)
 	}
 }
 
-// Benchmark tests
-func BenchmarkRenderFromSource_Simple(b *testing.B) {
-	source := `
-a -> b -> c -> d
-d -> e -> f
-`
+func TestParseFitBox(t *testing.T) {
+	if w, h, err := ParseFitBox("1920x1080"); err != nil || w != 1920 || h != 1080 {
+		t.Errorf("ParseFitBox(1920x1080) = %d, %d, %v", w, h, err)
+	}
+	if w, h, err := ParseFitBox(""); err != nil || w != 0 || h != 0 {
+		t.Errorf("ParseFitBox(\"\") = %d, %d, %v", w, h, err)
+	}
+	for _, input := range []string{"1920", "1920x", "0x100", "axb"} {
+		if _, _, err := ParseFitBox(input); err == nil {
+			t.Errorf("Expected ParseFitBox(%q) to fail", input)
+		}
+	}
+}
+
+func TestRenderFromSource_ShapeAliases(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "users: { shape: actor }\nstore: { shape: db }\nusers -> store", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource with shape aliases failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
+	}
+}
+
+func TestRenderFromSource_Overlays(t *testing.T) {
 	ctx := context.Background()
+	plain, err := RenderFromSource(ctx, "a -> b", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if bytes.Contains(plain, []byte("diagtool-grid")) || bytes.Contains(plain, []byte("diagtool-watermark")) {
+		t.Error("Expected no overlays by default")
+	}
+
 	opts := DefaultOptions()
+	opts.Watermark = "DRAFT & WIP"
+	opts.ShowGrid = true
+	svg, err := RenderFromSource(ctx, "a -> b", opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource with overlays failed: %v", err)
+	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = RenderFromSource(ctx, source, opts)
+	if n := bytes.Count(svg, []byte(">DRAFT &amp; WIP</text>")); n < 2 {
+		t.Errorf("Expected the watermark text repeated across the SVG, found %d times", n)
+	}
+	grid := regexp.MustCompile(`<g class="diagtool-grid"[^>]*><path d="M-?[0-9]+ -?[0-9.]+V`).Find(svg)
+	if grid == nil {
+		t.Error("Expected grid lines in the SVG")
+	}
+	// The grid is behind the diagram's shapes, the watermark in front of them
+	if bytes.Index(svg, []byte("diagtool-grid")) > bytes.Index(svg, []byte(`class="shape"`)) {
+		t.Error("Expected the grid before the diagram's shapes")
+	}
+	if bytes.Index(svg, []byte("diagtool-watermark")) < bytes.LastIndex(svg, []byte(`class="shape"`)) {
+		t.Error("Expected the watermark after the diagram's shapes")
+	}
+
+	if err := xml.Unmarshal(svg, new(interface{})); err != nil {
+		t.Errorf("SVG with overlays is not well-formed XML: %v", err)
 	}
 }
 
-func BenchmarkRenderFromSource_Complex(b *testing.B) {
-	source := `
-aws: AWS {
-  vpc: VPC {
-    web1: Web 1
-    web2: Web 2
-    app1: App 1
-    app2: App 2
-    db: Database { shape: cylinder }
-  }
+func TestRenderFromSource_CustomFont(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FontRegular = filepath.Join("..", "..", "testdata", "fonts", "SourceCodePro-Regular.ttf")
+
+	svg, err := RenderFromSource(context.Background(), "server -> database", opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource with custom font failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
+	}
+
+	// Text is measured with the monospaced test font rather than the default
+	family := loadFontFamily(opts)
+	if family == nil {
+		t.Fatal("Expected a custom font family")
+	}
+	ruler, err := textmeasure.NewRuler()
+	if err != nil {
+		t.Fatalf("NewRuler failed: %v", err)
+	}
+	custom, _ := ruler.Measure(family.Font(16, d2fonts.FONT_STYLE_REGULAR), "iiiiiiii")
+	standard, _ := ruler.Measure(d2fonts.SourceSansPro.Font(16, d2fonts.FONT_STYLE_REGULAR), "iiiiiiii")
+	if custom <= standard {
+		t.Errorf("Expected monospaced width > default width, got %d <= %d", custom, standard)
+	}
 }
-web1 -> app1
-web2 -> app2
-app1 -> db
-app2 -> db
-`
-	ctx := context.Background()
+
+func TestRenderFromSource_InvalidFontFallsBack(t *testing.T) {
 	opts := DefaultOptions()
+	opts.FontRegular = filepath.Join(t.TempDir(), "missing.ttf")
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = RenderFromSource(ctx, source, opts)
+	if family := loadFontFamily(opts); family != nil {
+		t.Errorf("Expected default fonts for a missing file, got family %s", *family)
+	}
+
+	svg, err := RenderFromSource(context.Background(), "server -> database", opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource should fall back to default fonts: %v", err)
+	}
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
 	}
 }
 
-func BenchmarkSVGRenderer_RenderToBytes(b *testing.B) {
+func TestIrToD2Source_Simple(t *testing.T) {
 	diagram := &ir.Diagram{
 		ID: "test",
 		Nodes: []*ir.Node{
-			{ID: "a", Label: "Node A", Shape: ir.ShapeRectangle},
-			{ID: "b", Label: "Node B", Shape: ir.ShapeRectangle},
-			{ID: "c", Label: "Node C", Shape: ir.ShapeRectangle},
+			{ID: "server", Label: "Web Server", Shape: ir.ShapeRectangle},
+			{ID: "database", Label: "Database", Shape: ir.ShapeCylinder},
 		},
 		Edges: []*ir.Edge{
-			{ID: "e1", Source: "a", Target: "b", Direction: ir.DirectionForward},
-			{ID: "e2", Source: "b", Target: "c", Direction: ir.DirectionForward},
+			{ID: "e1", Source: "server", Target: "database", Direction: ir.DirectionForward, Label: "SQL"},
+		},
+	}
+
+	source := irToD2Source(diagram)
+
+	if source == "" {
+		t.Error("Generated D2 source is empty")
+	}
+	if !strings.Contains(source, "direction: down") {
+		t.Error("Missing direction directive")
+	}
+	if !strings.Contains(source, "server") {
+		t.Error("Missing server node")
+	}
+	if !strings.Contains(source, "database") {
+		t.Error("Missing database node")
+	}
+	if !strings.Contains(source, "->") {
+		t.Error("Missing edge arrow")
+	}
+}
+
+func TestIrToD2Source_WithContainers(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "aws", Label: "AWS", Shape: ir.ShapeContainer},
+			{ID: "aws.server", Label: "Server", Shape: ir.ShapeRectangle, Container: "aws"},
+		},
+		Edges: []*ir.Edge{},
+	}
+
+	source := irToD2Source(diagram)
+
+	if !strings.Contains(source, "aws") {
+		t.Error("Missing aws container")
+	}
+	if !strings.Contains(source, "server") {
+		t.Error("Missing server node")
+	}
+}
+
+func TestIrToD2Source_WithStyles(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{
+				ID:    "styled",
+				Label: "Styled Node",
+				Shape: ir.ShapeRectangle,
+				Style: ir.Style{
+					Fill:      "#4CAF50",
+					Stroke:    "#2E7D32",
+					FontColor: "white",
+					Bold:      true,
+				},
+			},
+		},
+		Edges: []*ir.Edge{},
+	}
+
+	source := irToD2Source(diagram)
+
+	if !strings.Contains(source, "style:") {
+		t.Error("Missing style block")
+	}
+	if !strings.Contains(source, "#4CAF50") {
+		t.Error("Missing fill color")
+	}
+	if !strings.Contains(source, "#2E7D32") {
+		t.Error("Missing stroke color")
+	}
+	if !strings.Contains(source, "bold: true") {
+		t.Error("Missing bold style")
+	}
+}
+
+func TestIrToD2Source_Classes(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(ApplyC4Theme(`
+customer: Customer {
+  class: c4-person
+}
+banking: Banking System {
+  class: c4-system
+  style.bold: true
+}
+customer -> banking: Uses
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	source := irToD2Source(diagram)
+
+	if !strings.Contains(source, "classes: {") {
+		t.Errorf("Missing classes block:\n%s", source)
+	}
+	if !strings.Contains(source, "class: c4-system") || !strings.Contains(source, "class: c4-person") {
+		t.Errorf("Expected class references to survive:\n%s", source)
+	}
+	// The fill lives only in the class definition, not inlined on the node
+	if n := strings.Count(source, "#1168bd"); n != 1 {
+		t.Errorf("Expected c4-system fill once, in its class, got %d times:\n%s", n, source)
+	}
+	if !strings.Contains(source, "bold: true") {
+		t.Errorf("Expected style override outside the class to be kept:\n%s", source)
+	}
+
+	roundtrip, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	banking := roundtrip.GetNode("banking")
+	if banking == nil || banking.Style.Fill != "#1168bd" || !banking.Style.Bold {
+		t.Errorf("Expected banking to keep class fill and bold after roundtrip, got %+v", banking)
+	}
+}
+
+func TestIrToD2Source_Comments(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(`# Checkout flow
+api: API # public entry point
+aws: {
+  db: Database { # primary store
+    shape: cylinder
+  }
+}
+api -> aws.db
+`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	source := irToD2Source(diagram)
+	if !strings.HasPrefix(source, "# Checkout flow\n") {
+		t.Errorf("Expected file comment first:\n%s", source)
+	}
+
+	roundtrip, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	if got := roundtrip.Metadata["comment"]; got != "Checkout flow" {
+		t.Errorf("Expected file comment to survive roundtrip, got %q", got)
+	}
+	for id, want := range map[string]string{"api": "public entry point", "aws.db": "primary store"} {
+		node := roundtrip.GetNode(id)
+		if node == nil || node.Properties["comment"] != want {
+			t.Errorf("Expected %s to keep comment %q after roundtrip, got %+v\n%s", id, want, node, source)
+		}
+	}
+	if api := roundtrip.GetNode("api"); api == nil || api.Label != "API" {
+		t.Errorf("Expected comment to stay out of the label, got %+v", api)
+	}
+}
+
+func TestIrToD2SourceWithDirection(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID:    "test",
+		Nodes: []*ir.Node{{ID: "a", Shape: ir.ShapeRectangle}},
+	}
+
+	tests := []struct {
+		direction string
+		expected  string
+	}{
+		{"down", "direction: down"},
+		{"up", "direction: up"},
+		{"right", "direction: right"},
+		{"left", "direction: left"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.direction, func(t *testing.T) {
+			source := irToD2SourceWithDirection(diagram, tt.direction)
+			if !strings.Contains(source, tt.expected) {
+				t.Errorf("Expected %q in source, got: %s", tt.expected, source)
+			}
+		})
+	}
+}
+
+func TestIrToD2Source_ContainerDirection(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(`
+pipeline: {
+  direction: right
+  build -> test -> deploy
+}
+monitor -> pipeline
+`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if dir := diagram.GetNode("pipeline").Direction; dir != "right" {
+		t.Fatalf("Expected parsed container direction 'right', got '%s'", dir)
+	}
+
+	// Round-trip through D2 source
+	source := irToD2Source(diagram)
+	reparsed, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	if dir := reparsed.GetNode("pipeline").Direction; dir != "right" {
+		t.Errorf("Expected container direction 'right' after regeneration, got '%s'\n%s", dir, source)
+	}
+	if strings.Count(source, "direction: ") != 2 {
+		t.Errorf("Expected top-level and container directions, got:\n%s", source)
+	}
+}
+
+func TestIrToD2Source_Near(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(`
+server -> database
+legend: Legend { near: bottom-right }
+note: Primary store { near: database }
+`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if near := diagram.GetNode("legend").Near; near != "bottom-right" {
+		t.Fatalf("Expected parsed near 'bottom-right', got '%s'", near)
+	}
+	if near := diagram.GetNode("note").Near; near != "database" {
+		t.Fatalf("Expected parsed near 'database', got '%s'", near)
+	}
+
+	// Round-trip through D2 source
+	source := irToD2Source(diagram)
+	reparsed, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	if near := reparsed.GetNode("legend").Near; near != "bottom-right" {
+		t.Errorf("Expected near 'bottom-right' after regeneration, got '%s'\n%s", near, source)
+	}
+	if near := reparsed.GetNode("note").Near; near != "database" {
+		t.Errorf("Expected near 'database' after regeneration, got '%s'\n%s", near, source)
+	}
+	if reparsed.GetNode("server").Near != "" {
+		t.Error("Nodes without near should stay unplaced")
+	}
+}
+
+func TestGenerateD2_MinSize(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "api", Shape: ir.ShapeRectangle, MinWidth: 120.2},
+			{ID: "hub", Shape: ir.ShapeCircle, MinWidth: 60, MinHeight: 80},
+			{ID: "db", Shape: ir.ShapeCylinder},
+		},
+	}
+
+	source := GenerateD2(diagram)
+	for _, want := range []string{"api {\n  width: 121\n}", "width: 80\n  height: 80\n"} {
+		if !strings.Contains(source, want) {
+			t.Errorf("Expected %q in source:\n%s", want, source)
+		}
+	}
+	if strings.Count(source, "width:") != 2 {
+		t.Errorf("Expected no size for nodes without hints:\n%s", source)
+	}
+
+	// Round-trip through D2 source
+	reparsed, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	if api := reparsed.GetNode("api"); api.MinWidth != 121 || api.MinHeight != 0 {
+		t.Errorf("Expected api min size 121x0 after regeneration, got %vx%v", api.MinWidth, api.MinHeight)
+	}
+
+	// The source for rendering leaves them to layout
+	if source := irToD2Source(diagram); strings.Contains(source, "width:") {
+		t.Errorf("Expected no width in the source for rendering:\n%s", source)
+	}
+	if diagram.Nodes[0].MinWidth != 120.2 {
+		t.Error("Original diagram was modified")
+	}
+}
+
+func TestGenerateD2_TitleRoundTrip(t *testing.T) {
+	for _, title := range []string{"Checkout Flow", `Say "hi" to ${user}`, "Café — naïve\ttabs", "Costs $5: 100%"} {
+		diagram := &ir.Diagram{
+			ID:       "test",
+			Metadata: map[string]string{"title": title},
+			Nodes:    []*ir.Node{{ID: "a", Shape: ir.ShapeRectangle}},
+		}
+
+		source := GenerateD2(diagram)
+		reparsed, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+		}
+		if got := reparsed.Metadata["title"]; got != title {
+			t.Errorf("Expected title %q after regeneration, got %q\n%s", title, got, source)
+		}
+	}
+}
+
+func TestRenderFromIR_MinSizeKeepsLongLabel(t *testing.T) {
+	ctx := context.Background()
+	label := "A label much wider than the minimum width"
+	render := func(minWidth float64) string {
+		diagram := &ir.Diagram{
+			ID:    "test",
+			Nodes: []*ir.Node{{ID: "a", Label: label, Shape: ir.ShapeRectangle, MinWidth: minWidth}},
+		}
+		svg, err := RenderFromIR(ctx, diagram, DefaultOptions())
+		if err != nil {
+			t.Fatalf("RenderFromIR failed: %v", err)
+		}
+		return string(svg)
+	}
+
+	// A minimum smaller than the measured size changes nothing
+	if render(50) != render(0) {
+		t.Error("Expected a minimum below the measured width to leave the node as measured")
+	}
+	if wide := render(600); !strings.Contains(wide, `width="600.000000"`) {
+		t.Error("Expected a minimum above the measured width to widen the node")
+	}
+}
+
+func TestRenderFromIR_EdgeWeight(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID:    "test",
+		Nodes: []*ir.Node{{ID: "a", Shape: ir.ShapeRectangle}, {ID: "b", Shape: ir.ShapeRectangle}},
+		Edges: []*ir.Edge{{ID: "e1", Source: "a", Target: "b", Direction: ir.DirectionForward, Weight: 3}},
+	}
+
+	source := irToD2Source(diagram)
+	if n := strings.Count(source, "a -> b: {class: "+weightClass+"}"); n != 2 {
+		t.Errorf("Expected 2 weight copies of a -> b, got %d:\n%s", n, source)
+	}
+
+	svg, err := RenderFromIR(context.Background(), diagram, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	if n := strings.Count(string(svg), `class="connection stroke`); n != 1 {
+		t.Errorf("Expected only the real edge to be drawn, got %d connections", n)
+	}
+}
+
+func TestIrToD2Source_CurvedEdge(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse("a -> b -> c -> d\na -> d: skip")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Dagre curves the edge that spans several ranks
+	if err := layout.NewDagreLayout().Apply(context.Background(), diagram); err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+	var curved *ir.Edge
+	for _, edge := range diagram.Edges {
+		if edge.Label == "skip" {
+			curved = edge
+		}
+	}
+	if curved == nil || !curved.Curved {
+		t.Fatalf("Expected the a -> d edge to be curved after layout")
+	}
+
+	// Round-trip through D2 source
+	source := irToD2Source(diagram)
+	if !strings.Contains(source, "a -> d: skip {\n  style: {\n    border-radius: 8") {
+		t.Errorf("Expected a curve hint on a -> d, got:\n%s", source)
+	}
+	reparsed, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+	}
+	for _, edge := range reparsed.Edges {
+		if edge.Label == "skip" && !edge.Curved {
+			t.Errorf("Expected a -> d to stay curved after regeneration")
+		}
+	}
+}
+
+func TestShapeToD2(t *testing.T) {
+	tests := []struct {
+		shape    ir.ShapeType
+		expected string
+	}{
+		{ir.ShapeRectangle, "rectangle"},
+		{ir.ShapePerson, "person"},
+		{ir.ShapeCloud, "cloud"},
+		{ir.ShapeCylinder, "cylinder"},
+		{ir.ShapeCircle, "circle"},
+		{ir.ShapeDiamond, "diamond"},
+		{ir.ShapeHexagon, "hexagon"},
+		{ir.ShapeSquare, "square"},
+		{ir.ShapeParallelogram, "parallelogram"},
+		{ir.ShapeSQLTable, "sql_table"},
+		{ir.ShapeClass, "class"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.shape), func(t *testing.T) {
+			result := shapeToD2(tt.shape)
+			if result != tt.expected {
+				t.Errorf("shapeToD2(%s) = %s, expected %s", tt.shape, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHasNonDefaultStyle(t *testing.T) {
+	tests := []struct {
+		name     string
+		style    ir.Style
+		expected bool
+	}{
+		{"empty style", ir.Style{}, false},
+		{"with fill", ir.Style{Fill: "#fff"}, true},
+		{"with stroke", ir.Style{Stroke: "#000"}, true},
+		{"with bold", ir.Style{Bold: true}, true},
+		{"with shadow", ir.Style{Shadow: true}, true},
+		{"with animated", ir.Style{Animated: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := hasNonDefaultStyle(tt.style)
+			if result != tt.expected {
+				t.Errorf("hasNonDefaultStyle() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteEdge(t *testing.T) {
+	tests := []struct {
+		name     string
+		edge     *ir.Edge
+		expected string
+	}{
+		{
+			"forward arrow",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward},
+			"a -> b\n",
+		},
+		{
+			"backward arrow",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionBackward},
+			"a <- b\n",
+		},
+		{
+			"bidirectional",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionBoth},
+			"a <-> b\n",
+		},
+		{
+			"no arrow",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionNone},
+			"a -- b\n",
+		},
+		{
+			"with label",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, Label: "connects to"},
+			"a -> b: connects to\n",
+		},
+		{
+			"with ports",
+			&ir.Edge{Source: "users", SourcePort: "id", Target: "orders", TargetPort: "user_id", Direction: ir.DirectionForward},
+			"users.id -> orders.user_id\n",
+		},
+		{
+			"with arrowheads",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, SourceArrowhead: "cf-one", TargetArrowhead: "cf-many"},
+			"a -> b {\n  source-arrowhead: {\n    shape: cf-one\n  }\n  target-arrowhead: {\n    shape: cf-many\n  }\n}\n",
+		},
+		{
+			"with style",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, Style: ir.Style{Opacity: 0.25}},
+			"a -> b {\n  style: {\n    opacity: 0.25\n  }\n}\n",
+		},
+		{
+			"curved",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, Curved: true},
+			"a -> b {\n  style: {\n    border-radius: 8\n  }\n}\n",
+		},
+		{
+			"with multi-line label and tooltip",
+			&ir.Edge{Source: "a", Target: "b", Direction: ir.DirectionForward, Label: "two\nlines", Properties: map[string]interface{}{"tooltip": "full text"}},
+			"a -> b: \"two\\nlines\" {\n  tooltip: full text\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := writeEdge(tt.edge, nil)
+			if result != tt.expected {
+				t.Errorf("writeEdge() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEscapeText_RoundTrip(t *testing.T) {
+	texts := []string{
+		"plain label", "a; b", "say #hi", "two\nlines", " padded ", "{braces}", "[list]",
+		"pipe | here", `it's "quoted"`, "cost $5", "key: value", "null", "@import", `back\slash`,
+	}
+	diagram := &ir.Diagram{ID: "test"}
+	for i, text := range texts {
+		id := fmt.Sprintf("n%d", i)
+		diagram.Nodes = append(diagram.Nodes, &ir.Node{
+			ID: id, Label: text, Shape: ir.ShapeRectangle,
+			Properties: map[string]interface{}{"tooltip": text},
+		})
+		if i > 0 {
+			diagram.Edges = append(diagram.Edges, &ir.Edge{
+				ID: id, Source: "n0", Target: id, Direction: ir.DirectionForward, Label: text,
+			})
+		}
+	}
+
+	source := GenerateD2(diagram)
+	reparsed, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Generated source failed to parse: %v\n%s", err, source)
+	}
+	if len(reparsed.Nodes) != len(texts) || len(reparsed.Edges) != len(texts)-1 {
+		t.Fatalf("Expected %d nodes and %d edges, got %d and %d:\n%s", len(texts), len(texts)-1, len(reparsed.Nodes), len(reparsed.Edges), source)
+	}
+	for i, text := range texts {
+		node := reparsed.GetNode(fmt.Sprintf("n%d", i))
+		if node == nil || node.Label != text || node.Properties["tooltip"] != text {
+			t.Errorf("Expected label and tooltip %q to survive, got %+v", text, node)
+		}
+		if i > 0 && reparsed.Edges[i-1].Label != text {
+			t.Errorf("Expected edge label %q to survive, got %q", text, reparsed.Edges[i-1].Label)
+		}
+	}
+}
+
+func TestIrToD2Source_Arrowheads(t *testing.T) {
+	source := `a -> b { target-arrowhead: { shape: diamond } }`
+
+	diagram, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if got := diagram.Edges[0].TargetArrowhead; got != "diamond" {
+		t.Fatalf("TargetArrowhead = %q, expected %q", got, "diamond")
+	}
+
+	regenerated, err := parser.NewD2Parser().Parse(irToD2Source(diagram))
+	if err != nil {
+		t.Fatalf("Parse of regenerated source failed: %v", err)
+	}
+	edge := regenerated.Edges[0]
+	if edge.TargetArrowhead != "diamond" {
+		t.Errorf("TargetArrowhead after regeneration = %q, expected %q", edge.TargetArrowhead, "diamond")
+	}
+	if edge.SourceArrowhead != "" {
+		t.Errorf("SourceArrowhead after regeneration = %q, expected empty", edge.SourceArrowhead)
+	}
+}
+
+func TestHighlightDiagram(t *testing.T) {
+	diagram, err := parser.NewD2Parser().Parse("server -> database\ndatabase -> cache\ncache -> queue")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	result := highlightDiagram(diagram, []string{"server", "database"})
+
+	for _, node := range result.Nodes {
+		switch node.ID {
+		case "server", "database":
+			if node.Style.Opacity != 0 {
+				t.Errorf("Highlighted node %s should keep full opacity, got %v", node.ID, node.Style.Opacity)
+			}
+		default:
+			if node.Style.Opacity != dimmedOpacity {
+				t.Errorf("Node %s should be dimmed, got opacity %v", node.ID, node.Style.Opacity)
+			}
+		}
+	}
+
+	// Only the edge between two dimmed nodes is dimmed
+	for _, edge := range result.Edges {
+		dimmed := edge.Style.Opacity == dimmedOpacity
+		if expected := edge.Source == "cache"; dimmed != expected {
+			t.Errorf("Edge %s dimmed = %v, expected %v", edge.ID, dimmed, expected)
+		}
+	}
+
+	// The input diagram is left untouched
+	for _, node := range diagram.Nodes {
+		if node.Style.Opacity != 0 {
+			t.Errorf("Original node %s was modified", node.ID)
+		}
+	}
+
+	// Dimmed nodes carry an opacity style in the generated D2
+	source := irToD2Source(result)
+	if !strings.Contains(source, "cache {\n  style: {\n    opacity: 0.25") {
+		t.Errorf("Expected dimmed cache node in generated source:\n%s", source)
+	}
+	if strings.Contains(source, "server {") {
+		t.Errorf("Highlighted server node should have no style block:\n%s", source)
+	}
+}
+
+func TestFocusDiagram(t *testing.T) {
+	diagram, err := parser.NewD2Parser().Parse("a -> b\nb -> c\nc -> d")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	result, err := focusDiagram(diagram, "b", 1)
+	if err != nil {
+		t.Fatalf("focusDiagram failed: %v", err)
+	}
+	if len(result.Nodes) != 3 || result.GetNode("d") != nil || len(result.Edges) != 2 {
+		t.Errorf("Expected a, b and c with 2 edges, got %d nodes and %d edges", len(result.Nodes), len(result.Edges))
+	}
+	if width := result.GetNode("b").Style.StrokeWidth; width != focusStrokeWidth {
+		t.Errorf("Expected the focus node to have stroke width %d, got %d", focusStrokeWidth, width)
+	}
+	if diagram.GetNode("b").Style.StrokeWidth != 0 {
+		t.Error("Original focus node was modified")
+	}
+
+	if _, err := focusDiagram(diagram, "e", 1); err == nil {
+		t.Error("Expected an error for an unknown focus node")
+	}
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name, svg, want string
+	}{
+		{"script element", `<svg><script type="text/javascript">alert(1)</script><rect/></svg>`, `<svg><rect/></svg>`},
+		{"uppercase script", `<svg><SCRIPT>alert(1)</SCRIPT ></svg>`, `<svg/>`},
+		{"unknown element", `<svg><iframe src="x"><g/></iframe><set attributeName="href" to="javascript:a()"/></svg>`, `<svg/>`},
+		{"event attributes", `<g onclick="a()" class="shape" ONMOUSEOVER='b()'></g>`, `<g class="shape"/>`},
+		{"javascript link", `<a href=" java&#x09;script:a()" xlink:href="JavaScript:a()">x</a>`, `<a>x</a>`},
+		{"encoded javascript link", `<a href="&#106;avascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"prefixed attributes", `<a x:href="javascript:a()" xlink:href="#id" xmlns:x="http://www.w3.org/1999/xlink"></a>`, `<a xlink:href="#id" xmlns:x="http://www.w3.org/1999/xlink"></a>`},
+		{"safe link", `<a href="https://example.com/onclick=1">x</a>`, `<a href="https://example.com/onclick=1">x</a>`},
+		{"data image", `<img src="data:image/png;base64,AA==" />`, `<img src="data:image/png;base64,AA=="/>`},
+		{"data link", `<a href="data:text/html,&lt;script&gt;">x</a>`, `<a>x</a>`},
+		{"escaped text", `<text class="online">&lt;b onclick=x&gt;</text>`, `<text class="online">&lt;b onclick=x&gt;</text>`},
+		{"comments", `<?xml version="1.0"?><!-- c --><svg><style><![CDATA[a > b {}]]></style></svg>`, `<?xml version="1.0"?><svg><style><![CDATA[a > b {}]]></style></svg>`},
+		{"html elements", `<div><p></p><br/></div>`, `<div><p></p><br/></div>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeSVG([]byte(tt.svg))
+			if err != nil {
+				t.Fatalf("SanitizeSVG(%q) failed: %v", tt.svg, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("SanitizeSVG(%q) = %q, want %q", tt.svg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVG_Bypasses(t *testing.T) {
+	// Markup a regular expression sanitizer lets through. Malformed markup
+	// is rejected, anything else must come out without script.
+	payloads := []string{
+		`<svg/onload=alert(1)>`,
+		`<svg><scr<script></script>ipt>alert(1)</script></svg>`,
+		`<svg><a href="&#106;avascript:alert(1)">x</a></svg>`,
+		`<svg><a href="&#x6A;avascript:alert(1)">x</a></svg>`,
+		`<svg><g></svg></g>`,
+		`<svg><script>alert(1)`,
+	}
+	for _, payload := range payloads {
+		got, err := SanitizeSVG([]byte(payload))
+		if err != nil {
+			continue
+		}
+		lower := strings.ToLower(string(got))
+		for _, unsafe := range []string{"<script", "onload", "javascript:"} {
+			if strings.Contains(lower, unsafe) {
+				t.Errorf("SanitizeSVG(%q) = %q, contains %s", payload, got, unsafe)
+			}
+		}
+	}
+}
+
+func TestSanitizeSVG_RenderedDiagram(t *testing.T) {
+	source := "a: |md\n  # Title\n  <b onclick=\"x()\">bold</b>\n|\na -> b: label {style.animated: true}\nb.icon: https://icons.terrastruct.com/essentials/087-display.svg\n"
+	svg, err := RenderFromSource(context.Background(), source, Options{Sketch: true})
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	got, err := SanitizeSVG(svg)
+	if err != nil {
+		t.Fatalf("SanitizeSVG failed on D2 output: %v", err)
+	}
+	for _, want := range []string{"<marker", "<style", ">bold</b>", "viewBox=", `href="https://icons.terrastruct.com`, "label"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("Expected sanitized SVG to keep %s", want)
+		}
+	}
+	if strings.Contains(string(got), "onclick") {
+		t.Error("Expected onclick to be removed")
+	}
+}
+
+func TestGroupByTag(t *testing.T) {
+	source := `api: {tags: [backend, public]}
+worker.tags: [backend]
+db: {
+  tags: [backend]
+  users
+}
+web: {tags: [frontend]}
+client
+web -> api
+api -> db.users
+worker -> db`
+	diagram, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	result := groupByTag(diagram)
+	if errs := result.Validate(); len(errs) > 0 {
+		t.Fatalf("Grouped diagram is invalid: %v", errs)
+	}
+
+	var groups []*ir.Node
+	for _, node := range result.Nodes {
+		if node.Container == "" && strings.HasPrefix(node.ID, tagGroupPrefix) {
+			groups = append(groups, node)
+		}
+	}
+	if len(groups) != 2 || groups[0].Label != "backend" || groups[1].Label != "frontend" {
+		t.Fatalf("Expected backend and frontend containers, got %+v", groups)
+	}
+	backend := groups[0]
+	var children []string
+	for _, child := range result.GetNodesByContainer(backend.ID) {
+		children = append(children, child.ID)
+	}
+	want := []string{backend.ID + ".api", backend.ID + ".worker", backend.ID + ".db"}
+	if !slices.Equal(children, want) {
+		t.Errorf("Expected %v in the backend container, got %v", want, children)
+	}
+	if result.GetNode(backend.ID+".db.users") == nil {
+		t.Error("Expected db's children to move with it")
+	}
+	if node := result.GetNode("client"); node == nil || node.Container != "" {
+		t.Error("Expected the untagged node to stay at the top level")
+	}
+	for _, edge := range result.Edges {
+		if result.GetNode(edge.Source) == nil || result.GetNode(edge.Target) == nil {
+			t.Errorf("Edge %s points at a missing node: %s -> %s", edge.ID, edge.Source, edge.Target)
+		}
+	}
+	if diagram.GetNode("api").Container != "" || diagram.Edges[1].Target != "db.users" {
+		t.Error("groupByTag should not modify the original diagram")
+	}
+
+	opts := DefaultOptions()
+	opts.GroupByTag = true
+	svg, err := RenderFromIR(context.Background(), diagram, opts)
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	if !strings.Contains(string(svg), ">backend<") {
+		t.Error("Expected the backend container's label in the SVG")
+	}
+}
+
+func TestLimitLabels(t *testing.T) {
+	long := strings.Repeat("abcdefghij", 10)
+	diagram := &ir.Diagram{
+		Nodes: []*ir.Node{
+			{ID: "a", Label: long, Shape: ir.ShapeRectangle},
+			{ID: "b", Label: "short", Shape: ir.ShapeRectangle},
+		},
+		Edges: []*ir.Edge{
+			{ID: "a-b-0", Source: "a", Target: "b", Direction: ir.DirectionForward, Label: long},
+		},
+	}
+
+	result := limitLabels(diagram, 20, 0)
+
+	node := result.GetNode("a")
+	if n := len([]rune(node.Label)); n != 20 || !strings.HasSuffix(node.Label, "…") {
+		t.Errorf("Expected 20 characters ending in an ellipsis, got %q (%d)", node.Label, n)
+	}
+	if node.Properties["tooltip"] != long {
+		t.Errorf("Expected the original label as tooltip, got %v", node.Properties["tooltip"])
+	}
+	edge := result.Edges[0]
+	if len([]rune(edge.Label)) != 20 || edge.Properties["tooltip"] != long {
+		t.Errorf("Edge label should be truncated with a tooltip, got %q %v", edge.Label, edge.Properties)
+	}
+	if short := result.GetNode("b"); short.Label != "short" || short.Properties != nil {
+		t.Errorf("Short label should be untouched, got %q %v", short.Label, short.Properties)
+	}
+	if diagram.Nodes[0].Label != long || diagram.Nodes[0].Properties != nil {
+		t.Error("limitLabels should not modify the original diagram")
+	}
+
+	// The tooltip survives the trip through D2
+	regenerated, err := parser.NewD2Parser().Parse(irToD2Source(result))
+	if err != nil {
+		t.Fatalf("Parse of regenerated source failed: %v", err)
+	}
+	if got := regenerated.GetNode("a").Properties["tooltip"]; got != long {
+		t.Errorf("Regenerated tooltip = %v, expected the original label", got)
+	}
+}
+
+func TestGenerateD2_LinkRoundTrip(t *testing.T) {
+	for _, link := range []string{
+		"https://example.com/docs?page=1#intro",
+		`https://example.com/search?q="café"&sort=${field}`,
+		"https://example.com/wiki/Zürich_$5",
+	} {
+		diagram := &ir.Diagram{
+			ID:    "test",
+			Nodes: []*ir.Node{{ID: "api", Shape: ir.ShapeRectangle, Properties: map[string]interface{}{"link": link}}},
+		}
+
+		source := GenerateD2(diagram)
+		reparsed, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			t.Fatalf("Regenerated source failed to parse: %v\n%s", err, source)
+		}
+		if got := reparsed.GetNode("api").Properties["link"]; got != link {
+			t.Errorf("Expected link %q after regeneration, got %v\n%s", link, got, source)
+		}
+	}
+}
+
+func TestSVGRenderer_InteractiveLinks(t *testing.T) {
+	const href = "https://example.com/docs?page=1#intro"
+	diagram, err := parser.NewD2Parser().Parse(`api: API {link: "` + href + `"}
+db: Database
+api -> db`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if diagram.GetNode("api").Properties["link"] != href {
+		t.Fatalf("Expected parsed link %q, got %v", href, diagram.GetNode("api").Properties["link"])
+	}
+	ctx := context.Background()
+	anchor := `<a href="https://example.com/docs?page=1#intro"`
+
+	// Links are static by default
+	svg, err := NewSVGRenderer().RenderToBytes(ctx, diagram)
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if bytes.Contains(svg, []byte("<a ")) {
+		t.Error("Links should not be clickable without InteractiveLinks")
+	}
+
+	opts := DefaultOptions()
+	opts.InteractiveLinks = true
+	svg, err = NewSVGRendererWithOptions(opts).RenderToBytes(ctx, diagram)
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte(anchor)) {
+		t.Errorf("Expected an anchor for %s in the SVG", href)
+	}
+	if bytes.Contains(svg, []byte(`target="_blank"`)) {
+		t.Error("Links should open in the same tab by default")
+	}
+
+	opts.OpenLinksInNewTab = true
+	svg, err = NewSVGRendererWithOptions(opts).RenderToBytes(ctx, diagram)
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte(`<a target="_blank" rel="noopener" href="`+href+`"`)) {
+		t.Error("Expected the link to open in a new tab")
+	}
+	if diagram.GetNode("api").Properties["link"] != href {
+		t.Error("Rendering should not modify the diagram")
+	}
+}
+
+func TestWrapLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		width    int
+		expected string
+	}{
+		{"disabled", "the quick brown fox", 0, "the quick brown fox"},
+		{"fits", "short label", 20, "short label"},
+		{"word boundaries", "the quick brown fox jumps", 10, "the quick\nbrown fox\njumps"},
+		{"long word", "a supercalifragilistic word", 10, "a\nsupercalifragilistic\nword"},
+		{"existing breaks", "first line\nsecond line here", 12, "first line\nsecond line\nhere"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapLabel(tt.label, tt.width); got != tt.expected {
+				t.Errorf("wrapLabel() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// Integration test: Parse -> Render roundtrip
+func TestParseAndRender_Roundtrip(t *testing.T) {
+	source := `
+server: Web Server
+database: Database { shape: cylinder }
+cache: Cache { shape: circle }
+
+server -> database: queries
+server -> cache: reads
+`
+	// Parse
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Render
+	r := NewSVGRenderer()
+	ctx := context.Background()
+	svg, err := r.RenderToBytes(ctx, diagram)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	// Verify SVG output
+	if !bytes.Contains(svg, []byte("<svg")) {
+		t.Error("Output doesn't contain <svg tag")
+	}
+	if !bytes.Contains(svg, []byte("Web Server")) {
+		t.Error("SVG doesn't contain 'Web Server'")
+	}
+	if !bytes.Contains(svg, []byte("Database")) {
+		t.Error("SVG doesn't contain 'Database'")
+	}
+}
+
+func TestIrToD2Source_SortStable(t *testing.T) {
+	sources := []string{
+		"api: API\ndb: { shape: cylinder }\napi -> db: reads\nweb -> api",
+		"web -> api\ndb: { shape: cylinder }\napi -> db: reads\napi: API",
+	}
+
+	var generated []string
+	for _, source := range sources {
+		diagram, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		diagram.SortStable()
+		generated = append(generated, irToD2Source(diagram))
+	}
+
+	if generated[0] != generated[1] {
+		t.Errorf("Expected identical D2 after sorting, got:\n%s\nand:\n%s", generated[0], generated[1])
+	}
+}
+
+func TestIrToD2Source_TablePorts(t *testing.T) {
+	source := `users: {
+  shape: sql_table
+  id: int
+  name: string
+}
+orders: {
+  shape: sql_table
+  id: int
+  user_id: int
+}
+orders.user_id -> users.id: belongs to`
+
+	diagram, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	edge := diagram.Edges[0]
+	if edge.SourcePort != "user_id" || edge.TargetPort != "id" {
+		t.Fatalf("Expected ports user_id and id, got %q and %q", edge.SourcePort, edge.TargetPort)
+	}
+
+	d2 := irToD2Source(diagram)
+	if !strings.Contains(d2, "orders.user_id -> users.id: belongs to") {
+		t.Errorf("Expected the edge to connect the columns, got:\n%s", d2)
+	}
+
+	reparsed, err := parser.NewD2Parser().Parse(d2)
+	if err != nil {
+		t.Fatalf("Parse of generated D2 failed: %v\n%s", err, d2)
+	}
+	if len(reparsed.Edges) != 1 || reparsed.Edges[0].SourcePort != "user_id" || reparsed.Edges[0].TargetPort != "id" {
+		t.Errorf("Expected ports to round-trip, got %+v", reparsed.Edges)
+	}
+}
+
+// Test rendering example files
+func TestRender_ExampleFiles(t *testing.T) {
+	examplesDir := "../../examples"
+
+	files, err := filepath.Glob(filepath.Join(examplesDir, "*.d2"))
+	if err != nil {
+		t.Fatalf("Failed to glob examples: %v", err)
+	}
+
+	if len(files) == 0 {
+		t.Skip("No example files found")
+	}
+
+	ctx := context.Background()
+	opts := DefaultOptions()
+
+	for _, file := range files {
+		// Skip macOS metadata files
+		if strings.HasPrefix(filepath.Base(file), "._") {
+			continue
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read file: %v", err)
+			}
+
+			svg, err := RenderFromSource(ctx, string(content), opts)
+			if err != nil {
+				t.Fatalf("Failed to render %s: %v", file, err)
+			}
+
+			if !bytes.Contains(svg, []byte("<svg")) {
+				t.Error("Output doesn't contain <svg tag")
+			}
+
+			t.Logf("Rendered %s: %d bytes SVG", filepath.Base(file), len(svg))
+		})
+	}
+}
+
+// Benchmark tests
+func BenchmarkRenderFromSource_Simple(b *testing.B) {
+	source := `
+a -> b -> c -> d
+d -> e -> f
+`
+	ctx := context.Background()
+	opts := DefaultOptions()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = RenderFromSource(ctx, source, opts)
+	}
+}
+
+func BenchmarkRenderFromSource_Complex(b *testing.B) {
+	source := `
+aws: AWS {
+  vpc: VPC {
+    web1: Web 1
+    web2: Web 2
+    app1: App 1
+    app2: App 2
+    db: Database { shape: cylinder }
+  }
+}
+web1 -> app1
+web2 -> app2
+app1 -> db
+app2 -> db
+`
+	ctx := context.Background()
+	opts := DefaultOptions()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = RenderFromSource(ctx, source, opts)
+	}
+}
+
+func BenchmarkSVGRenderer_RenderToBytes(b *testing.B) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "a", Label: "Node A", Shape: ir.ShapeRectangle},
+			{ID: "b", Label: "Node B", Shape: ir.ShapeRectangle},
+			{ID: "c", Label: "Node C", Shape: ir.ShapeRectangle},
+		},
+		Edges: []*ir.Edge{
+			{ID: "e1", Source: "a", Target: "b", Direction: ir.DirectionForward},
+			{ID: "e2", Source: "b", Target: "c", Direction: ir.DirectionForward},
+		},
+	}
+
+	r := NewSVGRenderer()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.RenderToBytes(ctx, diagram)
+	}
+}
+
+// Integration test: Parse -> Render to PDF
+func TestPDFRenderer_RenderToBytes_Integration(t *testing.T) {
+	// Skip if Chrome is not available (CI environments)
+	if testing.Short() {
+		t.Skip("Skipping PDF test in short mode (requires Chrome)")
+	}
+
+	source := `
+server: Web Server
+database: Database { shape: cylinder }
+cache: Cache { shape: circle }
+
+server -> database: queries
+server -> cache: reads
+`
+
+	// Parse
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Render to PDF
+	r, err := NewPDFRenderer()
+	if err != nil {
+		t.Fatalf("NewPDFRenderer failed: %v", err)
+	}
+	defer r.Close()
+
+	ctx := context.Background()
+	pdfBytes, err := r.RenderToBytes(ctx, diagram)
+	if err != nil {
+		t.Fatalf("PDF render failed: %v", err)
+	}
+
+	// Verify PDF output (PDF files start with %PDF-)
+	if len(pdfBytes) < 100 {
+		t.Errorf("PDF output too small: %d bytes", len(pdfBytes))
+	}
+	if !bytes.HasPrefix(pdfBytes, []byte("%PDF-")) {
+		t.Error("Output doesn't start with PDF magic bytes %PDF-")
+	}
+
+	t.Logf("Generated PDF: %d bytes", len(pdfBytes))
+}
+
+func TestEmbedSource(t *testing.T) {
+	svg := []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"><svg class="inner"></svg></svg>`)
+	source := "a -> b: label with ]]> inside"
+
+	result := string(EmbedSource(svg, source))
+
+	if !strings.HasPrefix(result, `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"><metadata id="d2-source">`) {
+		t.Errorf("Metadata should be inserted after the outer <svg> tag, got: %s", result)
+	}
+	if strings.Count(result, "<metadata") != 1 {
+		t.Error("Expected exactly one metadata element")
+	}
+	if strings.Contains(result, "with ]]> inside") {
+		t.Error("CDATA terminator in source should be escaped")
+	}
+	if !strings.Contains(result, "a -> b: label with") {
+		t.Error("Expected source text in output")
+	}
+}
+
+func TestEmbedSource_NoSVGTag(t *testing.T) {
+	input := []byte("not an svg")
+	if got := EmbedSource(input, "a -> b"); !bytes.Equal(got, input) {
+		t.Errorf("Expected input unchanged, got: %s", got)
+	}
+}
+
+func TestRender_Title(t *testing.T) {
+	ctx := context.Background()
+
+	svg, err := RenderFromSource(ctx, "vars: {title: Orders & Billing}\na -> b", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if !strings.Contains(string(svg), "<title>Orders &amp; Billing</title>") {
+		t.Error("Expected the escaped title in the SVG")
+	}
+	if got := SVGTitle(EmbedSource(svg, "a -> b")); got != "Orders & Billing" {
+		t.Errorf("SVGTitle() = %q, want %q", got, "Orders & Billing")
+	}
+
+	diagram := &ir.Diagram{
+		ID:       "test",
+		Metadata: map[string]string{"title": "From IR"},
+		Nodes:    []*ir.Node{{ID: "a", Label: "A"}},
+	}
+	svg, err = RenderFromIR(ctx, diagram, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	if got := SVGTitle(svg); got != "From IR" {
+		t.Errorf("SVGTitle() = %q, want %q", got, "From IR")
+	}
+
+	svg, err = RenderFromSource(ctx, "a -> b", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if got := SVGTitle(svg); got != "" {
+		t.Errorf("Expected no title for an untitled diagram, got %q", got)
+	}
+}
+
+func TestRender_Accessible(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Accessible = true
+
+	diagram := &ir.Diagram{
+		ID:       "test",
+		Metadata: map[string]string{"title": "Checkout Flow"},
+		Nodes: []*ir.Node{
+			{ID: "api", Label: "API Gateway"},
+			{ID: "db", Label: "Orders DB", Properties: map[string]interface{}{"tooltip": "Primary store"}},
+		},
+		Edges: []*ir.Edge{{ID: "e1", Source: "api", Target: "db"}},
+	}
+	svg, err := RenderFromIR(context.Background(), diagram, opts)
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	output := string(svg)
+
+	if !strings.Contains(output, `<svg role="img" aria-label="Checkout Flow"`) {
+		t.Error("Expected role and aria-label on the root <svg>")
+	}
+	if got := SVGTitle(svg); got != "Checkout Flow" {
+		t.Errorf("SVGTitle() = %q, want %q", got, "Checkout Flow")
+	}
+	if !strings.Contains(output, "<desc>2 shapes and 1 connection</desc>") {
+		t.Error("Expected a root <desc> summarizing the diagram")
+	}
+	if !strings.Contains(output, "<title>API Gateway</title>") {
+		t.Error("Expected a <title> with the node label")
+	}
+	if strings.Contains(output, "<title>Orders DB</title>") || !strings.Contains(output, "<title>Primary store</title>") {
+		t.Error("Expected a node's tooltip to remain its only title")
+	}
+
+	plain, err := RenderFromIR(context.Background(), diagram, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	if strings.Contains(string(plain), `role="img"`) || strings.Contains(string(plain), "<title>API Gateway</title>") {
+		t.Error("Accessibility markup should only be added when Accessible is set")
+	}
+}
+
+func TestAddShapeTitle_ClassPrefix(t *testing.T) {
+	// "abc" and "abcd" encode to "YWJj" and "YWJjZA==", so the first is a
+	// prefix of the second
+	svg := []byte(`<svg><g class="YWJjZA=="></g><g class="YWJj animated-shape"></g></svg>`)
+	got := string(addShapeTitle(svg, "abc", "ABC"))
+	want := `<svg><g class="YWJjZA=="></g><g class="YWJj animated-shape"><title>ABC</title></g></svg>`
+	if got != want {
+		t.Errorf("addShapeTitle() = %s, want %s", got, want)
+	}
+}
+
+func TestMetadata_SetAllRoutingModes(t *testing.T) {
+	meta := &Metadata{}
+	source := "c: { a -> b }\nx -> c.a\nx <-> y"
+
+	if err := meta.SetAllRoutingModes(source, RoutingOrthogonal); err != nil {
+		t.Fatalf("SetAllRoutingModes failed: %v", err)
+	}
+
+	expected := []string{"c.(a -> b)[0]", "(x -> c.a)[0]", "(x <-> y)[0]"}
+	if len(meta.RoutingMode) != len(expected) {
+		t.Fatalf("Expected %d routing modes, got %d: %v", len(expected), len(meta.RoutingMode), meta.RoutingMode)
+	}
+	for _, id := range expected {
+		if meta.RoutingMode[id] != RoutingOrthogonal {
+			t.Errorf("Expected edge %q to be orthogonal, got %q", id, meta.RoutingMode[id])
+		}
+	}
+	if !meta.HasLayout() {
+		t.Error("Metadata with routing modes should require JointJS rendering")
+	}
+
+	// Direct is the default and clears stored modes
+	if err := meta.SetAllRoutingModes(source, RoutingDirect); err != nil {
+		t.Fatalf("SetAllRoutingModes failed: %v", err)
+	}
+	if len(meta.RoutingMode) != 0 {
+		t.Errorf("Expected routing modes to be cleared, got %v", meta.RoutingMode)
+	}
+}
+
+func TestMetadata_SetEdgeRoutingModes(t *testing.T) {
+	diagram := &ir.Diagram{
+		Nodes: []*ir.Node{{ID: "a", Shape: ir.ShapeRectangle}, {ID: "b", Shape: ir.ShapeRectangle}, {ID: "c", Shape: ir.ShapeRectangle}},
+		Edges: []*ir.Edge{
+			{ID: "a->b", Source: "a", Target: "b", Direction: ir.DirectionForward, RoutingMode: ir.RoutingOrthogonal},
+			{ID: "b->c", Source: "b", Target: "c", Direction: ir.DirectionForward, RoutingMode: ir.RoutingDirect},
+			{ID: "a->c", Source: "a", Target: "c", Direction: ir.DirectionForward, RoutingMode: ir.RoutingOrthogonal},
+		},
+	}
+	source := GenerateD2(diagram)
+	if !strings.Contains(source, "# diagtool: routing orthogonal") || strings.Contains(source, "  routing:") {
+		t.Errorf("Expected generated D2 to declare the routing mode in a comment, got:\n%s", source)
+	}
+	if _, _, err := d2compiler.Compile("", strings.NewReader(source), nil); err != nil {
+		t.Errorf("Generated D2 should compile as plain D2: %v", err)
+	}
+	parsed, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	for i, edge := range parsed.Edges {
+		if want := diagram.Edges[i].RoutingMode; edge.RoutingMode != want && !(edge.RoutingMode == "" && want == ir.RoutingDirect) {
+			t.Errorf("Edge %s: expected routing mode %q after a round trip, got %q", edge.ID, want, edge.RoutingMode)
+		}
+	}
+
+	// A mode chosen in the editor wins over the declared one
+	meta := &Metadata{RoutingMode: map[string]string{"(a -> c)[0]": RoutingDirect}}
+	if err := meta.SetEdgeRoutingModes("", diagram); err != nil {
+		t.Fatalf("SetEdgeRoutingModes failed: %v", err)
+	}
+	want := map[string]string{"(a -> b)[0]": RoutingOrthogonal, "(a -> c)[0]": RoutingDirect}
+	if !maps.Equal(meta.RoutingMode, want) {
+		t.Errorf("Expected routing modes %v, got %v", want, meta.RoutingMode)
+	}
+	if !meta.HasLayout() {
+		t.Error("Metadata with a declared orthogonal edge should require JointJS rendering")
+	}
+
+	// The D2 renderer ignores the routing field
+	if _, err := RenderFromIR(context.Background(), diagram, DefaultOptions()); err != nil {
+		t.Errorf("RenderFromIR failed with a routing mode: %v", err)
+	}
+}
+
+func TestMetadata_SetAllRoutingModes_InvalidSource(t *testing.T) {
+	meta := &Metadata{}
+	if err := meta.SetAllRoutingModes("a -> -> b", RoutingOrthogonal); err == nil {
+		t.Error("Expected error for invalid D2 source")
+	}
+}
+
+func TestMetadata_HasLayout(t *testing.T) {
+	var nilMeta *Metadata
+	if nilMeta.HasLayout() {
+		t.Error("nil metadata should not have layout")
+	}
+	if (&Metadata{}).HasLayout() {
+		t.Error("empty metadata should not have layout")
+	}
+	if !(&Metadata{Positions: map[string]NodeOffset{"a": {DX: 1}}}).HasLayout() {
+		t.Error("metadata with positions should have layout")
+	}
+}
+
+func TestThemeIDByName(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		wantID int64
+		wantOK bool
+	}{
+		{"exact name", "Terminal", 300, true},
+		{"lowercase", "terminal", 300, true},
+		{"hyphenated", "dark-mauve", 200, true},
+		{"underscored", "cool_classics", 4, true},
+		{"default", "Neutral Default", 0, true},
+		{"numeric", "3", 3, true},
+		{"unknown numeric", "999", 0, false},
+		{"unknown name", "rainbow", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := ThemeIDByName(tt.input)
+			if ok != tt.wantOK || id != tt.wantID {
+				t.Errorf("ThemeIDByName(%q) = (%d, %v), expected (%d, %v)", tt.input, id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSVGRenderer_RenderToBytes_ConfigTheme(t *testing.T) {
+	newDiagram := func(theme string) *ir.Diagram {
+		return &ir.Diagram{
+			ID: "test",
+			Nodes: []*ir.Node{
+				{ID: "a", Shape: ir.ShapeRectangle},
+				{ID: "b", Shape: ir.ShapeRectangle},
+			},
+			Edges: []*ir.Edge{
+				{ID: "e1", Source: "a", Target: "b", Direction: ir.DirectionForward},
+			},
+			Config: ir.DiagramConfig{Theme: theme},
+		}
+	}
+	ctx := context.Background()
+
+	// Config theme is used when no theme is set in options
+	fromConfig, err := NewSVGRenderer().RenderToBytes(ctx, newDiagram("Grape Soda"))
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.ThemeID = 6 // Grape Soda
+	explicit, err := NewSVGRendererWithOptions(opts).RenderToBytes(ctx, newDiagram(""))
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+
+	defaultTheme, err := NewSVGRenderer().RenderToBytes(ctx, newDiagram(""))
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+
+	if !bytes.Equal(fromConfig, explicit) {
+		t.Error("Config theme should render the same as the equivalent explicit theme ID")
+	}
+	if bytes.Equal(fromConfig, defaultTheme) {
+		t.Error("Config theme should change the rendered output")
+	}
+
+	// Explicit options take precedence over the config
+	opts.ThemeID = 1
+	overridden, err := NewSVGRendererWithOptions(opts).RenderToBytes(ctx, newDiagram("Grape Soda"))
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if bytes.Equal(overridden, fromConfig) {
+		t.Error("Explicit ThemeID should override the config theme")
+	}
+}
+
+func TestExternalizeStyles(t *testing.T) {
+	svg := []byte(`<?xml version="1.0"?><svg><style type="text/css"><![CDATA[.a { fill: red; }]]></style>` +
+		`<rect class="shape" style="stroke-width:2;"/><path style="stroke-width:2;"/><text style="font-size:16px">x</text></svg>`)
+
+	result, css := ExternalizeStyles(svg, "styles.css")
+	out := string(result)
+
+	if strings.Contains(out, "<style") || strings.Contains(out, "style=") {
+		t.Errorf("Expected all styles removed from SVG, got: %s", out)
+	}
+	if !strings.HasPrefix(out, `<?xml version="1.0"?><?xml-stylesheet type="text/css" href="styles.css"?>`) {
+		t.Errorf("Expected stylesheet reference after XML declaration, got: %s", out)
+	}
+	if !strings.Contains(css, ".a { fill: red; }") {
+		t.Errorf("Expected <style> rules in CSS, got: %s", css)
+	}
+
+	// Identical inline styles share one class
+	if strings.Count(css, "stroke-width:2;") != 1 {
+		t.Errorf("Expected one rule for the shared inline style, got: %s", css)
+	}
+	for _, match := range classAttrRe.FindAllStringSubmatch(out, -1) {
+		for _, class := range strings.Fields(match[1]) {
+			if strings.HasPrefix(class, "s-") && !strings.Contains(css, "."+class+" ") {
+				t.Errorf("Class %s is not defined in CSS", class)
+			}
+		}
+	}
+	if !strings.Contains(out, `<rect class="shape s-`) {
+		t.Errorf("Expected generated class merged into existing class attribute, got: %s", out)
+	}
+}
+
+func TestMergeStylesheets(t *testing.T) {
+	existing := ".d2-1 .fill-N1 { fill: #000; }\n/* note */\n.s-aaaa { stroke-width:2; }\n@media screen { .d2-1 { opacity: 1; } }\n"
+	added := ".d2-2 .fill-N1 { fill: #000; }\n.s-aaaa { stroke-width:2; }\n@import url(\"x}.css\");\n"
+
+	merged := MergeStylesheets(existing, added)
+	for _, want := range []string{".d2-1 .fill-N1", ".d2-2 .fill-N1", "@media screen { .d2-1 { opacity: 1; } }", `@import url("x}.css");`} {
+		if !strings.Contains(merged, want) {
+			t.Errorf("Expected %q in merged CSS:\n%s", want, merged)
+		}
+	}
+	if n := strings.Count(merged, ".s-aaaa"); n != 1 {
+		t.Errorf("Expected the shared rule once, got %d times:\n%s", n, merged)
+	}
+	if again := MergeStylesheets(merged, added); again != merged {
+		t.Errorf("Expected merging the same rules again to change nothing, got:\n%s", again)
+	}
+}
+
+func TestFormatColor(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"hex color is quoted", "#4CAF50", `"#4CAF50"`},
+		{"named color is bare", "white", "white"},
+		{"keyword is bare", "transparent", "transparent"},
+		{"linear gradient is quoted", "linear-gradient(#f69d3c, #3f87a6)", `"linear-gradient(#f69d3c, #3f87a6)"`},
+		{"radial gradient is quoted", "radial-gradient(red, blue)", `"radial-gradient(red, blue)"`},
+		{"embedded quote is escaped", `a"b`, `"a\"b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatColor(tt.input); got != tt.expected {
+				t.Errorf("formatColor(%q) = %s, expected %s", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIrToD2Source_FillQuoting(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "hex", Shape: ir.ShapeRectangle, Style: ir.Style{Fill: "#4CAF50"}},
+			{ID: "named", Shape: ir.ShapeRectangle, Style: ir.Style{Fill: "lightblue", FontColor: "white"}},
 		},
 	}
 
-	r := NewSVGRenderer()
-	ctx := context.Background()
+	source := irToD2Source(diagram)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, _ = r.RenderToBytes(ctx, diagram)
+	if !strings.Contains(source, `fill: "#4CAF50"`) {
+		t.Errorf("Expected quoted hex fill, got:\n%s", source)
+	}
+	if !strings.Contains(source, "fill: lightblue\n") {
+		t.Errorf("Expected bare named fill, got:\n%s", source)
+	}
+	if !strings.Contains(source, "font-color: white\n") {
+		t.Errorf("Expected bare named font color, got:\n%s", source)
 	}
 }
 
-// Integration test: Parse -> Render to PDF
-func TestPDFRenderer_RenderToBytes_Integration(t *testing.T) {
-	// Skip if Chrome is not available (CI environments)
-	if testing.Short() {
-		t.Skip("Skipping PDF test in short mode (requires Chrome)")
+func TestSVGRenderer_RenderToBytes_GradientFill(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID: "test",
+		Nodes: []*ir.Node{
+			{ID: "a", Shape: ir.ShapeRectangle, Style: ir.Style{Fill: "linear-gradient(#f69d3c, #3f87a6)"}},
+		},
 	}
 
-	source := `
-server: Web Server
-database: Database { shape: cylinder }
-cache: Cache { shape: circle }
+	svg, err := NewSVGRenderer().RenderToBytes(context.Background(), diagram)
+	if err != nil {
+		t.Fatalf("RenderToBytes failed: %v", err)
+	}
+	if !bytes.Contains(svg, []byte("linearGradient")) {
+		t.Error("Expected gradient fill to render as an SVG linearGradient")
+	}
+}
 
-server -> database: queries
-server -> cache: reads
+func TestParsePageSize(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  PageSize
+		expectErr bool
+	}{
+		{"", "", false},
+		{"a4", PageSizeA4, false},
+		{"A4", PageSizeA4, false},
+		{"Letter", PageSizeLetter, false},
+		{"tabloid", PageSizeTabloid, false},
+		{"postcard", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePageSize(tt.input)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("ParsePageSize(%q) error = %v, expectErr %v", tt.input, err, tt.expectErr)
+		}
+		if got != tt.expected {
+			t.Errorf("ParsePageSize(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestPageSize_Dimensions(t *testing.T) {
+	w, h, ok := PageSizeA4.Dimensions(false)
+	if !ok || w != 8.27 || h != 11.69 {
+		t.Errorf("A4 portrait = (%v, %v, %v), expected (8.27, 11.69, true)", w, h, ok)
+	}
+
+	w, h, ok = PageSizeA4.Dimensions(true)
+	if !ok || w != 11.69 || h != 8.27 {
+		t.Errorf("A4 landscape = (%v, %v, %v), expected (11.69, 8.27, true)", w, h, ok)
+	}
+
+	if _, _, ok := PageSize("").Dimensions(false); ok {
+		t.Error("Empty page size should have no dimensions")
+	}
+}
+
+// pdfMediaBoxRe matches the first page's MediaBox in Chrome-generated PDFs.
+var pdfMediaBoxRe = regexp.MustCompile(`/MediaBox\s*\[\s*0 0 ([\d.]+) ([\d.]+)\s*\]`)
+
+// pdfPageRe matches page objects (but not the /Pages tree) in a PDF.
+var pdfPageRe = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// pdfPageDimensions returns the first page's width and height in points.
+func pdfPageDimensions(t *testing.T, pdf []byte) (width, height float64) {
+	t.Helper()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Fatal("Output is not a valid PDF (missing %PDF header)")
+	}
+	match := pdfMediaBoxRe.FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("PDF has no MediaBox")
+	}
+	width, _ = strconv.ParseFloat(string(match[1]), 64)
+	height, _ = strconv.ParseFloat(string(match[2]), 64)
+	return width, height
+}
+
+// Integration test: SVG -> PDF on a standard page
+func TestSVGToPDFWithOptions_A4(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "a -> b -> c", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.PDFPageSize = PageSizeA4
+	pdf, err := SVGToPDFWithOptions(context.Background(), svg, opts)
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("PDF render failed: %v", err)
+	}
+
+	// A4 is 595 x 842 points
+	width, height := pdfPageDimensions(t, pdf)
+	if width < 590 || width > 600 || height < 837 || height > 847 {
+		t.Errorf("Expected A4 page (~595x842pt), got %.1fx%.1f", width, height)
+	}
+}
+
+// Integration test: SVG -> PDF on a landscape page
+func TestSVGToPDFWithOptions_A4Landscape(t *testing.T) {
+	svg, err := RenderFromSource(context.Background(), "direction: right\na -> b -> c", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.PDFPageSize = PageSizeA4
+	opts.PDFLandscape = true
+	pdf, err := SVGToPDFWithOptions(context.Background(), svg, opts)
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("PDF render failed: %v", err)
+	}
+
+	// A4 landscape is 842 x 595 points
+	width, height := pdfPageDimensions(t, pdf)
+	if width < 837 || width > 847 || height < 590 || height > 600 {
+		t.Errorf("Expected A4 landscape page (~842x595pt), got %.1fx%.1f", width, height)
+	}
+	if pages := len(pdfPageRe.FindAll(pdf, -1)); pages != 1 {
+		t.Errorf("Expected the diagram to fit on a single page, got %d pages", pages)
+	}
+}
+
+// twoLayerSource has an empty root board and two layers.
+const twoLayerSource = `
+layers: {
+  current: {
+    client -> api
+  }
+  future: {
+    client -> gateway -> api
+  }
+}
 `
 
-	// Parse
+func TestRenderBoardsFromSource(t *testing.T) {
+	pages, err := RenderBoardsFromSource(context.Background(), twoLayerSource, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderBoardsFromSource failed: %v", err)
+	}
+
+	// The folder-only root board is skipped
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 boards, got %d", len(pages))
+	}
+	if !strings.Contains(string(pages[0]), "api") || strings.Contains(string(pages[0]), "gateway") {
+		t.Error("First page should be the 'current' layer")
+	}
+	if !strings.Contains(string(pages[1]), "gateway") {
+		t.Error("Second page should be the 'future' layer")
+	}
+
+	// A diagram without boards renders as a single page
+	pages, err = RenderBoardsFromSource(context.Background(), "a -> b", DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderBoardsFromSource failed: %v", err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("Expected 1 board, got %d", len(pages))
+	}
+}
+
+// Integration test: each layer on its own PDF page
+func TestSVGPagesToPDF_Layers(t *testing.T) {
+	pages, err := RenderBoardsFromSource(context.Background(), twoLayerSource, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderBoardsFromSource failed: %v", err)
+	}
+
+	pdf, err := SVGPagesToPDF(context.Background(), pages, DefaultOptions())
+	if errors.Is(err, exec.ErrNotFound) {
+		t.Skipf("Chrome is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("PDF render failed: %v", err)
+	}
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Fatal("Output is not a valid PDF (missing %PDF header)")
+	}
+	if count := len(pdfPageRe.FindAll(pdf, -1)); count != 2 {
+		t.Errorf("Expected a 2-page PDF, got %d pages", count)
+	}
+}
+
+func TestPDFBoards(t *testing.T) {
+	diagram := &ir.Diagram{
+		ID:         "diagram",
+		FolderOnly: true,
+		Boards: []*ir.Diagram{
+			{ID: "one", Nodes: []*ir.Node{{ID: "a"}}},
+			{ID: "two", Nodes: []*ir.Node{{ID: "b"}}, Boards: []*ir.Diagram{
+				{ID: "nested", Nodes: []*ir.Node{{ID: "c"}}},
+			}},
+		},
+	}
+
+	var ids []string
+	for _, board := range pdfBoards(diagram) {
+		ids = append(ids, board.ID)
+	}
+	if strings.Join(ids, ",") != "one,two,nested" {
+		t.Errorf("Expected boards [one two nested], got %v", ids)
+	}
+
+	// A diagram without boards is a single page
+	if boards := pdfBoards(&ir.Diagram{ID: "solo"}); len(boards) != 1 {
+		t.Errorf("Expected 1 board, got %d", len(boards))
+	}
+}
+
+func TestRender_Board(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultOptions()
+	opts.Board = "future"
+
+	svg, err := RenderFromSource(ctx, twoLayerSource, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+	if !strings.Contains(string(svg), "gateway") {
+		t.Error("Expected the 'future' layer to be rendered")
+	}
+
+	// The IR renderer selects the same board
+	diagram, err := parser.NewD2Parser().Parse(twoLayerSource)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	opts.Board = "current"
+	svg, err = RenderFromIR(ctx, diagram, opts)
+	if err != nil {
+		t.Fatalf("RenderFromIR failed: %v", err)
+	}
+	if !strings.Contains(string(svg), "api") || strings.Contains(string(svg), "gateway") {
+		t.Error("Expected only the 'current' layer to be rendered")
+	}
+
+	opts.Board = "past"
+	if _, err := RenderFromSource(ctx, twoLayerSource, opts); err == nil || !strings.Contains(err.Error(), "available: current, future") {
+		t.Errorf("Expected unknown board error listing the boards, got %v", err)
+	}
+	if _, err := RenderFromIR(ctx, diagram, opts); err == nil || !strings.Contains(err.Error(), `unknown board "past"`) {
+		t.Errorf("Expected unknown board error, got %v", err)
+	}
+}
+
+func TestStartChrome_Retries(t *testing.T) {
+	origLaunch, origBackoff := launchChrome, chromeStartBackoff
+	defer func() { launchChrome, chromeStartBackoff = origLaunch, origBackoff }()
+	chromeStartBackoff = time.Millisecond
+
+	// Fails twice, then starts
+	attempts := 0
+	launchChrome = func(ctx context.Context, opts []chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, nil, errors.New("websocket url timeout reached")
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	chromeCtx, cancel, err := startChrome(context.Background())
+	if err != nil {
+		t.Fatalf("Expected Chrome to start on the third attempt, got %v", err)
+	}
+	cancel()
+	if chromeCtx == nil || attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Never starts
+	attempts = 0
+	launchChrome = func(ctx context.Context, opts []chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc, error) {
+		attempts++
+		return nil, nil, errors.New("websocket url timeout reached")
+	}
+	_, _, err = startChrome(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || attempts != 3 {
+		t.Errorf("Expected a final error after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	// A missing executable is not retried
+	attempts = 0
+	launchChrome = func(ctx context.Context, opts []chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc, error) {
+		attempts++
+		return nil, nil, &exec.Error{Name: "google-chrome", Err: exec.ErrNotFound}
+	}
+	if _, _, err := startChrome(context.Background()); !errors.Is(err, exec.ErrNotFound) || attempts != 1 {
+		t.Errorf("Expected a single attempt for a missing executable, got %v after %d", err, attempts)
+	}
+}
+
+func TestDiffDiagram(t *testing.T) {
 	p := parser.NewD2Parser()
-	diagram, err := p.Parse(source)
+	old, err := p.Parse("a -> b\nb -> c\nc: {style.fill: white}")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	new, err := p.Parse("a -> b\nb -> d\nc: {style.fill: gray}")
 	if err != nil {
 		t.Fatalf("Parse failed: %v", err)
 	}
 
-	// Render to PDF
-	r, err := NewPDFRenderer()
+	diagram := DiffDiagram(old, new)
+	for id, fill := range map[string]string{"a": "", "c": diffChangedFill, "d": diffAddedFill} {
+		if node := diagram.GetNode(id); node == nil || node.Style.Fill != fill {
+			t.Errorf("Expected node %s filled %q, got %+v", id, fill, node)
+		}
+	}
+	if edge := diagram.GetEdge("b-d-1"); edge == nil || edge.Style.Stroke != diffAddedStroke {
+		t.Errorf("Expected the added edge in green, got %+v", edge)
+	}
+	if edge := diagram.GetEdge("b-c-1"); edge == nil || edge.Style.Stroke != diffRemovedStroke || edge.Style.Opacity != diffRemovedOpacity {
+		t.Errorf("Expected the removed edge ghosted in red, got %+v", edge)
+	}
+	if new.GetNode("d").Style.Fill != "" {
+		t.Error("DiffDiagram should not modify its inputs")
+	}
+
+	if _, err := RenderFromIR(context.Background(), diagram, DefaultOptions()); err != nil {
+		t.Errorf("RenderFromIR failed: %v", err)
+	}
+}
+
+func TestIrToD2Source_ConfigDirection(t *testing.T) {
+	p := parser.NewD2Parser()
+	diagram, err := p.Parse("direction: right\na -> b")
 	if err != nil {
-		t.Fatalf("NewPDFRenderer failed: %v", err)
+		t.Fatalf("Parse failed: %v", err)
 	}
-	defer r.Close()
 
+	source := irToD2Source(diagram)
+	if !strings.Contains(source, "direction: right\n") {
+		t.Errorf("Expected the diagram's direction in the regenerated source:\n%s", source)
+	}
+	regenerated, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse of regenerated source failed: %v", err)
+	}
+	if regenerated.Config.Direction != "right" {
+		t.Errorf("Expected direction right after regeneration, got %q", regenerated.Config.Direction)
+	}
+
+	// Abbreviations from other DSLs map to D2's names
+	diagram.Config.Direction = "BT"
+	if source := irToD2Source(diagram); !strings.Contains(source, "direction: up\n") {
+		t.Errorf("Expected BT to regenerate as up:\n%s", source)
+	}
+	diagram.Config.Direction = ""
+	if source := irToD2Source(diagram); !strings.Contains(source, "direction: down\n") {
+		t.Errorf("Expected down without a direction:\n%s", source)
+	}
+}
+
+func TestRender_EmbedFonts(t *testing.T) {
 	ctx := context.Background()
-	pdfBytes, err := r.RenderToBytes(ctx, diagram)
+	opts := DefaultOptions()
+	opts.Watermark = "DRAFT"
+	plain, err := RenderFromSource(ctx, "a -> b", opts)
 	if err != nil {
-		t.Fatalf("PDF render failed: %v", err)
+		t.Fatalf("RenderFromSource failed: %v", err)
 	}
 
-	// Verify PDF output (PDF files start with %PDF-)
-	if len(pdfBytes) < 100 {
-		t.Errorf("PDF output too small: %d bytes", len(pdfBytes))
+	opts.EmbedFonts = true
+	embedded, err := RenderFromSource(ctx, "a -> b", opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
 	}
-	if !bytes.HasPrefix(pdfBytes, []byte("%PDF-")) {
-		t.Error("Output doesn't start with PDF magic bytes %PDF-")
+	if strings.Contains(string(plain), "data:font/ttf") {
+		t.Error("Expected no whole fonts without EmbedFonts")
+	}
+	if !strings.Contains(string(embedded), "@font-face") || !strings.Contains(string(embedded), "data:font/ttf;base64,") {
+		t.Error("Expected @font-face rules with font data URIs")
+	}
+	if !strings.Contains(string(embedded), `.diagtool-watermark { font-family: "diagtool-font"`) {
+		t.Error("Expected the watermark to use the embedded fonts")
 	}
+	if len(embedded) <= len(plain) {
+		t.Errorf("Expected the SVG with embedded fonts to be larger, got %d vs %d bytes", len(embedded), len(plain))
+	}
+}
 
-	t.Logf("Generated PDF: %d bytes", len(pdfBytes))
+func TestRender_InlineIcons(t *testing.T) {
+	var icon bytes.Buffer
+	if err := png.Encode(&icon, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/icon.png" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(icon.Bytes())
+	}))
+	defer srv.Close()
+
+	source := fmt.Sprintf("a: {icon: %[1]s/icon.png}\nb: {icon: %[1]s/icon.png}\nc: {icon: %[1]s/missing.png}\na -> b -> c", srv.URL)
+	opts := DefaultOptions()
+	opts.InlineIcons = true
+	svg, err := RenderFromSource(context.Background(), source, opts)
+	if err != nil {
+		t.Fatalf("RenderFromSource failed: %v", err)
+	}
+
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(icon.Bytes())
+	if got := strings.Count(string(svg), dataURI); got != 2 {
+		t.Errorf("Expected both icons to be inlined as %s, got %d", dataURI, got)
+	}
+	if strings.Contains(string(svg), srv.URL+"/icon.png") {
+		t.Error("Expected no references to the inlined icon's URL")
+	}
+	if !strings.Contains(string(svg), srv.URL+"/missing.png") {
+		t.Error("Expected an icon that can't be fetched to keep its URL")
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected the icon to be fetched once, got %d", got)
+	}
+
+	// The editor's renders inline icons too
+	svg, _, err = RenderWithLayout(context.Background(), source, opts, nil)
+	if err != nil {
+		t.Fatalf("RenderWithLayout failed: %v", err)
+	}
+	if got := strings.Count(string(svg), dataURI); got != 2 {
+		t.Errorf("Expected RenderWithLayout to inline both icons, got %d", got)
+	}
+}
+
+func TestRender_EmptyAndSingleNode(t *testing.T) {
+	ctx := context.Background()
+	for name, source := range map[string]string{
+		"comments only":     "# Architecture overview\n# TODO: add services\n",
+		"single node":       "solo: Lonely service",
+		"empty":             "",
+		"blank and comment": "\n\n# nothing yet\n\n",
+	} {
+		diagram, err := parser.NewD2Parser().Parse(source)
+		if err != nil {
+			t.Fatalf("%s: Parse failed: %v", name, err)
+		}
+		fromSource, err := RenderFromSource(ctx, source, DefaultOptions())
+		if err != nil {
+			t.Fatalf("%s: RenderFromSource failed: %v", name, err)
+		}
+		fromIR, err := RenderFromIR(ctx, diagram, DefaultOptions())
+		if err != nil {
+			t.Fatalf("%s: RenderFromIR failed: %v", name, err)
+		}
+
+		for path, svg := range map[string][]byte{"source": fromSource, "IR": fromIR} {
+			if err := xml.Unmarshal(svg, new(interface{})); err != nil {
+				t.Errorf("%s from %s: SVG is not well-formed XML: %v", name, path, err)
+			}
+			if !bytes.Contains(svg, []byte("<svg")) {
+				t.Errorf("%s from %s: expected an <svg> element", name, path)
+			}
+			if len(diagram.Nodes) > 0 && !bytes.Contains(svg, []byte("Lonely service")) {
+				t.Errorf("%s from %s: expected the node's label", name, path)
+			}
+		}
+	}
 }
@@ -0,0 +1,271 @@
+// Package render provides diagram rendering to various formats.
+// This file implements a sanitizer removing script from SVG markup.
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// elementKind tells how an allowed element is written back.
+type elementKind int
+
+const (
+	svgElement  elementKind = iota // SVG element, self-closed when empty
+	htmlElement                    // HTML element, always given an end tag
+	voidElement                    // HTML element that never has content
+)
+
+// allowedElements lists the elements kept by SanitizeSVG, by lowercase
+// local name: the SVG elements D2 and its icons use, and the HTML that
+// Markdown labels are rendered to.
+var allowedElements = map[string]elementKind{
+	"svg": svgElement, "g": svgElement, "defs": svgElement, "style": svgElement,
+	"symbol": svgElement, "use": svgElement, "switch": svgElement,
+	"title": svgElement, "desc": svgElement, "metadata": svgElement,
+	"path": svgElement, "rect": svgElement, "circle": svgElement, "ellipse": svgElement,
+	"line": svgElement, "polyline": svgElement, "polygon": svgElement,
+	"text": svgElement, "tspan": svgElement, "textpath": svgElement,
+	"image": svgElement, "foreignobject": svgElement,
+	"marker": svgElement, "mask": svgElement, "clippath": svgElement, "pattern": svgElement,
+	"lineargradient": svgElement, "radialgradient": svgElement, "stop": svgElement,
+	"filter": svgElement, "feblend": svgElement, "fecolormatrix": svgElement,
+	"fecomposite": svgElement, "fedropshadow": svgElement, "feflood": svgElement,
+	"fegaussianblur": svgElement, "femerge": svgElement, "femergenode": svgElement,
+	"feoffset": svgElement, "feturbulence": svgElement, "fedisplacementmap": svgElement,
+
+	"a": htmlElement, "div": htmlElement, "span": htmlElement, "p": htmlElement,
+	"h1": htmlElement, "h2": htmlElement, "h3": htmlElement,
+	"h4": htmlElement, "h5": htmlElement, "h6": htmlElement,
+	"ul": htmlElement, "ol": htmlElement, "li": htmlElement,
+	"strong": htmlElement, "em": htmlElement, "b": htmlElement, "i": htmlElement,
+	"u": htmlElement, "s": htmlElement, "del": htmlElement, "ins": htmlElement,
+	"sub": htmlElement, "sup": htmlElement, "mark": htmlElement, "kbd": htmlElement,
+	"code": htmlElement, "pre": htmlElement, "blockquote": htmlElement,
+	"table": htmlElement, "caption": htmlElement, "thead": htmlElement,
+	"tbody": htmlElement, "tfoot": htmlElement, "tr": htmlElement,
+	"th": htmlElement, "td": htmlElement,
+	"br": voidElement, "hr": voidElement, "img": voidElement,
+}
+
+// allowedAttrs lists the attributes kept by SanitizeSVG, by lowercase local
+// name. Attributes starting with data- or aria- are kept as well.
+var allowedAttrs = stringSet(
+	"id", "class", "style", "type", "version", "lang", "dir", "role", "title", "alt",
+	"href", "src", "target",
+	"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "dx", "dy",
+	"fx", "fy", "d", "points", "width", "height", "viewbox", "preserveaspectratio",
+	"transform", "opacity", "visibility", "display", "pointer-events",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-opacity",
+	"stroke-linecap", "stroke-linejoin", "stroke-dasharray", "stroke-dashoffset",
+	"stroke-miterlimit", "clip-path", "clip-rule", "mask", "filter",
+	"marker-start", "marker-mid", "marker-end", "markerwidth", "markerheight",
+	"markerunits", "refx", "refy", "orient",
+	"patternunits", "patterncontentunits", "patterntransform",
+	"maskunits", "maskcontentunits", "clippathunits",
+	"gradientunits", "gradienttransform", "spreadmethod",
+	"offset", "stop-color", "stop-opacity",
+	"font-family", "font-size", "font-weight", "font-style", "text-anchor",
+	"dominant-baseline", "alignment-baseline", "letter-spacing", "text-decoration",
+	"textlength", "lengthadjust", "startoffset",
+	"in", "in2", "result", "mode", "operator", "stddeviation", "values",
+	"k1", "k2", "k3", "k4", "flood-color", "flood-opacity",
+	"basefrequency", "numoctaves", "seed", "scale",
+	"xchannelselector", "ychannelselector", "requiredfeatures",
+	"space", "colspan", "rowspan", "align", "start",
+)
+
+// urlAttrs lists the attributes holding a URL.
+var urlAttrs = stringSet("href", "src")
+
+func stringSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// SanitizeSVG parses an SVG document and writes it back keeping only
+// allowed elements and attributes, so that nothing in it runs script when
+// it is shown in a browser. D2 escapes labels, but raw HTML in Markdown
+// labels is copied into the SVG as is. Elements that are not allowed are
+// dropped along with their content, as are comments, doctypes and links
+// to URLs other than http, https, mailto, data images and relative ones.
+// Malformed markup is rejected rather than repaired.
+func SanitizeSVG(svg []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	d.Entity = xml.HTMLEntity
+
+	var (
+		out     bytes.Buffer
+		stack   []xml.Name
+		kinds   []elementKind
+		skip    int  // Depth inside a dropped element
+		tagOpen bool // The last start tag written still lacks its ">"
+	)
+	closeTag := func() {
+		if tagOpen {
+			out.WriteByte('>')
+			tagOpen = false
+		}
+	}
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed SVG: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			kind, ok := allowedElements[strings.ToLower(t.Name.Local)]
+			if skip > 0 || !ok || !allowedPrefix(t.Name.Space) {
+				skip++
+				continue
+			}
+			kinds = append(kinds, kind)
+			closeTag()
+			out.WriteByte('<')
+			writeName(&out, t.Name)
+			for _, attr := range t.Attr {
+				if !allowedAttr(attr) {
+					continue
+				}
+				out.WriteByte(' ')
+				writeName(&out, attr.Name)
+				out.WriteString(`="`)
+				escapeXML(&out, attr.Value, true)
+				out.WriteByte('"')
+			}
+			tagOpen = true
+
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1] != t.Name {
+				return nil, fmt.Errorf("malformed SVG: unexpected end element </%s>", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			kind := kinds[len(kinds)-1]
+			kinds = kinds[:len(kinds)-1]
+			if tagOpen && kind != htmlElement {
+				out.WriteString("/>")
+				tagOpen = false
+				continue
+			}
+			closeTag()
+			if kind == voidElement {
+				continue
+			}
+			out.WriteString("</")
+			writeName(&out, t.Name)
+			out.WriteByte('>')
+
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			closeTag()
+			inStyle := len(stack) > 0 && strings.EqualFold(stack[len(stack)-1].Local, "style")
+			if inStyle && !bytes.Contains(t, []byte("]]>")) {
+				out.WriteString("<![CDATA[")
+				out.Write(t)
+				out.WriteString("]]>")
+			} else {
+				escapeXML(&out, string(t), false)
+			}
+
+		case xml.ProcInst:
+			if t.Target == "xml" && len(stack) == 0 {
+				fmt.Fprintf(&out, "<?xml %s?>", t.Inst)
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("malformed SVG: unclosed element <%s>", stack[len(stack)-1].Local)
+	}
+	return out.Bytes(), nil
+}
+
+// allowedPrefix reports whether a name with the given namespace prefix may
+// be kept. Other prefixes could bind the name to an unexpected namespace.
+func allowedPrefix(prefix string) bool {
+	return prefix == "" || prefix == "xlink" || prefix == "xml"
+}
+
+// allowedAttr reports whether an attribute is kept.
+func allowedAttr(attr xml.Attr) bool {
+	if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+		return true
+	}
+	if !allowedPrefix(attr.Name.Space) {
+		return false
+	}
+	name := strings.ToLower(attr.Name.Local)
+	if urlAttrs[name] {
+		return safeURL(attr.Value)
+	}
+	return allowedAttrs[name] || strings.HasPrefix(name, "data-") || strings.HasPrefix(name, "aria-")
+}
+
+// safeURL reports whether a link may be followed without running script.
+// Browsers ignore whitespace and control characters in a URL's scheme, so
+// they are removed before looking at it.
+func safeURL(value string) bool {
+	u := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value))
+	colon := strings.IndexByte(u, ':')
+	if colon < 0 || strings.ContainsAny(u[:colon], "/?#") {
+		return true // relative
+	}
+	switch u[:colon] {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		return strings.HasPrefix(u, "data:image/")
+	}
+	return false
+}
+
+func writeName(out *bytes.Buffer, name xml.Name) {
+	if name.Space != "" {
+		out.WriteString(name.Space)
+		out.WriteByte(':')
+	}
+	out.WriteString(name.Local)
+}
+
+// escapeXML writes s with the characters that are special in XML text, or
+// in an attribute value if attr is set, replaced by references.
+func escapeXML(out *bytes.Buffer, s string, attr bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			out.WriteString("&amp;")
+		case r == '<':
+			out.WriteString("&lt;")
+		case r == '>':
+			out.WriteString("&gt;")
+		case attr && r == '"':
+			out.WriteString("&quot;")
+		case attr && (r == '\n' || r == '\r' || r == '\t'):
+			fmt.Fprintf(out, "&#x%X;", r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+}
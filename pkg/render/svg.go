@@ -0,0 +1,323 @@
+// Package render provides diagram rendering to various formats.
+// This file contains post-processing helpers that operate on rendered SVG bytes.
+package render
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EmbedSource inserts the original diagram source into the SVG as a <metadata>
+// element, so the exported file carries the text it was generated from.
+// The source is wrapped in a CDATA section; any "]]>" sequences are split
+// so they cannot terminate it early.
+func EmbedSource(svg []byte, source string) []byte {
+	escaped := strings.ReplaceAll(source, "]]>", "]]]]><![CDATA[>")
+	metadata := `<metadata id="d2-source"><![CDATA[` + escaped + `]]></metadata>`
+	return insertAfterSVGOpenTag(svg, metadata)
+}
+
+// withTitle inserts a <title> element naming the SVG document, which screen
+// readers announce and browsers show as the page title. An empty title leaves
+// the SVG unchanged.
+func withTitle(svg []byte, title string) []byte {
+	if title == "" {
+		return svg
+	}
+	return insertAfterSVGOpenTag(svg, "<title>"+html.EscapeString(title)+"</title>")
+}
+
+// svgTitleRe matches the document title among the leading children of the
+// outermost <svg> element, before any drawing content.
+var svgTitleRe = regexp.MustCompile(`^(?s)(?:<\?xml[^>]*\?>)?\s*<svg[^>]*>\s*(?:<metadata[^>]*>.*?</metadata>\s*)?<title>([^<]*)</title>`)
+
+// SVGTitle returns the document title of an SVG rendered by this package,
+// or "" if it has none.
+func SVGTitle(svg []byte) string {
+	m := svgTitleRe.FindSubmatch(svg)
+	if m == nil {
+		return ""
+	}
+	return html.UnescapeString(string(m[1]))
+}
+
+// GzipSVG compresses an SVG into the .svgz format.
+func GzipSVG(svg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(svg); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// insertAfterSVGOpenTag inserts content immediately after the opening tag of
+// the outermost <svg> element. The SVG is returned unchanged if no <svg> tag
+// is found.
+func insertAfterSVGOpenTag(svg []byte, content string) []byte {
+	start := bytes.Index(svg, []byte("<svg"))
+	if start < 0 {
+		return svg
+	}
+	end := bytes.IndexByte(svg[start:], '>')
+	if end < 0 {
+		return svg
+	}
+	return insertAt(svg, start+end+1, content)
+}
+
+// insertAt returns a copy of b with content inserted at pos.
+func insertAt(b []byte, pos int, content string) []byte {
+	result := make([]byte, 0, len(b)+len(content))
+	result = append(result, b[:pos]...)
+	result = append(result, content...)
+	result = append(result, b[pos:]...)
+	return result
+}
+
+var (
+	styleElementRe = regexp.MustCompile(`(?s)<style[^>]*>(.*?)</style>`)
+	styleAttrTagRe = regexp.MustCompile(`<[a-zA-Z][^<>]*\sstyle="[^"]*"[^<>]*>`)
+	styleAttrRe    = regexp.MustCompile(`\sstyle="([^"]*)"`)
+	classAttrRe    = regexp.MustCompile(`\sclass="([^"]*)"`)
+	xmlDeclRe      = regexp.MustCompile(`^<\?xml[^>]*\?>`)
+)
+
+// ExternalizeStyles moves all styling out of the SVG so it can be served from
+// a shared stylesheet. Embedded <style> elements are removed and their rules
+// collected, and inline style attributes are replaced by classes whose names
+// are derived from the declarations, so identical styles share a class across
+// diagrams. The SVG references the stylesheet at href via an xml-stylesheet
+// processing instruction.
+//
+// Returns the rewritten SVG and the CSS to write to href.
+func ExternalizeStyles(svg []byte, href string) ([]byte, string) {
+	var css strings.Builder
+
+	// Collect and remove <style> elements
+	for _, match := range styleElementRe.FindAllSubmatch(svg, -1) {
+		rules := string(match[1])
+		rules = strings.TrimPrefix(strings.TrimSpace(rules), "<![CDATA[")
+		rules = strings.TrimSuffix(rules, "]]>")
+		css.WriteString(strings.TrimSpace(rules))
+		css.WriteString("\n")
+	}
+	svg = styleElementRe.ReplaceAll(svg, nil)
+
+	// Replace inline style attributes with generated classes
+	classes := make(map[string]string)
+	var order []string
+	svg = styleAttrTagRe.ReplaceAllFunc(svg, func(tag []byte) []byte {
+		decl := strings.TrimSpace(string(styleAttrRe.FindSubmatch(tag)[1]))
+		tag = styleAttrRe.ReplaceAll(tag, nil)
+		if decl == "" {
+			return tag
+		}
+
+		class, ok := classes[decl]
+		if !ok {
+			hash := sha256.Sum256([]byte(decl))
+			class = "s-" + hex.EncodeToString(hash[:4])
+			classes[decl] = class
+			order = append(order, decl)
+		}
+
+		if loc := classAttrRe.FindSubmatchIndex(tag); loc != nil {
+			existing := strings.TrimSpace(string(tag[loc[2]:loc[3]]))
+			merged := strings.TrimSpace(existing + " " + class)
+			return append(append(append([]byte{}, tag[:loc[0]]...), fmt.Sprintf(` class="%s"`, merged)...), tag[loc[1]:]...)
+		}
+
+		// Insert the class attribute after the tag name
+		nameEnd := bytes.IndexAny(tag, " \t\n/>")
+		return append(append(append([]byte{}, tag[:nameEnd]...), fmt.Sprintf(` class="%s"`, class)...), tag[nameEnd:]...)
+	})
+	for _, decl := range order {
+		css.WriteString(fmt.Sprintf(".%s { %s }\n", classes[decl], decl))
+	}
+
+	// Reference the external stylesheet
+	pi := fmt.Sprintf(`<?xml-stylesheet type="text/css" href="%s"?>`, href)
+	if loc := xmlDeclRe.FindIndex(svg); loc != nil {
+		svg = append(append(append([]byte{}, svg[:loc[1]]...), pi...), svg[loc[1]:]...)
+	} else {
+		svg = append([]byte(pi), svg...)
+	}
+
+	return svg, css.String()
+}
+
+// MergeStylesheets returns the CSS rules of existing followed by the rules
+// of added that existing lacks, so several diagrams externalized with
+// ExternalizeStyles can share one stylesheet. D2 scopes its rules to each
+// diagram's class and generated style classes are named by their
+// declarations, so rules only repeat when they mean the same thing.
+func MergeStylesheets(existing, added string) string {
+	var merged strings.Builder
+	seen := make(map[string]bool)
+	for _, css := range []string{existing, added} {
+		for _, rule := range cssRules(css) {
+			if seen[rule] {
+				continue
+			}
+			seen[rule] = true
+			merged.WriteString(rule)
+			merged.WriteString("\n")
+		}
+	}
+	return merged.String()
+}
+
+// cssRules splits a stylesheet into its top-level rules and at-rules,
+// trimmed, keeping nested blocks such as @media with their rule. Comments
+// are dropped.
+func cssRules(css string) []string {
+	var rules []string
+	start, depth := 0, 0
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '/' && strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				end = len(css) - i - 4
+			}
+			if depth == 0 {
+				if rule := strings.TrimSpace(css[start:i]); rule != "" {
+					rules = append(rules, rule)
+				}
+				start = i + end + 4
+			}
+			i += end + 3
+		case c == '{':
+			depth++
+		case c == '}' || c == ';' && depth == 0:
+			if c == '}' {
+				depth--
+			}
+			if depth <= 0 {
+				depth = 0
+				if rule := strings.TrimSpace(css[start : i+1]); rule != "" {
+					rules = append(rules, rule)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if rule := strings.TrimSpace(css[start:]); rule != "" {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ParseFitBox parses a fit box of the form WIDTHxHEIGHT, such as "1920x1080".
+// An empty string means no fit box.
+func ParseFitBox(s string) (width, height int, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if ok {
+		width, err = strconv.Atoi(w)
+		if err == nil {
+			height, err = strconv.Atoi(h)
+		}
+	}
+	if !ok || err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid fit box: %s (use WIDTHxHEIGHT, e.g. 1920x1080)", s)
+	}
+	return width, height, nil
+}
+
+// fitSizeRe matches the size of a D2 SVG: the viewBox of the outer <svg>
+// element and the size of the <svg> element it wraps the diagram in.
+var fitSizeRe = regexp.MustCompile(`^(?s)(.*?<svg\b[^>]*?) viewBox="0 0 ([0-9.]+) ([0-9.]+)"([^>]*>\s*<svg\b[^>]*?) width="[0-9.]+" height="[0-9.]+"`)
+
+// fitSVG scales a D2 SVG to fit within maxWidth by maxHeight pixels,
+// preserving its aspect ratio. A zero maxWidth or maxHeight leaves that
+// dimension unbounded. With both set, the SVG is sized to the box and the
+// diagram is centered in it. SVGs not rendered by D2 are returned unchanged.
+func fitSVG(svg []byte, maxWidth, maxHeight int) []byte {
+	m := fitSizeRe.FindSubmatchIndex(svg)
+	if m == nil {
+		return svg
+	}
+	width, _ := strconv.ParseFloat(string(svg[m[4]:m[5]]), 64)
+	height, _ := strconv.ParseFloat(string(svg[m[6]:m[7]]), 64)
+	if width <= 0 || height <= 0 {
+		return svg
+	}
+
+	scale := math.Inf(1)
+	if maxWidth > 0 {
+		scale = float64(maxWidth) / width
+	}
+	if maxHeight > 0 {
+		scale = math.Min(scale, float64(maxHeight)/height)
+	}
+	if math.IsInf(scale, 1) {
+		return svg
+	}
+
+	fitWidth, fitHeight := width*scale, height*scale
+	boxWidth, boxHeight := fitWidth, fitHeight
+	if maxWidth > 0 && maxHeight > 0 {
+		boxWidth, boxHeight = float64(maxWidth), float64(maxHeight)
+	}
+
+	num := func(v float64) string {
+		v = math.Round(v*100) / 100
+		if v == 0 {
+			v = 0 // Not -0
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	var result bytes.Buffer
+	result.Write(svg[m[2]:m[3]])
+	fmt.Fprintf(&result, ` viewBox="0 0 %s %s" width="%s" height="%s"`, num(boxWidth), num(boxHeight), num(boxWidth), num(boxHeight))
+	result.Write(svg[m[8]:m[9]])
+	fmt.Fprintf(&result, ` x="%s" y="%s" width="%s" height="%s"`, num((boxWidth-fitWidth)/2), num((boxHeight-fitHeight)/2), num(fitWidth), num(fitHeight))
+	result.Write(svg[m[1]:])
+	return result.Bytes()
+}
+
+// fittedSVGRe matches the box size and diagram placement written by fitSVG.
+var fittedSVGRe = regexp.MustCompile(`^(?s)(?:<\?xml[^>]*\?>)?\s*<svg\b[^>]*? width="([0-9.]+)" height="([0-9.]+)"[^>]*>\s*<svg\b[^>]*? x="([0-9.]+)" y="([0-9.]+)" width="([0-9.]+)" height="([0-9.]+)"`)
+
+// fittedPlacement returns the box size of an SVG scaled by fitSVG, and the
+// position and size of the diagram within it. ok is false for other SVGs.
+func fittedPlacement(svg []byte) (boxWidth, boxHeight, x, y, width, height float64, ok bool) {
+	m := fittedSVGRe.FindSubmatch(svg)
+	if m == nil {
+		return 0, 0, 0, 0, 0, 0, false
+	}
+	var v [6]float64
+	for i := range v {
+		v[i], _ = strconv.ParseFloat(string(m[i+1]), 64)
+	}
+	return v[0], v[1], v[2], v[3], v[4], v[5], true
+}
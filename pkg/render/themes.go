@@ -0,0 +1,115 @@
+// Package render provides diagram rendering to various formats.
+// This file maps D2 theme names to their numeric IDs and lists the built-in themes.
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// ThemeIDByName returns the D2 theme ID for a theme name.
+// Matching is case-insensitive and treats spaces, underscores, and hyphens
+// alike, so "Dark Mauve", "dark-mauve", and "dark_mauve" are equivalent.
+// Numeric strings are accepted as IDs of known themes.
+func ThemeIDByName(name string) (int64, bool) {
+	key := normalizeThemeName(name)
+	if key == "" {
+		return 0, false
+	}
+
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		if d2themescatalog.Find(id).Name != "" {
+			return id, true
+		}
+		return 0, false
+	}
+
+	for _, catalog := range [][]d2themes.Theme{d2themescatalog.LightCatalog, d2themescatalog.DarkCatalog} {
+		for _, theme := range catalog {
+			if normalizeThemeName(theme.Name) == key {
+				return theme.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// normalizeThemeName lowercases a theme name and joins words with hyphens.
+func normalizeThemeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.NewReplacer("_", " ", "-", " ").Replace(name)
+	return strings.Join(strings.Fields(name), "-")
+}
+
+// DefaultDarkThemeID is the dark theme used in dark mode when the light
+// theme has no dark counterpart (Dark Mauve).
+const DefaultDarkThemeID int64 = 200
+
+// IsDarkTheme returns true if id is one of D2's built-in dark themes.
+func IsDarkTheme(id int64) bool {
+	for _, theme := range d2themescatalog.DarkCatalog {
+		if theme.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDarkThemeID returns the theme ID to render in dark mode.
+// An explicit darkThemeID must be a known dark theme. Otherwise the dark
+// counterpart of themeID is used if D2 has one (its ID is 100 higher),
+// falling back to DefaultDarkThemeID.
+func resolveDarkThemeID(themeID, darkThemeID int64) (int64, error) {
+	if darkThemeID != 0 {
+		if !IsDarkTheme(darkThemeID) {
+			return 0, fmt.Errorf("theme %d is not a dark theme", darkThemeID)
+		}
+		return darkThemeID, nil
+	}
+
+	if id, ok := darkVariant(themeID); ok {
+		return id, nil
+	}
+	return DefaultDarkThemeID, nil
+}
+
+// darkVariant returns the dark counterpart of a light theme, whose ID is
+// 100 higher, if D2 has one.
+func darkVariant(themeID int64) (int64, bool) {
+	if IsDarkTheme(themeID + 100) {
+		return themeID + 100, true
+	}
+	return 0, false
+}
+
+// ThemeInfo describes a built-in D2 theme.
+type ThemeInfo struct {
+	ID          int64
+	Name        string
+	Dark        bool
+	DarkVariant int64 // Theme used for this theme in dark mode, or 0 if it has none
+}
+
+// Themes returns D2's built-in themes: the light themes, then the dark
+// ones, each sorted by ID.
+func Themes() []ThemeInfo {
+	var themes []ThemeInfo
+	for _, catalog := range [][]d2themes.Theme{d2themescatalog.LightCatalog, d2themescatalog.DarkCatalog} {
+		start := len(themes)
+		for _, theme := range catalog {
+			info := ThemeInfo{ID: theme.ID, Name: theme.Name, Dark: IsDarkTheme(theme.ID)}
+			if !info.Dark {
+				info.DarkVariant, _ = darkVariant(theme.ID)
+			}
+			themes = append(themes, info)
+		}
+		added := themes[start:]
+		sort.Slice(added, func(i, j int) bool { return added[i].ID < added[j].ID })
+	}
+	return themes
+}
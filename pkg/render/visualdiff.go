@@ -0,0 +1,79 @@
+// Package render provides diagram rendering to various formats.
+// This file builds visual diffs, which color what changed between two diagrams.
+package render
+
+import "github.com/mark/dsl-diagram-tool/pkg/ir"
+
+// Colors of added, removed and changed elements in a visual diff.
+const (
+	diffAddedFill     = "#E8F5E9"
+	diffAddedStroke   = "#2E7D32"
+	diffRemovedFill   = "#FFEBEE"
+	diffRemovedStroke = "#C62828"
+	diffChangedFill   = "#FFF3E0"
+	diffChangedStroke = "#EF8F00"
+)
+
+// Removed elements are ghosted: dashed and faded.
+const (
+	diffRemovedOpacity = 0.5
+	diffRemovedDash    = 3
+)
+
+// DiffDiagram returns a diagram showing how new differs from old, for
+// rendering as a visual diff: new's nodes and edges, with added ones in
+// green and changed ones, including style-only changes, in amber, plus the
+// nodes and edges removed since old, ghosted in red. Neither input is
+// modified.
+func DiffDiagram(old, new *ir.Diagram) *ir.Diagram {
+	diff := ir.Diff(old, new)
+	result := new.Clone()
+	removed := old.Clone()
+
+	for _, id := range diff.AddedNodes {
+		markDiffNode(result.GetNode(id), diffAddedFill, diffAddedStroke)
+	}
+	for _, ids := range [][]string{diff.ChangedNodes, diff.StyledNodes} {
+		for _, id := range ids {
+			markDiffNode(result.GetNode(id), diffChangedFill, diffChangedStroke)
+		}
+	}
+	for _, id := range diff.AddedEdges {
+		result.GetEdge(id).Style.Stroke = diffAddedStroke
+	}
+	for _, ids := range [][]string{diff.ChangedEdges, diff.StyledEdges} {
+		for _, id := range ids {
+			result.GetEdge(id).Style.Stroke = diffChangedStroke
+		}
+	}
+
+	// Removed nodes keep their place in the hierarchy, so their containers
+	// must still draw as containers
+	for _, id := range diff.RemovedNodes {
+		node := removed.GetNode(id)
+		markDiffNode(node, diffRemovedFill, diffRemovedStroke)
+		node.Style.Opacity = diffRemovedOpacity
+		node.Style.StrokeDash = diffRemovedDash
+		result.Nodes = append(result.Nodes, node)
+	}
+	for _, id := range diff.RemovedNodes {
+		if parent := result.GetNode(removed.GetNode(id).Container); parent != nil {
+			parent.Shape = ir.ShapeContainer
+		}
+	}
+	for _, id := range diff.RemovedEdges {
+		edge := removed.GetEdge(id)
+		edge.Style.Stroke = diffRemovedStroke
+		edge.Style.Opacity = diffRemovedOpacity
+		edge.Style.StrokeDash = diffRemovedDash
+		result.Edges = append(result.Edges, edge)
+	}
+
+	return result
+}
+
+// markDiffNode colors a node for a visual diff.
+func markDiffNode(node *ir.Node, fill, stroke string) {
+	node.Style.Fill = fill
+	node.Style.Stroke = stroke
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requireToken wraps an API handler so it rejects requests without the
+// server's token as a bearer token with 401 Unauthorized. Requests pass
+// through unchanged when no token is configured.
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" && !s.authorized(bearerToken(r)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireWebSocketToken is like requireToken, but also accepts the token as
+// a ?token= query parameter, since browsers can't set headers on WebSocket
+// upgrades. Other endpoints don't accept it there, where it would end up in
+// logs and browser history for no reason.
+func (s *Server) requireWebSocketToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if s.Token != "" && !s.authorized(token) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bearerToken returns the token of the request's Authorization header, or ""
+// if there is none.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// authorized reports whether token is the server's token.
+func (s *Server) authorized(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// isLocalhostOrigin reports whether a WebSocket upgrade comes from a page
+// served from localhost. Requests without an Origin header (non-browser
+// clients) are allowed.
+func isLocalhostOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -1,13 +1,23 @@
 package server
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
+	"github.com/mark/dsl-diagram-tool/pkg/parser"
 	"github.com/mark/dsl-diagram-tool/pkg/render"
 )
 
@@ -31,12 +41,75 @@ type RenderResponse struct {
 	Error string `json:"error,omitempty"`
 }
 
+// ValidateRequest is the request body for POST /api/validate.
+type ValidateRequest struct {
+	Source string `json:"source"`
+}
+
+// ValidateResponse is the response body for POST /api/validate.
+type ValidateResponse struct {
+	Valid  bool              `json:"valid"`
+	Errors []ValidationIssue `json:"errors"`
+}
+
+// ValidationIssue is a problem found in the source. Line and Column are
+// 1-based, or 0 for structural errors that have no position.
+type ValidationIssue struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// ParseRequest is the request body for POST /api/parse.
+type ParseRequest struct {
+	Source string `json:"source"`
+}
+
+// ParseResponse is the response body for POST /api/parse. Diagram is the
+// IR in the same JSON form as "diagtool convert --to json", or nil if
+// the source has syntax errors. Errors lists the syntax or structural
+// errors, as for POST /api/validate.
+type ParseResponse struct {
+	Diagram *ir.Diagram       `json:"diagram,omitempty"`
+	Errors  []ValidationIssue `json:"errors"`
+}
+
 // FileResponse is the response body for GET /api/file.
 type FileResponse struct {
 	Source   string `json:"source"`
 	FilePath string `json:"filePath"`
 }
 
+// FilesResponse is the response body for GET /api/files.
+type FilesResponse struct {
+	Files []string `json:"files"` // .d2 files relative to the root directory
+}
+
+// ConfigResponse is the response body for GET /api/config.
+type ConfigResponse struct {
+	C4Mode   bool   `json:"c4Mode"`
+	ReadOnly bool   `json:"readOnly"` // Always false: files can be edited via PUT /api/file
+	HasFile  bool   `json:"hasFile"`
+	FilePath string `json:"filePath,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// handleConfig handles GET /api/config requests, describing the server
+// settings so the frontend can adapt its UI.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConfigResponse{
+		C4Mode:   s.C4Mode,
+		HasFile:  s.FilePath != "",
+		FilePath: s.FilePath,
+		Version:  s.Version,
+	})
+}
+
 // handleRender handles POST /api/render requests.
 func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -50,13 +123,88 @@ func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	svg, err := renderD2(r.Context(), req.Source, req.Options, s.C4Mode)
+	start := time.Now()
+	svg, err := renderD2(r.Context(), req.Source, req.Options, s.C4Mode, s.limits())
+	if err == nil {
+		// Markdown labels can carry raw HTML, and the SVG is shown to every
+		// user editing the diagram
+		svg, err = render.SanitizeSVG(svg)
+	}
+	s.metrics.observeRender(time.Since(start), err)
 	if err != nil {
+		logging.Debug("render failed", "err", err)
 		writeJSON(w, http.StatusOK, RenderResponse{Error: err.Error()})
 		return
 	}
+	logging.Debug("rendered", "bytes", len(svg), "duration", time.Since(start).Round(time.Millisecond))
+
+	writeCompressedJSON(w, r, http.StatusOK, RenderResponse{SVG: string(svg)})
+}
+
+// handleValidate handles POST /api/validate requests, checking the source
+// for syntax and structural errors without laying it out or rendering it.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, validateSource(req.Source))
+}
+
+// handleParse handles POST /api/parse requests, returning the diagram the
+// source describes as IR, so other frontends can inspect its nodes, edges
+// and containers without parsing D2 themselves.
+func (s *Server) handleParse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	writeJSON(w, http.StatusOK, RenderResponse{SVG: string(svg)})
+	var req ParseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	diagram, issues := parseSource(req.Source)
+	writeCompressedJSON(w, r, http.StatusOK, ParseResponse{Diagram: diagram, Errors: issues})
+}
+
+// validateSource parses D2 source and validates the resulting diagram.
+func validateSource(source string) ValidateResponse {
+	diagram, issues := parseSource(source)
+	return ValidateResponse{Valid: diagram != nil && len(issues) == 0, Errors: issues}
+}
+
+// parseSource parses D2 source and validates the resulting diagram,
+// returning the diagram, or nil if the source has syntax errors, and the
+// problems found.
+func parseSource(source string) (*ir.Diagram, []ValidationIssue) {
+	issues := []ValidationIssue{}
+
+	diagram, err := parser.NewD2Parser().Parse(source)
+	if err != nil {
+		syntaxErrors := parser.SyntaxErrors(err)
+		for _, e := range syntaxErrors {
+			issues = append(issues, ValidationIssue{Line: e.Line, Column: e.Column, Message: e.Message})
+		}
+		if len(syntaxErrors) == 0 {
+			issues = append(issues, ValidationIssue{Message: err.Error()})
+		}
+		return nil, issues
+	}
+
+	for _, err := range diagram.Validate() {
+		issues = append(issues, ValidationIssue{Message: err.Error()})
+	}
+	return diagram, issues
 }
 
 // handleFile handles GET and PUT /api/file requests.
@@ -71,22 +219,50 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleFileGet returns the current file content.
+// handleFileGet returns the content of the requested file, or of the
+// current file if no path is given.
 func (s *Server) handleFileGet(w http.ResponseWriter, r *http.Request) {
-	if s.FilePath == "" {
+	path, err := s.requestedFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if path == "" {
 		writeJSON(w, http.StatusOK, FileResponse{Source: "", FilePath: ""})
 		return
 	}
 
+	if path == s.FilePath {
+		writeJSON(w, http.StatusOK, FileResponse{
+			Source:   s.GetFileContent(),
+			FilePath: s.FilePath,
+		})
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
 	writeJSON(w, http.StatusOK, FileResponse{
-		Source:   s.GetFileContent(),
-		FilePath: s.FilePath,
+		Source:   string(content),
+		FilePath: path,
 	})
 }
 
-// handleFilePut saves content to the file.
+// handleFilePut saves content to the requested file, or to the current file
+// if no path is given.
 func (s *Server) handleFilePut(w http.ResponseWriter, r *http.Request) {
-	if s.FilePath == "" {
+	path, err := s.requestedFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if path == "" {
 		http.Error(w, "No file opened", http.StatusBadRequest)
 		return
 	}
@@ -106,23 +282,121 @@ func (s *Server) handleFilePut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update cached content first (prevents file watcher from triggering)
-	s.SetFileContent(req.Source)
+	if path == s.FilePath {
+		s.SetFileContent(req.Source)
+	}
 
 	// Write to file
-	if err := os.WriteFile(s.FilePath, []byte(req.Source), 0644); err != nil {
+	if err := os.WriteFile(path, []byte(req.Source), 0644); err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
+	s.metrics.fileSaved()
 
 	writeJSON(w, http.StatusOK, map[string]bool{"saved": true})
 }
 
+// handleFiles handles GET /api/files requests, listing the .d2 files
+// under the root directory.
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files := []string{}
+	if s.Root != "" {
+		err := filepath.WalkDir(s.Root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Skip hidden directories such as .git
+			if d.IsDir() && path != s.Root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && filepath.Ext(path) == ".d2" {
+				rel, err := filepath.Rel(s.Root, path)
+				if err != nil {
+					return err
+				}
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, "Failed to list files", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, FilesResponse{Files: files})
+}
+
+// requestedFile resolves the ?path= query parameter to an absolute path
+// within the root directory (see resolveFile).
+func (s *Server) requestedFile(r *http.Request) (string, error) {
+	return s.resolveFile(r.URL.Query().Get("path"))
+}
+
+// resolveFile resolves a path relative to the root directory to an absolute
+// path. An empty path is the current file. Absolute paths, paths containing
+// "..", and paths that leave the root through a symbolic link are rejected.
+func (s *Server) resolveFile(rel string) (string, error) {
+	if rel == "" {
+		return s.FilePath, nil
+	}
+
+	if s.Root == "" {
+		return "", fmt.Errorf("no root directory configured")
+	}
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "/") {
+		return "", fmt.Errorf("path must be relative to the root directory")
+	}
+	for _, part := range strings.FieldsFunc(rel, func(c rune) bool { return c == '/' || c == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("path must not contain '..'")
+		}
+	}
+	if filepath.Ext(rel) != ".d2" {
+		return "", fmt.Errorf("only .d2 files can be opened")
+	}
+
+	path := filepath.Join(s.Root, filepath.FromSlash(rel))
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+	if inside, err := filepath.Rel(s.Root, resolved); err != nil || inside == ".." ||
+		strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path must be within the root directory")
+	}
+	if current, err := resolvePath(s.FilePath); s.FilePath != "" && err == nil && current == resolved {
+		return s.FilePath, nil
+	}
+	return resolved, nil
+}
+
+// resolvePath follows the symbolic links in path. A file that does not exist
+// yet, as when it is about to be created, resolves to its name in the
+// resolved parent directory.
+func resolvePath(path string) (string, error) {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, filepath.Base(path)), nil
+	}
+	return filepath.EvalSymlinks(path)
+}
+
 // WSMessage represents a WebSocket message.
 type WSMessage struct {
 	Type   string `json:"type"`
 	Source string `json:"source,omitempty"`
 	SVG    string `json:"svg,omitempty"`
 	Error  string `json:"error,omitempty"`
+	Path   string `json:"path,omitempty"` // For edits: file edited, relative to the root as in /api/file?path= (default: current file)
 
 	// Position-related fields
 	NodeID    string                `json:"nodeId,omitempty"`    // For position: node identifier
@@ -150,24 +424,29 @@ type WSMessage struct {
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		logging.Warn("WebSocket upgrade failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
 	// Register client
 	s.clientsMu.Lock()
-	s.clients[conn] = true
+	s.clients[conn] = &sync.Mutex{}
+	clients := len(s.clients)
 	s.clientsMu.Unlock()
+	logging.Info("client connected", "remote", r.RemoteAddr, "clients", clients)
 
 	defer func() {
 		s.clientsMu.Lock()
 		delete(s.clients, conn)
+		clients := len(s.clients)
 		s.clientsMu.Unlock()
 		conn.Close()
+		logging.Info("client disconnected", "remote", r.RemoteAddr, "clients", clients)
 	}()
 
 	// Send initial file content
 	if s.FilePath != "" {
-		conn.WriteJSON(WSMessage{
+		s.send(conn, WSMessage{
 			Type:   "file-changed",
 			Source: s.GetFileContent(),
 		})
@@ -176,32 +455,46 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Send initial positions, vertices, routing modes, and label positions
 	meta := s.GetMetadata()
 	if meta.HasPositions() || meta.HasVertices() || meta.HasRoutingModes() || meta.HasLabelPositions() {
-		conn.WriteJSON(WSMessage{
-			Type:              "positions",
-			Positions:         meta.Positions,
-			AllVertices:       meta.Vertices,
-			AllRoutingMode:    meta.RoutingMode,
-			AllLabelPositions: meta.LabelPositions,
-		})
+		s.send(conn, positionsMessage(meta))
 	}
 
 	// Message loop
+	live := &liveRenderer{c4Mode: s.C4Mode, limits: s.limits()}
 	for {
 		var msg WSMessage
 		if err := conn.ReadJSON(&msg); err != nil {
 			break
 		}
 
+		if editMessages[msg.Type] {
+			if err := s.checkEditable(msg.Path); err != nil {
+				s.send(conn, WSMessage{
+					Type:  "error",
+					Error: err.Error(),
+				})
+				continue
+			}
+		}
+
 		switch msg.Type {
 		case "render":
-			svg, err := renderD2(r.Context(), msg.Source, nil, s.C4Mode)
+			if ok, wait := s.allow(r); !ok {
+				s.send(conn, WSMessage{
+					Type:  "error",
+					Error: fmt.Sprintf("Too many render requests, retry in %s", wait.Round(time.Millisecond)),
+				})
+				continue
+			}
+			start := time.Now()
+			svg, err := live.render(r.Context(), msg.Source)
+			s.metrics.observeRender(time.Since(start), err)
 			if err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: err.Error(),
 				})
 			} else {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type: "rendered",
 					SVG:  string(svg),
 				})
@@ -209,7 +502,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		case "save":
 			if s.FilePath == "" {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "No file opened",
 				})
@@ -221,18 +514,20 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 			// Write to file
 			if err := os.WriteFile(s.FilePath, []byte(msg.Source), 0644); err != nil {
-				conn.WriteJSON(WSMessage{
+				logging.Error("failed to save file", "file", s.FilePath, "err", err)
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to save file",
 				})
 			} else {
-				conn.WriteJSON(WSMessage{Type: "saved"})
+				s.metrics.fileSaved()
+				s.send(conn, WSMessage{Type: "saved"})
 			}
 
 		case "position":
 			// Update node position
 			if msg.NodeID == "" {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "nodeId is required",
 				})
@@ -240,15 +535,18 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if err := s.SetNodePosition(msg.NodeID, msg.DX, msg.DY); err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to save position: " + err.Error(),
 				})
 				continue
 			}
 
+			// Sync other clients viewing the same file
+			s.broadcastExcept(conn, positionsMessage(s.GetMetadata()))
+
 			// Acknowledge position saved
-			conn.WriteJSON(WSMessage{
+			s.send(conn, WSMessage{
 				Type:   "position-saved",
 				NodeID: msg.NodeID,
 			})
@@ -256,7 +554,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		case "vertices":
 			// Update edge vertices
 			if msg.EdgeID == "" {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "edgeId is required",
 				})
@@ -264,15 +562,18 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if err := s.SetEdgeVertices(msg.EdgeID, msg.Vertices); err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to save vertices: " + err.Error(),
 				})
 				continue
 			}
 
+			// Sync other clients viewing the same file
+			s.broadcastExcept(conn, positionsMessage(s.GetMetadata()))
+
 			// Acknowledge vertices saved
-			conn.WriteJSON(WSMessage{
+			s.send(conn, WSMessage{
 				Type:   "vertices-saved",
 				EdgeID: msg.EdgeID,
 			})
@@ -280,7 +581,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		case "routing":
 			// Update edge routing mode
 			if msg.EdgeID == "" {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "edgeId is required",
 				})
@@ -288,15 +589,18 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if err := s.SetRoutingMode(msg.EdgeID, msg.RoutingMode); err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to save routing mode: " + err.Error(),
 				})
 				continue
 			}
 
+			// Sync other clients viewing the same file
+			s.broadcastExcept(conn, positionsMessage(s.GetMetadata()))
+
 			// Acknowledge routing mode saved
-			conn.WriteJSON(WSMessage{
+			s.send(conn, WSMessage{
 				Type:        "routing-saved",
 				EdgeID:      msg.EdgeID,
 				RoutingMode: msg.RoutingMode,
@@ -305,7 +609,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		case "label-position":
 			// Update edge label position
 			if msg.EdgeID == "" {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "edgeId is required",
 				})
@@ -313,23 +617,49 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if err := s.SetLabelPosition(msg.EdgeID, msg.LabelDistance, msg.LabelOffsetX, msg.LabelOffsetY); err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to save label position: " + err.Error(),
 				})
 				continue
 			}
 
+			// Sync other clients viewing the same file
+			s.broadcastExcept(conn, positionsMessage(s.GetMetadata()))
+
 			// Acknowledge label position saved
-			conn.WriteJSON(WSMessage{
+			s.send(conn, WSMessage{
 				Type:   "label-position-saved",
 				EdgeID: msg.EdgeID,
 			})
 
+		case "undo", "redo":
+			// Step through the layout edit history
+			restore := s.Undo
+			if msg.Type == "redo" {
+				restore = s.Redo
+			}
+
+			ok, err := restore()
+			if err != nil {
+				s.send(conn, WSMessage{
+					Type:  "error",
+					Error: "Failed to " + msg.Type + ": " + err.Error(),
+				})
+				continue
+			}
+			if !ok {
+				s.send(conn, WSMessage{Type: "nothing-to-" + msg.Type})
+				continue
+			}
+
+			// Broadcast the restored layout to all clients, including the sender
+			s.broadcast(positionsMessage(s.GetMetadata()))
+
 		case "clear-positions":
 			// Clear all positions and vertices
 			if err := s.ClearAllPositions(); err != nil {
-				conn.WriteJSON(WSMessage{
+				s.send(conn, WSMessage{
 					Type:  "error",
 					Error: "Failed to clear positions: " + err.Error(),
 				})
@@ -344,8 +674,104 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// renderD2 renders D2 source to SVG.
-func renderD2(ctx context.Context, source string, opts *RenderOptions, c4Mode bool) ([]byte, error) {
+// editMessages are the WebSocket message types that change the current file
+// or its layout metadata.
+var editMessages = map[string]bool{
+	"save":            true,
+	"position":        true,
+	"vertices":        true,
+	"routing":         true,
+	"label-position":  true,
+	"undo":            true,
+	"redo":            true,
+	"clear-positions": true,
+}
+
+// checkEditable returns an error unless path, relative to the root, names
+// the current file. Layout metadata, undo history, and the file watcher
+// only follow the current file, so other files opened with /api/file?path=
+// are saved with PUT instead of over the WebSocket.
+func (s *Server) checkEditable(path string) error {
+	file, err := s.resolveFile(path)
+	if err != nil {
+		return err
+	}
+	if file != s.FilePath {
+		return fmt.Errorf("only the current file can be edited live; save %s with PUT /api/file?path=", path)
+	}
+	return nil
+}
+
+// positionsMessage builds a "positions" message carrying the full layout state.
+func positionsMessage(meta *Metadata) WSMessage {
+	return WSMessage{
+		Type:              "positions",
+		Positions:         meta.Positions,
+		AllVertices:       meta.Vertices,
+		AllRoutingMode:    meta.RoutingMode,
+		AllLabelPositions: meta.LabelPositions,
+	}
+}
+
+// renderD2 renders D2 source to SVG, rejecting diagrams over the limits.
+func renderD2(ctx context.Context, source string, opts *RenderOptions, c4Mode bool, limits renderLimits) ([]byte, error) {
+	if limits.enabled() {
+		// Parse errors are left for the renderer to report
+		if diagram, err := parser.NewD2Parser().Parse(source); err == nil {
+			if err := limits.check(diagram); err != nil {
+				return nil, err
+			}
+		}
+	}
+	source, renderOpts := renderSettings(source, opts, c4Mode)
+	return render.RenderFromSource(ctx, source, renderOpts)
+}
+
+// renderLimits caps the size of diagrams the server renders. Zero means no
+// limit.
+type renderLimits struct {
+	maxNodes int
+	maxEdges int
+}
+
+// limits returns the server's render limits.
+func (s *Server) limits() renderLimits {
+	return renderLimits{maxNodes: s.MaxNodes, maxEdges: s.MaxEdges}
+}
+
+// enabled reports whether any limit is set.
+func (l renderLimits) enabled() bool {
+	return l.maxNodes > 0 || l.maxEdges > 0
+}
+
+// check returns an error if the diagram, counting all of its boards, has
+// more nodes or edges than allowed.
+func (l renderLimits) check(diagram *ir.Diagram) error {
+	nodes, edges := countElements(diagram)
+	if l.maxNodes > 0 && nodes > l.maxNodes {
+		return fmt.Errorf("diagram has %d nodes, more than the limit of %d", nodes, l.maxNodes)
+	}
+	if l.maxEdges > 0 && edges > l.maxEdges {
+		return fmt.Errorf("diagram has %d edges, more than the limit of %d", edges, l.maxEdges)
+	}
+	return nil
+}
+
+// countElements returns the number of nodes and edges in a diagram and all
+// of its boards.
+func countElements(diagram *ir.Diagram) (nodes, edges int) {
+	nodes, edges = len(diagram.Nodes), len(diagram.Edges)
+	for _, board := range diagram.Boards {
+		n, e := countElements(board)
+		nodes += n
+		edges += e
+	}
+	return nodes, edges
+}
+
+// renderSettings returns the source and render options used to render D2
+// source with the given request options.
+func renderSettings(source string, opts *RenderOptions, c4Mode bool) (string, render.Options) {
 	renderOpts := render.DefaultOptions()
 
 	// Apply C4 mode defaults (Terminal theme + inject C4 classes)
@@ -368,10 +794,51 @@ func renderD2(ctx context.Context, source string, opts *RenderOptions, c4Mode bo
 	}
 
 	// Use a timeout for rendering
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+	renderOpts.Timeout = 30 * time.Second
 
-	return render.RenderFromSource(ctx, source, renderOpts)
+	return source, renderOpts
+}
+
+// liveRenderer renders successive edits of a diagram for one WebSocket
+// client. When an edit only changes styles, the previous layout is reused
+// instead of running the layout engine again.
+type liveRenderer struct {
+	c4Mode  bool
+	limits  renderLimits
+	diagram *ir.Diagram    // IR of the last rendered source
+	layout  *render.Layout // Layout of the last rendered source
+}
+
+// render renders source to SVG, reusing the previous layout if possible.
+func (lr *liveRenderer) render(ctx context.Context, source string) ([]byte, error) {
+	source, renderOpts := renderSettings(source, nil, lr.c4Mode)
+
+	// Only offer the previous layout when nothing but styles changed
+	diagram, err := parser.NewD2Parser().Parse(source)
+	if err == nil {
+		if err := lr.limits.check(diagram); err != nil {
+			return nil, err
+		}
+	}
+	var prev *render.Layout
+	if err == nil && lr.diagram != nil && ir.Diff(lr.diagram, diagram).IsStyleOnly() {
+		prev = lr.layout
+	}
+
+	start := time.Now()
+	svg, layout, err := render.RenderWithLayout(ctx, source, renderOpts, prev)
+	if err != nil {
+		logging.Debug("live render failed", "err", err)
+		return nil, err
+	}
+	// As in handleRender, Markdown labels can carry raw HTML
+	if svg, err = render.SanitizeSVG(svg); err != nil {
+		return nil, err
+	}
+	logging.Debug("live rendered", "bytes", len(svg), "reused_layout", layout.Reused(),
+		"duration", time.Since(start).Round(time.Millisecond))
+	lr.diagram, lr.layout = diagram, layout
+	return svg, nil
 }
 
 // writeJSON writes a JSON response.
@@ -380,3 +847,31 @@ func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(v)
 }
+
+// writeCompressedJSON writes a JSON response, gzip-compressed if the client
+// accepts it.
+func writeCompressedJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		writeJSON(w, status, v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	json.NewEncoder(gz).Encode(v)
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
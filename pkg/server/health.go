@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// selfTestTimeout bounds the render done by the health self-test.
+const selfTestTimeout = 30 * time.Second
+
+// HealthResponse is the response body for GET /healthz and GET /readyz.
+type HealthResponse struct {
+	Status  string `json:"status"` // "ok" or "ready", otherwise "error" or "not ready"
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// selfTest renders a trivial diagram the first time it is called and
+// returns the cached result after that, so health checks report whether
+// the render pipeline works without rendering on every probe.
+func (s *Server) selfTest() error {
+	s.selfTestOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+		defer cancel()
+		_, s.selfTestErr = renderD2(ctx, "a -> b", nil, false, renderLimits{})
+	})
+	return s.selfTestErr
+}
+
+// handleHealth handles GET /healthz requests: 200 if the render pipeline
+// works, 503 otherwise. Load balancers call it without a token, so it is
+// not protected.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.selfTest(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "error", Version: s.Version, Error: "render self-test failed: " + err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok", Version: s.Version})
+}
+
+// handleReady handles GET /readyz requests: 200 once the edited file, if
+// any, has been read, and 503 while the latest change to it can't be read.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.fileContentMu.RLock()
+	err := s.fileErr
+	s.fileContentMu.RUnlock()
+
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "not ready", Version: s.Version, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ready", Version: s.Version})
+}
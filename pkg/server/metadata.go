@@ -1,13 +1,13 @@
 package server
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"html"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/mark/dsl-diagram-tool/pkg/ir"
 )
 
 // Metadata stores position overrides for diagram nodes and edge vertices.
@@ -58,6 +58,34 @@ func NewMetadata() *Metadata {
 	}
 }
 
+// Clone returns a deep copy of the metadata.
+func (m *Metadata) Clone() *Metadata {
+	clone := &Metadata{
+		Version:        m.Version,
+		SourceHash:     m.SourceHash,
+		Positions:      make(map[string]NodeOffset, len(m.Positions)),
+		Vertices:       make(map[string][]Vertex, len(m.Vertices)),
+		RoutingMode:    make(map[string]string, len(m.RoutingMode)),
+		LabelPositions: make(map[string]LabelPosition, len(m.LabelPositions)),
+	}
+	for k, v := range m.Positions {
+		clone.Positions[k] = v
+	}
+	for k, v := range m.Vertices {
+		// Deep copy vertices slice
+		verticesCopy := make([]Vertex, len(v))
+		copy(verticesCopy, v)
+		clone.Vertices[k] = verticesCopy
+	}
+	for k, v := range m.RoutingMode {
+		clone.RoutingMode[k] = v
+	}
+	for k, v := range m.LabelPositions {
+		clone.LabelPositions[k] = v
+	}
+	return clone
+}
+
 // MetadataPath returns the .d2meta path for a given .d2 file path.
 func MetadataPath(d2Path string) string {
 	ext := filepath.Ext(d2Path)
@@ -110,16 +138,10 @@ func SaveMetadata(d2Path string, meta *Metadata) error {
 	return os.WriteFile(metaPath, data, 0644)
 }
 
-// HashSource computes a SHA256 hash of the D2 source content.
-func HashSource(source string) string {
-	hash := sha256.Sum256([]byte(source))
-	return hex.EncodeToString(hash[:8]) // First 8 bytes is enough
-}
-
 // ValidateAndClean checks if source hash matches and clears positions/vertices/routing if not.
 // Returns true if data was cleared.
 func (m *Metadata) ValidateAndClean(currentSource string) bool {
-	currentHash := HashSource(currentSource)
+	currentHash := ir.HashSource(currentSource)
 
 	if m.SourceHash != currentHash {
 		// Source changed, clear all positions, vertices, routing modes, and label positions
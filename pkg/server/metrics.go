@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// renderDurationBuckets are the upper bounds, in seconds, of the render
+// duration histogram.
+var renderDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics counts server activity for the Prometheus /metrics endpoint.
+type metrics struct {
+	mu             sync.Mutex
+	renders        uint64
+	renderErrors   uint64
+	durationCounts []uint64 // Renders per duration bucket, plus one for +Inf
+	durationSum    float64  // Total render time in seconds
+	saves          uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{durationCounts: make([]uint64, len(renderDurationBuckets)+1)}
+}
+
+// observeRender records a render that took d and failed if err is non-nil.
+func (m *metrics) observeRender(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.renders++
+	if err != nil {
+		m.renderErrors++
+	}
+	seconds := d.Seconds()
+	m.durationSum += seconds
+	i := 0
+	for i < len(renderDurationBuckets) && seconds > renderDurationBuckets[i] {
+		i++
+	}
+	m.durationCounts[i]++
+}
+
+// fileSaved records a save of a D2 file.
+func (m *metrics) fileSaved() {
+	m.mu.Lock()
+	m.saves++
+	m.mu.Unlock()
+}
+
+// write writes the metrics in the Prometheus text exposition format, with
+// clients as the number of connected WebSocket clients.
+func (m *metrics) write(w io.Writer, clients int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP diagtool_renders_total Diagrams rendered, including failed renders.")
+	fmt.Fprintln(w, "# TYPE diagtool_renders_total counter")
+	fmt.Fprintf(w, "diagtool_renders_total %d\n", m.renders)
+
+	fmt.Fprintln(w, "# HELP diagtool_render_errors_total Renders that failed.")
+	fmt.Fprintln(w, "# TYPE diagtool_render_errors_total counter")
+	fmt.Fprintf(w, "diagtool_render_errors_total %d\n", m.renderErrors)
+
+	fmt.Fprintln(w, "# HELP diagtool_render_duration_seconds Time spent rendering a diagram.")
+	fmt.Fprintln(w, "# TYPE diagtool_render_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range renderDurationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "diagtool_render_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += m.durationCounts[len(renderDurationBuckets)]
+	fmt.Fprintf(w, "diagtool_render_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "diagtool_render_duration_seconds_sum %s\n", strconv.FormatFloat(m.durationSum, 'g', -1, 64))
+	fmt.Fprintf(w, "diagtool_render_duration_seconds_count %d\n", cumulative)
+
+	fmt.Fprintln(w, "# HELP diagtool_websocket_clients Connected WebSocket clients.")
+	fmt.Fprintln(w, "# TYPE diagtool_websocket_clients gauge")
+	fmt.Fprintf(w, "diagtool_websocket_clients %d\n", clients)
+
+	fmt.Fprintln(w, "# HELP diagtool_file_saves_total D2 files saved through the editor.")
+	fmt.Fprintln(w, "# TYPE diagtool_file_saves_total counter")
+	fmt.Fprintf(w, "diagtool_file_saves_total %d\n", m.saves)
+}
+
+// handleMetrics handles GET /metrics requests.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w, clients)
+}
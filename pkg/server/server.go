@@ -12,6 +12,8 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/websocket"
+
+	"github.com/mark/dsl-diagram-tool/pkg/logging"
 )
 
 // Server represents the diagram editor HTTP server.
@@ -19,30 +21,80 @@ type Server struct {
 	// Configuration
 	Port     int
 	FilePath string // Path to the D2 file being edited
+	Root     string // Directory whose .d2 files can be listed and edited via the API
 	C4Mode   bool   // If true, apply C4 diagram styling
+	Token    string // If set, required on API and WebSocket requests
+	Version  string // Tool version reported by /api/config
+
+	// Largest diagram that will be rendered; zero means no limit
+	MaxNodes int
+	MaxEdges int
+
+	// Metrics, if set, serves Prometheus metrics at /metrics
+	Metrics bool
 
 	// Internal state
+	limiter    *rateLimiter // Per-client limit on renders and parses, nil for none
 	httpServer *http.Server
 	watcher    *fsnotify.Watcher
-	clients    map[*websocket.Conn]bool
+	clients    map[*websocket.Conn]*sync.Mutex // Connection → write lock
 	clientsMu  sync.RWMutex
 	upgrader   websocket.Upgrader
 
-	// Current file content (cached)
+	// Current file content (cached), and the error reading its latest change
 	fileContent   string
+	fileErr       error
 	fileContentMu sync.RWMutex
 
+	// Result of the render self-test reported at /healthz
+	selfTestOnce sync.Once
+	selfTestErr  error
+
 	// Position metadata
 	metadata   *Metadata
 	metadataMu sync.RWMutex
+
+	// Layout edit history for undo/redo (guarded by metadataMu)
+	undoStack []*Metadata
+	redoStack []*Metadata
+
+	// Counters reported at /metrics
+	metrics *metrics
 }
 
+// maxHistory is the number of layout edits that can be undone.
+const maxHistory = 50
+
 // Options configures the server.
 type Options struct {
 	Port     int
 	FilePath string
-	DevMode  bool // If true, serve from filesystem instead of embedded
-	C4Mode   bool // If true, apply C4 diagram styling (Terminal theme)
+	Root     string // Directory of editable files; without it, only FilePath can be edited
+	DevMode  bool   // If true, serve from filesystem instead of embedded
+	C4Mode   bool   // If true, apply C4 diagram styling (Terminal theme)
+	Version  string // Tool version reported by /api/config
+
+	// Token, if set, must be sent as "Authorization: Bearer <token>" on /api/*
+	// requests, or as a ?token= query parameter on the WebSocket upgrade
+	Token string
+
+	// LocalhostOnly restricts WebSocket connections to pages served from localhost
+	LocalhostOnly bool
+
+	// MaxNodes and MaxEdges reject diagrams with more nodes or edges, across
+	// all boards, before they are laid out. Zero means no limit.
+	MaxNodes int
+	MaxEdges int
+
+	// Metrics serves render, WebSocket client, and file save metrics in the
+	// Prometheus text format at /metrics
+	Metrics bool
+
+	// RateLimit is the number of requests per second allowed from each
+	// client IP to /api/render, /api/parse and /api/validate, together with
+	// WebSocket render messages, in bursts of up to the same number. Zero
+	// means no limit.
+	RateLimit float64
 }
 
 // New creates a new server instance.
@@ -55,13 +107,25 @@ func New(opts Options) (*Server, error) {
 		Port:     opts.Port,
 		FilePath: opts.FilePath,
 		C4Mode:   opts.C4Mode,
-		clients:  make(map[*websocket.Conn]bool),
+		Token:    opts.Token,
+		Version:  opts.Version,
+		MaxNodes: opts.MaxNodes,
+		MaxEdges: opts.MaxEdges,
+		Metrics:  opts.Metrics,
+		metrics:  newMetrics(),
+		clients:  make(map[*websocket.Conn]*sync.Mutex),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for local development
 			},
 		},
 	}
+	if opts.LocalhostOnly {
+		s.upgrader.CheckOrigin = isLocalhostOrigin
+	}
+	if opts.RateLimit > 0 {
+		s.limiter = newRateLimiter(opts.RateLimit)
+	}
 
 	// Load initial file content if file specified
 	if opts.FilePath != "" {
@@ -93,26 +157,57 @@ func New(opts Options) (*Server, error) {
 		s.metadata = NewMetadata()
 	}
 
+	if opts.Root != "" {
+		absRoot, err := filepath.Abs(opts.Root)
+		if err == nil {
+			// Resolved so that requested files can be checked against it
+			absRoot, err = filepath.EvalSymlinks(absRoot)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid root directory: %w", err)
+		}
+		s.Root = absRoot
+	}
+
 	return s, nil
 }
 
-// Start starts the HTTP server.
-func (s *Server) Start(ctx context.Context) error {
+// Handler returns the HTTP handler serving the API and the frontend.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
+	// Health checks for load balancers and orchestrators
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+
 	// API routes
-	mux.HandleFunc("/api/render", s.handleRender)
-	mux.HandleFunc("/api/file", s.handleFile)
-	mux.HandleFunc("/api/ws", s.handleWebSocket)
+	mux.HandleFunc("/api/config", s.requireToken(s.handleConfig))
+	mux.HandleFunc("/api/render", s.rateLimit(s.requireToken(s.handleRender)))
+	mux.HandleFunc("/api/validate", s.rateLimit(s.requireToken(s.handleValidate)))
+	mux.HandleFunc("/api/parse", s.rateLimit(s.requireToken(s.handleParse)))
+	mux.HandleFunc("/api/file", s.requireToken(s.handleFile))
+	mux.HandleFunc("/api/files", s.requireToken(s.handleFiles))
+	mux.HandleFunc("/api/ws", s.requireWebSocketToken(s.handleWebSocket))
+	if s.Metrics {
+		mux.HandleFunc("/metrics", s.requireToken(s.handleMetrics))
+	}
 
 	// Static files (frontend)
 	mux.HandleFunc("/", s.handleStatic)
 
+	return mux
+}
+
+// Start starts the HTTP server.
+func (s *Server) Start(ctx context.Context) error {
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.Port),
-		Handler: mux,
+		Handler: s.Handler(),
 	}
 
+	// Run the health self-test while the server starts
+	go s.selfTest()
+
 	// Start file watcher if we have a file
 	if s.FilePath != "" {
 		if err := s.startFileWatcher(); err != nil {
@@ -212,7 +307,7 @@ func (s *Server) watchFileChanges() {
 			if !ok {
 				return
 			}
-			fmt.Fprintf(os.Stderr, "file watcher error: %v\n", err)
+			logging.Error("file watcher error", "err", err)
 		}
 	}
 }
@@ -220,8 +315,11 @@ func (s *Server) watchFileChanges() {
 // handleFileChanged is called when the D2 file changes externally.
 func (s *Server) handleFileChanged() {
 	content, err := os.ReadFile(s.FilePath)
+	s.fileContentMu.Lock()
+	s.fileErr = err
+	s.fileContentMu.Unlock()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read changed file: %v\n", err)
+		logging.Error("failed to read changed file", "file", s.FilePath, "err", err)
 		return
 	}
 
@@ -235,6 +333,7 @@ func (s *Server) handleFileChanged() {
 	if !changed {
 		return
 	}
+	logging.Info("file changed on disk", "file", s.FilePath)
 
 	// Update cached content
 	s.fileContentMu.Lock()
@@ -246,8 +345,14 @@ func (s *Server) handleFileChanged() {
 	positionsCleared := s.metadata.ValidateAndClean(newContent)
 	if positionsCleared {
 		_ = SaveMetadata(s.FilePath, s.metadata)
+		// Layouts recorded for the old source no longer apply
+		s.undoStack = nil
+		s.redoStack = nil
 	}
 	s.metadataMu.Unlock()
+	if positionsCleared {
+		logging.Info("source changed, cleared layout metadata", "file", s.FilePath)
+	}
 
 	// Broadcast file change to all clients
 	s.broadcast(WSMessage{
@@ -265,17 +370,43 @@ func (s *Server) handleFileChanged() {
 
 // broadcast sends a message to all connected WebSocket clients.
 func (s *Server) broadcast(msg WSMessage) {
+	s.broadcastExcept(nil, msg)
+}
+
+// broadcastExcept sends a message to all connected WebSocket clients except
+// sender, so a client's own edits aren't echoed back to it.
+func (s *Server) broadcastExcept(sender *websocket.Conn, msg WSMessage) {
 	s.clientsMu.RLock()
 	defer s.clientsMu.RUnlock()
 
-	for conn := range s.clients {
-		if err := conn.WriteJSON(msg); err != nil {
+	for conn, writeMu := range s.clients {
+		if conn == sender {
+			continue
+		}
+		writeMu.Lock()
+		err := conn.WriteJSON(msg)
+		writeMu.Unlock()
+		if err != nil {
 			// Connection will be cleaned up by read loop
 			continue
 		}
 	}
 }
 
+// send writes a message to a single WebSocket client. Writes are serialized
+// per connection, since broadcasts from other goroutines may write to it too.
+func (s *Server) send(conn *websocket.Conn, msg WSMessage) error {
+	s.clientsMu.RLock()
+	writeMu, ok := s.clients[conn]
+	s.clientsMu.RUnlock()
+
+	if ok {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+	}
+	return conn.WriteJSON(msg)
+}
+
 // GetFileContent returns the current file content.
 func (s *Server) GetFileContent() string {
 	s.fileContentMu.RLock()
@@ -296,33 +427,16 @@ func (s *Server) GetMetadata() *Metadata {
 	defer s.metadataMu.RUnlock()
 
 	// Return a copy to avoid race conditions
-	metaCopy := &Metadata{
-		Version:     s.metadata.Version,
-		SourceHash:  s.metadata.SourceHash,
-		Positions:   make(map[string]NodeOffset),
-		Vertices:    make(map[string][]Vertex),
-		RoutingMode: make(map[string]string),
-	}
-	for k, v := range s.metadata.Positions {
-		metaCopy.Positions[k] = v
-	}
-	for k, v := range s.metadata.Vertices {
-		// Deep copy vertices slice
-		verticesCopy := make([]Vertex, len(v))
-		copy(verticesCopy, v)
-		metaCopy.Vertices[k] = verticesCopy
-	}
-	for k, v := range s.metadata.RoutingMode {
-		metaCopy.RoutingMode[k] = v
-	}
-	return metaCopy
+	return s.metadata.Clone()
 }
 
 // SetNodePosition updates a node's position offset and saves metadata.
+// The lock is held while saving so the file write doesn't race with other edits.
 func (s *Server) SetNodePosition(nodeID string, dx, dy float64) error {
 	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	s.pushHistory()
 	s.metadata.SetPosition(nodeID, dx, dy)
-	s.metadataMu.Unlock()
 
 	if s.FilePath != "" {
 		return SaveMetadata(s.FilePath, s.metadata)
@@ -333,10 +447,11 @@ func (s *Server) SetNodePosition(nodeID string, dx, dy float64) error {
 // ClearAllPositions clears all position overrides, vertices, and routing modes.
 func (s *Server) ClearAllPositions() error {
 	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	s.pushHistory()
 	s.metadata.Positions = make(map[string]NodeOffset)
 	s.metadata.Vertices = make(map[string][]Vertex)
 	s.metadata.RoutingMode = make(map[string]string)
-	s.metadataMu.Unlock()
 
 	if s.FilePath != "" {
 		return SaveMetadata(s.FilePath, s.metadata)
@@ -347,8 +462,9 @@ func (s *Server) ClearAllPositions() error {
 // SetEdgeVertices updates an edge's vertices and saves metadata.
 func (s *Server) SetEdgeVertices(edgeID string, vertices []Vertex) error {
 	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	s.pushHistory()
 	s.metadata.SetVertices(edgeID, vertices)
-	s.metadataMu.Unlock()
 
 	if s.FilePath != "" {
 		return SaveMetadata(s.FilePath, s.metadata)
@@ -359,8 +475,9 @@ func (s *Server) SetEdgeVertices(edgeID string, vertices []Vertex) error {
 // SetRoutingMode updates an edge's routing mode and saves metadata.
 func (s *Server) SetRoutingMode(edgeID string, mode string) error {
 	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	s.pushHistory()
 	s.metadata.SetRoutingMode(edgeID, mode)
-	s.metadataMu.Unlock()
 
 	if s.FilePath != "" {
 		return SaveMetadata(s.FilePath, s.metadata)
@@ -371,11 +488,56 @@ func (s *Server) SetRoutingMode(edgeID string, mode string) error {
 // SetLabelPosition updates an edge label's position and saves metadata.
 func (s *Server) SetLabelPosition(edgeID string, distance, offsetX, offsetY float64) error {
 	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	s.pushHistory()
 	s.metadata.SetLabelPosition(edgeID, distance, offsetX, offsetY)
-	s.metadataMu.Unlock()
 
 	if s.FilePath != "" {
 		return SaveMetadata(s.FilePath, s.metadata)
 	}
 	return nil
 }
+
+// pushHistory records the current metadata so the next edit can be undone,
+// and discards the redo history. The caller must hold metadataMu.
+func (s *Server) pushHistory() {
+	s.undoStack = append(s.undoStack, s.metadata.Clone())
+	if len(s.undoStack) > maxHistory {
+		s.undoStack = s.undoStack[len(s.undoStack)-maxHistory:]
+	}
+	s.redoStack = nil
+}
+
+// Undo restores the metadata from before the last edit and saves it.
+// Returns false if there is nothing to undo.
+func (s *Server) Undo() (bool, error) {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	return s.restoreHistory(&s.undoStack, &s.redoStack)
+}
+
+// Redo reapplies the last undone edit and saves the metadata.
+// Returns false if there is nothing to redo.
+func (s *Server) Redo() (bool, error) {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	return s.restoreHistory(&s.redoStack, &s.undoStack)
+}
+
+// restoreHistory pops a snapshot from one stack, pushing the current metadata
+// onto the other. The caller must hold metadataMu.
+func (s *Server) restoreHistory(from, to *[]*Metadata) (bool, error) {
+	if len(*from) == 0 {
+		return false, nil
+	}
+
+	snapshot := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, s.metadata)
+	s.metadata = snapshot
+
+	if s.FilePath != "" {
+		return true, SaveMetadata(s.FilePath, s.metadata)
+	}
+	return true, nil
+}
@@ -0,0 +1,1265 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mark/dsl-diagram-tool/pkg/render"
+)
+
+func TestGetMetadata_ReturnsIndependentCopy(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	srv.SetNodePosition("a", 10, 20)
+	srv.SetEdgeVertices("(a -> b)[0]", []Vertex{{X: 1, Y: 2}})
+	srv.SetRoutingMode("(a -> b)[0]", "orthogonal")
+	srv.SetLabelPosition("(a -> b)[0]", 0.25, 5, 5)
+
+	meta := srv.GetMetadata()
+
+	// Mutate every map in the copy
+	meta.Positions["a"] = NodeOffset{DX: 99, DY: 99}
+	meta.Vertices["(a -> b)[0]"][0] = Vertex{X: 99, Y: 99}
+	meta.RoutingMode["(a -> b)[0]"] = "direct"
+	meta.LabelPositions["(a -> b)[0]"] = LabelPosition{Distance: 0.9}
+
+	live := srv.GetMetadata()
+	if live.Positions["a"] != (NodeOffset{DX: 10, DY: 20}) {
+		t.Errorf("Positions shared with live metadata: %v", live.Positions["a"])
+	}
+	if live.Vertices["(a -> b)[0]"][0] != (Vertex{X: 1, Y: 2}) {
+		t.Errorf("Vertices shared with live metadata: %v", live.Vertices["(a -> b)[0]"])
+	}
+	if live.RoutingMode["(a -> b)[0]"] != "orthogonal" {
+		t.Errorf("RoutingMode shared with live metadata: %v", live.RoutingMode)
+	}
+	if live.LabelPositions["(a -> b)[0]"] != (LabelPosition{Distance: 0.25, OffsetX: 5, OffsetY: 5}) {
+		t.Errorf("LabelPositions shared with live metadata: %v", live.LabelPositions)
+	}
+}
+
+// Run with -race to detect unsynchronized access to the metadata maps.
+func TestGetMetadata_ConcurrentEdits(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	const iterations = 200
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			edgeID := fmt.Sprintf("(a -> b)[%d]", i%10)
+			srv.SetEdgeVertices(edgeID, []Vertex{{X: float64(i), Y: float64(i)}})
+			srv.SetRoutingMode(edgeID, "orthogonal")
+			srv.SetLabelPosition(edgeID, 0.1, float64(i), 0)
+			srv.SetNodePosition("a", float64(i), 0)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			meta := srv.GetMetadata()
+			// Touch every map in the copy, as the WebSocket handler does when encoding
+			for _, v := range meta.Vertices {
+				_ = len(v)
+			}
+			for range meta.RoutingMode {
+			}
+			for range meta.LabelPositions {
+			}
+			for range meta.Positions {
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+// dialTestWS connects a WebSocket client to the test server.
+func dialTestWS(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readTestWS reads the next message, failing the test if none arrives in time.
+func readTestWS(t *testing.T, conn *websocket.Conn) WSMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg WSMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	return msg
+}
+
+func TestWebSocket_BroadcastsPositionToOtherClients(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWebSocket))
+	defer ts.Close()
+
+	first := dialTestWS(t, ts)
+	second := dialTestWS(t, ts)
+
+	// Wait until both clients are registered
+	for i := 0; i < 100; i++ {
+		srv.clientsMu.RLock()
+		n := len(srv.clients)
+		srv.clientsMu.RUnlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := first.WriteJSON(WSMessage{Type: "position", NodeID: "a", DX: 10, DY: 20}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+
+	// The sender gets an acknowledgement, not its own edit back
+	if msg := readTestWS(t, first); msg.Type != "position-saved" || msg.NodeID != "a" {
+		t.Errorf("Expected position-saved for 'a', got %+v", msg)
+	}
+
+	// The other client receives the updated positions
+	msg := readTestWS(t, second)
+	if msg.Type != "positions" {
+		t.Fatalf("Expected positions message, got %+v", msg)
+	}
+	if msg.Positions["a"] != (NodeOffset{DX: 10, DY: 20}) {
+		t.Errorf("Expected position (10, 20) for 'a', got %+v", msg.Positions["a"])
+	}
+
+	// No echo to the sender
+	first.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var echo WSMessage
+	if err := first.ReadJSON(&echo); err == nil {
+		t.Errorf("Sender should not receive its own update, got %+v", echo)
+	}
+}
+
+func TestHandler_TokenRequired(t *testing.T) {
+	srv, err := New(Options{Token: "s3cret"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		header     string
+		query      string
+		wantStatus int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", "", http.StatusUnauthorized},
+		{"bearer token", "Bearer s3cret", "", http.StatusOK},
+		// The query parameter is only accepted on the WebSocket upgrade
+		{"query token", "", "?token=s3cret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/file"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+
+	// The frontend itself is served without a token
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected static files to be public, got status %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_WebSocketToken(t *testing.T) {
+	srv, err := New(Options{Token: "s3cret"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected WebSocket upgrade without token to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %v", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=s3cret", nil)
+	if err != nil {
+		t.Fatalf("Expected WebSocket upgrade with token to succeed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestHandler_NoTokenConfigured(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/file")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected API to be open without a token, got status %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_RateLimit(t *testing.T) {
+	srv, err := New(Options{RateLimit: 2})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	post := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader("not json"))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	limited := 0
+	for i := 0; i < 5; i++ {
+		resp := post("/api/render")
+		if resp.StatusCode != http.StatusTooManyRequests {
+			continue
+		}
+		limited++
+		if retry, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retry < 1 {
+			t.Errorf("Expected a Retry-After of at least 1 second, got %q", resp.Header.Get("Retry-After"))
+		}
+	}
+	if limited == 0 || limited > 3 {
+		t.Errorf("Expected renders past the burst of 2 to be rate limited, got %d of 5", limited)
+	}
+
+	// Parsing and validating take from the same bucket
+	for _, path := range []string{"/api/parse", "/api/validate"} {
+		if resp := post(path); resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Expected %s to be rate limited, got %d", path, resp.StatusCode)
+		}
+	}
+	if resp := post("/api/config"); resp.StatusCode == http.StatusTooManyRequests {
+		t.Error("Expected /api/config not to be rate limited")
+	}
+}
+
+func TestHandler_RateLimitParseAndValidate(t *testing.T) {
+	for _, path := range []string{"/api/parse", "/api/validate"} {
+		srv, err := New(Options{RateLimit: 1})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+
+		limited := 0
+		for i := 0; i < 3; i++ {
+			resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(`{"source": "a -> b"}`))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusTooManyRequests {
+				limited++
+			}
+		}
+		ts.Close()
+		if limited == 0 {
+			t.Errorf("Expected %s past the burst of 1 to be rate limited", path)
+		}
+	}
+}
+
+func TestWebSocket_RenderRateLimit(t *testing.T) {
+	srv, err := New(Options{RateLimit: 1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWebSocket))
+	defer ts.Close()
+	conn := dialTestWS(t, ts)
+
+	var errs []string
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteJSON(WSMessage{Type: "render", Source: "a -> b"}); err != nil {
+			t.Fatalf("WriteJSON failed: %v", err)
+		}
+		if msg := readTestWS(t, conn); msg.Type == "error" {
+			errs = append(errs, msg.Error)
+		}
+	}
+	if len(errs) == 0 {
+		t.Fatal("Expected renders past the burst of 1 to get an error message")
+	}
+	if !strings.Contains(errs[0], "Too many render requests") {
+		t.Errorf("Expected a rate limit error, got %q", errs[0])
+	}
+}
+
+func TestRateLimiter_Refill(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("10.0.0.1"); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := l.allow("10.0.0.1")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms after the burst, got allowed=%v wait=%v", ok, wait)
+	}
+	if ok, _ := l.allow("10.0.0.2"); !ok {
+		t.Error("Expected another client to have its own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.allow("10.0.0.1"); !ok {
+		t.Error("Expected a token to be available after 500ms")
+	}
+
+	// Idle clients are forgotten once their bucket has refilled
+	now = now.Add(2 * time.Minute)
+	l.allow("10.0.0.3")
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected idle buckets to be pruned, got %d", len(l.buckets))
+	}
+}
+
+func TestHandler_HealthAndReadiness(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "diagram.d2")
+	os.WriteFile(filePath, []byte("a -> b"), 0644)
+	srv, err := New(Options{FilePath: filePath, Token: "s3cret", Version: "1.2.3"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Probes need no token
+	get := func(path string) (int, HealthResponse) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var health HealthResponse
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			t.Fatalf("Failed to decode %s response: %v", path, err)
+		}
+		return resp.StatusCode, health
+	}
+
+	status, health := get("/healthz")
+	if status != http.StatusOK || health.Status != "ok" || health.Version != "1.2.3" {
+		t.Errorf("Expected healthy with version 1.2.3, got %d %+v", status, health)
+	}
+	if status, health := get("/readyz"); status != http.StatusOK || health.Status != "ready" {
+		t.Errorf("Expected ready, got %d %+v", status, health)
+	}
+
+	// Not ready while the file can't be read
+	os.Remove(filePath)
+	srv.handleFileChanged()
+	if status, health := get("/readyz"); status != http.StatusServiceUnavailable || health.Error == "" {
+		t.Errorf("Expected not ready without the file, got %d %+v", status, health)
+	}
+	os.WriteFile(filePath, []byte("a -> c"), 0644)
+	srv.handleFileChanged()
+	if status, _ := get("/readyz"); status != http.StatusOK {
+		t.Errorf("Expected ready once the file is back, got %d", status)
+	}
+}
+
+func TestIsLocalhostOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://localhost:8080", true},
+		{"http://127.0.0.1:8080", true},
+		{"http://[::1]:8080", true},
+		{"http://example.com", false},
+		{"http://192.168.1.10:8080", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/ws", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := isLocalhostOrigin(req); got != tt.want {
+			t.Errorf("isLocalhostOrigin(%q) = %v, expected %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	dir := t.TempDir()
+	d2Path := filepath.Join(dir, "test.d2")
+	os.WriteFile(d2Path, []byte("a -> b"), 0644)
+
+	srv, err := New(Options{FilePath: d2Path})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	edgeID := "(a -> b)[0]"
+	srv.SetNodePosition("a", 10, 20)
+	srv.SetEdgeVertices(edgeID, []Vertex{{X: 1, Y: 2}})
+	srv.SetRoutingMode(edgeID, "orthogonal")
+
+	// Undo the routing change
+	if ok, err := srv.Undo(); !ok || err != nil {
+		t.Fatalf("Undo = (%v, %v), expected (true, nil)", ok, err)
+	}
+	meta := srv.GetMetadata()
+	if meta.HasRoutingModes() {
+		t.Errorf("Expected routing mode to be undone, got %v", meta.RoutingMode)
+	}
+	if len(meta.Vertices[edgeID]) != 1 {
+		t.Errorf("Expected vertices to remain, got %v", meta.Vertices)
+	}
+
+	// Undo the vertices and the position
+	srv.Undo()
+	srv.Undo()
+	meta = srv.GetMetadata()
+	if meta.HasPositions() || meta.HasVertices() {
+		t.Errorf("Expected empty layout after undoing everything, got %+v", meta)
+	}
+	if ok, _ := srv.Undo(); ok {
+		t.Error("Expected nothing left to undo")
+	}
+
+	// Redo the position
+	if ok, err := srv.Redo(); !ok || err != nil {
+		t.Fatalf("Redo = (%v, %v), expected (true, nil)", ok, err)
+	}
+	meta = srv.GetMetadata()
+	if meta.Positions["a"] != (NodeOffset{DX: 10, DY: 20}) {
+		t.Errorf("Expected position to be redone, got %v", meta.Positions)
+	}
+	if meta.HasVertices() {
+		t.Errorf("Expected vertices to stay undone, got %v", meta.Vertices)
+	}
+
+	// The restored state is persisted
+	saved, err := LoadMetadata(d2Path)
+	if err != nil {
+		t.Fatalf("LoadMetadata failed: %v", err)
+	}
+	if saved.Positions["a"] != (NodeOffset{DX: 10, DY: 20}) || saved.HasVertices() {
+		t.Errorf("Saved metadata doesn't match restored state: %+v", saved)
+	}
+
+	// A new edit discards the redo history
+	srv.SetNodePosition("b", 5, 5)
+	if ok, _ := srv.Redo(); ok {
+		t.Error("Expected redo history to be cleared by a new edit")
+	}
+}
+
+func TestUndo_HistoryIsBounded(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for i := 0; i < maxHistory+10; i++ {
+		srv.SetNodePosition("a", float64(i+1), 0)
+	}
+
+	undone := 0
+	for {
+		ok, _ := srv.Undo()
+		if !ok {
+			break
+		}
+		undone++
+	}
+	if undone != maxHistory {
+		t.Errorf("Expected %d undoable edits, got %d", maxHistory, undone)
+	}
+
+	// The oldest edits fell off the history
+	if got := srv.GetMetadata().Positions["a"]; got.DX != 10 {
+		t.Errorf("Expected oldest reachable position dx=10, got %v", got)
+	}
+}
+
+func TestWebSocket_UndoBroadcastsRestoredLayout(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWebSocket))
+	defer ts.Close()
+
+	conn := dialTestWS(t, ts)
+
+	conn.WriteJSON(WSMessage{Type: "position", NodeID: "a", DX: 10, DY: 20})
+	if msg := readTestWS(t, conn); msg.Type != "position-saved" {
+		t.Fatalf("Expected position-saved, got %+v", msg)
+	}
+
+	conn.WriteJSON(WSMessage{Type: "undo"})
+	msg := readTestWS(t, conn)
+	if msg.Type != "positions" || len(msg.Positions) != 0 {
+		t.Errorf("Expected empty positions after undo, got %+v", msg)
+	}
+
+	conn.WriteJSON(WSMessage{Type: "redo"})
+	msg = readTestWS(t, conn)
+	if msg.Type != "positions" || msg.Positions["a"] != (NodeOffset{DX: 10, DY: 20}) {
+		t.Errorf("Expected restored position after redo, got %+v", msg)
+	}
+
+	conn.WriteJSON(WSMessage{Type: "redo"})
+	if msg := readTestWS(t, conn); msg.Type != "nothing-to-redo" {
+		t.Errorf("Expected nothing-to-redo, got %+v", msg)
+	}
+}
+
+// newTestProject creates a directory with a few .d2 files and a server rooted at it.
+func newTestProject(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, "main.d2"), []byte("a -> b"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "other.d2"), []byte("x -> y"), 0644)
+	os.WriteFile(filepath.Join(dir, ".git", "ignored.d2"), []byte("ignored"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a diagram"), 0644)
+
+	srv, err := New(Options{FilePath: filepath.Join(dir, "main.d2"), Root: dir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func TestHandleFiles_ListsD2Files(t *testing.T) {
+	_, ts := newTestProject(t)
+
+	resp, err := http.Get(ts.URL + "/api/files")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var files FilesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if strings.Join(files.Files, ",") != "main.d2,sub/other.d2" {
+		t.Errorf("Expected [main.d2 sub/other.d2], got %v", files.Files)
+	}
+}
+
+func TestHandleFile_SwitchFiles(t *testing.T) {
+	srv, ts := newTestProject(t)
+
+	// Read another file in the root
+	resp, err := http.Get(ts.URL + "/api/file?path=sub/other.d2")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var file FileResponse
+	json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if file.Source != "x -> y" {
+		t.Errorf("Expected content of sub/other.d2, got %q", file.Source)
+	}
+
+	// Write to it
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/file?path=sub/other.d2", strings.NewReader(`{"source": "x -> z"}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	content, _ := os.ReadFile(filepath.Join(srv.Root, "sub", "other.d2"))
+	if string(content) != "x -> z" {
+		t.Errorf("Expected file to be updated, got %q", content)
+	}
+
+	// The current file is unaffected
+	if srv.GetFileContent() != "a -> b" {
+		t.Errorf("Current file content changed: %q", srv.GetFileContent())
+	}
+
+	// Without a path, the current file is returned
+	resp, err = http.Get(ts.URL + "/api/file")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if file.Source != "a -> b" {
+		t.Errorf("Expected current file content, got %q", file.Source)
+	}
+}
+
+func TestWebSocket_EditsOnlyCurrentFile(t *testing.T) {
+	srv, _ := newTestProject(t)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWebSocket))
+	defer ts.Close()
+
+	conn := dialTestWS(t, ts)
+	if msg := readTestWS(t, conn); msg.Type != "file-changed" {
+		t.Fatalf("Expected file-changed, got %+v", msg)
+	}
+
+	// Saving after switching to another file must not overwrite the current one
+	conn.WriteJSON(WSMessage{Type: "save", Path: "sub/other.d2", Source: "x -> z"})
+	if msg := readTestWS(t, conn); msg.Type != "error" {
+		t.Errorf("Expected an error saving another file, got %+v", msg)
+	}
+	conn.WriteJSON(WSMessage{Type: "position", Path: "sub/other.d2", NodeID: "x", DX: 5})
+	if msg := readTestWS(t, conn); msg.Type != "error" {
+		t.Errorf("Expected an error moving a node of another file, got %+v", msg)
+	}
+	if got, _ := os.ReadFile(srv.FilePath); string(got) != "a -> b" {
+		t.Errorf("Current file was changed: %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(srv.Root, "sub", "other.d2")); string(got) != "x -> y" {
+		t.Errorf("Other file was changed over the WebSocket: %q", got)
+	}
+	if srv.GetMetadata().HasPositions() {
+		t.Error("Position of another file's node was stored in the current file's metadata")
+	}
+
+	// Switching back to the current file allows edits again
+	conn.WriteJSON(WSMessage{Type: "save", Path: "main.d2", Source: "a -> c"})
+	if msg := readTestWS(t, conn); msg.Type != "saved" {
+		t.Errorf("Expected saved, got %+v", msg)
+	}
+	if got, _ := os.ReadFile(srv.FilePath); string(got) != "a -> c" {
+		t.Errorf("Expected the current file saved, got %q", got)
+	}
+}
+
+func TestHandleFile_RejectsPathTraversal(t *testing.T) {
+	_, ts := newTestProject(t)
+
+	for _, path := range []string{"../secret.d2", "sub/../../secret.d2", "/etc/passwd.d2", "notes.txt"} {
+		resp, err := http.Get(ts.URL + "/api/file?path=" + url.QueryEscape(path))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET %q: expected status 400, got %d", path, resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/file?path="+url.QueryEscape(path), strings.NewReader(`{"source": "pwned"}`))
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PUT %q: expected status 400, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleFile_RejectsSymlinksOutsideRoot(t *testing.T) {
+	srv, ts := newTestProject(t)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.d2"), []byte("secret"), 0644)
+	if err := os.Symlink(filepath.Join(outside, "secret.d2"), filepath.Join(srv.Root, "link.d2")); err != nil {
+		t.Skipf("Symbolic links are not supported: %v", err)
+	}
+	os.Symlink(outside, filepath.Join(srv.Root, "linkdir"))
+	os.Symlink(filepath.Join(srv.Root, "sub", "other.d2"), filepath.Join(srv.Root, "inside.d2"))
+
+	for _, path := range []string{"link.d2", "linkdir/secret.d2", "linkdir/new.d2"} {
+		resp, err := http.Get(ts.URL + "/api/file?path=" + url.QueryEscape(path))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET %q: expected status 400, got %d", path, resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/file?path="+url.QueryEscape(path), strings.NewReader(`{"source": "pwned"}`))
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PUT %q: expected status 400, got %d", path, resp.StatusCode)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(outside, "secret.d2")); string(content) != "secret" {
+		t.Errorf("File outside the root was modified: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.d2")); err == nil {
+		t.Error("File was created outside the root")
+	}
+
+	// Links that stay within the root are followed
+	resp, err := http.Get(ts.URL + "/api/file?path=inside.d2")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var file FileResponse
+	json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if file.Source != "x -> y" {
+		t.Errorf("Expected content of sub/other.d2, got %q", file.Source)
+	}
+}
+
+func TestHandleFile_NoRootOnlyServesCurrentFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.d2"), []byte("a -> b"), 0644)
+	os.WriteFile(filepath.Join(dir, "other.d2"), []byte("x -> y"), 0644)
+	srv, err := New(Options{FilePath: filepath.Join(dir, "main.d2")})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/file?path=other.d2")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without --root, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/files")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var files FilesResponse
+	json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if len(files.Files) != 0 {
+		t.Errorf("Expected no files listed without --root, got %v", files.Files)
+	}
+}
+
+func TestHandleConfig(t *testing.T) {
+	srv, err := New(Options{C4Mode: true, Version: "1.2.3"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	fetch := func() ConfigResponse {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/api/config")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var config ConfigResponse
+		if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return config
+	}
+
+	config := fetch()
+	if !config.C4Mode || config.ReadOnly || config.HasFile || config.FilePath != "" || config.Version != "1.2.3" {
+		t.Errorf("Unexpected config without file: %+v", config)
+	}
+
+	srv.FilePath = "/tmp/diagram.d2"
+	srv.C4Mode = false
+	config = fetch()
+	if config.C4Mode || !config.HasFile || config.FilePath != "/tmp/diagram.d2" {
+		t.Errorf("Config should reflect server fields, got %+v", config)
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	validate := func(source string) ValidateResponse {
+		t.Helper()
+		body, _ := json.Marshal(ValidateRequest{Source: source})
+		resp, err := http.Post(ts.URL+"/api/validate", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var result ValidateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return result
+	}
+
+	result := validate("a -> b\nb: {\n  shape: hexagonal\n}")
+	if result.Valid || len(result.Errors) == 0 {
+		t.Fatalf("Expected invalid source to be reported, got %+v", result)
+	}
+	issue := result.Errors[0]
+	if issue.Line != 3 || issue.Column == 0 || issue.Message == "" || strings.HasPrefix(issue.Message, "3:") {
+		t.Errorf("Expected a positioned error on line 3, got %+v", issue)
+	}
+
+	result = validate("a -> b")
+	if !result.Valid || len(result.Errors) != 0 {
+		t.Errorf("Expected valid source, got %+v", result)
+	}
+}
+
+func TestHandleParse(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	parse := func(source string) ParseResponse {
+		t.Helper()
+		body, _ := json.Marshal(ParseRequest{Source: source})
+		resp, err := http.Post(ts.URL+"/api/parse", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var result ParseResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return result
+	}
+
+	source, err := os.ReadFile("../../examples/07-microservices.d2")
+	if err != nil {
+		t.Fatalf("Failed to read example: %v", err)
+	}
+	result := parse(string(source))
+	if result.Diagram == nil || len(result.Errors) != 0 {
+		t.Fatalf("Expected a valid diagram, got %+v", result)
+	}
+	for _, id := range []string{"web", "gateway", "services", "services.auth", "services.payments", "data.userdb", "data.cache", "queue", "stripe", "sendgrid"} {
+		if result.Diagram.GetNode(id) == nil {
+			t.Errorf("Expected node %s in the response", id)
+		}
+	}
+	if services := result.Diagram.GetNode("services"); services != nil && !services.IsContainer() {
+		t.Error("Expected services to be a container")
+	}
+	if len(result.Diagram.Edges) != 14 {
+		t.Errorf("Expected 14 edges, got %d", len(result.Diagram.Edges))
+	}
+
+	result = parse("a -> b\nb: {\n  shape: hexagonal\n}")
+	if result.Diagram != nil || len(result.Errors) == 0 || result.Errors[0].Line != 3 {
+		t.Errorf("Expected no diagram and a positioned error, got %+v", result)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// Disabled by default
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	ts.Close()
+	if strings.Contains(string(body), "diagtool_renders_total") {
+		t.Error("Expected /metrics to be disabled without Options.Metrics")
+	}
+
+	srv, err = New(Options{Metrics: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts = httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	for _, source := range []string{"a -> b", "a -> -> b"} {
+		body, _ := json.Marshal(RenderRequest{Source: source})
+		resp, err := http.Post(ts.URL+"/api/render", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected text/plain metrics, got %q", ct)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	for _, want := range []string{
+		"diagtool_renders_total 2\n",
+		"diagtool_render_errors_total 1\n",
+		"diagtool_render_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"diagtool_render_duration_seconds_count 2\n",
+		"diagtool_websocket_clients 0\n",
+		"diagtool_file_saves_total 0\n",
+		"# TYPE diagtool_render_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleRender_Limits(t *testing.T) {
+	srv, err := New(Options{MaxNodes: 50, MaxEdges: 40})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	renderSource := func(source string) RenderResponse {
+		t.Helper()
+		body, _ := json.Marshal(RenderRequest{Source: source})
+		resp, err := http.Post(ts.URL+"/api/render", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result RenderResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return result
+	}
+
+	// 3 groups of 4 services: 15 nodes, 11 edges
+	if result := renderSource(largeDiagramSource(3, 4)); result.Error != "" || result.SVG == "" {
+		t.Errorf("Expected diagram within the limits to render, got error %q", result.Error)
+	}
+
+	// 6 groups of 10 services: 66 nodes
+	result := renderSource(largeDiagramSource(6, 10))
+	if result.SVG != "" || result.Error != "diagram has 66 nodes, more than the limit of 50" {
+		t.Errorf("Expected node limit error, got %q", result.Error)
+	}
+
+	// 45 edges between 10 nodes
+	var b strings.Builder
+	for i := 0; i < 45; i++ {
+		fmt.Fprintf(&b, "n%d -> n%d\n", i%10, (i+1)%10)
+	}
+	result = renderSource(b.String())
+	if result.SVG != "" || result.Error != "diagram has 45 edges, more than the limit of 40" {
+		t.Errorf("Expected edge limit error, got %q", result.Error)
+	}
+
+	live := &liveRenderer{limits: srv.limits()}
+	if _, err := live.render(context.Background(), largeDiagramSource(6, 10)); err == nil {
+		t.Error("Expected live render over the node limit to fail")
+	}
+}
+
+func TestHandleRender_Gzip(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	body, _ := json.Marshal(RenderRequest{Source: "client -> server: request"})
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/render", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected gzip Content-Encoding, got %q", got)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Response is not gzip: %v", err)
+	}
+	var result RenderResponse
+	if err := json.NewDecoder(gz).Decode(&result); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if result.Error != "" {
+		t.Fatalf("Render failed: %s", result.Error)
+	}
+	if !strings.Contains(result.SVG, "<svg") || !strings.Contains(result.SVG, "request") {
+		t.Error("Expected decompressed SVG with the edge label")
+	}
+}
+
+func TestHandleRender_Sanitized(t *testing.T) {
+	srv, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Raw HTML in Markdown labels reaches the SVG unescaped
+	source := `x: |md
+  <a href="#" onclick="alert(1)">click</a>
+  <img src="x" onerror="alert(2)" />
+  <script>alert(3)</script>
+|
+x -> y: "<script>alert(4)</script>"`
+	body, _ := json.Marshal(RenderRequest{Source: source})
+	resp, err := http.Post(ts.URL+"/api/render", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var result RenderResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if result.Error != "" {
+		t.Fatalf("Render failed: %s", result.Error)
+	}
+
+	for _, unsafe := range []string{"<script", "onclick", "onerror"} {
+		if strings.Contains(result.SVG, unsafe) {
+			t.Errorf("Expected no %s in the SVG", unsafe)
+		}
+	}
+	if !strings.Contains(result.SVG, ">click</a>") || !strings.Contains(result.SVG, "&lt;script&gt;alert(4)") {
+		t.Error("Expected the link text and the escaped edge label to be kept")
+	}
+}
+
+func TestLiveRenderer_Sanitized(t *testing.T) {
+	live := &liveRenderer{}
+	svg, err := live.render(context.Background(), `x: |md
+  <a href="javascript:alert(1)" onclick="alert(2)">click</a>
+  <script>alert(3)</script>
+|`)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	for _, unsafe := range []string{"<script", "onclick", "javascript:"} {
+		if strings.Contains(string(svg), unsafe) {
+			t.Errorf("Expected no %s in the live rendered SVG", unsafe)
+		}
+	}
+}
+
+// largeDiagramSource returns a diagram of groups x perGroup nodes with edges
+// within and between groups.
+func largeDiagramSource(groups, perGroup int) string {
+	var b strings.Builder
+	for g := 0; g < groups; g++ {
+		fmt.Fprintf(&b, "g%d: Group %d {\n", g, g)
+		for n := 0; n < perGroup; n++ {
+			fmt.Fprintf(&b, "  n%d: Service %d-%d\n", n, g, n)
+			if n > 0 {
+				fmt.Fprintf(&b, "  n%d -> n%d: call\n", n-1, n)
+			}
+		}
+		b.WriteString("}\n")
+		if g > 0 {
+			fmt.Fprintf(&b, "g%d.n0 -> g%d.n0\n", g-1, g)
+		}
+	}
+	return b.String()
+}
+
+func TestLiveRenderer(t *testing.T) {
+	ctx := context.Background()
+	live := &liveRenderer{}
+	source := largeDiagramSource(3, 4)
+
+	if _, err := live.render(ctx, source); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if live.layout.Reused() {
+		t.Error("First render should run the layout engine")
+	}
+
+	// Style-only edit reuses the layout
+	restyled := source + "g1.n2.style.fill: \"#ff0000\"\n"
+	svg, err := live.render(ctx, restyled)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if !live.layout.Reused() {
+		t.Error("Style-only change should reuse the layout")
+	}
+	if !strings.Contains(string(svg), "#ff0000") {
+		t.Error("Restyled render should contain the new fill")
+	}
+
+	// Structural edits are fully laid out again
+	structural := restyled + "g1.extra: Extra\ng1.n0 -> g1.extra\n"
+	svg, err = live.render(ctx, structural)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if live.layout.Reused() {
+		t.Error("Structural change should run the layout engine")
+	}
+	full, err := renderD2(ctx, structural, nil, false, renderLimits{})
+	if err != nil {
+		t.Fatalf("renderD2 failed: %v", err)
+	}
+	if full, err = render.SanitizeSVG(full); err != nil {
+		t.Fatalf("SanitizeSVG failed: %v", err)
+	}
+	if string(svg) != string(full) {
+		t.Error("Structural change should render the same SVG as a full render")
+	}
+
+	// A property change keeps every size but is still laid out again
+	tooltip := structural + "g0.n1.tooltip: hello\n"
+	if _, err := live.render(ctx, tooltip); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if live.layout.Reused() {
+		t.Error("Non-style change should run the layout engine")
+	}
+
+	// Errors keep the last good state
+	if _, err := live.render(ctx, "a -> -> b"); err == nil {
+		t.Error("Expected error for invalid source")
+	}
+	if _, err := live.render(ctx, tooltip+"g0.n1.style.stroke: \"#00ff00\"\n"); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if !live.layout.Reused() {
+		t.Error("Style change after an error should reuse the last good layout")
+	}
+}
+
+func BenchmarkLiveRenderer_FullLayout(b *testing.B) {
+	ctx := context.Background()
+	source := largeDiagramSource(8, 10)
+	for i := 0; i < b.N; i++ {
+		if _, err := renderD2(ctx, source, nil, false, renderLimits{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLiveRenderer_StyleChange(b *testing.B) {
+	ctx := context.Background()
+	source := largeDiagramSource(8, 10)
+	live := &liveRenderer{}
+	if _, err := live.render(ctx, source); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		restyled := fmt.Sprintf("%sg3.n4.style.fill: \"#%06x\"\n", source, i%0xffffff)
+		if _, err := live.render(ctx, restyled); err != nil {
+			b.Fatal(err)
+		}
+	}
+}